@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Color flags.
+//
+
+// colorFlags are the flags of the quantize command choosing the color space of the nearest color search,
+// the handling of the transparent pixels and the gray modes.
+type colorFlags struct {
+	colorspace, metric, metricWeights *string
+	alpha4D, alphaDither              *bool
+	alphaThreshold                    *int
+	linear                            *bool
+	grayscale, bw                     *bool
+	bwThreshold                       *int
+	duotone, background               *string
+}
+
+// addColorFlags defines the color flags of the quantize command.
+func addColorFlags(flags *flag.FlagSet) *colorFlags {
+	return &colorFlags{
+		colorspace:     flags.String("colorspace", "", "color space of the nearest color search (rgb, lab, oklab, oklch, ycbcr, hsv or hsl); rgb by default, lab for the ΔE metrics"),
+		metric:         flags.String("metric", "", "color distance of the nearest color search (euclidean, de76 or de2000); euclidean by default"),
+		metricWeights:  flags.String("metric-weights", "", "comma-separated weights of the three coordinates of the -colorspace in the color distance, e.g. \"2,1,1\" in ycbcr or lab to preserve the luminance over the hue"),
+		alpha4D:        flags.Bool("alpha-4d", false, "quantize colors in the 4D RGBA space, keeping translucent colors"),
+		alphaDither:    flags.Bool("alpha-dither", false, "dither the alpha channel of the translucent pixels against the transparent color with the -dither algorithm, instead of making them opaque, for a stippled edge"),
+		alphaThreshold: flags.Int("alpha-threshold", 1, "alpha value (0-255) below which pixels are transparent; 0 makes every pixel opaque"),
+		linear:         flags.Bool("linear", true, "average colors and apply dithering offsets in linear light instead of sRGB"),
+		grayscale:      flags.Bool("grayscale", false, "convert the image to gray levels; the palette is a ramp of -pal gray levels unless one is supplied"),
+		bw:             flags.Bool("bw", false, "black and white output (1-bit PNG, or PBM); implies -grayscale"),
+		bwThreshold:    flags.Int("bw-threshold", 128, "gray level (0-255) from which pixels are white in black and white mode"),
+		duotone:        flags.String("duotone", "", "map the luma onto a ramp of two or more comma-separated hex colors, e.g. \"#102030,#f0e0c0\"; implies -grayscale"),
+		background:     flags.String("background", "", "hex color of a matte the transparent images are composited over before being quantized, e.g. \"#ffffff\""),
+	}
+}
+
+// apply checks the color flags and sets the color settings, the fixed palette of -bw and -duotone included.
+func (f *colorFlags) apply(s *quantize.Settings) error {
+	metric, err := quantize.NewColorMetric(*f.colorspace, *f.metric)
+	if err != nil {
+		return err
+	}
+	if *f.alpha4D {
+		if *f.colorspace != "" || *f.metric != "" {
+			return fmt.Errorf("the RGBA space of -alpha-4d cannot be combined with -colorspace or -metric")
+		}
+		metric = quantize.RGBAMetric{}
+	}
+	if *f.alphaDither && *f.alpha4D {
+		return fmt.Errorf("-alpha-dither cannot be combined with -alpha-4d, which keeps the translucent colors")
+	}
+	if *f.metricWeights != "" {
+		if *f.alpha4D {
+			return fmt.Errorf("-metric-weights cannot be combined with -alpha-4d")
+		}
+		weights, err := quantize.ParseMetricWeights(*f.metricWeights)
+		if err != nil {
+			return err
+		}
+		metric, err = quantize.NewWeightedMetric(metric, weights)
+		if err != nil {
+			return err
+		}
+	}
+	grayscale := *f.grayscale || *f.bw || *f.duotone != ""
+	if grayscale && (*f.alpha4D || *f.colorspace != "" || *f.metric != "" || *f.metricWeights != "") {
+		return fmt.Errorf("-grayscale cannot be combined with -alpha-4d, -colorspace, -metric or -metric-weights")
+	}
+
+	s.Palette.Metric = metric
+	s.Palette.Linear = *f.linear
+	s.Palette.AlphaThreshold = uint8(quantize.ClampF64(float64(*f.alphaThreshold), 0., 255.))
+	s.Palette.Alpha4D = *f.alpha4D
+	s.Palette.Grayscale = grayscale
+	s.DitherAlpha = *f.alphaDither
+
+	// The black and white threshold is set by shifting the gray levels, as black and white are separated by 128.
+	if *f.bw {
+		if *f.duotone != "" {
+			return fmt.Errorf("-duotone cannot be combined with -bw")
+		}
+		s.Palette.Fixed = quantize.GrayRamp(2)
+		s.GrayBias = float64(128 - quantize.ClampBelowInt(quantize.ClampAboveInt(*f.bwThreshold, 255), 0))
+	}
+	if *f.duotone != "" {
+		if s.Palette.Fixed, err = quantize.ParseDuotone(*f.duotone); err != nil {
+			return err
+		}
+		s.Duotone = true
+	}
+	if *f.background != "" {
+		c, err := quantize.ParseHexColor(*f.background)
+		if err != nil {
+			return err
+		}
+		s.Background = &c
+	}
+
+	return nil
+}
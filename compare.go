@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Compare and preview commands.
+//
+
+// runCompare runs the compare subcommand, which reports the quality of a quantized image
+// compared to its original image (see quantize.CompareImages) and may draw them side by side.
+func runCompare(args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the quality report as JSON")
+	outFilepath := flags.String("out", "", "image file where both images are drawn side by side")
+	heatmap := flags.Bool("heatmap", false, "add a heatmap of the color differences to the -out image")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: image-quantization compare [flags] original quantized\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("the compare command needs two images")
+	}
+
+	var images [2]image.Image
+	for i := range images {
+		var err error
+		images[i], err = quantize.GetImageFromFilePath(flags.Arg(i))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", flags.Arg(i), err)
+		}
+	}
+
+	metrics, err := quantize.CompareImages(images[0], images[1])
+	if err != nil {
+		return err
+	}
+	if err := quantize.WriteQualityReport(os.Stdout, flags.Arg(1), metrics, *asJSON); err != nil {
+		return err
+	}
+
+	if *outFilepath != "" {
+		comparison, err := quantize.ComparisonImage(images[0], images[1], *heatmap)
+		if err == nil {
+			err = quantize.WriteImageToFilePath(comparison, *outFilepath)
+		}
+		if err != nil {
+			return fmt.Errorf("writing comparison image: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// runPreview runs the preview subcommand, which draws an image on the terminal (see quantize.WriteTerminalPreview).
+func runPreview(args []string) error {
+	flags := flag.NewFlagSet("preview", flag.ExitOnError)
+	srcFilepath := flags.String("in", "", "input image filepath; \"-\" or empty for the standard input")
+	width := flags.Int("width", quantize.DefaultPreviewWidth, "width of the preview, in characters")
+	flags.Parse(args)
+
+	img, err := quantize.GetImageFromFilePath(*srcFilepath)
+	if err != nil {
+		return fmt.Errorf("reading input image: %w", err)
+	}
+
+	return quantize.WriteTerminalPreview(os.Stdout, img, *width, quantize.TrueColorTerminal())
+}
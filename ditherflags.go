@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Dithering flags.
+//
+
+// ditherFlags are the flags of the quantize command choosing and tuning the dithering.
+type ditherFlags struct {
+	name                      *string
+	bayerMatSize              *int
+	strength                  *string
+	luma, lab, serpentine     *bool
+	errorClamp, edgeThreshold *float64
+	offset                    *string
+	rotate                    *int
+	flip, matrix              *string
+	temporalOffset            *bool
+	temporalReuse             *int
+}
+
+// addDitherFlags defines the dithering flags of the quantize command.
+func addDitherFlags(flags *flag.FlagSet) *ditherFlags {
+	return &ditherFlags{
+		name:           flags.String("dither", "bayer", fmt.Sprintf("dithering algorithm %v", quantize.DithererNames())),
+		bayerMatSize:   flags.Int("bay", 4, "Bayer dithering matrix size, a power of two (2, 4, 8, 16...)"),
+		strength:       flags.String("dither-strength", "1", "strength (0 to 1) of the dithering offsets, or the comma-separated strengths of the red, green and blue channels"),
+		luma:           flags.Bool("dither-luma", false, "apply the dithering offsets to the luma only, preserving the hues"),
+		serpentine:     flags.Bool("serpentine", false, "scan every other row from right to left in the error diffusion"),
+		errorClamp:     flags.Float64("error-clamp", 0, "if positive, bound the error diffused to a pixel to this value (0-255) in each channel"),
+		edgeThreshold:  flags.Float64("edge-threshold", 0, "if positive, do not diffuse the error to the neighbors whose color differs by more than this value (0-255) in a channel"),
+		lab:            flags.Bool("dither-lab", false, "apply the ordered dithering offsets to the CIELAB lightness, reducing the chroma at the gamut edges instead of shifting the hues"),
+		offset:         flags.String("dither-offset", "0,0", "shift x,y of the threshold matrix of the ordered ditherings, e.g. to align the patterns of tiles quantized separately"),
+		rotate:         flags.Int("dither-rotate", 0, "clockwise rotation (0, 90, 180 or 270 degrees) of the threshold matrix of the ordered ditherings"),
+		flip:           flags.String("dither-flip", "", "mirror the threshold matrix of the ordered ditherings after its rotation: h (horizontally), v (vertically) or hv (both)"),
+		matrix:         flags.String("dither-matrix", "", "threshold matrix file (text or image) of the ordered dithering; implies -dither=ordered"),
+		temporalOffset: flags.Bool("temporal-offset", false, "move the threshold matrix of the ordered ditherings from frame to frame of an animation or an image sequence, so that the frames average out"),
+		temporalReuse:  flags.Int("temporal-reuse", 0, "if positive, the pixels of an animation whose channels changed by at most this value (0-255) since the previous frame keep their palette color, which stops the shimmering of static areas"),
+	}
+}
+
+// apply checks the dithering flags and sets the dithering settings; the color settings must be set,
+// as the ditherer searches the nearest colors in their color space.
+// The ditherer is created for each image, but its name and options are checked right now.
+func (f *ditherFlags) apply(s *quantize.Settings) error {
+	strength, err := quantize.ParseDitherStrength(*f.strength)
+	if err != nil {
+		return err
+	}
+	o := &s.Dither
+	o.BayerMatSize, o.Metric, o.Linear, o.Strength = *f.bayerMatSize, s.Palette.Metric, s.Palette.Linear, &strength
+	o.Luminance, o.Lab = *f.luma, *f.lab
+	if o.Offset, err = quantize.ParseDitherOffset(*f.offset); err != nil {
+		return err
+	}
+	if o.Orientation, err = quantize.ParseOrientation(*f.rotate, *f.flip); err != nil {
+		return err
+	}
+	o.DiffusionOptions = quantize.DiffusionOptions{Serpentine: *f.serpentine, ErrorClamp: *f.errorClamp, EdgeThreshold: *f.edgeThreshold}
+
+	name := *f.name
+	if *f.matrix != "" {
+		if name != "bayer" && name != "ordered" {
+			return fmt.Errorf("-dither-matrix cannot be used with the %q ditherer", name)
+		}
+		name = "ordered"
+		if o.Matrix, err = quantize.GetThresholdMatrixFromFilePath(*f.matrix); err != nil {
+			return fmt.Errorf("reading threshold matrix: %w", err)
+		}
+	}
+	if _, err := quantize.NewDitherer(name, *o); err != nil {
+		return err
+	}
+	if s.Duotone && !quantize.IsGrayDithererName(name) {
+		return fmt.Errorf("-duotone cannot be used with the %q ditherer", name)
+	}
+	s.DitherName = name
+	s.Animation.TemporalOffset, s.Animation.Reuse = *f.temporalOffset, *f.temporalReuse
+
+	return nil
+}
@@ -1,3 +1,5 @@
 module image-quantization
 
 go 1.18
+
+require golang.org/x/image v0.24.0
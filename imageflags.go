@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Image flags.
+//
+
+// imageFlags are the flags of the quantize command preparing the input images before they are quantized
+// (orientation, color profile, scaling, filters), choosing the quantized regions and post-processing the output images.
+type imageFlags struct {
+	noAutorotate, invertCMYK, deep *bool
+	metadata, colorProfile         *string
+	scaleDown, scaleUp             *int
+	scaleFilter, scaleMode         *string
+	prefilter                      *string
+	outline                        *float64
+	wrap, despeckle                *bool
+	mask                           *string
+	maskInvert                     *bool
+	tileSize, grid                 *string
+	tileColors, gridColors         *int
+	tileJSON                       *string
+}
+
+// addImageFlags defines the image flags of the quantize command.
+func addImageFlags(flags *flag.FlagSet) *imageFlags {
+	return &imageFlags{
+		noAutorotate: flags.Bool("no-autorotate", false, "do not turn the photos upright according to their EXIF orientation"),
+		invertCMYK:   flags.Bool("cmyk-invert", false, "invert the CMYK values of the CMYK JPEGs, for the ones which come out as negatives"),
+		metadata:     flags.String("metadata", "strip", "EXIF metadata of the input: strip, or keep (PNG output only)"),
+		colorProfile: flags.String("color-profile", "convert", "embedded ICC profile of the input: convert the image to sRGB, or ignore it"),
+		deep:         flags.Bool("deep", false, "dither the channels of 16-bit images down to 8 bits instead of rounding them, keeping their precision"),
+		scaleDown:    flags.Int("scale-down", 1, "divide the width and height of the images by this factor before quantizing them"),
+		scaleFilter:  flags.String("scale-filter", quantize.ScaleBox, "filter of -scale-down: box (mean color) or nearest"),
+		scaleUp:      flags.Int("scale-up", 1, "multiply the width and height of the output images by this factor"),
+		scaleMode:    flags.String("scale-mode", quantize.ScaleNearest, "filter of -scale-up: nearest, which keeps the palette"),
+		prefilter:    flags.String("prefilter", "", fmt.Sprintf("comma-separated filters applied to the images before quantizing them, e.g. \"%s=0.5\" to sharpen or \"%s=1.2\" to blur", quantize.PrefilterUnsharp, quantize.PrefilterGaussian)),
+		outline:      flags.Float64("outline", 0, "if positive, snap the pixels on the dark side of the edges of the images whose Sobel gradient reaches this magnitude (about 1020 for black on white) to dark palette colors, without dithering, to keep line art crisp"),
+		wrap:         flags.Bool("wrap", false, "dither the images as if they wrapped around, so that the textures tiled in a game engine show no seams"),
+		despeckle:    flags.Bool("despeckle", false, "replace the isolated pixels of the output images, whose color none of their neighbors shares, by the majority color of their neighbors"),
+		mask:         flags.String("mask", "", "grayscale image of the size of the input whose white pixels are quantized while the black ones pass through untouched; the output is then a true-color image"),
+		maskInvert:   flags.Bool("mask-invert", false, "quantize the black pixels of -mask instead of the white ones"),
+		tileSize:     flags.String("tiles", "", "tile size WxH (e.g. 8x8) of the per-tile palettes, each tile getting at most -tile-colors colors of the palette"),
+		tileColors:   flags.Int("tile-colors", 4, "maximum number of colors of each tile of -tiles"),
+		grid:         flags.String("grid", "", "cell size WxH (e.g. 16x16) of a sprite sheet whose cells each get their own palette of at most -pal colors"),
+		gridColors:   flags.Int("grid-colors", 0, "if positive, each cell of -grid gets at most this number of colors of a palette of -pal colors shared by the cells instead"),
+		tileJSON:     flags.String("tile-json", "", "JSON file where the colors of each tile of -tiles or cell of -grid are saved; {name} is replaced by the input file name in batch mode"),
+	}
+}
+
+// apply checks the image flags and sets the image settings.
+func (f *imageFlags) apply(s *quantize.Settings) error {
+	if *f.metadata != "strip" && *f.metadata != "keep" {
+		return fmt.Errorf("unknown metadata mode %q (available: [keep strip])", *f.metadata)
+	}
+	if *f.colorProfile != "convert" && *f.colorProfile != "ignore" {
+		return fmt.Errorf("unknown color profile mode %q (available: [convert ignore])", *f.colorProfile)
+	}
+	if err := quantize.CheckScaleFilter(*f.scaleFilter); err != nil {
+		return err
+	}
+	if err := quantize.CheckScaleFactor(*f.scaleDown); err != nil {
+		return fmt.Errorf("-scale-down: %w", err)
+	}
+	if err := quantize.CheckScaleFactor(*f.scaleUp); err != nil {
+		return fmt.Errorf("-scale-up: %w", err)
+	}
+	if *f.scaleMode != quantize.ScaleNearest {
+		return fmt.Errorf("unknown upscaling mode %q (available: [%s])", *f.scaleMode, quantize.ScaleNearest)
+	}
+	prefilters, err := quantize.ParsePrefilters(*f.prefilter)
+	if err != nil {
+		return err
+	}
+
+	s.AutoRotate = !*f.noAutorotate
+	s.InvertCMYK = *f.invertCMYK
+	s.KeepMetadata = *f.metadata == "keep"
+	s.ConvertProfile = *f.colorProfile == "convert"
+	s.Deep = *f.deep
+	s.ScaleDown, s.ScaleFilter, s.ScaleUp = *f.scaleDown, *f.scaleFilter, *f.scaleUp
+	s.Prefilters = prefilters
+	s.Outline, s.Wrap, s.Despeckle = *f.outline, *f.wrap, *f.despeckle
+
+	if *f.mask != "" {
+		mask, err := quantize.GetImageFromFilePath(*f.mask)
+		if err != nil {
+			return fmt.Errorf("reading mask %s: %w", *f.mask, err)
+		}
+		s.Mask = &quantize.RegionMask{Mask: mask, Invert: *f.maskInvert}
+	}
+
+	if *f.tileSize != "" && *f.grid != "" {
+		return fmt.Errorf("only one of -tiles and -grid can be used")
+	}
+	if *f.tileSize != "" {
+		width, height, err := quantize.ParseTileSize(*f.tileSize)
+		if err != nil {
+			return err
+		}
+		if *f.tileColors < 1 {
+			return fmt.Errorf("invalid tile colors %d (a positive number expected)", *f.tileColors)
+		}
+		s.Tiles = &quantize.TileLayout{Width: width, Height: height, Colors: *f.tileColors}
+	} else if *f.grid != "" {
+		width, height, err := quantize.ParseTileSize(*f.grid)
+		if err != nil {
+			return err
+		}
+		if *f.gridColors < 0 {
+			return fmt.Errorf("invalid grid colors %d (a positive number, or 0 for a palette per cell, expected)", *f.gridColors)
+		}
+		s.Tiles = &quantize.TileLayout{Width: width, Height: height, Colors: *f.gridColors}
+	} else if *f.tileJSON != "" {
+		return fmt.Errorf("-tile-json requires -tiles or -grid")
+	}
+	s.TileJSON = *f.tileJSON
+
+	return nil
+}
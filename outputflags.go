@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Output flags.
+//
+
+// outputFlags are the flags of the quantize command choosing the format of the output images and the files
+// and reports written besides them: palette, swatch, quality report, usage statistics, comparison and preview.
+type outputFlags struct {
+	format                               *string
+	exportBits, exportAlign              *int
+	exportName, exportPackage            *string
+	pngOrder, gifPalette                 *string
+	savePalette, swatch                  *string
+	swatchColumns                        *int
+	swatchLabels                         *bool
+	report, reportJSON, stats, statsJSON *bool
+	statsStrip, compare                  *string
+	compareHeatmap, preview              *bool
+	previewWidth                         *int
+	stream                               *bool
+}
+
+// addOutputFlags defines the output flags of the quantize command.
+func addOutputFlags(flags *flag.FlagSet) *outputFlags {
+	return &outputFlags{
+		format:         flags.String("format", "", fmt.Sprintf("output image format %v; inferred from the output file extension if empty", quantize.FormatNames())),
+		exportBits:     flags.Int("export-bits", 0, "bits per pixel (1, 2, 4 or 8) of the h, go and bin formats; 0 is the smallest one holding the palette"),
+		exportAlign:    flags.Int("export-align", 1, "the rows of the h, go and bin formats are padded to a multiple of this number of bytes"),
+		exportName:     flags.String("export-name", "", "base name of the identifiers of the h and go formats; the output file name if empty"),
+		exportPackage:  flags.String("export-package", "main", "package of the go format"),
+		pngOrder:       flags.String("png-order", quantize.OrderKeep, "palette order of the indexed PNG images: keep, luma or usage; luma and usage also drop the unused colors and put the transparent color first"),
+		gifPalette:     flags.String("gif-palette", quantize.GIFPaletteGlobal, "palette of an animated GIF: one for all the frames (global) or one per frame (local)"),
+		savePalette:    flags.String("save-palette", "", fmt.Sprintf("palette file %v where the palette of the result is saved; {name} is replaced by the input file name in batch mode", quantize.PaletteFormatNames())),
+		swatch:         flags.String("swatch", "", "image file where the palette of the result is drawn as color cells; {name} is replaced by the input file name in batch mode"),
+		swatchColumns:  flags.Int("swatch-columns", 0, "number of cells per row of the -swatch; 0 puts them all in one row"),
+		swatchLabels:   flags.Bool("swatch-labels", false, "write the hex code of each color in its -swatch cell"),
+		report:         flags.Bool("report", false, "print the PSNR, MSE, mean ΔE and SSIM of each quantized image"),
+		reportJSON:     flags.Bool("report-json", false, "print the quality report as JSON, one object per image"),
+		stats:          flags.Bool("stats", false, "print the number of pixels mapped to each palette entry of each image"),
+		statsJSON:      flags.Bool("stats-json", false, "print the palette usage as JSON, one object per image"),
+		statsStrip:     flags.String("stats-strip", "", "image file of the palette colors side by side, each one as wide as its share of the pixels; {name} is replaced by the input file name in batch mode"),
+		compare:        flags.String("compare", "", "image file where the input and the output are drawn side by side; {name} is replaced by the input file name in batch mode"),
+		compareHeatmap: flags.Bool("compare-heatmap", false, "add a heatmap of the color differences to the -compare image"),
+		preview:        flags.Bool("preview", false, "draw the output on the terminal (standard error) with ANSI colors, 24-bit if COLORTERM announces it"),
+		previewWidth:   flags.Int("preview-width", quantize.DefaultPreviewWidth, "width of the -preview, in characters"),
+		stream:         flags.Bool("stream", false, "dither and write PNG images band by band to bound the memory use"),
+	}
+}
+
+// apply checks the output flags and sets the output settings.
+func (f *outputFlags) apply(s *quantize.Settings) error {
+	switch *f.exportBits {
+	case 0, 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("invalid export bit depth %d (available: [0 1 2 4 8])", *f.exportBits)
+	}
+	if err := quantize.CheckPaletteOrder(*f.pngOrder); err != nil {
+		return err
+	}
+	if *f.gifPalette != quantize.GIFPaletteGlobal && *f.gifPalette != quantize.GIFPaletteLocal {
+		return fmt.Errorf("unknown GIF palette mode %q (available: [%s %s])", *f.gifPalette, quantize.GIFPaletteGlobal, quantize.GIFPaletteLocal)
+	}
+	if *f.swatchColumns < 0 {
+		return fmt.Errorf("invalid swatch columns %d (a positive number, or 0 for a single row, expected)", *f.swatchColumns)
+	}
+	if *f.previewWidth <= 0 {
+		return fmt.Errorf("invalid preview width %d (a positive number of characters expected)", *f.previewWidth)
+	}
+
+	s.Format = *f.format
+	s.Export = quantize.ExportOptions{BitDepth: *f.exportBits, RowAlign: *f.exportAlign, Name: *f.exportName, Package: *f.exportPackage}
+	s.PNGOrder = *f.pngOrder
+	s.Animation.PaletteMode = *f.gifPalette
+	s.SavePalette = *f.savePalette
+	s.Swatch, s.SwatchColumns, s.SwatchLabels = *f.swatch, *f.swatchColumns, *f.swatchLabels
+	s.Report, s.ReportJSON = *f.report, *f.reportJSON
+	s.Stats, s.StatsJSON, s.StatsStrip = *f.stats, *f.statsJSON, *f.statsStrip
+	s.Compare, s.CompareHeatmap = *f.compare, *f.compareHeatmap
+	s.Preview, s.PreviewWidth = *f.preview, *f.previewWidth
+	s.Stream = *f.stream
+
+	return nil
+}
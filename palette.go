@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Palette command.
+//
+
+// runPalette runs the palette subcommand: "palette extract" saves the palette generated from an image,
+// "palette convert" converts a palette file to another format, "palette merge" saves one palette shared
+// by several images and palette files (see quantize.MergePalettes), and "palette ramp" expands key colors
+// to a ramp (see quantize.ExpandRamp), which may be saved and an image quantized to.
+func runPalette(args []string) error {
+	if len(args) == 0 || (args[0] != "extract" && args[0] != "convert" && args[0] != "merge" && args[0] != "ramp") {
+		return fmt.Errorf("the palette command needs a subcommand (available: [convert extract merge ramp])")
+	}
+
+	flags := flag.NewFlagSet("palette "+args[0], flag.ExitOnError)
+	srcFilepath := flags.String("in", "", "input image (extract) or palette file (convert)")
+	outFilepath := flags.String("out", "", fmt.Sprintf("output palette file %v", quantize.PaletteFormatNames()))
+	paletteMaxSize := flags.Int("pal", 16, "maximum size of the palette (extract, merge), or number of colors of the ramp (ramp)")
+	algorithm := flags.String("algo", "mediancut", fmt.Sprintf("palette generation algorithm %v (extract, merge)", quantize.QuantizerNames()))
+	linear := flags.Bool("linear", true, "average colors in linear light instead of sRGB (extract, merge)")
+	keys := flags.String("keys", "", "comma-separated hex key colors of the ramp, in order, e.g. \"#1a1c2c,#b13e53,#ffcd75\" (ramp)")
+	space := flags.String("space", quantize.DefaultRampSpace, fmt.Sprintf("color space %v the ramp colors are interpolated in (ramp)", quantize.RampSpaceNames()))
+	imageIn := flags.String("image", "", "image quantized to the ramp (ramp)")
+	imageOut := flags.String("image-out", "", "output image of -image (ramp)")
+	ditherName := flags.String("dither", quantize.Bayer, fmt.Sprintf("dithering algorithm %v of -image (ramp)", quantize.DithererNames()))
+	flags.Parse(args[1:])
+	if args[0] == "ramp" {
+		return runPaletteRamp(*keys, *paletteMaxSize, *space, *outFilepath, *imageIn, *imageOut, *ditherName)
+	}
+	if args[0] == "merge" {
+		return runPaletteMerge(flags.Args(), *paletteMaxSize, *algorithm, *linear, *outFilepath)
+	}
+	if *srcFilepath == "" || *outFilepath == "" {
+		return fmt.Errorf("-in and -out are required")
+	}
+
+	var palette []color.RGBA
+	if args[0] == "convert" {
+		var err error
+		palette, err = quantize.GetPaletteFromFilePath(*srcFilepath)
+		if err != nil {
+			return fmt.Errorf("reading palette file: %w", err)
+		}
+	} else {
+		quantizer, err := quantize.NewQuantizer(*algorithm)
+		if err != nil {
+			return err
+		}
+		img, err := quantize.GetImageFromFilePath(*srcFilepath)
+		if err != nil {
+			return fmt.Errorf("reading input image: %w", err)
+		}
+		opts := quantize.PaletteOptions{Quantizer: quantizer, Linear: *linear, AlphaThreshold: 1, HistogramBits: 6, Exact: true, Refine: true}
+		palette = quantize.PaletteFromImage(img, *paletteMaxSize, opts)
+	}
+
+	if err := quantize.WritePaletteToFile(palette, *outFilepath); err != nil {
+		return fmt.Errorf("saving palette: %w", err)
+	}
+
+	return nil
+}
+
+// runPaletteMerge runs the "palette merge" subcommand: it saves to <outFilepath> one palette of at most <size> colors
+// generated from the colors of the <inputs>, images or palette files (see quantize.MergePalettes).
+func runPaletteMerge(inputs []string, size int, algorithm string, linear bool, outFilepath string) error {
+	if len(inputs) == 0 || outFilepath == "" {
+		return fmt.Errorf("-out and the input images or palette files, given after the flags, are required")
+	}
+	if size < 1 || size > quantize.MaxPaletteSize {
+		return fmt.Errorf("invalid palette size %d (1 to %d)", size, quantize.MaxPaletteSize)
+	}
+	quantizer, err := quantize.NewQuantizer(algorithm)
+	if err != nil {
+		return err
+	}
+
+	opts := quantize.PaletteOptions{Quantizer: quantizer, Linear: linear, AlphaThreshold: 1, HistogramBits: 6}
+	colors := make([][]quantize.WeightedColor, len(inputs))
+	for i, input := range inputs {
+		if _, err := quantize.PaletteFormatFromFilePath(input); err == nil {
+			palette, err := quantize.GetPaletteFromFilePath(input)
+			if err != nil {
+				return fmt.Errorf("reading palette file %s: %w", input, err)
+			}
+			for _, c := range palette {
+				colors[i] = append(colors[i], quantize.WeightedColor{Color: c, Weight: 1})
+			}
+			continue
+		}
+
+		img, err := quantize.GetImageFromFilePath(input)
+		if err != nil {
+			return fmt.Errorf("reading input image %s: %w", input, err)
+		}
+		colors[i] = quantize.PaletteHistogram(img, opts).Colors()
+	}
+
+	if err := quantize.WritePaletteToFile(quantize.MergePalettes(colors, size, opts), outFilepath); err != nil {
+		return fmt.Errorf("saving palette: %w", err)
+	}
+
+	return nil
+}
+
+// runPaletteRamp runs the "palette ramp" subcommand: it expands key colors to a ramp of <size> colors,
+// saves it to <outFilepath> and quantizes the <imageIn> image to it, if these filepaths are not empty.
+func runPaletteRamp(keys string, size int, space, outFilepath, imageIn, imageOut, ditherName string) error {
+	if outFilepath == "" && imageOut == "" {
+		return fmt.Errorf("-out or -image-out is required")
+	}
+	if (imageIn == "") != (imageOut == "") {
+		return fmt.Errorf("-image and -image-out go together")
+	}
+
+	keyColors, err := quantize.ParseHexColors(keys)
+	if err != nil {
+		return err
+	}
+	ramp, err := quantize.ExpandRamp(keyColors, size, space)
+	if err != nil {
+		return err
+	}
+
+	if outFilepath != "" {
+		if err := quantize.WritePaletteToFile(ramp, outFilepath); err != nil {
+			return fmt.Errorf("saving palette: %w", err)
+		}
+	}
+
+	if imageIn != "" {
+		img, err := quantize.GetImageFromFilePath(imageIn)
+		if err != nil {
+			return fmt.Errorf("reading input image: %w", err)
+		}
+		out, err := quantize.Image(img, quantize.WithPalette(ramp), quantize.WithDither(ditherName))
+		if err != nil {
+			return err
+		}
+		if err := quantize.WriteImageToFilePath(out, imageOut); err != nil {
+			return fmt.Errorf("writing output image: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Palette flags.
+//
+
+// paletteSizeFlag is the value of the -pal flag: a palette size, or quantize.PaletteSizeAuto.
+type paletteSizeFlag struct {
+	size int
+	auto bool
+}
+
+// String implements the flag.Value interface.
+func (f *paletteSizeFlag) String() string {
+	if f.auto {
+		return quantize.PaletteSizeAuto
+	}
+	return strconv.Itoa(f.size)
+}
+
+// Set implements the flag.Value interface.
+func (f *paletteSizeFlag) Set(s string) error {
+	if s == quantize.PaletteSizeAuto {
+		f.size, f.auto = 0, true
+		return nil
+	}
+	size, err := strconv.Atoi(s)
+	if err != nil || size < 1 || size > quantize.MaxPaletteSize {
+		return fmt.Errorf("invalid palette size %q (1 to %d, or %q)", s, quantize.MaxPaletteSize, quantize.PaletteSizeAuto)
+	}
+	f.size, f.auto = size, false
+
+	return nil
+}
+
+// paletteFlags are the flags of the quantize command choosing the palette: its size, its source
+// (generated from the image, or given) and the generation settings.
+type paletteFlags struct {
+	size                         *paletteSizeFlag
+	name, file, from, keepColors *string
+	algorithm, sample            *string
+	histogramBits                *int
+	seed                         *int64
+	exact, dedupe                *bool
+	mergeDE, colorblindDE        *float64
+	posterize                    *int
+	focus, weightMask            *string
+	focusWeight                  *int
+	cacheDir                     *string
+	targetDE                     *float64
+	targetStat                   *string
+}
+
+// addPaletteFlags defines the palette flags of the quantize command.
+func addPaletteFlags(flags *flag.FlagSet) *paletteFlags {
+	f := &paletteFlags{size: &paletteSizeFlag{size: 4}}
+	flags.Var(f.size, "pal", fmt.Sprintf("maximum size of the palette, or %q to estimate it for each image", quantize.PaletteSizeAuto))
+	f.name = flags.String("palette", "", fmt.Sprintf("built-in palette %v used instead of generating one", quantize.PaletteNames()))
+	f.file = flags.String("palette-file", "", fmt.Sprintf("palette file %v used instead of generating one", quantize.PaletteFormatNames()))
+	f.from = flags.String("palette-from", "", "reference image whose palette is used instead of generating one from the input image")
+	f.keepColors = flags.String("keep-colors", "", "comma-separated hex colors always in the palette and matched exactly, e.g. brand colors; the other slots are generated from the image")
+	f.algorithm = flags.String("algo", "mediancut", fmt.Sprintf("palette generation algorithm %v", quantize.QuantizerNames()))
+	f.histogramBits = flags.Int("histogram-bits", 6, "bits per channel (1-8) of the color histogram the palette is generated from; 0 uses every pixel")
+	f.seed = flags.Int64("seed", quantize.DefaultSeed, "seed of the random choices (see -sample random:N and -dither random); the output only depends on the input, the settings and the seed")
+	f.sample = flags.String("sample", "", "pixels the palette is generated from: all (default), every:N, random:N or proxy:N (downscaled to N pixels at most)")
+	f.exact = flags.Bool("exact", true, "keep the colors of an image which has no more colors than the palette size, without dithering")
+	f.dedupe = flags.Bool("dedupe", true, "remove the duplicated palette colors and use their slots for other colors")
+	f.colorblindDE = flags.Float64("colorblind-de", 0, "if positive, pull apart the lightness of the palette colors closer than this CIE 1976 ΔE as seen with protanopia or deuteranopia (e.g. 10), so that colorblind viewers tell them apart")
+	f.mergeDE = flags.Float64("merge-de", 0, "merge the palette colors closer than this CIE 1976 ΔE (e.g. 2.3); implies -dedupe")
+	f.posterize = flags.Int("posterize", 0, fmt.Sprintf("if positive, generate no palette but reduce each channel to this number of levels (2-%d), which is much faster", quantize.MaxPosterizeLevels))
+	f.focus = flags.String("focus", "", "region of interest x,y,w,h whose pixels weigh more in the palette generation")
+	f.weightMask = flags.String("weight-mask", "", "grayscale image of the size of the input whose white pixels weigh more in the palette generation")
+	f.focusWeight = flags.Int("focus-weight", quantize.DefaultFocusWeight, "weight of the pixels of -focus, or of the white pixels of -weight-mask")
+	f.cacheDir = flags.String("cache-dir", "", "directory where the generated palettes are cached, keyed by the hash of the input file and of the palette settings, so that the runs which only change the dithering skip the palette generation")
+	f.targetDE = flags.Float64("target-de", 0, "if positive, use the smallest palette, of at most -pal colors, whose ΔE stays under this value")
+	f.targetStat = flags.String("target-stat", quantize.TargetMean, "ΔE statistic of -target-de: mean or p95 (95th percentile)")
+
+	return f
+}
+
+// apply checks the palette flags and sets the palette settings; the color settings must be set,
+// as the palette of -palette-from is generated with them.
+func (f *paletteFlags) apply(s *quantize.Settings) error {
+	quantizer, err := quantize.NewQuantizer(*f.algorithm)
+	if err != nil {
+		return err
+	}
+	sampling, err := quantize.ParseSampling(*f.sample)
+	if err != nil {
+		return err
+	}
+	sampling.Seed = *f.seed
+	if *f.colorblindDE > 0 && s.Palette.Alpha4D {
+		return fmt.Errorf("-colorblind-de cannot be combined with -alpha-4d, whose translucent colors it would make opaque")
+	}
+	if *f.targetStat != quantize.TargetMean && *f.targetStat != quantize.TargetP95 {
+		return fmt.Errorf("unknown quality target %q (available: [%s %s])", *f.targetStat, quantize.TargetMean, quantize.TargetP95)
+	}
+
+	s.PaletteMaxSize, s.AutoPaletteSize = f.size.size, f.size.auto
+	s.Algorithm = *f.algorithm
+	s.Palette.Quantizer = quantizer
+	s.Palette.Sampling = sampling
+	s.Palette.HistogramBits = quantize.ClampBelowInt(quantize.ClampAboveInt(*f.histogramBits, 8), 0)
+	s.Palette.Exact = *f.exact
+	s.Palette.Refine = *f.dedupe || *f.mergeDE > 0
+	s.Palette.MergeDeltaE = *f.mergeDE
+	s.Palette.ColorblindDeltaE = *f.colorblindDE
+	s.TargetDeltaE, s.TargetStat = *f.targetDE, *f.targetStat
+	// The seed drives the random dithering too.
+	s.Dither.Seed = *f.seed
+
+	// The palette may not be generated from the input image.
+	given := *f.name != "" || *f.file != "" || *f.from != ""
+	if (*f.name != "" && *f.file != "") || (*f.name != "" && *f.from != "") || (*f.file != "" && *f.from != "") {
+		return fmt.Errorf("only one of -palette, -palette-file and -palette-from can be used")
+	}
+	if given && s.Palette.Fixed != nil {
+		return fmt.Errorf("-bw and -duotone cannot be combined with -palette, -palette-file or -palette-from")
+	}
+	if f.size.auto && (given || s.Palette.Fixed != nil || *f.posterize != 0 || *f.targetDE > 0) {
+		return fmt.Errorf("-pal %s cannot be combined with -palette, -palette-file, -palette-from, -bw, -duotone, -posterize or -target-de", quantize.PaletteSizeAuto)
+	}
+	if *f.posterize != 0 {
+		if err := quantize.CheckPosterizeLevels(*f.posterize); err != nil {
+			return err
+		}
+		if s.Palette.Grayscale || s.Palette.Alpha4D || given {
+			return fmt.Errorf("-posterize cannot be combined with -grayscale, -bw, -duotone, -alpha-4d, -palette, -palette-file or -palette-from")
+		}
+		s.Posterize = *f.posterize
+	}
+	if *f.name != "" {
+		if s.Palette.Fixed, err = quantize.NamedPalette(*f.name); err != nil {
+			return err
+		}
+	}
+	if *f.file != "" {
+		if s.Palette.Fixed, err = quantize.GetPaletteFromFilePath(*f.file); err != nil {
+			return fmt.Errorf("reading palette file: %w", err)
+		}
+	}
+	if *f.keepColors != "" {
+		if *f.name != "" || *f.file != "" || *f.posterize != 0 {
+			return fmt.Errorf("-keep-colors cannot be combined with -palette, -palette-file or -posterize")
+		}
+		if s.Palette.Keep, err = quantize.ParseHexColors(*f.keepColors); err != nil {
+			return err
+		}
+		if !f.size.auto && len(s.Palette.Keep) > f.size.size {
+			return fmt.Errorf("-keep-colors has more colors (%d) than the palette size (%d)", len(s.Palette.Keep), f.size.size)
+		}
+	}
+	if *f.from != "" {
+		refImage, err := quantize.GetImageFromFilePath(*f.from)
+		if err != nil {
+			return fmt.Errorf("reading reference image %s: %w", *f.from, err)
+		}
+		s.Palette.Fixed = quantize.PaletteFromImage(refImage, f.size.size, s.Palette)
+	}
+
+	// The weights only apply to the input images, not to -palette-from.
+	if *f.focus != "" && *f.weightMask != "" {
+		return fmt.Errorf("only one of -focus and -weight-mask can be used")
+	}
+	if *f.focus != "" {
+		rect, err := quantize.ParseFocusRect(*f.focus)
+		if err != nil {
+			return err
+		}
+		s.Palette.Weights = quantize.FocusWeights{Rect: rect, Focus: *f.focusWeight}
+	}
+	if *f.weightMask != "" {
+		mask, err := quantize.GetImageFromFilePath(*f.weightMask)
+		if err != nil {
+			return fmt.Errorf("reading weight mask %s: %w", *f.weightMask, err)
+		}
+		s.Palette.Weights = quantize.MaskWeights{Mask: mask, Focus: *f.focusWeight}
+	}
+	if *f.cacheDir != "" {
+		if s.PaletteCache, err = quantize.NewPaletteCache(*f.cacheDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
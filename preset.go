@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+//
+// 			Presets.
+//
+
+// Preset is a named set of command line flag values, e.g. {"pal": "4", "dither": "floyd-steinberg"}.
+type Preset map[string]string
+
+// presets holds the built-in presets, indexed by name.
+var presets = map[string]Preset{
+	"gameboy-photo":   {"palette": "gameboy", "colorspace": "lab", "dither": "bayer", "bay": "4"},
+	"pixel-art":       {"pal": "16", "algo": "popularity", "dither": "none"},
+	"web-gif":         {"pal": "256", "format": "gif", "dither": "floyd-steinberg"},
+	"eink":            {"pal": "16", "grayscale": "true", "dither": "floyd-steinberg"},
+	"thermal-printer": {"bw": "true", "dither": "floyd-steinberg"},
+}
+
+// PresetNames returns the names of the built-in presets, sorted alphabetically.
+func PresetNames() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DefaultPresetFilePath returns the filepath of the user's presets file, e.g. ~/.config/quantize/presets.json on Linux.
+// It is empty if the user configuration directory is unknown.
+func DefaultPresetFilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "quantize", "presets.json")
+}
+
+// GetPresetsFromFilePath reads a JSON presets file, an object mapping the preset names to objects of flag values:
+//
+//	{"team-photo": {"pal": 32, "dither": "floyd-steinberg", "colorspace": "lab"}}
+//
+// The values may be strings, numbers or booleans.
+func GetPresetsFromFilePath(filePath string) (map[string]Preset, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	filePresets := make(map[string]Preset, len(raw))
+	for name, values := range raw {
+		preset := make(Preset, len(values))
+		for flagName, value := range values {
+			switch value.(type) {
+			case string, float64, bool:
+				preset[flagName] = fmt.Sprint(value)
+			default:
+				return nil, fmt.Errorf("preset %q: invalid value of %q", name, flagName)
+			}
+		}
+		filePresets[name] = preset
+	}
+
+	return filePresets, nil
+}
+
+// FindPreset returns the preset of a given name: one of the presets file, if <filePath> is not empty, or a built-in one.
+// A missing presets file is not an error.
+func FindPreset(name, filePath string) (Preset, error) {
+	if filePath != "" {
+		filePresets, err := GetPresetsFromFilePath(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading presets file: %w", err)
+		}
+		if preset, ok := filePresets[name]; ok {
+			return preset, nil
+		}
+	}
+
+	if preset, ok := presets[name]; ok {
+		return preset, nil
+	}
+
+	return nil, fmt.Errorf("unknown preset %q (available: %v, or the presets of %s)", name, PresetNames(), filePath)
+}
+
+// Apply sets the flags of the preset which are not set on the command line yet,
+// so that the command line overrides the preset.
+func (p Preset) Apply(flags *flag.FlagSet) error {
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for name, value := range p {
+		if set[name] {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("preset flag -%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+//
+// 			Profiling.
+//
+
+// StartProfiles starts writing a CPU profile to the file <cpuFilepath>, if not empty, and returns the function
+// which stops it and writes a heap profile to the file <memFilepath>, if not empty, once the processing is done.
+// The profiles are in the pprof format (see "go tool pprof").
+func StartProfiles(cpuFilepath, memFilepath string) (func() error, error) {
+	var cpuFile *os.File
+	if cpuFilepath != "" {
+		var err error
+		if cpuFile, err = os.Create(cpuFilepath); err != nil {
+			return nil, fmt.Errorf("writing CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("writing CPU profile: %w", err)
+		}
+	}
+
+	return func() error {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				return fmt.Errorf("writing CPU profile: %w", err)
+			}
+		}
+		if memFilepath != "" {
+			if err := writeHeapProfile(memFilepath); err != nil {
+				return fmt.Errorf("writing memory profile: %w", err)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// writeHeapProfile writes the allocations of the program to a file, after a garbage collection
+// which brings the statistics up to date.
+func writeHeapProfile(filePath string) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	err = pprof.WriteHeapProfile(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Quantize command.
+//
+
+// The flags of the quantize command are defined and checked by feature (see colorFlags, paletteFlags, ditherFlags,
+// imageFlags and outputFlags), each group setting its part of the quantize.Settings. The flags below choose
+// the files and how the run goes; the combinations of features which do not work together are checked last.
+
+// runQuantize parses the command line flags of the quantize subcommand and processes the images accordingly.
+func runQuantize(args []string) error {
+	return runQuantizeWith(args, nil, nil)
+}
+
+// runQuantizeWith is runQuantize, reading the input files through <inputs> and counting their pixels through <histograms>
+// (nil caches read and count them on each run).
+func runQuantizeWith(args []string, inputs *quantize.InputCache, histograms *quantize.HistogramCache) (err error) {
+	// Setup the command line flags and retrieve their values.
+	flags := flag.NewFlagSet("quantize", flag.ExitOnError)
+	srcFilepath := flags.String("in", "", "input image filepath; \"-\" or empty for the standard input; a directory or a glob pattern for batch processing; a numbered sequence pattern such as frame_%04d.png for sequence processing; also given as the only argument")
+	outFilepath := flags.String("out", "", "output image filepath or filename template ({dir}, {name}, {ext}, {pal}); \"-\" for the standard output; empty for {dir}/{name}.quantized.png, or the standard output for the standard input; a directory or a filename template for batch processing; a directory or a numbered sequence pattern for sequence processing")
+	jobs := flags.Int("jobs", 1, "number of files processed concurrently in batch and sequence modes")
+	sequenceSamples := flags.Int("sequence-samples", quantize.DefaultSequenceSamples, "number of frames, evenly spaced, the palette of an image sequence is generated from; 0 uses every frame")
+	threads := flags.Int("threads", 0, "maximum number of goroutines working on an image; 0 means one per CPU")
+	jsonResult := flags.Bool("json", false, "print a JSON object per image to the standard output: the input and output files, the palette, the algorithms, the timings and the quality metrics")
+	quiet := flags.Bool("quiet", false, "print nothing but errors")
+	verbose := flags.Bool("verbose", false, "print details about the images and the duration of each processing phase")
+	timing := flags.Bool("timing", false, "print the duration of each processing phase of each image (decode, histogram, palette, dither, encode) on one line")
+	cpuProfile := flags.String("cpuprofile", "", "file where a CPU profile of the run is written, in the pprof format")
+	memProfile := flags.String("memprofile", "", "file where a heap profile is written at the end of the run, in the pprof format")
+	sweep := flags.String("sweep", "", "semicolon-separated flags and comma-separated values, e.g. \"pal=4,8,16;dither=bayer8,fs\": write an output file per combination, the -out filepath getting each {flag} replaced by its value")
+	preset := flags.String("preset", "", fmt.Sprintf("named settings, overridden by the flags given: a built-in preset %v or one of the -preset-file", PresetNames()))
+	presetFile := flags.String("preset-file", DefaultPresetFilePath(), "JSON file of user presets")
+	watch := flags.Bool("watch", false, "process the input files again each time they change, until Ctrl+C is pressed")
+	plugins := flags.String("plugin", "", "comma-separated Go plugin files (.so) adding palette generation and dithering algorithms, selected by name with -algo and -dither")
+	colors := addColorFlags(flags)
+	palette := addPaletteFlags(flags)
+	dither := addDitherFlags(flags)
+	img := addImageFlags(flags)
+	output := addOutputFlags(flags)
+	flags.Parse(args)
+
+	// An input file may be given as the only argument, e.g. by dropping it onto the program.
+	if flags.NArg() > 1 || (flags.NArg() == 1 && *srcFilepath != "") {
+		return fmt.Errorf("unexpected argument %q: the input file is given by -in, or as the only argument", flags.Arg(flags.NArg()-1))
+	}
+	if flags.NArg() == 1 {
+		*srcFilepath = flags.Arg(0)
+		// The flags after the input file would not be parsed, e.g. those added by a sweep.
+		rest := args[:len(args)-1]
+		if len(rest) > 0 && rest[len(rest)-1] == "--" {
+			rest = rest[:len(rest)-1]
+		}
+		args = append([]string{"-in=" + *srcFilepath}, rest...)
+	}
+
+	if *plugins != "" {
+		if err := quantize.LoadPlugins(*plugins); err != nil {
+			return err
+		}
+	}
+
+	if *preset != "" {
+		p, err := FindPreset(*preset, *presetFile)
+		if err != nil {
+			return err
+		}
+		if err := p.Apply(flags); err != nil {
+			return err
+		}
+	}
+
+	// The profiles cover the whole run, e.g. every file of a batch or every combination of a sweep.
+	stopProfiles, err := StartProfiles(*cpuProfile, *memProfile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if stopErr := stopProfiles(); err == nil {
+			err = stopErr
+		}
+	}()
+
+	// Without -out, the output files of input files are put next to them (see quantize.DefaultOutputTemplate),
+	// as PNG files unless -format is given; the standard output is then only used for the standard input.
+	if *outFilepath == "" && !quantize.IsStdio(*srcFilepath) && !quantize.IsSequenceInput(*srcFilepath) {
+		*outFilepath = quantize.DefaultOutputTemplate
+		if *output.format == "" {
+			*output.format = "png"
+		}
+	}
+
+	if *watch && (quantize.IsStdio(*srcFilepath) || *sweep != "") {
+		return fmt.Errorf("-watch needs input files, and cannot be combined with -sweep")
+	}
+
+	// A sweep runs the flags again for each of its combinations.
+	if *sweep != "" {
+		return runSweep(args, flags, *sweep, *srcFilepath, *outFilepath)
+	}
+
+	multiple := quantize.IsBatchInput(*srcFilepath) || quantize.IsSequenceInput(*srcFilepath)
+	settings := quantize.Settings{
+		Inputs:  inputs,
+		Verbose: *verbose,
+		Timing:  *timing,
+		JSON:    *jsonResult,
+		Quiet:   *quiet,
+		// A live progress bar is drawn on terminals, unless several files are processed at once.
+		ProgressBar: !*quiet && quantize.IsTerminal(os.Stderr) && (*jobs <= 1 || !multiple),
+	}
+	settings.Palette.Histograms = histograms
+	settings.Dither.Threads = *threads
+	for _, apply := range []func(*quantize.Settings) error{colors.apply, palette.apply, dither.apply, img.apply, output.apply, checkCombinations} {
+		if err := apply(&settings); err != nil {
+			return err
+		}
+	}
+
+	if *jsonResult && !multiple && quantize.IsStdio(*outFilepath) {
+		return fmt.Errorf("-json writes to the standard output, which cannot also receive the output image")
+	}
+
+	// Stop the processing on Ctrl+C.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// <changed> lists the files to process again in watch mode; nil means all of them.
+	run := func(changed []string) error {
+		// The frames of an image sequence share one palette.
+		if quantize.IsSequenceInput(*srcFilepath) {
+			return quantize.ProcessSequence(ctx, *srcFilepath, *outFilepath, settings, *jobs, *sequenceSamples)
+		}
+
+		// Several input files are processed in batch mode.
+		if quantize.IsBatchInput(*srcFilepath) {
+			if changed != nil {
+				return quantize.ProcessBatchFiles(ctx, changed, *outFilepath, settings, *jobs)
+			}
+			return quantize.ProcessBatch(ctx, *srcFilepath, *outFilepath, settings, *jobs)
+		}
+
+		// The output filepath of a single file may be a template too.
+		fileSettings := settings
+		if fileSettings.Format == "" && strings.Contains(*outFilepath, "{ext}") {
+			var err error
+			if fileSettings.Format, err = quantize.OutputFormat(*srcFilepath, *outFilepath); err != nil {
+				return err
+			}
+		}
+		return quantize.ProcessFile(ctx, *srcFilepath, quantize.ExpandOutputTemplate(*srcFilepath, *outFilepath, fileSettings.Format, settings.PaletteSizeName()), fileSettings)
+	}
+	if *watch {
+		return quantize.Watch(ctx, func() ([]string, error) { return quantize.WatchedFiles(*srcFilepath) }, run, func(format string, args ...interface{}) {
+			if !settings.Quiet {
+				fmt.Fprintf(os.Stderr, format+"\n", args...)
+			}
+		})
+	}
+
+	return run(nil)
+}
+
+// checkCombinations returns an error if the settings combine features which do not work together,
+// e.g. the streaming mode, which does not keep the output image, with the reports made from it.
+func checkCombinations(s *quantize.Settings) error {
+	if s.Stream && (s.Report || s.ReportJSON || s.TargetDeltaE > 0 || s.Compare != "" || s.Preview || s.Stats || s.StatsJSON || s.StatsStrip != "") {
+		return fmt.Errorf("-report, -target-de, -compare, -preview and -stats cannot be combined with -stream, which does not keep the output image")
+	}
+	if s.Stream && s.ScaleUp > 1 {
+		return fmt.Errorf("-scale-up cannot be combined with -stream")
+	}
+	tiled := s.Tiles != nil
+	if (s.Despeckle || s.Outline > 0) && (s.Stream || tiled) {
+		return fmt.Errorf("-despeckle and -outline cannot be combined with -stream, -tiles or -grid")
+	}
+	if s.Wrap && (s.Stream || tiled) {
+		return fmt.Errorf("-wrap cannot be combined with -stream, -tiles or -grid")
+	}
+	if s.Posterize > 0 && (s.Stream || s.TargetDeltaE > 0 || tiled) {
+		return fmt.Errorf("-posterize cannot be combined with -stream, -target-de, -tiles or -grid")
+	}
+	if tiled && (s.Stream || s.TargetDeltaE > 0) {
+		return fmt.Errorf("-tiles and -grid cannot be combined with -stream or -target-de")
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package quantize
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+)
+
+//
+// 			Adaptive ordered dithering.
+//
+
+// The pattern of an ordered dithering is most visible in the flat areas, where a few pixels flip between two palette
+// colors on a regular grid, and the least needed there. The adaptive Bayer dithering scales the offsets of each pixel
+// by the local contrast around it: the standard deviation of the luma in a small window. The flat areas get
+// weak offsets, and come out clean, while the edges and the gradients keep strong ones, which break the banding.
+
+// Default settings of the AdaptiveBayerDitherer.
+const (
+	DefaultAdaptiveRadius      = 3
+	DefaultAdaptiveMinStrength = 0.25
+	DefaultAdaptiveMaxStrength = 1.25
+	DefaultAdaptiveContrast    = 4.
+)
+
+// AdaptiveBayerDitherer applies ordered dithering with a Bayer matrix, as BayerDitherer does, with offsets
+// scaled by the local contrast of each pixel (see LocalContrast): from MinStrength for a standard deviation of 0
+// up to MaxStrength for a standard deviation of Contrast and more. The zero values of these settings
+// and of Radius mean their defaults.
+type AdaptiveBayerDitherer struct {
+	BayerDitherer
+	// Radius is the radius of the square window the local contrast is measured in.
+	Radius int
+	// MinStrength and MaxStrength bound the factor of the offsets.
+	MinStrength, MaxStrength float64
+	// Contrast is the standard deviation of the luma, in [0, 255], which gets the MaxStrength factor.
+	Contrast float64
+}
+
+// Dither implements the Ditherer interface.
+func (d AdaptiveBayerDitherer) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	out, _ := d.DitherContext(context.Background(), img, palette)
+	return out
+}
+
+// ForFrame implements the FrameDitherer interface.
+func (d AdaptiveBayerDitherer) ForFrame(frame int) Ditherer {
+	d.Offset = d.Offset.Add(TemporalOffset(frame))
+	return d
+}
+
+// DitherContext implements the ContextDitherer interface.
+func (d AdaptiveBayerDitherer) DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	out := image.NewPaletted(img.Bounds(), ColorPalette(palette))
+	if err := d.DitherInto(ctx, out, img, palette); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// DitherInto implements the IntoDitherer interface.
+func (d AdaptiveBayerDitherer) DitherInto(ctx context.Context, out *image.Paletted, img image.Image, palette []color.RGBA) error {
+	matrix, err := BayerMatrix(d.MatSize)
+	if err != nil {
+		return err
+	}
+	radius, minStrength, maxStrength, contrast := d.Radius, d.MinStrength, d.MaxStrength, d.Contrast
+	if radius <= 0 {
+		radius = DefaultAdaptiveRadius
+	}
+	if minStrength == 0 && maxStrength == 0 {
+		minStrength, maxStrength = DefaultAdaptiveMinStrength, DefaultAdaptiveMaxStrength
+	}
+	if contrast <= 0 {
+		contrast = DefaultAdaptiveContrast
+	}
+
+	index := d.Indexes.Index(palette, d.Metric)
+	offsetPixel := offsetFunc(d.Linear, d.Luminance, d.Lab)
+	strength := d.Strength.orFull()
+	rows := NewRowProgress(d.Progress, "dither", img.Bounds().Dy())
+
+	// Each band measures the contrast of its own rows, reading the rows around it.
+	return ParallelRowsContext(ctx, img.Bounds(), d.Threads, func(minY, maxY int) {
+		deviations := LocalContrast(img, minY, maxY, radius)
+		width := img.Bounds().Dx()
+		row := make([]color.RGBA, width)
+		for y := minY; y < maxY; y++ {
+			for i := range row {
+				x := img.Bounds().Min.X + i
+				t := ClampF64(deviations[(y-minY)*width+i]/contrast, 0., 1.)
+				k := minStrength + (maxStrength-minStrength)*t
+				pixelStrength := DitherStrength{strength[0] * k, strength[1] * k, strength[2] * k}
+				row[i] = offsetPixel(PixelColor(img, x, y), matrix.Coefficient(d.Orientation.Point(x+d.Offset.X, y+d.Offset.Y)), len(palette), pixelStrength)
+			}
+			writeNearestRow(out, y, row, index)
+		}
+		rows.Done(maxY - minY)
+	})
+}
+
+// LocalContrast returns the standard deviation of the luma, in [0, 255], in the square window of radius <radius>
+// around each pixel of the rows [minY, maxY) of an image, row by row. The window is cut at the image borders.
+// The sums over the windows are computed incrementally: horizontally along each row, then vertically.
+func LocalContrast(img image.Image, minY, maxY, radius int) []float64 {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	top, bottom := ClampBelowInt(minY-radius, bounds.Min.Y), ClampAboveInt(maxY+radius, bounds.Max.Y)
+
+	// Horizontal window sums of the luma and of its square, and their number of pixels, for the rows [top, bottom).
+	sums := make([]float64, (bottom-top)*width)
+	squares := make([]float64, (bottom-top)*width)
+	counts := make([]int, width)
+	lumas := make([]float64, width)
+	for y := top; y < bottom; y++ {
+		for i := range lumas {
+			c := PixelColor(img, bounds.Min.X+i, y)
+			lumas[i] = luma(float64(c.R), float64(c.G), float64(c.B))
+		}
+		var sum, square float64
+		for i := 0; i < width+radius; i++ {
+			if i < width {
+				sum += lumas[i]
+				square += lumas[i] * lumas[i]
+			}
+			if out := i - 2*radius - 1; out >= 0 {
+				sum -= lumas[out]
+				square -= lumas[out] * lumas[out]
+			}
+			if center := i - radius; center >= 0 {
+				sums[(y-top)*width+center], squares[(y-top)*width+center] = sum, square
+				if y == top {
+					counts[center] = ClampAboveInt(i, width-1) - ClampBelowInt(center-radius, 0) + 1
+				}
+			}
+		}
+	}
+
+	deviations := make([]float64, (maxY-minY)*width)
+	for i := 0; i < width; i++ {
+		var sum, square float64
+		for y := top; y < bottom+radius; y++ {
+			if y < bottom {
+				sum += sums[(y-top)*width+i]
+				square += squares[(y-top)*width+i]
+			}
+			if out := y - 2*radius - 1; out >= top {
+				sum -= sums[(out-top)*width+i]
+				square -= squares[(out-top)*width+i]
+			}
+			center := y - radius
+			if center < minY || center >= maxY {
+				continue
+			}
+			n := float64(counts[i] * (ClampAboveInt(y, bottom-1) - ClampBelowInt(center-radius, top) + 1))
+			mean := sum / n
+			deviations[(center-minY)*width+i] = math.Sqrt(math.Max(square/n-mean*mean, 0))
+		}
+	}
+
+	return deviations
+}
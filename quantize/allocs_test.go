@@ -0,0 +1,32 @@
+// The race detector makes sync.Pool drop buffers at random, hence the allocations.
+//go:build !race
+
+package quantize_test
+
+import (
+	"image"
+	"testing"
+
+	"image-quantization/quantize"
+)
+
+func TestToPalettedAllocs(t *testing.T) {
+	img := gradient()
+	for _, dither := range []string{"none", quantize.Bayer, quantize.FloydSteinberg} {
+		p, err := quantize.NewProcessor(quantize.WithPaletteSize(8), quantize.WithDither(dither), quantize.WithThreads(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := image.NewPaletted(img.Bounds(), quantize.ColorPalette(p.Palette(img)))
+
+		// The first call builds the palette index and the buffers; the next ones reuse them.
+		allocs := testing.AllocsPerRun(20, func() {
+			if err := p.ToPaletted(dst, img); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs > 3 {
+			t.Errorf("%s: %v allocations per call, want at most 3", dither, allocs)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package quantize
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+//
+// 			Transparency functions.
+//
+
+// The colors handled by this program are premultiplied by their alpha value, as color.RGBA requires.
+// Unless the palette is generated in the 4D RGBA space (PaletteOptions.Alpha4D), the pixels
+// are either transparent or opaque:
+//   - the pixels whose alpha is below PaletteOptions.AlphaThreshold get a single fully transparent palette entry,
+//   - the other pixels are made opaque (see Opaque) before being quantized.
+
+// TransparentColor is the palette entry given to the transparent pixels.
+var TransparentColor = color.RGBA{0, 0, 0, 0}
+
+// IsTransparent reports whether a color is transparent with respect to an alpha threshold.
+func IsTransparent(c color.RGBA, alphaThreshold uint8) bool {
+	return c.A < alphaThreshold
+}
+
+// Opaque returns the opaque version of a premultiplied color.
+// A fully transparent color becomes opaque black.
+func Opaque(c color.RGBA) color.RGBA {
+	if c.A == 0 {
+		return color.RGBA{0, 0, 0, 255}
+	}
+
+	a := uint32(c.A)
+	return color.RGBA{
+		uint8(ClampAboveInt(int(uint32(c.R)*255/a), 255)),
+		uint8(ClampAboveInt(int(uint32(c.G)*255/a), 255)),
+		uint8(ClampAboveInt(int(uint32(c.B)*255/a), 255)),
+		255,
+	}
+}
+
+// TransparencyThreshold returns the alpha value below which pixels may be transparent in the result of ApplyPalette:
+// opts.AlphaThreshold, or 255 if the alpha channel is dithered (see PaletteOptions.AlphaDither).
+func (opts PaletteOptions) TransparencyThreshold() uint8 {
+	if opts.AlphaDither != nil && opts.AlphaThreshold > 0 {
+		return 255
+	}
+
+	return opts.AlphaThreshold
+}
+
+// HasTransparentPixels reports whether an image contains pixels whose alpha is below <alphaThreshold>.
+func HasTransparentPixels(img image.Image, alphaThreshold uint8) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return false
+	}
+
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			if IsTransparent(PixelColor(img, x, y), alphaThreshold) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// PalettePixels collects the pixels colors of an image which take part in the palette generation,
+// among the pixels selected by opts.Sampling.
+// Transparent pixels are left out and, unless opts.Alpha4D is set, the other ones are made opaque.
+// In grayscale mode, they are converted to gray too.
+// The pixels are repeated opts.Weights times, if set; the pixels of the opts.Keep colors are left out.
+func PalettePixels(img image.Image, opts PaletteOptions) []color.RGBA {
+	var pixels []color.RGBA
+	kept := colorSet(opts.Keep)
+	opts.Sampling.EachAt(img, func(x, y int, c color.RGBA) {
+		if c, ok := paletteColor(c, opts); ok && !kept[c] {
+			for n := pixelWeight(opts.Weights, x, y); n > 0; n-- {
+				pixels = append(pixels, c)
+			}
+		}
+	})
+
+	return pixels
+}
+
+// paletteColor returns a pixel color as seen by the palette generation (see PalettePixels),
+// or false if the pixel is transparent.
+func paletteColor(c color.RGBA, opts PaletteOptions) (color.RGBA, bool) {
+	if IsTransparent(c, opts.AlphaThreshold) {
+		return c, false
+	}
+	if !opts.Alpha4D {
+		c = Opaque(c)
+	}
+	if opts.Grayscale {
+		c = Gray(c, opts.Linear)
+	}
+
+	return c, true
+}
+
+// KeepColors returns the opts.Keep colors followed by the colors of a palette generated by <generate>
+// with the remaining slots, without opts.Keep, leaving out the colors already kept.
+func KeepColors(paletteMaxSize int, opts PaletteOptions, generate func(size int, opts PaletteOptions) []color.RGBA) []color.RGBA {
+	keep := opts.Keep
+	if len(keep) >= paletteMaxSize {
+		return keep
+	}
+
+	opts.Keep = nil
+	kept := colorSet(keep)
+	palette := append([]color.RGBA(nil), keep...)
+	for _, c := range generate(paletteMaxSize-len(keep), opts) {
+		if !kept[c] {
+			palette = append(palette, c)
+			kept[c] = true
+		}
+	}
+
+	return palette
+}
+
+// keepExactColors maps the pixels of the opts.Keep colors of an image, as PalettePixels sees them,
+// to these colors in a dithered image, whatever the dithering offsets.
+func keepExactColors(img image.Image, out *image.Paletted, palette []color.RGBA, opts PaletteOptions) {
+	kept := colorSet(opts.Keep)
+	indices := map[color.RGBA]uint8{}
+	for i := len(palette) - 1; i >= 0; i-- {
+		if kept[palette[i]] {
+			indices[palette[i]] = uint8(i)
+		}
+	}
+
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			if c, ok := paletteColor(PixelColor(img, x, y), opts); ok {
+				if i, ok := indices[c]; ok {
+					out.SetColorIndex(x, y, i)
+				}
+			}
+		}
+	}
+}
+
+// colorSet returns the set of some colors.
+func colorSet(colors []color.RGBA) map[color.RGBA]bool {
+	set := make(map[color.RGBA]bool, len(colors))
+	for _, c := range colors {
+		set[c] = true
+	}
+
+	return set
+}
+
+// FlattenImage composites an image over an opaque background color (a matte), so that it has no transparent pixels,
+// e.g. for output formats or palettes which cannot hold transparency.
+func FlattenImage(img image.Image, background color.RGBA) *image.RGBA {
+	background = Opaque(background)
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			// The colors are premultiplied, so the background only has to be added in the proportion left by the alpha.
+			c := PixelColor(img, x, y)
+			rest := uint32(255 - c.A)
+			out.SetRGBA(x, y, color.RGBA{
+				c.R + uint8((uint32(background.R)*rest+127)/255),
+				c.G + uint8((uint32(background.G)*rest+127)/255),
+				c.B + uint8((uint32(background.B)*rest+127)/255),
+				255,
+			})
+		}
+	}
+
+	return out
+}
+
+// opaqueImage shows an image with all its pixels made opaque.
+type opaqueImage struct {
+	image.Image
+}
+
+func (opaqueImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (img opaqueImage) At(x, y int) color.Color {
+	return Opaque(PixelColor(img.Image, x, y))
+}
+
+// alphaImage shows the alpha channel of an image as gray levels, the pixels below an alpha threshold being black.
+type alphaImage struct {
+	image.Image
+	threshold uint8
+}
+
+func (alphaImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (img alphaImage) At(x, y int) color.Color {
+	a := PixelColor(img.Image, x, y).A
+	if IsTransparent(color.RGBA{A: a}, img.threshold) {
+		a = 0
+	}
+
+	return color.RGBA{a, a, a, 255}
+}
+
+// alphaPalette is the palette the alpha channel is dithered with: transparent, then opaque.
+var alphaPalette = []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}}
+
+// ApplyPalette dithers an image with a palette generated by PaletteFromImage (or PaletteFromPixels)
+// and the same options.
+// If the image has transparent pixels, TransparentColor is appended to the palette of the result
+// and given to them; with opts.AlphaDither, the translucent pixels are dithered between it and their color.
+// An error is returned if the palette is empty, or too big to hold the transparent color.
+// The dithering stops early, returning ctx.Err(), if <ctx> is canceled (see DitherContext).
+func ApplyPalette(ctx context.Context, img image.Image, palette []color.RGBA, opts PaletteOptions, ditherer Ditherer) (*image.Paletted, error) {
+	transparent := HasTransparentPixels(img, opts.TransparencyThreshold())
+	if len(palette) == 0 {
+		return nil, fmt.Errorf("the palette is empty")
+	}
+	if len(palette) > MaxPaletteSize || (transparent && len(palette) == MaxPaletteSize) {
+		return nil, fmt.Errorf("the palette has too many colors (%d) to map a %s image",
+			len(palette), map[bool]string{false: "opaque", true: "transparent"}[transparent])
+	}
+
+	src := img
+	if !opts.Alpha4D {
+		src = opaqueImage{img}
+	}
+	if opts.Grayscale {
+		src = grayImage{src, opts.Linear}
+	}
+
+	// An image whose colors are all in the palette needs no dithering.
+	out, ok := (*image.Paletted)(nil), false
+	if opts.Exact {
+		out, ok = ExactPaletted(img, palette, opts)
+	}
+	if !ok {
+		var err error
+		out, err = DitherContext(ctx, ditherer, src, palette)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Keep != nil {
+		keepExactColors(img, out, palette, opts)
+	}
+
+	if transparent {
+		out.Palette = append(out.Palette, TransparentColor)
+		t := uint8(len(out.Palette) - 1)
+
+		// The dithered alpha channel tells the transparent pixels by its first palette color.
+		var alpha *image.Paletted
+		if opts.AlphaDither != nil {
+			var err error
+			alpha, err = DitherContext(ctx, opts.AlphaDither, alphaImage{img, opts.AlphaThreshold}, alphaPalette)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+			for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+				if alpha != nil && alpha.ColorIndexAt(x, y) == 0 || alpha == nil && IsTransparent(PixelColor(img, x, y), opts.AlphaThreshold) {
+					out.SetColorIndex(x, y, t)
+				}
+			}
+		}
+	}
+
+	return out, nil
+}
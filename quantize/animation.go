@@ -0,0 +1,163 @@
+package quantize
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+)
+
+//
+// 			Animated GIF functions.
+//
+
+// GIF palette modes, see TransformGIF.
+const (
+	// GIFPaletteGlobal makes all the frames share a single palette computed from every frame.
+	GIFPaletteGlobal = "global"
+	// GIFPaletteLocal gives each frame its own palette computed from that frame only.
+	GIFPaletteLocal = "local"
+)
+
+// AnimationOptions are the options of the quantization of the frames of an animation.
+type AnimationOptions struct {
+	// PaletteMode is either GIFPaletteGlobal or GIFPaletteLocal; empty means GIFPaletteGlobal.
+	PaletteMode string
+	// TemporalOffset makes the thresholds of the ordered ditherers move from frame to frame (see FrameDitherer).
+	TemporalOffset bool
+	// Reuse, if positive, makes the pixels whose channels changed by at most Reuse since the previous frame
+	// keep their palette color (see ReuseUnchangedPixels).
+	Reuse int
+}
+
+// frameDitherer returns the ditherer of a frame, counted from 0.
+func (a AnimationOptions) frameDitherer(ditherer Ditherer, frame int) Ditherer {
+	if fd, ok := ditherer.(FrameDitherer); ok && a.TemporalOffset {
+		return fd.ForFrame(frame)
+	}
+
+	return ditherer
+}
+
+// ReuseUnchangedPixels gives the pixels of a quantized frame whose source color changed by at most <threshold>
+// in every channel since the previous frame the palette color of the previous quantized frame, if the palette
+// of the frame has it at the same index. Static areas then keep their dithering pattern instead of shimmering,
+// as the error diffusion makes them do when the rest of the frame changes.
+// The frames are compared over the intersection of their bounds.
+func ReuseUnchangedPixels(prevSrc, src image.Image, prevOut, out *image.Paletted, threshold int) {
+	// same tells whether the palette color of an index of the previous frame is at the same index in the frame.
+	same := make([]bool, len(prevOut.Palette))
+	for i := range same {
+		same[i] = i < len(out.Palette) && samePalette(prevOut.Palette[i:i+1], out.Palette[i:i+1])
+	}
+
+	near := func(a, b uint8) bool { return int(a)-int(b) <= threshold && int(b)-int(a) <= threshold }
+	r := src.Bounds().Intersect(prevSrc.Bounds()).Intersect(out.Bounds()).Intersect(prevOut.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c, p := PixelColor(src, x, y), PixelColor(prevSrc, x, y)
+			if !near(c.R, p.R) || !near(c.G, p.G) || !near(c.B, p.B) || !near(c.A, p.A) {
+				continue
+			}
+			if i := prevOut.ColorIndexAt(x, y); int(i) < len(same) && same[i] {
+				out.SetColorIndex(x, y, i)
+			}
+		}
+	}
+}
+
+// TransformGIF quantizes and dithers every frame of an animated GIF.
+// Frame delays, disposal methods and the loop count are preserved.
+// The original animation is not modified; a new one is created and returned.
+// The processing stops early, returning ctx.Err(), if <ctx> is canceled.
+func TransformGIF(ctx context.Context, g *gif.GIF, paletteMaxSize int, paletteOpts PaletteOptions, ditherer Ditherer, anim AnimationOptions) (*gif.GIF, error) {
+	out := &gif.GIF{
+		Delay:     append([]int(nil), g.Delay...),
+		Disposal:  append([]byte(nil), g.Disposal...),
+		LoopCount: g.LoopCount,
+		Config: image.Config{
+			Width:  g.Config.Width,
+			Height: g.Config.Height,
+		},
+	}
+
+	// In global mode, the palette is computed once from the pixels of all the frames
+	// and it is written as the GIF global color table.
+	// A palette entry is reserved for the transparent pixels if some frames have some.
+	var palette []color.RGBA
+	if anim.PaletteMode != GIFPaletteLocal {
+		frames := make([]image.Image, len(g.Image))
+		transparent := false
+		for i, frame := range g.Image {
+			frames[i] = frame
+			transparent = transparent || HasTransparentPixels(frame, paletteOpts.TransparencyThreshold())
+		}
+		palette = PaletteFromImages(frames, paletteMaxSize, paletteOpts)
+
+		globalPalette := ColorPalette(palette)
+		if transparent {
+			globalPalette = append(globalPalette, TransparentColor)
+		}
+		out.Config.ColorModel = globalPalette
+	}
+
+	for i, frame := range g.Image {
+		framePalette := palette
+		if anim.PaletteMode == GIFPaletteLocal {
+			framePalette = PaletteFromImage(frame, paletteMaxSize, paletteOpts)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		outFrame, err := ApplyPalette(ctx, frame, framePalette, paletteOpts, anim.frameDitherer(ditherer, i))
+		if err != nil && err == ctx.Err() {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", len(out.Image), err)
+		}
+		if anim.Reuse > 0 && i > 0 {
+			ReuseUnchangedPixels(g.Image[i-1], frame, out.Image[i-1], outFrame, anim.Reuse)
+		}
+		out.Image = append(out.Image, outFrame)
+	}
+
+	return out, nil
+}
+
+// GetGIFFromFilePath returns all the frames of a GIF file.
+// The standard input is read if IsStdio(filePath).
+func GetGIFFromFilePath(filePath string) (*gif.GIF, error) {
+	data, err := ReadInputFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeGIF(data)
+}
+
+// DecodeGIF decodes all the frames of a GIF from its encoded content.
+func DecodeGIF(data []byte) (*gif.GIF, error) {
+	return gif.DecodeAll(bytes.NewReader(data))
+}
+
+// WriteGIFToFile saves an animated GIF to a file.
+// The animation is written to the standard output if IsStdio(filepath).
+func WriteGIFToFile(g *gif.GIF, filepath string) error {
+	outputFile, err := CreateOutputFile(filepath)
+	if err != nil {
+		return err
+	}
+
+	err = gif.EncodeAll(outputFile, g)
+
+	// Don't forget to close files
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
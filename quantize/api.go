@@ -0,0 +1,280 @@
+package quantize
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//
+// 			In-memory API.
+//
+
+// SettingsFromParams returns the processing settings and the output format given by named parameters,
+// e.g. the query parameters of an HTTP request. The parameters are:
+//   - pal: the maximum size of the palette (4 by default);
+//   - algo: the palette generation algorithm (see NewQuantizer);
+//   - dither: the dithering algorithm (see NewDitherer), bay: the Bayer matrix size;
+//   - colorspace and metric: the color metric (see NewColorMetric);
+//   - format: the format of the output image (see FormatNames), PNG by default.
+//
+// The other settings have the default values of the command line flags.
+// <threads> is the maximum number of goroutines working on an image; 0 means one per CPU.
+func SettingsFromParams(query url.Values, threads int) (Settings, string, error) {
+	param := func(name, fallback string) string {
+		if value := query.Get(name); value != "" {
+			return value
+		}
+		return fallback
+	}
+
+	paletteMaxSize, err := strconv.Atoi(param("pal", "4"))
+	if err != nil || paletteMaxSize < 1 || paletteMaxSize > MaxPaletteSize {
+		return Settings{}, "", fmt.Errorf("invalid palette size %q (1 to %d)", query.Get("pal"), MaxPaletteSize)
+	}
+	bayerMatSize, err := strconv.Atoi(param("bay", "4"))
+	if err != nil {
+		return Settings{}, "", fmt.Errorf("invalid Bayer matrix size %q", query.Get("bay"))
+	}
+
+	settings, err := NewSettings(
+		WithPaletteSize(paletteMaxSize),
+		WithAlgorithm(param("algo", "mediancut")),
+		WithDither(param("dither", Bayer)),
+		WithBayerSize(bayerMatSize),
+		WithColorSpace(query.Get("colorspace")),
+		WithMetric(query.Get("metric")),
+		WithThreads(threads),
+	)
+	if err != nil {
+		return Settings{}, "", err
+	}
+
+	format := strings.ToLower(param("format", "png"))
+	if _, ok := encoders[format]; !ok {
+		return Settings{}, "", unknownFormatError(format)
+	}
+	settings.Format = format
+
+	return settings, format, nil
+}
+
+// QuantizeImageData quantizes and dithers an encoded image with given settings
+// and returns the result encoded in <format> (see FormatNames).
+// Unlike ProcessFile, it neither reads nor writes files.
+func QuantizeImageData(ctx context.Context, data []byte, settings Settings, format string) ([]byte, error) {
+	encode, ok := encoders[format]
+	if !ok {
+		return nil, unknownFormatError(format)
+	}
+
+	img, err := DecodeImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	ditherer, err := settings.newDitherer()
+	if err != nil {
+		return nil, err
+	}
+	out, err := QuantizeImageContext(ctx, img, settings.PaletteMaxSize, settings.Palette, ditherer)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, out); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+//
+// 			Functional options.
+//
+
+// Names of common dithering algorithms and color spaces, for WithDither and WithColorSpace.
+const (
+	NoDither       = "none"
+	Bayer          = "bayer"
+	FloydSteinberg = "floyd-steinberg"
+
+	RGB   = "rgb"
+	Lab   = "lab"
+	OKLab = "oklab"
+	OKLCH = "oklch"
+	YCbCr = "ycbcr"
+	HSV   = "hsv"
+	HSL   = "hsl"
+)
+
+// Options gathers the settings of the library entry points (see Image).
+// They are set by Option functions, so that new settings do not change the signatures of the functions.
+type Options struct {
+	// PaletteSize is the maximum size of the palette.
+	PaletteSize int
+	// Algorithm is the name of the palette generation algorithm (see NewQuantizer).
+	Algorithm string
+	// Palette, if not nil, is used instead of generating one.
+	Palette []color.RGBA
+	// Dither is the name of the dithering algorithm (see NewDitherer); BayerSize is the size of its Bayer matrix.
+	Dither    string
+	BayerSize int
+	// ColorSpace and Metric select the color metric (see NewColorMetric); empty means the default one.
+	ColorSpace, Metric string
+	// Linear makes the colors averaged and the dithering offsets applied in linear light.
+	Linear bool
+	// Threads is the maximum number of goroutines working on an image; 0 means one per CPU.
+	Threads int
+	// Progress is notified of the progress of the processing, if not nil.
+	Progress ProgressFunc
+}
+
+// DefaultOptions returns the default options, which are the defaults of the command line flags:
+// a palette of 4 colors generated by median cut, and a 4x4 Bayer dithering in linear light.
+func DefaultOptions() Options {
+	return Options{PaletteSize: 4, Algorithm: "mediancut", Dither: Bayer, BayerSize: 4, Linear: true}
+}
+
+// Option changes an option of the library entry points.
+type Option func(*Options)
+
+// WithPaletteSize sets the maximum size of the palette.
+func WithPaletteSize(size int) Option {
+	return func(o *Options) { o.PaletteSize = size }
+}
+
+// WithAlgorithm sets the palette generation algorithm, e.g. "mediancut" (see QuantizerNames).
+func WithAlgorithm(name string) Option {
+	return func(o *Options) { o.Algorithm = name }
+}
+
+// WithPalette sets the palette used instead of generating one.
+func WithPalette(palette []color.RGBA) Option {
+	return func(o *Options) { o.Palette = palette }
+}
+
+// WithDither sets the dithering algorithm, e.g. FloydSteinberg (see DithererNames).
+func WithDither(name string) Option {
+	return func(o *Options) { o.Dither = name }
+}
+
+// WithBayerSize sets the size of the Bayer matrix, a power of two (see CheckBayerMatSize).
+func WithBayerSize(size int) Option {
+	return func(o *Options) { o.BayerSize = size }
+}
+
+// WithColorSpace sets the color space of the nearest color search, e.g. Lab.
+func WithColorSpace(name string) Option {
+	return func(o *Options) { o.ColorSpace = name }
+}
+
+// WithMetric sets the color distance of the nearest color search: "euclidean", "de76" or "de2000".
+func WithMetric(name string) Option {
+	return func(o *Options) { o.Metric = name }
+}
+
+// WithLinear sets whether the colors are averaged and dithered in linear light.
+func WithLinear(linear bool) Option {
+	return func(o *Options) { o.Linear = linear }
+}
+
+// WithThreads sets the maximum number of goroutines working on an image; 0 means one per CPU.
+func WithThreads(threads int) Option {
+	return func(o *Options) { o.Threads = threads }
+}
+
+// WithProgress sets the function notified of the progress of the processing.
+func WithProgress(progress ProgressFunc) Option {
+	return func(o *Options) { o.Progress = progress }
+}
+
+// NewSettings returns the processing settings given by options applied over DefaultOptions.
+// The settings which have no option have the default values of the command line flags.
+// An error is returned if an option is invalid, e.g. an unknown algorithm name.
+func NewSettings(opts ...Option) (Settings, error) {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.PaletteSize < 1 || o.PaletteSize > MaxPaletteSize {
+		return Settings{}, fmt.Errorf("invalid palette size %d (1 to %d)", o.PaletteSize, MaxPaletteSize)
+	}
+	quantizer, err := NewQuantizer(o.Algorithm)
+	if err != nil {
+		return Settings{}, err
+	}
+	metric, err := NewColorMetric(o.ColorSpace, o.Metric)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	if o.Dither == "bayer" || o.Dither == "ordered" || o.Dither == "adaptive-bayer" {
+		if err := CheckBayerMatSize(o.BayerSize); err != nil {
+			return Settings{}, err
+		}
+	}
+	ditherOpts := DitherOptions{BayerMatSize: o.BayerSize, Threads: o.Threads, Metric: metric, Linear: o.Linear, Progress: o.Progress}
+	if _, err := NewDitherer(o.Dither, ditherOpts); err != nil {
+		return Settings{}, err
+	}
+
+	settings := Settings{
+		PaletteMaxSize: o.PaletteSize,
+		Palette: PaletteOptions{
+			Quantizer:      quantizer,
+			Metric:         metric,
+			Linear:         o.Linear,
+			AlphaThreshold: 1,
+			HistogramBits:  6,
+			Exact:          true,
+			Refine:         true,
+			Fixed:          o.Palette,
+			Progress:       o.Progress,
+		},
+		Algorithm:  o.Algorithm,
+		DitherName: o.Dither,
+		Dither:     ditherOpts,
+	}
+
+	return settings, nil
+}
+
+// Image quantizes and dithers an image with options applied over DefaultOptions; it is the entry point of the library, e.g.
+//
+//	quantize.Image(img, quantize.WithPaletteSize(16), quantize.WithDither(quantize.FloydSteinberg))
+func Image(img image.Image, opts ...Option) (*image.Paletted, error) {
+	return ImageContext(context.Background(), img, opts...)
+}
+
+// ToPaletted maps and dithers an image into a paletted image with options applied over DefaultOptions,
+// using its palette (see Processor.ToPaletted). Callers converting frame after frame should keep a Processor instead,
+// which keeps the palette indices between the calls.
+func ToPaletted(dst *image.Paletted, src image.Image, opts ...Option) error {
+	p, err := NewProcessor(opts...)
+	if err != nil {
+		return err
+	}
+
+	return p.ToPaletted(dst, src)
+}
+
+// ImageContext is Image, but it can be canceled through <ctx> (see QuantizeImageContext).
+func ImageContext(ctx context.Context, img image.Image, opts ...Option) (*image.Paletted, error) {
+	settings, err := NewSettings(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ditherer, err := settings.newDitherer()
+	if err != nil {
+		return nil, err
+	}
+
+	return QuantizeImageContext(ctx, img, settings.PaletteMaxSize, settings.Palette, ditherer)
+}
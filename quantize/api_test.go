@@ -0,0 +1,83 @@
+package quantize_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"image-quantization/quantize"
+)
+
+// gradient returns a 32x32 image whose red and green channels grow along x and y.
+func gradient() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 8), uint8(y * 8), 128, 255})
+		}
+	}
+
+	return img
+}
+
+func TestImage(t *testing.T) {
+	img := gradient()
+	out, err := quantize.Image(img, quantize.WithPaletteSize(4), quantize.WithDither(quantize.FloydSteinberg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds() != img.Bounds() {
+		t.Errorf("bounds %v, want %v", out.Bounds(), img.Bounds())
+	}
+	if len(out.Palette) > 4 {
+		t.Errorf("%d palette colors, want at most 4", len(out.Palette))
+	}
+}
+
+// fixedQuantizer is a palette generation algorithm defined outside the package.
+type fixedQuantizer []color.RGBA
+
+func (q fixedQuantizer) Palette(pixels []color.RGBA, size int, opts quantize.PaletteOptions) []color.RGBA {
+	return q
+}
+
+func TestRegisterQuantizer(t *testing.T) {
+	palette := fixedQuantizer{{0, 0, 0, 255}, {255, 255, 255, 255}}
+	quantize.RegisterQuantizer("test-fixed", func() quantize.Quantizer { return palette })
+
+	out, err := quantize.Image(gradient(), quantize.WithAlgorithm("test-fixed"), quantize.WithPaletteSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range out.Palette {
+		if c != palette[0] && c != palette[1] {
+			t.Errorf("palette color %v not generated by the registered quantizer", c)
+		}
+	}
+}
+
+func TestProcessor(t *testing.T) {
+	p, err := quantize.NewProcessor(quantize.WithPaletteSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := gradient()
+	palette := p.Palette(img)
+	if len(palette) == 0 || len(palette) > 8 {
+		t.Fatalf("%d palette colors, want 1 to 8", len(palette))
+	}
+
+	out, err := p.Apply(img, palette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := image.NewPaletted(img.Bounds(), out.Palette)
+	if err := p.ToPaletted(dst, img); err != nil {
+		t.Fatal(err)
+	}
+	for i := range dst.Pix {
+		if dst.Pix[i] != out.Pix[i] {
+			t.Fatalf("pixel %d: ToPaletted wrote index %d, Apply %d", i, dst.Pix[i], out.Pix[i])
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package quantize
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+)
+
+//
+// 			Animated PNG functions.
+//
+
+// An animated PNG (APNG) is a PNG file whose acTL chunk announces several frames: each frame is described
+// by an fcTL chunk followed by its compressed pixels, in IDAT chunks for the first frame and in fdAT chunks
+// for the others. Decoders which do not know APNG show the default image, i.e. the IDAT chunks.
+// See https://wiki.mozilla.org/APNG_Specification
+
+// pngSignature starts every PNG file.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// Frame disposal and blending operations of the fcTL chunks.
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendSource       = 0
+	apngBlendOver         = 1
+)
+
+// APNG is an animated PNG whose frames are rendered to the size of the animation.
+type APNG struct {
+	// Frames are the images of the animation, as they are displayed.
+	Frames []image.Image
+	// Delays are the display durations of the frames.
+	Delays []APNGDelay
+	// Plays is the number of times the animation is played; 0 means forever.
+	Plays int
+}
+
+// APNGDelay is the display duration of a frame, a fraction of a second.
+type APNGDelay struct {
+	Num, Den uint16
+}
+
+// Centiseconds returns the delay in hundredths of a second, the unit of GIF delays.
+func (d APNGDelay) Centiseconds() int {
+	den := int(d.Den)
+	if den == 0 {
+		den = 100
+	}
+
+	return (int(d.Num)*100 + den/2) / den
+}
+
+// readPNGChunks splits a PNG file into its chunks (see writePNGChunk), checking their CRCs.
+func readPNGChunks(data []byte) ([]PNGChunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, fmt.Errorf("not a PNG file")
+	}
+
+	var chunks []PNGChunk
+	for pos := len(pngSignature); pos < len(data); {
+		if len(data)-pos < 12 {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if length < 0 || length > len(data)-pos-12 {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		body := data[pos+4 : pos+8+length]
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[pos+8+length:]) {
+			return nil, fmt.Errorf("invalid CRC of the PNG chunk %q", body[:4])
+		}
+		chunks = append(chunks, PNGChunk{string(body[:4]), body[4:]})
+		pos += 12 + length
+	}
+
+	return chunks, nil
+}
+
+// appendUint32 appends a big-endian 32-bit integer to a byte slice.
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendUint16 appends a big-endian 16-bit integer to a byte slice.
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// IsAPNG reports whether an encoded image is an animated PNG, i.e. a PNG file with an acTL chunk.
+func IsAPNG(data []byte) bool {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return false
+	}
+	for _, chunk := range chunks {
+		switch chunk.Kind {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+	}
+
+	return false
+}
+
+// apngFrameControl is the content of an fcTL chunk.
+type apngFrameControl struct {
+	width, height, x, y int
+	delay               APNGDelay
+	dispose, blend      byte
+}
+
+// parseFrameControl decodes an fcTL chunk.
+func parseFrameControl(data []byte) (apngFrameControl, error) {
+	if len(data) != 26 {
+		return apngFrameControl{}, fmt.Errorf("invalid fcTL chunk")
+	}
+
+	u32 := func(i int) int { return int(binary.BigEndian.Uint32(data[i:])) }
+	return apngFrameControl{
+		width:   u32(4),
+		height:  u32(8),
+		x:       u32(12),
+		y:       u32(16),
+		delay:   APNGDelay{binary.BigEndian.Uint16(data[20:]), binary.BigEndian.Uint16(data[22:])},
+		dispose: data[24],
+		blend:   data[25],
+	}, nil
+}
+
+// DecodeAPNG decodes all the frames of an animated PNG from its encoded content.
+// The frames are rendered to the size of the animation: their disposal and blending operations are applied.
+// A default image which is not part of the animation is skipped.
+func DecodeAPNG(data []byte) (*APNG, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].Kind != "IHDR" || len(chunks[0].Data) != 13 {
+		return nil, fmt.Errorf("missing PNG header")
+	}
+	ihdr := chunks[0].Data
+	width, height := int(binary.BigEndian.Uint32(ihdr)), int(binary.BigEndian.Uint32(ihdr[4:]))
+
+	// The chunks before the image data (palette, transparency, gamma...) apply to every frame.
+	a := &APNG{}
+	var shared []PNGChunk
+	var controls []apngFrameControl
+	var frameData [][]byte
+	seenIDAT := false
+	for _, chunk := range chunks[1:] {
+		switch chunk.Kind {
+		case "acTL":
+			if len(chunk.Data) != 8 {
+				return nil, fmt.Errorf("invalid acTL chunk")
+			}
+			a.Plays = int(binary.BigEndian.Uint32(chunk.Data[4:]))
+		case "fcTL":
+			control, err := parseFrameControl(chunk.Data)
+			if err != nil {
+				return nil, err
+			}
+			if control.width <= 0 || control.height <= 0 || control.x+control.width > width || control.y+control.height > height {
+				return nil, fmt.Errorf("frame %d is out of the %dx%d animation", len(controls), width, height)
+			}
+			controls = append(controls, control)
+			frameData = append(frameData, nil)
+		case "IDAT":
+			// The default image is the first frame only if an fcTL chunk precedes it.
+			seenIDAT = true
+			if len(controls) == 1 {
+				frameData[0] = append(frameData[0], chunk.Data...)
+			}
+		case "fdAT":
+			if len(controls) == 0 || len(chunk.Data) < 4 {
+				return nil, fmt.Errorf("invalid fdAT chunk")
+			}
+			frameData[len(frameData)-1] = append(frameData[len(frameData)-1], chunk.Data[4:]...)
+		case "IEND":
+		default:
+			if !seenIDAT {
+				shared = append(shared, chunk)
+			}
+		}
+	}
+	if len(controls) == 0 {
+		return nil, fmt.Errorf("the animated PNG has no frame")
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, control := range controls {
+		frame, err := decodeAPNGFrame(ihdr, shared, control, frameData[i])
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+
+		// The area of a frame disposed to the previous content is saved before the frame is drawn.
+		r := image.Rect(control.x, control.y, control.x+control.width, control.y+control.height)
+		var previous *image.RGBA
+		if control.dispose == apngDisposePrevious && i > 0 {
+			previous = image.NewRGBA(r)
+			draw.Draw(previous, r, canvas, r.Min, draw.Src)
+		}
+
+		op := draw.Src
+		if control.blend == apngBlendOver {
+			op = draw.Over
+		}
+		draw.Draw(canvas, r, frame, frame.Bounds().Min, op)
+
+		rendered := image.NewRGBA(canvas.Bounds())
+		copy(rendered.Pix, canvas.Pix)
+		a.Frames = append(a.Frames, rendered)
+		a.Delays = append(a.Delays, control.delay)
+
+		switch {
+		case previous != nil:
+			draw.Draw(canvas, r, previous, r.Min, draw.Src)
+		case control.dispose == apngDisposeBackground || control.dispose == apngDisposePrevious:
+			draw.Draw(canvas, r, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+
+	return a, nil
+}
+
+// decodeAPNGFrame decodes the pixels of a frame as a PNG file of the size of the frame.
+func decodeAPNGFrame(ihdr []byte, shared []PNGChunk, control apngFrameControl, data []byte) (image.Image, error) {
+	if data == nil {
+		return nil, fmt.Errorf("missing image data")
+	}
+
+	header := append([]byte(nil), ihdr...)
+	binary.BigEndian.PutUint32(header, uint32(control.width))
+	binary.BigEndian.PutUint32(header[4:], uint32(control.height))
+
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	writePNGChunk(&buf, "IHDR", header)
+	for _, chunk := range shared {
+		writePNGChunk(&buf, chunk.Kind, chunk.Data)
+	}
+	writePNGChunk(&buf, "IDAT", data)
+	writePNGChunk(&buf, "IEND", nil)
+
+	return png.Decode(&buf)
+}
+
+// EncodeAPNG writes an animated PNG whose frames are paletted images of the same size sharing one palette,
+// e.g. the result of TransformAPNG in global palette mode.
+// Each frame replaces the whole previous one.
+func EncodeAPNG(w io.Writer, a *APNG) error {
+	if len(a.Frames) == 0 {
+		return fmt.Errorf("the animation has no frame")
+	}
+
+	var palette color.Palette
+	var size image.Point
+	var idats [][]byte
+	var header []PNGChunk
+	for i, img := range a.Frames {
+		frame, ok := img.(*image.Paletted)
+		if !ok {
+			return fmt.Errorf("frame %d is not a paletted image", i)
+		}
+		if i == 0 {
+			palette, size = frame.Palette, frame.Bounds().Size()
+		} else if frame.Bounds().Size() != size || !samePalette(frame.Palette, palette) {
+			return fmt.Errorf("frame %d does not have the size and the palette of the first frame; an animated PNG has a single palette", i)
+		}
+
+		// Every frame is encoded as a PNG file, whose image data is moved to the animation.
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, frame); err != nil {
+			return err
+		}
+		chunks, err := readPNGChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		var idat []byte
+		for _, chunk := range chunks {
+			switch {
+			case chunk.Kind == "IDAT":
+				idat = append(idat, chunk.Data...)
+			case i == 0 && chunk.Kind != "IEND":
+				header = append(header, chunk)
+			}
+		}
+		idats = append(idats, idat)
+	}
+
+	if _, err := io.WriteString(w, pngSignature); err != nil {
+		return err
+	}
+	for i, chunk := range header {
+		if err := writePNGChunk(w, chunk.Kind, chunk.Data); err != nil {
+			return err
+		}
+		if i == 0 {
+			actl := appendUint32(nil, uint32(len(a.Frames)))
+			if err := writePNGChunk(w, "acTL", appendUint32(actl, uint32(a.Plays))); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The fcTL and fdAT chunks share a sequence number.
+	seq := uint32(0)
+	for i, idat := range idats {
+		var delay APNGDelay
+		if i < len(a.Delays) {
+			delay = a.Delays[i]
+		}
+		fctl := appendUint32(nil, seq)
+		for _, v := range []int{size.X, size.Y, 0, 0} {
+			fctl = appendUint32(fctl, uint32(v))
+		}
+		fctl = appendUint16(fctl, delay.Num)
+		fctl = appendUint16(fctl, delay.Den)
+		fctl = append(fctl, apngDisposeNone, apngBlendSource)
+		if err := writePNGChunk(w, "fcTL", fctl); err != nil {
+			return err
+		}
+		seq++
+
+		if i == 0 {
+			if err := writePNGChunk(w, "IDAT", idat); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writePNGChunk(w, "fdAT", append(appendUint32(nil, seq), idat...)); err != nil {
+			return err
+		}
+		seq++
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+// samePalette reports whether two palettes have the same colors.
+func samePalette(p, q color.Palette) bool {
+	if len(p) != len(q) {
+		return false
+	}
+	for i := range p {
+		r1, g1, b1, a1 := p[i].RGBA()
+		r2, g2, b2, a2 := q[i].RGBA()
+		if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// TransformAPNG quantizes and dithers every frame of an animated PNG, as TransformGIF does.
+// In GIFPaletteGlobal mode (the default), the frames share their palette, including its transparent color if any frame has
+// transparent pixels, so that the result can be written as an animated PNG (see EncodeAPNG).
+// In GIFPaletteLocal mode, the result can only be written as an animated GIF (see APNG.GIF).
+func TransformAPNG(ctx context.Context, a *APNG, paletteMaxSize int, paletteOpts PaletteOptions, ditherer Ditherer, anim AnimationOptions) (*APNG, error) {
+	out := &APNG{Delays: append([]APNGDelay(nil), a.Delays...), Plays: a.Plays}
+
+	var palette []color.RGBA
+	transparent := false
+	if anim.PaletteMode != GIFPaletteLocal {
+		palette = PaletteFromImages(a.Frames, paletteMaxSize, paletteOpts)
+		for _, frame := range a.Frames {
+			transparent = transparent || HasTransparentPixels(frame, paletteOpts.TransparencyThreshold())
+		}
+	}
+
+	for i, frame := range a.Frames {
+		framePalette := palette
+		if anim.PaletteMode == GIFPaletteLocal {
+			framePalette = PaletteFromImage(frame, paletteMaxSize, paletteOpts)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		outFrame, err := ApplyPalette(ctx, frame, framePalette, paletteOpts, anim.frameDitherer(ditherer, i))
+		if err != nil && err == ctx.Err() {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+
+		// The frames without transparent pixels lack the transparent color of the shared palette.
+		if transparent && len(outFrame.Palette) == len(palette) {
+			outFrame.Palette = append(outFrame.Palette, TransparentColor)
+		}
+		if anim.Reuse > 0 && i > 0 {
+			ReuseUnchangedPixels(a.Frames[i-1], frame, out.Frames[i-1].(*image.Paletted), outFrame, anim.Reuse)
+		}
+		out.Frames = append(out.Frames, outFrame)
+	}
+
+	return out, nil
+}
+
+// GIF converts an animation whose frames are paletted images to an animated GIF, whose delays are
+// rounded to hundredths of a second. Each frame replaces the whole previous one.
+func (a *APNG) GIF() (*gif.GIF, error) {
+	g := &gif.GIF{LoopCount: a.Plays - 1}
+	if a.Plays == 0 {
+		g.LoopCount = 0
+	}
+
+	for i, img := range a.Frames {
+		frame, ok := img.(*image.Paletted)
+		if !ok {
+			return nil, fmt.Errorf("frame %d is not a paletted image", i)
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, a.Delays[i].Centiseconds())
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+	g.Config = image.Config{ColorModel: g.Image[0].Palette, Width: g.Image[0].Bounds().Dx(), Height: g.Image[0].Bounds().Dy()}
+
+	return g, nil
+}
+
+// GetAPNGFromFilePath returns all the frames of an animated PNG file.
+// The standard input is read if IsStdio(filePath).
+func GetAPNGFromFilePath(filePath string) (*APNG, error) {
+	data, err := ReadInputFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeAPNG(data)
+}
+
+// WriteAPNGToFile saves an animated PNG to a file (see EncodeAPNG).
+// The animation is written to the standard output if IsStdio(filepath).
+func WriteAPNGToFile(a *APNG, filepath string) error {
+	outputFile, err := CreateOutputFile(filepath)
+	if err != nil {
+		return err
+	}
+
+	err = EncodeAPNG(outputFile, a)
+
+	// Don't forget to close files
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,275 @@
+package quantize
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+//
+// 			Aseprite files.
+//
+
+// The Aseprite files (.ase, .aseprite) are written as indexed sprites of a single frame and layer,
+// whose palette is the palette of the image. Aseprite can also load the palette of any Aseprite file,
+// which is what the "ase" palette format reads and writes: a sprite of one row holding each palette color once.
+
+// Aseprite file constants, see https://github.com/aseprite/aseprite/blob/main/docs/ase-file-specs.md.
+const (
+	aseHeaderSize      = 128
+	aseFrameHeaderSize = 16
+	aseFileMagic       = 0xa5e0
+	aseFrameMagic      = 0xf1fa
+
+	aseChunkOldPalette = 0x0004
+	aseChunkLayer      = 0x2004
+	aseChunkCel        = 0x2005
+	aseChunkPalette    = 0x2019
+
+	aseLayerVisible    = 1
+	aseLayerEditable   = 2
+	aseLayerBackground = 8
+	aseCelCompressed   = 2
+)
+
+func init() {
+	encoders["ase"] = EncodeAseprite
+	formatAliases["aseprite"] = "ase"
+	paletteFormats["ase"] = PaletteFormat{DecodeAsepritePalette, EncodeAsepritePalette}
+}
+
+// EncodeAseprite writes a paletted image as an indexed Aseprite sprite.
+// If the palette has a fully transparent color, it is the transparent color of the sprite;
+// otherwise the image is a background layer, where no color is transparent.
+func EncodeAseprite(w io.Writer, img image.Image) error {
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		return fmt.Errorf("the ase format requires a paletted image")
+	}
+	if len(paletted.Palette) == 0 || len(paletted.Palette) > MaxPaletteSize {
+		return fmt.Errorf("ase: invalid palette size %d", len(paletted.Palette))
+	}
+
+	transparent, layerFlags := 0, aseLayerVisible|aseLayerEditable|aseLayerBackground
+	for i, c := range paletted.Palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			transparent, layerFlags = i, aseLayerVisible|aseLayerEditable
+			break
+		}
+	}
+
+	// The cel holds one byte per pixel, compressed with zlib.
+	b := paletted.Bounds()
+	var pixels bytes.Buffer
+	zw := zlib.NewWriter(&pixels)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := paletted.PixOffset(b.Min.X, y)
+		zw.Write(paletted.Pix[i : i+b.Dx()])
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	var layer aseWriter
+	layer.put(uint16(layerFlags), uint16(0), uint16(0), uint16(0), uint16(0), uint16(0), uint8(255), [3]byte{})
+	layer.putString("Image")
+
+	var cel aseWriter
+	cel.put(uint16(0), int16(0), int16(0), uint8(255), uint16(aseCelCompressed), int16(0), [5]byte{})
+	cel.put(uint16(b.Dx()), uint16(b.Dy()))
+	cel.Write(pixels.Bytes())
+
+	chunks := []aseChunk{
+		{aseChunkPalette, asePaletteChunk(paletted.Palette)},
+		{aseChunkLayer, layer.Bytes()},
+		{aseChunkCel, cel.Bytes()},
+	}
+
+	return writeAseprite(w, b.Dx(), b.Dy(), transparent, len(paletted.Palette), chunks)
+}
+
+// EncodeAsepritePalette writes a palette as an Aseprite sprite of one row, one pixel per color.
+func EncodeAsepritePalette(w io.Writer, palette []color.RGBA) error {
+	img := image.NewPaletted(image.Rect(0, 0, len(palette), 1), ColorPalette(palette))
+	for i := range palette {
+		img.Pix[i] = uint8(i)
+	}
+
+	return EncodeAseprite(w, img)
+}
+
+// DecodeAsepritePalette reads the palette of the first frame of an Aseprite file,
+// from its palette chunk or else from its old palette chunk.
+func DecodeAsepritePalette(r io.Reader) ([]color.RGBA, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aseHeaderSize+aseFrameHeaderSize || binary.LittleEndian.Uint16(data[4:]) != aseFileMagic {
+		return nil, fmt.Errorf("ase: not an Aseprite file")
+	}
+
+	frame := data[aseHeaderSize:]
+	if binary.LittleEndian.Uint16(frame[4:]) != aseFrameMagic {
+		return nil, fmt.Errorf("ase: invalid frame header")
+	}
+	if size := int(binary.LittleEndian.Uint32(frame)); size < len(frame) {
+		frame = frame[:size]
+	}
+
+	var oldPalette []color.RGBA
+	for chunks := frame[aseFrameHeaderSize:]; len(chunks) >= 6; {
+		size := int(binary.LittleEndian.Uint32(chunks))
+		if size < 6 || size > len(chunks) {
+			return nil, fmt.Errorf("ase: invalid chunk size %d", size)
+		}
+		kind, chunk := binary.LittleEndian.Uint16(chunks[4:]), chunks[6:size]
+		chunks = chunks[size:]
+
+		switch kind {
+		case aseChunkPalette:
+			return decodeAsePaletteChunk(chunk)
+		case aseChunkOldPalette:
+			if oldPalette == nil {
+				oldPalette, err = decodeAseOldPaletteChunk(chunk)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if oldPalette == nil {
+		return nil, fmt.Errorf("ase: no palette in the first frame")
+	}
+
+	return oldPalette, nil
+}
+
+// asePaletteChunk returns the data of the palette chunk of a palette, whose colors are stored non-premultiplied.
+func asePaletteChunk(palette color.Palette) []byte {
+	var chunk aseWriter
+	chunk.put(uint32(len(palette)), uint32(0), uint32(len(palette)-1), [8]byte{})
+	for _, c := range palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		chunk.put(uint16(0), n.R, n.G, n.B, n.A)
+	}
+
+	return chunk.Bytes()
+}
+
+// decodeAsePaletteChunk reads the colors of a palette chunk, skipping their names.
+func decodeAsePaletteChunk(chunk []byte) ([]color.RGBA, error) {
+	if len(chunk) < 20 {
+		return nil, fmt.Errorf("ase: truncated palette chunk")
+	}
+	first, last := int(binary.LittleEndian.Uint32(chunk[4:])), int(binary.LittleEndian.Uint32(chunk[8:]))
+	if first > last || last-first >= MaxPaletteSize {
+		return nil, fmt.Errorf("ase: invalid palette range %d-%d", first, last)
+	}
+
+	var palette []color.RGBA
+	entries := chunk[20:]
+	for i := first; i <= last; i++ {
+		if len(entries) < 6 {
+			return nil, fmt.Errorf("ase: truncated palette chunk")
+		}
+		flags := binary.LittleEndian.Uint16(entries)
+		n := color.NRGBA{entries[2], entries[3], entries[4], entries[5]}
+		palette = append(palette, color.RGBAModel.Convert(n).(color.RGBA))
+		entries = entries[6:]
+
+		// An entry may have a name, a string prefixed by its length.
+		if flags&1 != 0 {
+			if len(entries) < 2 || len(entries) < 2+int(binary.LittleEndian.Uint16(entries)) {
+				return nil, fmt.Errorf("ase: truncated palette chunk")
+			}
+			entries = entries[2+int(binary.LittleEndian.Uint16(entries)):]
+		}
+	}
+
+	return palette, nil
+}
+
+// decodeAseOldPaletteChunk reads the opaque colors of an old palette chunk, made of packets of consecutive colors.
+func decodeAseOldPaletteChunk(chunk []byte) ([]color.RGBA, error) {
+	if len(chunk) < 2 {
+		return nil, fmt.Errorf("ase: truncated old palette chunk")
+	}
+
+	var palette []color.RGBA
+	packets, chunk := int(binary.LittleEndian.Uint16(chunk)), chunk[2:]
+	for p := 0; p < packets; p++ {
+		if len(chunk) < 2 {
+			return nil, fmt.Errorf("ase: truncated old palette chunk")
+		}
+		skip, count := int(chunk[0]), int(chunk[1])
+		if count == 0 {
+			count = 256
+		}
+		chunk = chunk[2:]
+		if len(chunk) < 3*count || len(palette)+skip+count > MaxPaletteSize {
+			return nil, fmt.Errorf("ase: invalid old palette chunk")
+		}
+
+		// The skipped entries are left black.
+		for ; skip > 0; skip-- {
+			palette = append(palette, color.RGBA{0, 0, 0, 255})
+		}
+		for i := 0; i < count; i++ {
+			palette = append(palette, color.RGBA{chunk[3*i], chunk[3*i+1], chunk[3*i+2], 255})
+		}
+		chunk = chunk[3*count:]
+	}
+
+	return palette, nil
+}
+
+// aseChunk is a chunk of an Aseprite frame.
+type aseChunk struct {
+	Kind uint16
+	Data []byte
+}
+
+// writeAseprite writes an indexed Aseprite file of one frame made of the given chunks.
+func writeAseprite(w io.Writer, width, height, transparent, colors int, chunks []aseChunk) error {
+	var frame aseWriter
+	for _, c := range chunks {
+		frame.put(uint32(6+len(c.Data)), c.Kind)
+		frame.Write(c.Data)
+	}
+
+	var file aseWriter
+	// A number of colors of 0 means 256.
+	file.put(uint32(aseHeaderSize+aseFrameHeaderSize+frame.Len()), uint16(aseFileMagic), uint16(1),
+		uint16(width), uint16(height), uint16(8), uint32(1), uint16(100), uint32(0), uint32(0),
+		uint8(transparent), [3]byte{}, uint16(colors%256), uint8(1), uint8(1),
+		int16(0), int16(0), uint16(16), uint16(16), [84]byte{})
+	file.put(uint32(aseFrameHeaderSize+frame.Len()), uint16(aseFrameMagic), uint16(len(chunks)),
+		uint16(100), [2]byte{}, uint32(len(chunks)))
+	file.Write(frame.Bytes())
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// aseWriter builds the little-endian data of an Aseprite file.
+type aseWriter struct {
+	bytes.Buffer
+}
+
+// put appends fixed-size values.
+func (w *aseWriter) put(values ...any) {
+	for _, v := range values {
+		binary.Write(&w.Buffer, binary.LittleEndian, v)
+	}
+}
+
+// putString appends a string prefixed by its length.
+func (w *aseWriter) putString(s string) {
+	w.put(uint16(len(s)))
+	w.WriteString(s)
+}
@@ -0,0 +1,112 @@
+package quantize
+
+import (
+	"image"
+	"math"
+)
+
+//
+// 			Palette size estimation.
+//
+
+// The right palette size of an image is rarely obvious: a flat illustration needs its few colors and no more,
+// while a photo keeps gaining from each new color, less and less. With -pal auto, the size is estimated from the
+// histogram of the image: the number of its colors if they fit in a palette, and otherwise the elbow of the curve
+// of the mean error against the palette size, beyond which more colors bring little.
+
+// PaletteSizeAuto is the -pal value estimating the palette size of each image (see AutoPaletteSize).
+const PaletteSizeAuto = "auto"
+
+// autoPaletteSizes are the palette sizes whose error is measured to find the elbow, about evenly spaced on a log scale.
+var autoPaletteSizes = []int{2, 3, 4, 6, 8, 12, 16, 24, 32, 48, 64, 96, 128, 192, 256}
+
+// autoPaletteDeltaE is the mean CIE 1976 ΔE under which a palette is good enough, whatever the elbow:
+// about the smallest color difference that can be noticed.
+const autoPaletteDeltaE = 2.3
+
+// AutoPaletteSizeOf estimates the palette size of some images, e.g. the frames of an animation, from the histogram
+// of their pixels (see AutoPaletteSize). An entry is added for the transparent pixels, and the opts.Keep colors.
+func AutoPaletteSizeOf(imgs []image.Image, opts PaletteOptions) int {
+	if opts.HistogramBits == 0 {
+		opts.HistogramBits = 6
+	}
+
+	var h *Histogram
+	if len(imgs) == 1 {
+		h = opts.Histograms.Histogram(imgs[0], opts)
+	} else {
+		h = NewHistogram(opts.HistogramBits)
+		for _, img := range imgs {
+			AddToHistogram(h, img, opts)
+		}
+	}
+
+	size := AutoPaletteSize(h, opts) + len(opts.Keep)
+	for _, img := range imgs {
+		if HasTransparentPixels(img, opts.TransparencyThreshold()) {
+			size++
+			break
+		}
+	}
+
+	return ClampAboveInt(size, MaxPaletteSize)
+}
+
+// AutoPaletteSize estimates the number of colors needed by the colors of a histogram. If they are at most
+// MaxPaletteSize distinct colors, that is their number. Otherwise palettes of increasing sizes are generated,
+// with the histogram quantizer of <opts> (MedianCutQuantizer for the others, which would be too slow),
+// and the size is the elbow of the curve of their mean CIE 1976 ΔE against the logarithm of their size:
+// the point the farthest below the line joining its ends. It is smaller if a smaller palette
+// has a mean ΔE under autoPaletteDeltaE. The result is at least 2.
+func AutoPaletteSize(h *Histogram, opts PaletteOptions) int {
+	if colors, ok := h.DistinctColors(MaxPaletteSize); ok {
+		return ClampBelowInt(len(colors), 2)
+	}
+
+	opts.Fixed, opts.Keep, opts.ColorblindDeltaE, opts.Exact, opts.Progress = nil, nil, 0, false, nil
+	if _, ok := opts.Quantizer.(HistogramQuantizer); !ok {
+		opts.Quantizer = MedianCutQuantizer{}
+	}
+	colors := h.Colors()
+	metric := DE76Metric{}
+	points := make([]ColorPoint, len(colors))
+	for i, wc := range colors {
+		points[i] = metric.Point(wc.Color)
+	}
+
+	deltaEs := make([]float64, len(autoPaletteSizes))
+	for k, size := range autoPaletteSizes {
+		palette := PaletteFromHistogram(h, size, opts)
+		index := NewPaletteIndex(palette, metric)
+		var sum, total float64
+		for i, wc := range colors {
+			nearest := metric.Point(palette[index.Nearest(wc.Color)])
+			sum += metric.Distance(points[i], nearest) * wc.Weight
+			total += wc.Weight
+		}
+		deltaEs[k] = sum / total
+
+		if deltaEs[k] < autoPaletteDeltaE {
+			return size
+		}
+	}
+
+	// The sizes are spread on [0, 1] by their logarithm, and the errors by the error of the smallest palette.
+	last := len(autoPaletteSizes) - 1
+	x := func(k int) float64 {
+		return math.Log2(float64(autoPaletteSizes[k])/float64(autoPaletteSizes[0])) /
+			math.Log2(float64(autoPaletteSizes[last])/float64(autoPaletteSizes[0]))
+	}
+	y := func(k int) float64 {
+		return deltaEs[k] / math.Max(deltaEs[0], 1e-9)
+	}
+	best, bestGap := last, 0.
+	for k := range autoPaletteSizes {
+		chord := y(0) + (y(last)-y(0))*x(k)
+		if gap := chord - y(k); gap > bestGap {
+			best, bestGap = k, gap
+		}
+	}
+
+	return autoPaletteSizes[best]
+}
@@ -0,0 +1,233 @@
+package quantize
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//
+// 			Batch processing functions.
+//
+
+// imageExtensions are the file extensions of the images picked up in an input directory.
+var imageExtensions = []string{".png", ".gif", ".jpg", ".jpeg", ".bmp", ".tif", ".tiff", ".webp", ".pbm", ".pgm", ".ppm", ".pnm", ".pam", ".ff"}
+
+// IsBatchInput reports whether an input filepath designates several files,
+// i.e. whether it is a directory or a glob pattern.
+func IsBatchInput(srcFilepath string) bool {
+	if IsStdio(srcFilepath) {
+		return false
+	}
+
+	if strings.ContainsAny(srcFilepath, "*?[") {
+		return true
+	}
+
+	info, err := os.Stat(srcFilepath)
+	return err == nil && info.IsDir()
+}
+
+// BatchInputFiles lists the files designated by a batch input: the images of a directory
+// (not recursively) or the files matching a glob pattern. The list is sorted.
+// The output files written there by a previous run (see DefaultOutputTemplate) are left out,
+// unless the input is the filepath of one of them.
+func BatchInputFiles(srcFilepath string) ([]string, error) {
+	info, err := os.Stat(srcFilepath)
+	if err != nil || !info.IsDir() {
+		matches, err := filepath.Glob(srcFilepath)
+		var files []string
+		for _, file := range matches {
+			if file == srcFilepath || !IsDefaultOutputFile(file) {
+				files = append(files, file)
+			}
+		}
+		sort.Strings(files)
+		return files, err
+	}
+
+	entries, err := os.ReadDir(srcFilepath)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || !containsString(imageExtensions, ext) || IsDefaultOutputFile(entry.Name()) {
+			continue
+		}
+
+		files = append(files, filepath.Join(srcFilepath, entry.Name()))
+	}
+
+	return files, nil
+}
+
+// DefaultOutputTemplate is the output filename template used when no output filepath is given for input files:
+// the output file is put next to its input file, e.g. photo.jpg gives photo.quantized.png.
+const DefaultOutputTemplate = "{dir}/{name}.quantized.{ext}"
+
+// IsDefaultOutputFile reports whether a file is named as the output files of DefaultOutputTemplate, e.g. photo.quantized.png.
+func IsDefaultOutputFile(filePath string) bool {
+	name := filepath.Base(filePath)
+	return strings.HasSuffix(strings.TrimSuffix(name, filepath.Ext(name)), ".quantized")
+}
+
+// ExpandOutputTemplate computes the output filepath of an input file from a filename template, where "{dir}" is replaced
+// by the directory of the input file, "{name}" by the input file name without its extension, "{ext}" by the extension
+// of the output format and "{pal}" by the palette size (see Settings.PaletteSizeName). A filepath without these
+// placeholders is returned as is.
+func ExpandOutputTemplate(srcFilepath, out, format, pal string) string {
+	if !strings.Contains(out, "{") {
+		return out
+	}
+	name := strings.TrimSuffix(filepath.Base(srcFilepath), filepath.Ext(srcFilepath))
+
+	return filepath.Clean(strings.NewReplacer("{dir}", filepath.Dir(srcFilepath), "{name}", name, "{ext}", FormatExtension(format), "{pal}", pal).Replace(out))
+}
+
+// OutputFormat returns the format of the output file of an input file when none is forced:
+// the extension of the output filepath or template if any (see FormatFromFilePath), otherwise the input file format
+// if it can be written (see WritableFormat).
+func OutputFormat(srcFilepath, out string) (string, error) {
+	if ext := filepath.Ext(out); ext != "" && ext != ".{ext}" {
+		return FormatFromFilePath(out)
+	}
+
+	return WritableFormat(srcFilepath), nil
+}
+
+// BatchOutputFilepath computes the output filepath of an input file in batch mode.
+// If <out> is a directory, the output file is put in it, with the input file name
+// and the extension of the output format.
+// Otherwise <out> is a filename template (see ExpandOutputTemplate).
+func BatchOutputFilepath(srcFilepath, out, format, pal string) string {
+	name := strings.TrimSuffix(filepath.Base(srcFilepath), filepath.Ext(srcFilepath))
+
+	if info, err := os.Stat(out); (err == nil && info.IsDir()) || strings.HasSuffix(out, string(filepath.Separator)) {
+		return filepath.Join(out, name+"."+FormatExtension(format))
+	}
+
+	return ExpandOutputTemplate(srcFilepath, out, format, pal)
+}
+
+// ProcessBatch transforms all the files designated by a batch input (see BatchInputFiles).
+// The output filepaths are computed by BatchOutputFilepath.
+// Up to <jobs> files are processed concurrently.
+// A failure on a file does not stop the processing of the others: it is reported to
+// the standard error and ProcessBatch returns an error once every file has been processed.
+// Canceling <ctx> stops the processing, and ProcessBatch then returns ctx.Err().
+func ProcessBatch(ctx context.Context, srcFilepath, out string, settings Settings, jobs int) error {
+	files, err := BatchInputFiles(srcFilepath)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no input file matches %q", srcFilepath)
+	}
+
+	return ProcessBatchFiles(ctx, files, out, settings, jobs)
+}
+
+// ProcessBatchFiles transforms some of the files of a batch input, e.g. those which changed (see Watch),
+// as ProcessBatch does.
+func ProcessBatchFiles(ctx context.Context, files []string, out string, settings Settings, jobs int) error {
+	if IsStdio(out) {
+		return fmt.Errorf("batch mode needs an output directory or filename template")
+	}
+
+	// The format of a template is checked once, before any file is processed.
+	if _, err := OutputFormat("", out); settings.Format == "" && err != nil {
+		return err
+	}
+
+	// A trailing separator designates a directory which may not exist yet.
+	if strings.HasSuffix(out, string(filepath.Separator)) {
+		if err := os.MkdirAll(out, 0755); err != nil {
+			return err
+		}
+	}
+
+	return processFiles(ctx, files, jobs, func(path string) (string, Settings) {
+		// Each file gets its own format when none is forced (see OutputFormat).
+		fileSettings := settings
+		if fileSettings.Format == "" {
+			fileSettings.Format, _ = OutputFormat(path, out)
+		}
+
+		outPath := BatchOutputFilepath(path, out, fileSettings.Format, settings.PaletteSizeName())
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		fileSettings.SavePalette = strings.ReplaceAll(settings.SavePalette, "{name}", name)
+		fileSettings.Compare = strings.ReplaceAll(settings.Compare, "{name}", name)
+		fileSettings.Swatch = strings.ReplaceAll(settings.Swatch, "{name}", name)
+		fileSettings.StatsStrip = strings.ReplaceAll(settings.StatsStrip, "{name}", name)
+		fileSettings.TileJSON = strings.ReplaceAll(settings.TileJSON, "{name}", name)
+		return outPath, fileSettings
+	})
+}
+
+// processFiles transforms files with up to <jobs> files processed concurrently.
+// <output> gives the output filepath and the settings of each file.
+// Failures are handled as ProcessBatch describes.
+func processFiles(ctx context.Context, files []string, jobs int, output func(path string) (string, Settings)) error {
+	jobs = ClampBelowInt(jobs, 1)
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures := 0
+
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for path := range paths {
+				outPath, fileSettings := output(path)
+				err := ProcessFile(ctx, path, outPath, fileSettings)
+				if err != nil && err != ctx.Err() {
+					mu.Lock()
+					failures++
+					fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+send:
+	for _, path := range files {
+		select {
+		case paths <- path:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(paths)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d files failed", failures, len(files))
+	}
+
+	return nil
+}
+
+// containsString reports whether a slice contains a given string.
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+
+	return false
+}
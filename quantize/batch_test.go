@@ -0,0 +1,56 @@
+package quantize
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBatchInputFilesSkipsOutputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.png", "a.quantized.png", "b.jpg", "b.quantized.gif", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{filepath.Join(dir, "a.png"), filepath.Join(dir, "b.jpg")}
+
+	for _, input := range []string{dir, filepath.Join(dir, "*.*")} {
+		files, err := BatchInputFiles(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if input != dir {
+			want = append(want, filepath.Join(dir, "notes.txt"))
+		}
+		if !reflect.DeepEqual(files, want) {
+			t.Errorf("%s: got %v, want %v", input, files, want)
+		}
+	}
+
+	// An output file given by its filepath is an input as any other.
+	output := filepath.Join(dir, "a.quantized.png")
+	if files, err := BatchInputFiles(output); err != nil || len(files) != 1 || files[0] != output {
+		t.Errorf("%s: got %v, %v", output, files, err)
+	}
+}
+
+func TestOutputFormatRejectsUnwritableExtensions(t *testing.T) {
+	for _, test := range []struct{ in, out, want string }{
+		{"a.png", "b.gif", "gif"},
+		{"a.gif", "out/{name}.{ext}", "gif"},
+		{"a.jpg", "out/{name}.{ext}", "png"},
+		{"a.png", "-", "png"},
+		{"a.png", "b.PNM", "ppm"},
+	} {
+		if got, err := OutputFormat(test.in, test.out); err != nil || got != test.want {
+			t.Errorf("OutputFormat(%q, %q) = %q, %v, want %q", test.in, test.out, got, err, test.want)
+		}
+	}
+	for _, out := range []string{"b.jpg", "b.webp", "b.txt"} {
+		if format, err := OutputFormat("a.png", out); err == nil {
+			t.Errorf("OutputFormat(%q) = %q, want an error", out, format)
+		}
+	}
+}
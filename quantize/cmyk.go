@@ -0,0 +1,47 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+)
+
+//
+// 			CMYK images.
+//
+
+// The JPEGs of print workflows hold CMYK inks instead of RGB lights. The JPEG decoder gives them as image.CMYK,
+// assuming the values were stored inverted, as Adobe applications write them (255 meaning no ink); the images of
+// the applications which store them as they are come out as negatives, and have to be inverted back.
+// The inks are converted to sRGB by the usual formula: each ink, and the black, absorbs its share of the light.
+
+// cmykColor converts CMYK inks, from 0 (no ink) to 255 (full ink), to an opaque color, the values being rounded.
+func cmykColor(c, m, y, k uint8) color.RGBA {
+	w := 255 - uint32(k)
+	light := func(ink uint8) uint8 {
+		return uint8(((255-uint32(ink))*w + 127) / 255)
+	}
+
+	return color.RGBA{light(c), light(m), light(y), 255}
+}
+
+// CMYKToRGB converts a CMYK image to an RGB one (see cmykColor), with up to <threads> goroutines (0 means GOMAXPROCS).
+// With <invert>, the CMYK values are inverted first, for the images whose values were not stored inverted.
+func CMYKToRGB(img *image.CMYK, invert bool, threads int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	ParallelRows(b, threads, func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := img.PixOffset(x, y)
+				inks := img.Pix[i : i+4 : i+4]
+				if invert {
+					out.SetRGBA(x, y, cmykColor(255-inks[0], 255-inks[1], 255-inks[2], 255-inks[3]))
+				} else {
+					out.SetRGBA(x, y, cmykColor(inks[0], inks[1], inks[2], inks[3]))
+				}
+			}
+		}
+	})
+
+	return out
+}
@@ -0,0 +1,158 @@
+package quantize
+
+import (
+	"image/color"
+	"math"
+)
+
+//
+// 			Color manipulation functions.
+//
+
+// ColorDistance computes the Euclidean distance between two colors.
+// Note however that the alpha channel is ignored.
+func ColorDistance(c1, c2 color.RGBA) float64 {
+	// Euclidean distance
+	dr := float64(c1.R) - float64(c2.R)
+	dr *= dr
+
+	dg := float64(c1.G) - float64(c2.G)
+	dg *= dg
+
+	db := float64(c1.B) - float64(c2.B)
+	db *= db
+
+	return math.Sqrt(float64(dr + dg + db))
+}
+
+// LinearGradient computes the following linear combination of colors c1 and c2: s * c1 + t * c2.
+// The resulting alpha channel is set to 255.
+func LinearGradient(s float64, c1 color.RGBA, t float64, c2 color.RGBA) color.RGBA {
+	sc1 := ScalMult(s, c1)
+	tc2 := ScalMult(t, c2)
+
+	return Add(sc1, tc2)
+}
+
+// Add adds the three color channels of two colors, saturating at 255.
+// The resulting alpha channel is set to 255.
+func Add(c1, c2 color.RGBA) color.RGBA {
+	return color.RGBA{
+		SaturateU8(int(c1.R) + int(c2.R)),
+		SaturateU8(int(c1.G) + int(c2.G)),
+		SaturateU8(int(c1.B) + int(c2.B)),
+		255,
+	}
+}
+
+// Sub subtracts the three color channels of <c2> from those of <c1>, saturating at 0.
+// The resulting alpha channel is set to 255.
+func Sub(c1, c2 color.RGBA) color.RGBA {
+	return color.RGBA{
+		SaturateU8(int(c1.R) - int(c2.R)),
+		SaturateU8(int(c1.G) - int(c2.G)),
+		SaturateU8(int(c1.B) - int(c2.B)),
+		255,
+	}
+}
+
+// ScalMult multiplies the RGB channels of a color by a scalar lambda in [0.0, 1.0].
+// The alpha channel remains unchanged.
+func ScalMult(lambda float64, c color.RGBA) color.RGBA {
+	// Clamp lambda to [0, 1]
+	if lambda < 0. {
+		lambda = 0.
+	} else if lambda > 1. {
+		lambda = 1.
+	}
+
+	return color.RGBA{
+		uint8(lambda * float64(c.R)),
+		uint8(lambda * float64(c.G)),
+		uint8(lambda * float64(c.B)),
+		c.A,
+	}
+}
+
+// Scale multiplies the RGB channels of a color by any non-negative scalar <lambda>, e.g. to brighten it,
+// rounding and saturating at 255; a negative lambda gives black. The alpha channel remains unchanged.
+// Unlike ScalMult, the scalar is not clamped to [0.0, 1.0] and the channels are rounded instead of truncated.
+func Scale(lambda float64, c color.RGBA) color.RGBA {
+	return color.RGBA{
+		SaturateF64U8(lambda * float64(c.R)),
+		SaturateF64U8(lambda * float64(c.G)),
+		SaturateF64U8(lambda * float64(c.B)),
+		c.A,
+	}
+}
+
+// Lerp interpolates linearly between two colors: it returns <c1> for t = 0 and <c2> for t = 1, <t> being
+// clamped to [0.0, 1.0]. The four channels are interpolated, alpha included, and rounded.
+func Lerp(c1, c2 color.RGBA, t float64) color.RGBA {
+	t = ClampF64(t, 0., 1.)
+	lerp := func(a, b uint8) uint8 {
+		return SaturateF64U8(float64(a) + t*(float64(b)-float64(a)))
+	}
+
+	return color.RGBA{lerp(c1.R, c2.R), lerp(c1.G, c2.G), lerp(c1.B, c2.B), lerp(c1.A, c2.A)}
+}
+
+//
+// 			General functions.
+//
+
+// ClampU8 clamps an uint8 <x> inside the range [low; high].
+func ClampU8(x, low, high uint8) uint8 {
+	if x < low {
+		return low
+	} else if x > high {
+		return high
+	} else {
+		return x
+	}
+}
+
+// SaturateU8 converts an integer <x> to an uint8, clamping it inside the range [0; 255].
+func SaturateU8(x int) uint8 {
+	if x < 0 {
+		return 0
+	} else if x > 255 {
+		return 255
+	} else {
+		return uint8(x)
+	}
+}
+
+// SaturateF64U8 rounds a float <x> to the nearest uint8, clamping it inside the range [0; 255].
+func SaturateF64U8(x float64) uint8 {
+	return uint8(math.Round(ClampF64(x, 0., 255.)))
+}
+
+// ClampF64 clamps a float <x> inside the range [low; high].
+func ClampF64(x, low, high float64) float64 {
+	if x < low {
+		return low
+	} else if x > high {
+		return high
+	} else {
+		return x
+	}
+}
+
+// ClampAboveInt clamps an integer <x> if it is below the value <low>.
+func ClampBelowInt(x, low int) int {
+	if x < low {
+		return low
+	} else {
+		return x
+	}
+}
+
+// ClampAboveInt clamps an integer <x> if it is above the value <high>.
+func ClampAboveInt(x, high int) int {
+	if x > high {
+		return high
+	} else {
+		return x
+	}
+}
@@ -0,0 +1,32 @@
+package quantize
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestColorArithmeticSaturates(t *testing.T) {
+	rgb := func(r, g, b uint8) color.RGBA { return color.RGBA{r, g, b, 255} }
+	for _, test := range []struct {
+		name      string
+		got, want color.RGBA
+	}{
+		{"Add 200+100", Add(rgb(200, 200, 200), rgb(100, 100, 100)), rgb(255, 255, 255)},
+		{"Add 255+255", Add(rgb(255, 0, 255), rgb(255, 0, 1)), rgb(255, 0, 255)},
+		{"Add 0+0", Add(rgb(0, 0, 0), rgb(0, 0, 0)), rgb(0, 0, 0)},
+		{"Sub 100-200", Sub(rgb(100, 100, 100), rgb(200, 200, 200)), rgb(0, 0, 0)},
+		{"Sub 0-255", Sub(rgb(0, 255, 10), rgb(255, 255, 0)), rgb(0, 0, 10)},
+		{"Sub 255-0", Sub(rgb(255, 255, 255), rgb(0, 0, 0)), rgb(255, 255, 255)},
+		{"Scale x2", Scale(2, rgb(200, 100, 0)), rgb(255, 200, 0)},
+		{"Scale x-1", Scale(-1, rgb(200, 100, 0)), rgb(0, 0, 0)},
+		{"Scale x1", Scale(1, rgb(255, 0, 128)), rgb(255, 0, 128)},
+		{"Lerp t=0", Lerp(rgb(0, 255, 0), rgb(255, 0, 255), 0), rgb(0, 255, 0)},
+		{"Lerp t=1", Lerp(rgb(0, 255, 0), rgb(255, 0, 255), 1), rgb(255, 0, 255)},
+		{"Lerp t=2", Lerp(rgb(0, 255, 0), rgb(255, 0, 255), 2), rgb(255, 0, 255)},
+		{"Lerp t=-1", Lerp(rgb(0, 255, 0), rgb(255, 0, 255), -1), rgb(0, 255, 0)},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package quantize
+
+import (
+	"image/color"
+	"math"
+)
+
+//
+// 			Color vision deficiencies.
+//
+
+// The palettes of charts and maps must stay distinguishable for the colorblind viewers. The most common deficiencies,
+// protanopia and deuteranopia, confuse the reds and the greens, but keep the lightness: the palette colors
+// which they confuse are told apart by pulling their lightness apart, which keeps their hues.
+
+// cvdMatrices holds the linear RGB transforms simulating the color vision deficiencies, indexed by name.
+// See Machado, Oliveira and Fernandes, "A Physiologically-based Model for Simulation of Color Vision Deficiency", 2009.
+var cvdMatrices = map[string][3][3]float64{
+	"protanopia": {
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	},
+	"deuteranopia": {
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	},
+}
+
+// SimulateCVD returns an opaque color as seen with a color vision deficiency, "protanopia" or "deuteranopia".
+func SimulateCVD(c color.RGBA, deficiency string) color.RGBA {
+	m := cvdMatrices[deficiency]
+	rgb := [3]float64{SRGBToLinear(c.R), SRGBToLinear(c.G), SRGBToLinear(c.B)}
+
+	var out [3]uint8
+	for i := range out {
+		out[i] = LinearToSRGB(m[i][0]*rgb[0] + m[i][1]*rgb[1] + m[i][2]*rgb[2])
+	}
+
+	return color.RGBA{out[0], out[1], out[2], 255}
+}
+
+// cvdLabs returns the CIELAB coordinates of a color as seen with protanopia then with deuteranopia.
+func cvdLabs(c color.RGBA) [2][3]float64 {
+	return [2][3]float64{RGBToLab(SimulateCVD(c, "protanopia")), RGBToLab(SimulateCVD(c, "deuteranopia"))}
+}
+
+// cvdDistance returns the smallest CIE 1976 ΔE between two colors seen with the same deficiency, given by their cvdLabs.
+func cvdDistance(labs1, labs2 [2][3]float64) float64 {
+	d := math.Inf(1)
+	for k := range labs1 {
+		var sum float64
+		for i := range labs1[k] {
+			sum += (labs1[k][i] - labs2[k][i]) * (labs1[k][i] - labs2[k][i])
+		}
+		d = math.Min(d, math.Sqrt(sum))
+	}
+
+	return d
+}
+
+// colorblindStep is the lightness change (CIELAB L*) of a color each time it is pulled away from another one.
+const colorblindStep = 1.
+
+// SeparateForColorblindness returns a palette whose colors are at least <minDeltaE> (CIE 1976 ΔE) apart
+// as seen with protanopia and with deuteranopia (see SimulateCVD), as far as possible: the lightness of the pairs
+// of colors which are too close is pulled apart step by step, the lighter color getting lighter and the darker one
+// darker, until no pair is too close, or the colors which are still too close reach black or white.
+// The <fixed> colors, e.g. the -keep-colors, do not change. The palette colors are made opaque.
+func SeparateForColorblindness(palette []color.RGBA, minDeltaE float64, fixed []color.RGBA) []color.RGBA {
+	separated := make([]color.RGBA, len(palette))
+	labs := make([][3]float64, len(palette))
+	seen := make([][2][3]float64, len(palette))
+	movable := make([]bool, len(palette))
+	kept := colorSet(fixed)
+	for i, c := range palette {
+		separated[i] = Opaque(c)
+		labs[i] = RGBToLab(separated[i])
+		seen[i] = cvdLabs(separated[i])
+		movable[i] = !kept[c]
+	}
+
+	// Each pass pulls apart every pair of colors which is too close; the pairs which cannot be pulled apart
+	// any more are left out. The lightness range is crossed in 100 passes at most.
+	stuck := map[[2]int]bool{}
+	pull := func(i int, step float64) bool {
+		if !movable[i] {
+			return false
+		}
+		lab := labs[i]
+		lab[0] = ClampF64(lab[0]+step, 0., 100.)
+		if lab[0] == labs[i][0] {
+			return false
+		}
+		rgb := LabToLinearRGB(lab)
+		c := color.RGBA{LinearToSRGB(rgb[0]), LinearToSRGB(rgb[1]), LinearToSRGB(rgb[2]), 255}
+		labs[i], separated[i], seen[i] = lab, c, cvdLabs(c)
+		return true
+	}
+	for pass := 0; pass < 100/colorblindStep; pass++ {
+		moved := false
+		for i := range separated {
+			for j := i + 1; j < len(separated); j++ {
+				if stuck[[2]int{i, j}] || cvdDistance(seen[i], seen[j]) >= minDeltaE {
+					continue
+				}
+
+				lighter, darker := i, j
+				if labs[lighter][0] < labs[darker][0] {
+					lighter, darker = darker, lighter
+				}
+				// Both pulls are attempted, even if the first one succeeds.
+				pulledUp := pull(lighter, colorblindStep)
+				pulledDown := pull(darker, -colorblindStep)
+				if !pulledUp && !pulledDown {
+					stuck[[2]int{i, j}] = true
+				} else {
+					moved = true
+				}
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+
+	return separated
+}
@@ -0,0 +1,677 @@
+package quantize
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//
+// 			Color spaces and color distances.
+//
+
+// ColorPoint holds the coordinates of a color in a color space.
+// Color spaces with three dimensions leave the last coordinate to 0.
+type ColorPoint [4]float64
+
+// ColorMetric measures how different two colors are.
+// Colors are first converted to points of a color space, then the distance between the points is measured.
+type ColorMetric interface {
+	// Point returns the coordinates of a color in the color space of the metric.
+	Point(c color.RGBA) ColorPoint
+	// Distance returns the distance between two points of the color space.
+	Distance(p, q ColorPoint) float64
+	// IsEuclidean reports whether Distance is the Euclidean distance (or a monotonic function of it).
+	// Nearest color searches can then use a k-d tree.
+	IsEuclidean() bool
+}
+
+// SpaceMetric is implemented by the metrics whose points can be converted back to colors, like OKLab.
+// Their palettes are generated in their color space (see MedianCutQuantizer).
+type SpaceMetric interface {
+	ColorMetric
+	// Color returns the color of a point of the color space, e.g. the mean of some points.
+	Color(p ColorPoint) color.RGBA
+}
+
+// NewColorMetric returns the metric of a color space ("rgb", "lab", "oklab", "oklch", "ycbcr", "hsv" or "hsl") and a distance ("euclidean", "de76" or "de2000").
+// Empty strings select the defaults: the rgb color space, or lab if the distance is a ΔE;
+// and the Euclidean distance of the color space.
+func NewColorMetric(colorspace, distance string) (ColorMetric, error) {
+	switch {
+	case (colorspace == "" || colorspace == "rgb") && (distance == "" || distance == "euclidean"):
+		return RGBMetric{}, nil
+	case (colorspace == "" || colorspace == "lab") && distance == "de76",
+		colorspace == "lab" && (distance == "" || distance == "euclidean"):
+		return DE76Metric{}, nil
+	case (colorspace == "" || colorspace == "lab") && distance == "de2000":
+		return DE2000Metric{}, nil
+	case colorspace == "ycbcr" && (distance == "" || distance == "euclidean"):
+		return YCbCrMetric{}, nil
+	case colorspace == "oklab" && (distance == "" || distance == "euclidean"):
+		return OKLabMetric{}, nil
+	case colorspace == "oklch" && (distance == "" || distance == "euclidean"):
+		return OKLCHMetric{}, nil
+	case colorspace == "hsv" && (distance == "" || distance == "euclidean"):
+		return HSVMetric{}, nil
+	case colorspace == "hsl" && (distance == "" || distance == "euclidean"):
+		return HSLMetric{}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported color space %q with metric %q (color spaces: rgb, lab, oklab, oklch, ycbcr, hsv, hsl; metrics: euclidean, de76, de2000)", colorspace, distance)
+}
+
+// RGBMetric is the Euclidean distance in the RGB cube, as computed by ColorDistance.
+type RGBMetric struct{}
+
+// Point implements the ColorMetric interface.
+func (RGBMetric) Point(c color.RGBA) ColorPoint {
+	return ColorPoint{float64(c.R), float64(c.G), float64(c.B)}
+}
+
+// Distance implements the ColorMetric interface.
+func (RGBMetric) Distance(p, q ColorPoint) float64 {
+	return EuclideanDistance(p, q)
+}
+
+// IsEuclidean implements the ColorMetric interface.
+func (RGBMetric) IsEuclidean() bool { return true }
+
+// DE76Metric is the CIE 1976 color difference (ΔE*ab): the Euclidean distance in CIELAB.
+type DE76Metric struct{}
+
+// Point implements the ColorMetric interface.
+func (DE76Metric) Point(c color.RGBA) ColorPoint {
+	lab := RGBToLab(c)
+	return ColorPoint{lab[0], lab[1], lab[2]}
+}
+
+// Distance implements the ColorMetric interface.
+func (DE76Metric) Distance(p, q ColorPoint) float64 {
+	return EuclideanDistance(p, q)
+}
+
+// IsEuclidean implements the ColorMetric interface.
+func (DE76Metric) IsEuclidean() bool { return true }
+
+// DE2000Metric is the CIEDE2000 color difference, computed in CIELAB.
+// It is more accurate than ΔE76, especially for blues and dark or unsaturated colors, but slower.
+type DE2000Metric struct{}
+
+// Point implements the ColorMetric interface.
+func (DE2000Metric) Point(c color.RGBA) ColorPoint {
+	lab := RGBToLab(c)
+	return ColorPoint{lab[0], lab[1], lab[2]}
+}
+
+// Distance implements the ColorMetric interface.
+func (DE2000Metric) Distance(p, q ColorPoint) float64 {
+	return DeltaE2000([3]float64{p[0], p[1], p[2]}, [3]float64{q[0], q[1], q[2]})
+}
+
+// IsEuclidean implements the ColorMetric interface.
+func (DE2000Metric) IsEuclidean() bool { return false }
+
+// RGBAMetric is the Euclidean distance in the 4D RGBA space.
+// The colors are compared premultiplied by their alpha, so that all the fully transparent colors are equal.
+type RGBAMetric struct{}
+
+// Point implements the ColorMetric interface.
+func (RGBAMetric) Point(c color.RGBA) ColorPoint {
+	return ColorPoint{float64(c.R), float64(c.G), float64(c.B), float64(c.A)}
+}
+
+// Distance implements the ColorMetric interface.
+func (RGBAMetric) Distance(p, q ColorPoint) float64 {
+	return EuclideanDistance(p, q)
+}
+
+// IsEuclidean implements the ColorMetric interface.
+func (RGBAMetric) IsEuclidean() bool { return true }
+
+// YCbCrMetric is the Euclidean distance in the YCbCr space of JPEG files (see color.RGBToYCbCr).
+// It separates the luma from the chroma, like CIELAB, but is much cheaper to compute.
+type YCbCrMetric struct{}
+
+// Point implements the ColorMetric interface.
+func (YCbCrMetric) Point(c color.RGBA) ColorPoint {
+	y, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+	return ColorPoint{float64(y), float64(cb), float64(cr)}
+}
+
+// Distance implements the ColorMetric interface.
+func (YCbCrMetric) Distance(p, q ColorPoint) float64 {
+	return EuclideanDistance(p, q)
+}
+
+// IsEuclidean implements the ColorMetric interface.
+func (YCbCrMetric) IsEuclidean() bool { return true }
+
+// EuclideanDistance computes the Euclidean distance between two points.
+func EuclideanDistance(p, q ColorPoint) float64 {
+	d := 0.
+	for i := range p {
+		d += (p[i] - q[i]) * (p[i] - q[i])
+	}
+
+	return math.Sqrt(d)
+}
+
+// WeightedMetric is a Euclidean metric whose coordinates weigh differently in the distance,
+// e.g. the luma more than the chroma in YCbCr or CIELAB, so that the nearest colors keep the luminance of the pixels
+// at the expense of their hue. The coordinate differences are multiplied by the weights.
+type WeightedMetric struct {
+	Metric  ColorMetric
+	Weights [3]float64
+}
+
+// NewWeightedMetric weighs the coordinates of a metric (see WeightedMetric). The metric must be Euclidean,
+// and must not have a hue, whose coordinates do not stand for a color component each.
+// The result is a SpaceMetric if <metric> is one.
+func NewWeightedMetric(metric ColorMetric, weights [3]float64) (ColorMetric, error) {
+	if _, hue := metric.(HueMetric); hue || !metric.IsEuclidean() {
+		return nil, fmt.Errorf("the metric weights need a Euclidean color space without a hue (rgb, lab, oklab or ycbcr)")
+	}
+	for _, w := range weights {
+		if w <= 0 {
+			return nil, fmt.Errorf("invalid metric weights %v (positive values expected)", weights)
+		}
+	}
+
+	w := WeightedMetric{metric, weights}
+	if _, ok := metric.(SpaceMetric); ok {
+		return weightedSpaceMetric{w}, nil
+	}
+	return w, nil
+}
+
+// ParseMetricWeights parses the comma-separated weights of the three coordinates of a color space, e.g. "2,1,1".
+func ParseMetricWeights(s string) ([3]float64, error) {
+	var weights [3]float64
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 {
+		return weights, fmt.Errorf("invalid metric weights %q (three values expected)", s)
+	}
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || v <= 0 {
+			return weights, fmt.Errorf("invalid metric weight %q (a positive value expected)", field)
+		}
+		weights[i] = v
+	}
+
+	return weights, nil
+}
+
+// Point implements the ColorMetric interface.
+func (m WeightedMetric) Point(c color.RGBA) ColorPoint {
+	p := m.Metric.Point(c)
+	for i, w := range m.Weights {
+		p[i] *= w
+	}
+
+	return p
+}
+
+// Distance implements the ColorMetric interface.
+func (m WeightedMetric) Distance(p, q ColorPoint) float64 {
+	return m.Metric.Distance(p, q)
+}
+
+// IsEuclidean implements the ColorMetric interface.
+func (m WeightedMetric) IsEuclidean() bool { return true }
+
+// weightedSpaceMetric is a WeightedMetric of a SpaceMetric.
+type weightedSpaceMetric struct {
+	WeightedMetric
+}
+
+// Color implements the SpaceMetric interface.
+func (m weightedSpaceMetric) Color(p ColorPoint) color.RGBA {
+	for i, w := range m.Weights {
+		p[i] /= w
+	}
+
+	return m.Metric.(SpaceMetric).Color(p)
+}
+
+//
+// 			CIELAB.
+//
+
+// The reference white of CIELAB: the D65 illuminant, which is sRGB's.
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+// srgbToLinearTable caches the linear-light values of the 256 sRGB channel values.
+var srgbToLinearTable = func() (table [256]float64) {
+	for v := range table {
+		c := float64(v) / 255.
+		if c <= 0.04045 {
+			table[v] = c / 12.92
+		} else {
+			table[v] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+
+	return table
+}()
+
+// SRGBToLinear converts an sRGB channel value in [0, 255] to a linear-light value in [0, 1].
+func SRGBToLinear(v uint8) float64 {
+	return srgbToLinearTable[v]
+}
+
+// LinearToSRGB converts a linear-light channel value in [0, 1] to an sRGB value in [0, 255].
+// Out of range values are clamped.
+func LinearToSRGB(l float64) uint8 {
+	l = ClampF64(l, 0., 1.)
+
+	c := l * 12.92
+	if l > 0.0031308 {
+		c = 1.055*math.Pow(l, 1./2.4) - 0.055
+	}
+
+	return uint8(math.Round(c * 255.))
+}
+
+// RGBToLab converts an sRGB color to CIELAB (L in [0, 100]).
+// The alpha channel is ignored.
+func RGBToLab(c color.RGBA) [3]float64 {
+	r, g, b := SRGBToLinear(c.R), SRGBToLinear(c.G), SRGBToLinear(c.B)
+
+	// Linear sRGB to CIE XYZ.
+	x := 0.4124564*r + 0.3575761*g + 0.1804375*b
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := 0.0193339*r + 0.1191920*g + 0.9503041*b
+
+	fx, fy, fz := labF(x/whiteX), labF(y/whiteY), labF(z/whiteZ)
+
+	return [3]float64{
+		116.*fy - 16.,
+		500. * (fx - fy),
+		200. * (fy - fz),
+	}
+}
+
+// LabToLinearRGB converts a CIELAB color to linear-light sRGB channel values,
+// which are out of [0, 1] if the color is outside the sRGB gamut.
+func LabToLinearRGB(lab [3]float64) [3]float64 {
+	fy := (lab[0] + 16.) / 116.
+	fx := fy + lab[1]/500.
+	fz := fy - lab[2]/200.
+	x, y, z := whiteX*labFInverse(fx), whiteY*labFInverse(fy), whiteZ*labFInverse(fz)
+
+	// CIE XYZ to linear sRGB.
+	return [3]float64{
+		3.2404542*x - 1.5371385*y - 0.4985314*z,
+		-0.9692660*x + 1.8760108*y + 0.0415560*z,
+		0.0556434*x - 0.2040259*y + 1.0572252*z,
+	}
+}
+
+// labFInverse is the inverse of labF.
+func labFInverse(t float64) float64 {
+	const delta = 6. / 29.
+	if t > delta {
+		return t * t * t
+	}
+
+	return 3. * delta * delta * (t - 4./29.)
+}
+
+// labF is the nonlinear function of the XYZ to CIELAB conversion.
+func labF(t float64) float64 {
+	const delta = 6. / 29.
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+
+	return t/(3.*delta*delta) + 4./29.
+}
+
+// DeltaE2000 computes the CIEDE2000 color difference between two CIELAB colors.
+// See https://en.wikipedia.org/wiki/Color_difference#CIEDE2000
+func DeltaE2000(lab1, lab2 [3]float64) float64 {
+	l1, a1, b1 := lab1[0], lab1[1], lab1[2]
+	l2, a2, b2 := lab2[0], lab2[1], lab2[2]
+
+	// Adjust the a* axis so that neutral colors are handled better.
+	c1 := math.Hypot(a1, b1)
+	c2 := math.Hypot(a2, b2)
+	cMean7 := math.Pow((c1+c2)/2., 7)
+	g := 0.5 * (1. - math.Sqrt(cMean7/(cMean7+math.Pow(25., 7))))
+	a1p := (1. + g) * a1
+	a2p := (1. + g) * a2
+
+	c1p := math.Hypot(a1p, b1)
+	c2p := math.Hypot(a2p, b2)
+	h1p := hueAngle(a1p, b1)
+	h2p := hueAngle(a2p, b2)
+
+	// Differences in lightness, chroma and hue.
+	dLp := l2 - l1
+	dCp := c2p - c1p
+	dhp := 0.
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180. {
+			dhp -= 360.
+		} else if dhp < -180. {
+			dhp += 360.
+		}
+	}
+	dHp := 2. * math.Sqrt(c1p*c2p) * math.Sin(degToRad(dhp/2.))
+
+	// Means of lightness, chroma and hue.
+	lMean := (l1 + l2) / 2.
+	cMean := (c1p + c2p) / 2.
+	hMean := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) <= 180. {
+			hMean /= 2.
+		} else if h1p+h2p < 360. {
+			hMean = (hMean + 360.) / 2.
+		} else {
+			hMean = (hMean - 360.) / 2.
+		}
+	}
+
+	t := 1. -
+		0.17*math.Cos(degToRad(hMean-30.)) +
+		0.24*math.Cos(degToRad(2.*hMean)) +
+		0.32*math.Cos(degToRad(3.*hMean+6.)) -
+		0.20*math.Cos(degToRad(4.*hMean-63.))
+
+	lMean50 := (lMean - 50.) * (lMean - 50.)
+	sL := 1. + 0.015*lMean50/math.Sqrt(20.+lMean50)
+	sC := 1. + 0.045*cMean
+	sH := 1. + 0.015*cMean*t
+
+	cMean7 = math.Pow(cMean, 7)
+	rC := 2. * math.Sqrt(cMean7/(cMean7+math.Pow(25., 7)))
+	dTheta := 30. * math.Exp(-((hMean-275.)/25.)*((hMean-275.)/25.))
+	rT := -math.Sin(degToRad(2.*dTheta)) * rC
+
+	dL := dLp / sL
+	dC := dCp / sC
+	dH := dHp / sH
+
+	return math.Sqrt(dL*dL + dC*dC + dH*dH + rT*dC*dH)
+}
+
+// hueAngle returns the angle of the point (a, b) in degrees, in [0, 360).
+func hueAngle(a, b float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+
+	h := radToDeg(math.Atan2(b, a))
+	if h < 0 {
+		h += 360.
+	}
+
+	return h
+}
+
+func degToRad(d float64) float64 { return d * math.Pi / 180. }
+
+func radToDeg(r float64) float64 { return r * 180. / math.Pi }
+
+//
+// 			OKLab.
+//
+
+// RGBToOKLab converts an sRGB color to OKLab (L in [0, 1]), a perceptual color space whose hues stay constant
+// along straight lines better than CIELAB's. The alpha channel is ignored.
+func RGBToOKLab(c color.RGBA) [3]float64 {
+	r, g, b := SRGBToLinear(c.R), SRGBToLinear(c.G), SRGBToLinear(c.B)
+
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+
+	return [3]float64{
+		0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+// OKLabToLinearRGB converts an OKLab color to linear-light sRGB channel values,
+// which are out of [0, 1] if the color is outside the sRGB gamut.
+func OKLabToLinearRGB(lab [3]float64) [3]float64 {
+	l := lab[0] + 0.3963377774*lab[1] + 0.2158037573*lab[2]
+	m := lab[0] - 0.1055613458*lab[1] - 0.0638541728*lab[2]
+	s := lab[0] - 0.0894841775*lab[1] - 1.2914855480*lab[2]
+	l, m, s = l*l*l, m*m*m, s*s*s
+
+	return [3]float64{
+		4.0767416621*l - 3.3077115913*m + 0.2309699292*s,
+		-1.2684380046*l + 2.6097574011*m - 0.3413193965*s,
+		-0.0041960863*l - 0.7034186147*m + 1.7076147010*s,
+	}
+}
+
+// OKLabMetric is the Euclidean distance in OKLab, which is nearly as perceptually uniform as CIEDE2000
+// but as cheap as ΔE76. The mediancut palettes are generated by sorting the colors by lightness,
+// and averaged in OKLab.
+type OKLabMetric struct{}
+
+// Point implements the ColorMetric interface.
+func (OKLabMetric) Point(c color.RGBA) ColorPoint {
+	lab := RGBToOKLab(c)
+	return ColorPoint{lab[0], lab[1], lab[2]}
+}
+
+// Distance implements the ColorMetric interface.
+func (OKLabMetric) Distance(p, q ColorPoint) float64 {
+	return EuclideanDistance(p, q)
+}
+
+// IsEuclidean implements the ColorMetric interface.
+func (OKLabMetric) IsEuclidean() bool { return true }
+
+// Color implements the SpaceMetric interface.
+func (OKLabMetric) Color(p ColorPoint) color.RGBA {
+	return okLabColor(p)
+}
+
+// OKLCHMetric is OKLCH, the cylindrical form of OKLab: its distance is OKLabMetric's,
+// but the mediancut palettes are generated by sorting the colors by hue.
+type OKLCHMetric struct{ OKLabMetric }
+
+// Hue implements the HueMetric interface.
+func (OKLCHMetric) Hue(c color.RGBA) float64 {
+	lab := RGBToOKLab(c)
+	h := radToDeg(math.Atan2(lab[2], lab[1]))
+	if h < 0 {
+		h += 360.
+	}
+
+	return h
+}
+
+// okLabColor converts a point of OKLab to an opaque sRGB color; the colors out of the sRGB gamut are clipped.
+func okLabColor(p ColorPoint) color.RGBA {
+	rgb := OKLabToLinearRGB([3]float64{p[0], p[1], p[2]})
+	return color.RGBA{LinearToSRGB(rgb[0]), LinearToSRGB(rgb[1]), LinearToSRGB(rgb[2]), 255}
+}
+
+//
+// 			HSV and HSL.
+//
+
+// HueMetric is implemented by the metrics of the color spaces with a hue, like HSV and HSL.
+// Their palettes are generated by sorting the colors by hue (see MedianCutQuantizer).
+type HueMetric interface {
+	SpaceMetric
+	// Hue returns the hue of a color, in degrees in [0, 360).
+	Hue(c color.RGBA) float64
+}
+
+// HSVMetric is the Euclidean distance in the HSV cylinder: a color is the point (S cos H, S sin H, V),
+// so that the distance between hues is circular. S and V are scaled to [0, 100].
+type HSVMetric struct{}
+
+// Point implements the ColorMetric interface.
+func (HSVMetric) Point(c color.RGBA) ColorPoint {
+	return cylinderPoint(RGBToHSV(c))
+}
+
+// Distance implements the ColorMetric interface.
+func (HSVMetric) Distance(p, q ColorPoint) float64 {
+	return EuclideanDistance(p, q)
+}
+
+// IsEuclidean implements the ColorMetric interface.
+func (HSVMetric) IsEuclidean() bool { return true }
+
+// Hue implements the HueMetric interface.
+func (HSVMetric) Hue(c color.RGBA) float64 {
+	return RGBToHSV(c)[0]
+}
+
+// Color implements the SpaceMetric interface.
+func (HSVMetric) Color(p ColorPoint) color.RGBA {
+	return HSVToRGB(cylinderCoordinates(p))
+}
+
+// HSLMetric is the Euclidean distance in the HSL cylinder: a color is the point (S cos H, S sin H, L),
+// so that the distance between hues is circular. S and L are scaled to [0, 100].
+type HSLMetric struct{}
+
+// Point implements the ColorMetric interface.
+func (HSLMetric) Point(c color.RGBA) ColorPoint {
+	return cylinderPoint(RGBToHSL(c))
+}
+
+// Distance implements the ColorMetric interface.
+func (HSLMetric) Distance(p, q ColorPoint) float64 {
+	return EuclideanDistance(p, q)
+}
+
+// IsEuclidean implements the ColorMetric interface.
+func (HSLMetric) IsEuclidean() bool { return true }
+
+// Hue implements the HueMetric interface.
+func (HSLMetric) Hue(c color.RGBA) float64 {
+	return RGBToHSL(c)[0]
+}
+
+// Color implements the SpaceMetric interface.
+func (HSLMetric) Color(p ColorPoint) color.RGBA {
+	return HSLToRGB(cylinderCoordinates(p))
+}
+
+// cylinderPoint returns the cartesian coordinates of a point given by a hue (in degrees), a radius and a height, both in [0, 1].
+func cylinderPoint(hsx [3]float64) ColorPoint {
+	h := degToRad(hsx[0])
+	return ColorPoint{100 * hsx[1] * math.Cos(h), 100 * hsx[1] * math.Sin(h), 100 * hsx[2]}
+}
+
+// cylinderCoordinates is the inverse of cylinderPoint.
+func cylinderCoordinates(p ColorPoint) [3]float64 {
+	h := radToDeg(math.Atan2(p[1], p[0]))
+	if h < 0 {
+		h += 360
+	}
+
+	return [3]float64{h, ClampF64(math.Hypot(p[0], p[1])/100, 0, 1), ClampF64(p[2]/100, 0, 1)}
+}
+
+// RGBToHSV converts a color to HSV: its hue in degrees in [0, 360), its saturation and its value in [0, 1].
+// The alpha channel is ignored.
+func RGBToHSV(c color.RGBA) [3]float64 {
+	h, max, min := hue(c)
+
+	s := 0.
+	if max > 0 {
+		s = (max - min) / max
+	}
+
+	return [3]float64{h, s, max}
+}
+
+// HSVToRGB converts an HSV color to an opaque RGB color.
+func HSVToRGB(hsv [3]float64) color.RGBA {
+	c := hsv[2] * hsv[1]
+	return hueToRGB(hsv[0], c, hsv[2]-c)
+}
+
+// RGBToHSL converts a color to HSL: its hue in degrees in [0, 360), its saturation and its lightness in [0, 1].
+// The alpha channel is ignored.
+func RGBToHSL(c color.RGBA) [3]float64 {
+	h, max, min := hue(c)
+
+	l := (max + min) / 2
+	s := 0.
+	if l > 0 && l < 1 {
+		s = (max - min) / (1 - math.Abs(2*l-1))
+	}
+
+	return [3]float64{h, s, l}
+}
+
+// HSLToRGB converts an HSL color to an opaque RGB color.
+func HSLToRGB(hsl [3]float64) color.RGBA {
+	c := (1 - math.Abs(2*hsl[2]-1)) * hsl[1]
+	return hueToRGB(hsl[0], c, hsl[2]-c/2)
+}
+
+// hue returns the hue of a color, in degrees in [0, 360), and its maximum and minimum channel values in [0, 1].
+func hue(c color.RGBA) (h, max, min float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	max = math.Max(r, math.Max(g, b))
+	min = math.Min(r, math.Min(g, b))
+
+	d := max - min
+	switch {
+	case d == 0:
+		h = 0
+	case max == r:
+		h = 60 * math.Mod((g-b)/d, 6)
+	case max == g:
+		h = 60 * ((b-r)/d + 2)
+	default:
+		h = 60 * ((r-g)/d + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	return h, max, min
+}
+
+// hueToRGB returns the opaque color of a hue (in degrees) with a chroma <c>, to which <m> is added.
+func hueToRGB(h, c, m float64) color.RGBA {
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	return color.RGBA{
+		uint8(math.Round(ClampF64(r+m, 0, 1) * 255)),
+		uint8(math.Round(ClampF64(g+m, 0, 1) * 255)),
+		uint8(math.Round(ClampF64(b+m, 0, 1) * 255)),
+		255,
+	}
+}
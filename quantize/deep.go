@@ -0,0 +1,88 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+)
+
+//
+// 			16-bit images.
+//
+
+// IsDeepImage reports whether an image has 16 bits per channel, e.g. a 16-bit PNG.
+func IsDeepImage(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+
+	return false
+}
+
+// to8 rounds a 16-bit channel value to 8 bits.
+func to8(v uint32) uint8 {
+	return uint8((v*255 + 32767) / 65535)
+}
+
+// deepColor returns the 16-bit premultiplied channel values of the pixel located at column x and row y of a 16-bit image.
+func deepColor(img image.Image, x, y int) (r, g, b, a uint32) {
+	switch img := img.(type) {
+	case *image.RGBA64:
+		c := img.RGBA64At(x, y)
+		return uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
+	case *image.NRGBA64:
+		i := img.PixOffset(x, y)
+		p := img.Pix[i : i+8 : i+8]
+		return color.NRGBA64{
+			R: uint16(p[0])<<8 | uint16(p[1]),
+			G: uint16(p[2])<<8 | uint16(p[3]),
+			B: uint16(p[4])<<8 | uint16(p[5]),
+			A: uint16(p[6])<<8 | uint16(p[7]),
+		}.RGBA()
+	}
+
+	return img.At(x, y).RGBA()
+}
+
+// deepPixelColor returns the color of a pixel of a 16-bit image, rounding its channels to 8 bits.
+func deepPixelColor(img image.Image, x, y int) color.RGBA {
+	r, g, b, a := deepColor(img, x, y)
+	return color.RGBA{to8(r), to8(g), to8(b), to8(a)}
+}
+
+// ditheredDeepImage shows a 16-bit image with its channels dithered down to 8 bits (see DitherDeepImage).
+type ditheredDeepImage struct {
+	image.Image
+}
+
+func (ditheredDeepImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (img ditheredDeepImage) At(x, y int) color.Color {
+	return PixelColor(img, x, y)
+}
+
+// DitherDeepImage returns a view of a 16-bit image whose channels are dithered down to 8 bits with a 4x4 Bayer matrix
+// instead of being rounded: the part of each value below the 8-bit step is kept on average over the neighboring pixels.
+// The palette generation then sees the 16-bit mean colors, and the smooth 16-bit gradients do not turn into bands
+// before the dithering. Other images are returned as is.
+func DitherDeepImage(img image.Image) image.Image {
+	if !IsDeepImage(img) {
+		return img
+	}
+
+	return ditheredDeepImage{img}
+}
+
+// ditheredDeepColor returns the color of a pixel of a ditheredDeepImage.
+func ditheredDeepColor(img image.Image, x, y int) color.RGBA {
+	// The threshold is in [0, 1) of the 8-bit step, i.e. 257 16-bit levels.
+	threshold := uint32((BayerCoefficient(x, y, 4) + 0.5) * 257)
+	channel := func(v uint32) uint8 {
+		return uint8(ClampAboveInt(int(v+threshold)/257, 255))
+	}
+
+	r, g, b, a := deepColor(img, x, y)
+	return color.RGBA{channel(r), channel(g), channel(b), channel(a)}
+}
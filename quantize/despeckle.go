@@ -0,0 +1,83 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+)
+
+//
+// 			Despeckling.
+//
+
+// The mapping leaves lone pixels of another color in the flat areas, where the source colors hesitate between two
+// palette colors, and around the edges. The pixel-art look wants clean areas: the despeckling replaces each pixel
+// whose color none of its eight neighbors shares by the majority color of these neighbors.
+
+// Despeckle returns a copy of a paletted image where each isolated pixel, whose palette index none of its
+// eight neighbors shares, gets the most frequent index among them, and the number of pixels replaced.
+// The ties go to the neighbor color the closest to the color of the pixel, then to the lowest index.
+// The pixels on the borders only count their neighbors in the image. All the pixels are decided on
+// the original image, so that the result does not depend on the scan order.
+func Despeckle(img *image.Paletted) (*image.Paletted, int) {
+	b := img.Bounds()
+	out := image.NewPaletted(b, img.Palette)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		copy(out.Pix[out.PixOffset(b.Min.X, y):], img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)])
+	}
+	palette := PaletteColors(img.Palette)
+
+	replaced := 0
+	var counts [MaxPaletteSize]int
+	neighbors := make([]uint8, 0, 8)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			index := img.ColorIndexAt(x, y)
+
+			// Count the indices of the neighbors, stopping at the first one sharing the index of the pixel.
+			neighbors = neighbors[:0]
+			isolated := true
+			for dy := -1; dy <= 1 && isolated; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					p := image.Pt(x+dx, y+dy)
+					if (dx == 0 && dy == 0) || !p.In(b) {
+						continue
+					}
+					n := img.ColorIndexAt(p.X, p.Y)
+					if n == index {
+						isolated = false
+						break
+					}
+					neighbors = append(neighbors, n)
+				}
+			}
+			if !isolated || len(neighbors) == 0 {
+				continue
+			}
+
+			for _, n := range neighbors {
+				counts[n]++
+			}
+			best := neighbors[0]
+			for _, n := range neighbors[1:] {
+				if counts[n] > counts[best] || (counts[n] == counts[best] && closer(palette, index, n, best)) {
+					best = n
+				}
+			}
+			for _, n := range neighbors {
+				counts[n] = 0
+			}
+
+			out.SetColorIndex(x, y, best)
+			replaced++
+		}
+	}
+
+	return out, replaced
+}
+
+// closer reports whether the palette color <a> is closer to the color <index> than the color <b> is,
+// the lowest index winning the ties.
+func closer(palette []color.RGBA, index, a, b uint8) bool {
+	da, db := ColorDistance(palette[index], palette[a]), ColorDistance(palette[index], palette[b])
+	return da < db || (da == db && a < b)
+}
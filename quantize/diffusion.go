@@ -0,0 +1,239 @@
+package quantize
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+//
+// 			Error diffusion dithering.
+//
+
+// FloydSteinbergDitherer applies the Floyd–Steinberg error diffusion: the difference between each pixel
+// color and its palette color is spread over the next pixels.
+// See https://en.wikipedia.org/wiki/Floyd%E2%80%93Steinberg_dithering
+// The pixels depend on the previous ones, so an image is processed by a single goroutine.
+type FloydSteinbergDitherer struct {
+	// Metric is the color metric used to find the nearest palette colors; nil means RGBMetric.
+	Metric ColorMetric
+	// Linear makes the error computed and spread in linear light instead of sRGB.
+	Linear bool
+	// Strength scales the error spread in each channel; nil means FullDitherStrength.
+	Strength *DitherStrength
+	DiffusionOptions
+	// Indexes, if not nil, caches the palette indices across images (see PaletteIndexCache).
+	Indexes *PaletteIndexCache
+	// Progress is notified of the rows processed, if not nil.
+	Progress ProgressFunc
+}
+
+// Dither implements the Ditherer interface.
+func (d FloydSteinbergDitherer) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	out, _ := d.DitherContext(context.Background(), img, palette)
+	return out
+}
+
+// DitherContext implements the ContextDitherer interface.
+func (d FloydSteinbergDitherer) DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	out := image.NewPaletted(img.Bounds(), ColorPalette(palette))
+	if err := d.DitherInto(ctx, out, img, palette); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// DitherInto implements the IntoDitherer interface.
+func (d FloydSteinbergDitherer) DitherInto(ctx context.Context, out *image.Paletted, img image.Image, palette []color.RGBA) error {
+	index := d.Indexes.Index(palette, d.Metric)
+	strength := d.Strength.orFull()
+	rows := NewRowProgress(d.Progress, "dither", img.Bounds().Dy())
+
+	// The channel values are handled in [0, 255], in linear light or not.
+	toValue := func(v uint8) float64 { return float64(v) }
+	toChannel := func(v float64) uint8 { return uint8(math.Round(ClampF64(v, 0., 255.))) }
+	if d.Linear {
+		toValue = func(v uint8) float64 { return SRGBToLinear(v) * 255. }
+		toChannel = func(v float64) uint8 { return LinearToSRGB(v / 255.) }
+	}
+
+	// The errors of the current row and the next one; they have one more column on each side.
+	errs := newDiffusionRows(img.Bounds().Dx(), 3)
+	defer errs.release()
+
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		start, end, dir := d.columns(img.Bounds(), y)
+		for x := start; x != end; x += dir {
+			c := PixelColor(img, x, y)
+			e := d.clamp(errs.at(x - img.Bounds().Min.X))
+
+			// The color is premultiplied, so its channels cannot exceed its alpha.
+			alpha := toValue(c.A)
+			v := [3]float64{
+				ClampF64(toValue(c.R)+e[0], 0., alpha),
+				ClampF64(toValue(c.G)+e[1], 0., alpha),
+				ClampF64(toValue(c.B)+e[2], 0., alpha),
+			}
+
+			i := index.Nearest(color.RGBA{toChannel(v[0]), toChannel(v[1]), toChannel(v[2]), c.A})
+			out.SetColorIndex(x, y, uint8(i))
+
+			p := palette[i]
+			errs.spreadTo(x-img.Bounds().Min.X, []float64{
+				(v[0] - toValue(p.R)) * strength[0],
+				(v[1] - toValue(p.G)) * strength[1],
+				(v[2] - toValue(p.B)) * strength[2],
+			}, dir, d.keep(img, x, y))
+		}
+
+		errs.advance()
+		rows.Done(1)
+	}
+
+	return nil
+}
+
+// DiffusionOptions are the options of the error diffusion. The zero value scans the rows from left to right
+// and spreads the whole error to every neighbor.
+type DiffusionOptions struct {
+	// Serpentine makes every other row scanned from right to left, which breaks the diagonal artifacts.
+	Serpentine bool
+	// ErrorClamp, if positive, bounds the error diffused to a pixel in each channel (in [0, 255]),
+	// so that the error does not pile up in the areas out of the palette gamut and then bleed far away.
+	ErrorClamp float64
+	// EdgeThreshold, if positive, stops the error at the edges: it is not spread to the neighbors whose color differs from
+	// the pixel color by more than this value in a channel (in [0, 255]), which keeps flat-color illustrations clean.
+	EdgeThreshold float64
+}
+
+// columns returns the first column of the row <y> of an image in the scanning order, the column past the last one,
+// and the scanning direction: 1 from left to right, -1 from right to left.
+func (o DiffusionOptions) columns(b image.Rectangle, y int) (start, end, dir int) {
+	if o.Serpentine && (y-b.Min.Y)%2 == 1 {
+		return b.Max.X - 1, b.Min.X - 1, -1
+	}
+
+	return b.Min.X, b.Max.X, 1
+}
+
+// clamp bounds the error diffused to a pixel, in place, and returns it.
+func (o DiffusionOptions) clamp(e []float64) []float64 {
+	if o.ErrorClamp > 0 {
+		for k := range e {
+			e[k] = ClampF64(e[k], -o.ErrorClamp, o.ErrorClamp)
+		}
+	}
+
+	return e
+}
+
+// keep returns the function reporting whether the error of the pixel (<x>, <y>) of an image is spread
+// to its neighbor at offset (dx, dy), or nil if it is spread to every neighbor.
+func (o DiffusionOptions) keep(img image.Image, x, y int) func(dx, dy int) bool {
+	if o.EdgeThreshold <= 0 {
+		return nil
+	}
+
+	c := PixelColor(img, x, y)
+	return func(dx, dy int) bool {
+		n := PixelColor(img, x+dx, y+dy)
+		return math.Abs(float64(n.R)-float64(c.R)) <= o.EdgeThreshold &&
+			math.Abs(float64(n.G)-float64(c.G)) <= o.EdgeThreshold &&
+			math.Abs(float64(n.B)-float64(c.B)) <= o.EdgeThreshold &&
+			math.Abs(float64(n.A)-float64(c.A)) <= o.EdgeThreshold
+	}
+}
+
+// diffusionRows holds the errors diffused to the current row of pixels and to the next one.
+type diffusionRows struct {
+	channels  int
+	cur, next []float64
+}
+
+// newDiffusionRows creates the error rows of an image of a given width, with some channels per pixel.
+// They are taken from diffusionBuffers if possible; release puts them back.
+func newDiffusionRows(width, channels int) *diffusionRows {
+	n := (width + 2) * channels
+	if r, ok := diffusionBuffers.Get().(*diffusionRows); ok && cap(r.cur) >= n && cap(r.next) >= n {
+		r.channels, r.cur, r.next = channels, r.cur[:n], r.next[:n]
+		for i := range r.cur {
+			r.cur[i], r.next[i] = 0, 0
+		}
+		return r
+	}
+
+	return &diffusionRows{
+		channels: channels,
+		cur:      make([]float64, n),
+		next:     make([]float64, n),
+	}
+}
+
+// diffusionBuffers keeps the error rows released by the ditherers across the images they map.
+var diffusionBuffers sync.Pool
+
+// release puts the error rows back into diffusionBuffers; they must not be used afterwards.
+func (r *diffusionRows) release() {
+	diffusionBuffers.Put(r)
+}
+
+// at returns the error diffused to the pixel of column <x> of the current row.
+func (r *diffusionRows) at(x int) []float64 {
+	i := (x + 1) * r.channels
+	return r.cur[i : i+r.channels]
+}
+
+// floydSteinbergWeights are the Floyd–Steinberg weights, out of 16, of the next pixel in the scanning order
+// and of the pixels below, behind, under and ahead.
+var floydSteinbergWeights = [4]struct {
+	dx, dy int
+	weight float64
+}{{1, 0, 7}, {-1, 1, 3}, {0, 1, 5}, {1, 1, 1}}
+
+// spread diffuses the error of the pixel of column <x> of the current row with the Floyd–Steinberg weights.
+func (r *diffusionRows) spread(x int, e []float64) {
+	r.spreadTo(x, e, 1, nil)
+}
+
+// spreadTo is spread, with the scanning direction <dir> (1 from left to right, -1 from right to left).
+// If <keep> is not nil, the error is only spread to the neighbors at the offsets (dx, dy) it accepts,
+// whose weights are scaled up so that the whole error is spread; it is lost if there is none.
+func (r *diffusionRows) spreadTo(x int, e []float64, dir int, keep func(dx, dy int) bool) {
+	total := 16.
+	var kept [4]bool
+	for n, w := range floydSteinbergWeights {
+		kept[n] = keep == nil || keep(w.dx*dir, w.dy)
+		if !kept[n] {
+			total -= w.weight
+		}
+	}
+
+	for n, w := range floydSteinbergWeights {
+		if !kept[n] {
+			continue
+		}
+		row := r.cur
+		if w.dy == 1 {
+			row = r.next
+		}
+		i := (x + 1 + w.dx*dir) * r.channels
+		for k, v := range e {
+			row[i+k] += v * w.weight / total
+		}
+	}
+}
+
+// advance moves to the next row.
+func (r *diffusionRows) advance() {
+	r.cur, r.next = r.next, r.cur
+	for i := range r.next {
+		r.next[i] = 0
+	}
+}
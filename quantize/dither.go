@@ -0,0 +1,721 @@
+package quantize
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//
+// 			Dithering algorithms.
+//
+
+// Ditherer is implemented by every dithering algorithm.
+// Dither maps each pixel of <img> to a color of <palette> and returns the result as a paletted image.
+// The source image is not modified.
+// Dither may be called concurrently, on different images, in batch mode.
+type Ditherer interface {
+	Dither(img image.Image, palette []color.RGBA) *image.Paletted
+}
+
+// ContextDitherer is implemented by the ditherers whose work can be canceled.
+// DitherContext is Dither, but it returns ctx.Err() as soon as possible once <ctx> is canceled.
+type ContextDitherer interface {
+	Ditherer
+	DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error)
+}
+
+// IntoDitherer is implemented by the ditherers which can write their result into an existing paletted image,
+// e.g. the frame buffer of a GIF encoder or of a game engine, instead of allocating one (see ToPaletted).
+// DitherInto is DitherContext, but it writes the palette indices into the pixels of <dst>, whose bounds
+// must be those of <img>; the palette of dst is left as is.
+type IntoDitherer interface {
+	ContextDitherer
+	DitherInto(ctx context.Context, dst *image.Paletted, img image.Image, palette []color.RGBA) error
+}
+
+// DitherInto dithers an image with a ditherer into the pixels of an existing paletted image of the same bounds,
+// stopping early if <ctx> is canceled (see DitherContext). The ditherers which are not IntoDitherers
+// write into an image of their own, which is then copied.
+func DitherInto(ctx context.Context, d Ditherer, dst *image.Paletted, img image.Image, palette []color.RGBA) error {
+	if dst.Rect != img.Bounds() {
+		return fmt.Errorf("the paletted image is %v, not the %v bounds of the image", dst.Rect, img.Bounds())
+	}
+	if id, ok := d.(IntoDitherer); ok {
+		return id.DitherInto(ctx, dst, img, palette)
+	}
+
+	out, err := DitherContext(ctx, d, img, palette)
+	if err != nil {
+		return err
+	}
+	for y := dst.Rect.Min.Y; y < dst.Rect.Max.Y; y++ {
+		copy(dst.Pix[dst.PixOffset(dst.Rect.Min.X, y):dst.PixOffset(dst.Rect.Max.X, y)], out.Pix[out.PixOffset(out.Rect.Min.X, y):])
+	}
+
+	return nil
+}
+
+// DitherContext dithers an image with a ditherer, stopping early if <ctx> is canceled.
+// A ditherer which is not a ContextDitherer cannot be interrupted: ctx is only checked
+// before and after its work.
+func DitherContext(ctx context.Context, d Ditherer, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	if cd, ok := d.(ContextDitherer); ok {
+		return cd.DitherContext(ctx, img, palette)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	out := d.Dither(img, palette)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// DitherOptions gathers the settings a DithererFactory can use to configure its ditherer.
+// Factories are free to ignore the settings they do not need.
+type DitherOptions struct {
+	// BayerMatSize is the size of the Bayer matrix, a power of two (see CheckBayerMatSize).
+	BayerMatSize int
+	// Threads is the maximum number of goroutines working on an image; 0 means GOMAXPROCS.
+	Threads int
+	// Metric is the color metric used to find the nearest palette colors; nil means RGBMetric.
+	Metric ColorMetric
+	// Linear makes the dithering offsets applied in linear light instead of sRGB.
+	Linear bool
+	// Strength scales the dithering offsets of each channel; nil means FullDitherStrength.
+	Strength *DitherStrength
+	// Luminance makes the dithering offsets applied to the luma only, preserving the hues.
+	Luminance bool
+	// Lab makes the dithering offsets applied to the CIELAB lightness (see OffsetPixelLab); it overrides Linear and Luminance.
+	Lab bool
+	// DiffusionOptions are the options of the error diffusion.
+	DiffusionOptions
+	// Seed is the seed of the random ditherer; 0 means DefaultSeed.
+	Seed int64
+	// Matrix is the threshold map of the ordered dithering; nil means the Bayer matrix of size BayerMatSize.
+	Matrix ThresholdMatrix
+	// Offset shifts the threshold matrix of the ordered dithering.
+	Offset image.Point
+	// Orientation turns and mirrors the threshold matrix of the ordered dithering.
+	Orientation Orientation
+	// Indexes, if not nil, caches the palette indices across images (see PaletteIndexCache).
+	Indexes *PaletteIndexCache
+	// Progress is notified of the rows processed during the "dither" phase, if not nil.
+	Progress ProgressFunc
+}
+
+// DithererFactory creates a ditherer configured with some options, or returns an error if the options it uses
+// are invalid, e.g. a Bayer matrix size which is not a power of two.
+type DithererFactory func(opts DitherOptions) (Ditherer, error)
+
+// ditherers holds the registered dithering algorithms, indexed by name.
+var ditherers = map[string]DithererFactory{}
+
+func init() {
+	RegisterDitherer("bayer", func(opts DitherOptions) (Ditherer, error) {
+		if err := CheckBayerMatSize(opts.BayerMatSize); err != nil {
+			return nil, err
+		}
+		return BayerDitherer{
+			MatSize:     opts.BayerMatSize,
+			Offset:      opts.Offset,
+			Orientation: opts.Orientation,
+			Threads:     opts.Threads,
+			Metric:      opts.Metric,
+			Linear:      opts.Linear,
+			Strength:    opts.Strength,
+			Luminance:   opts.Luminance,
+			Lab:         opts.Lab,
+			Indexes:     opts.Indexes,
+			Progress:    opts.Progress,
+		}, nil
+	})
+	RegisterDitherer("adaptive-bayer", func(opts DitherOptions) (Ditherer, error) {
+		d, err := ditherers["bayer"](opts)
+		if err != nil {
+			return nil, err
+		}
+		return AdaptiveBayerDitherer{BayerDitherer: d.(BayerDitherer)}, nil
+	})
+	RegisterDitherer("ordered", func(opts DitherOptions) (Ditherer, error) {
+		if opts.Matrix == nil {
+			return ditherers["bayer"](opts)
+		}
+		return OrderedDitherer{
+			Matrix:      opts.Matrix,
+			Offset:      opts.Offset,
+			Orientation: opts.Orientation,
+			Threads:     opts.Threads,
+			Metric:      opts.Metric,
+			Linear:      opts.Linear,
+			Strength:    opts.Strength,
+			Luminance:   opts.Luminance,
+			Lab:         opts.Lab,
+			Indexes:     opts.Indexes,
+			Progress:    opts.Progress,
+		}, nil
+	})
+	RegisterDitherer("floyd-steinberg", func(opts DitherOptions) (Ditherer, error) {
+		return FloydSteinbergDitherer{
+			Metric:           opts.Metric,
+			Linear:           opts.Linear,
+			Strength:         opts.Strength,
+			DiffusionOptions: opts.DiffusionOptions,
+			Indexes:          opts.Indexes,
+			Progress:         opts.Progress,
+		}, nil
+	})
+	for name, pattern := range patterns {
+		matrix := pattern()
+		RegisterDitherer(name, func(opts DitherOptions) (Ditherer, error) {
+			opts.Matrix = matrix
+			return ditherers["ordered"](opts)
+		})
+	}
+	RegisterDitherer("ign", func(opts DitherOptions) (Ditherer, error) {
+		return noiseDitherer(opts, InterleavedGradientNoise), nil
+	})
+	RegisterDitherer("random", func(opts DitherOptions) (Ditherer, error) {
+		return noiseDitherer(opts, WhiteNoise(opts.Seed)), nil
+	})
+	RegisterDitherer("riemersma", func(opts DitherOptions) (Ditherer, error) {
+		return RiemersmaDitherer{Metric: opts.Metric, Linear: opts.Linear, Strength: opts.Strength, Indexes: opts.Indexes, Progress: opts.Progress}, nil
+	})
+	RegisterDitherer("none", func(opts DitherOptions) (Ditherer, error) {
+		return NoDitherer{Threads: opts.Threads, Metric: opts.Metric, Indexes: opts.Indexes, Progress: opts.Progress}, nil
+	})
+}
+
+// noiseDitherer creates an ordered ditherer whose offsets are given by a noise function.
+func noiseDitherer(opts DitherOptions, noise ThresholdFunc) OrderedDitherer {
+	return OrderedDitherer{
+		Noise:       noise,
+		Offset:      opts.Offset,
+		Orientation: opts.Orientation,
+		Threads:     opts.Threads,
+		Metric:      opts.Metric,
+		Linear:      opts.Linear,
+		Strength:    opts.Strength,
+		Luminance:   opts.Luminance,
+		Lab:         opts.Lab,
+		Indexes:     opts.Indexes,
+		Progress:    opts.Progress,
+	}
+}
+
+// RegisterDitherer makes a dithering algorithm available under a given name.
+// Registering a name twice replaces the previous factory.
+func RegisterDitherer(name string, factory DithererFactory) {
+	ditherers[name] = factory
+}
+
+// NewDitherer creates the ditherer registered under <name>.
+func NewDitherer(name string, opts DitherOptions) (Ditherer, error) {
+	factory, ok := ditherers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown ditherer %q (available: %v)", name, DithererNames())
+	}
+
+	return factory(opts)
+}
+
+// DithererNames returns the names of all the registered ditherers, sorted alphabetically.
+func DithererNames() []string {
+	var names []string
+	for name := range ditherers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NoDitherer maps every pixel to its nearest palette color, without any dithering.
+type NoDitherer struct {
+	// Threads is the maximum number of goroutines working on an image; 0 means GOMAXPROCS.
+	Threads int
+	// Metric is the color metric used to find the nearest palette colors; nil means RGBMetric.
+	Metric ColorMetric
+	// Indexes, if not nil, caches the palette indices across images (see PaletteIndexCache).
+	Indexes *PaletteIndexCache
+	// Progress is notified of the rows processed, if not nil.
+	Progress ProgressFunc
+}
+
+// Dither implements the Ditherer interface.
+func (d NoDitherer) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	out, _ := d.DitherContext(context.Background(), img, palette)
+	return out
+}
+
+// DitherContext implements the ContextDitherer interface.
+func (d NoDitherer) DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	out := image.NewPaletted(img.Bounds(), ColorPalette(palette))
+	if err := d.DitherInto(ctx, out, img, palette); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// DitherInto implements the IntoDitherer interface.
+func (d NoDitherer) DitherInto(ctx context.Context, out *image.Paletted, img image.Image, palette []color.RGBA) error {
+	index := d.Indexes.Index(palette, d.Metric)
+	rows := NewRowProgress(d.Progress, "dither", img.Bounds().Dy())
+
+	// A paletted image, e.g. a GIF frame, is remapped by its palette entries.
+	if remap, ok := paletteRemap(img, index); ok {
+		src := paletteSource(img)
+		return ParallelRowsContext(ctx, img.Bounds(), d.Threads, func(minY, maxY int) {
+			for y := minY; y < maxY; y++ {
+				in := src.Pix[src.PixOffset(img.Bounds().Min.X, y):src.PixOffset(img.Bounds().Max.X, y)]
+				row := out.Pix[out.PixOffset(img.Bounds().Min.X, y):]
+				for x, i := range in {
+					row[x] = remap[i]
+				}
+			}
+			rows.Done(maxY - minY)
+		})
+	}
+
+	return ParallelRowsContext(ctx, img.Bounds(), d.Threads, func(minY, maxY int) {
+		buf := getRowBuffer(img.Bounds().Dx())
+		defer rowBuffers.Put(buf)
+		row := *buf
+		for y := minY; y < maxY; y++ {
+			for x := range row {
+				row[x] = PixelColor(img, img.Bounds().Min.X+x, y)
+			}
+			writeNearestRow(out, y, row, index)
+		}
+		rows.Done(maxY - minY)
+	})
+}
+
+// paletteSource returns the paletted image under the views ApplyPalette puts on the images (see opaqueImage
+// and grayImage), or nil if the image is not paletted.
+func paletteSource(img image.Image) *image.Paletted {
+	switch img := img.(type) {
+	case *image.Paletted:
+		return img
+	case opaqueImage:
+		return paletteSource(img.Image)
+	case grayImage:
+		return paletteSource(img.Image)
+	}
+
+	return nil
+}
+
+// withPaletteSource returns the views of an image (see paletteSource) put on another paletted image.
+func withPaletteSource(img image.Image, src *image.Paletted) image.Image {
+	switch img := img.(type) {
+	case opaqueImage:
+		return opaqueImage{withPaletteSource(img.Image, src)}
+	case grayImage:
+		return grayImage{withPaletteSource(img.Image, src), img.linear}
+	}
+
+	return src
+}
+
+// paletteRemap returns the palette indices of the nearest colors of the 256 possible indices of a paletted image,
+// seen through its views (see paletteSource), so that it is mapped with one lookup per palette entry
+// instead of one per pixel. Only the indices of the palette of the image are looked up: image.Paletted cannot read
+// the pixels of other indices either, so the remap leaves them at 0. It reports false if the image is not paletted.
+func paletteRemap(img image.Image, index *PaletteIndex) (*[MaxPaletteSize]uint8, bool) {
+	src := paletteSource(img)
+	if src == nil {
+		return nil, false
+	}
+
+	// The colors are read through the views, put on a one-pixel paletted image holding each index in turn.
+	pixel := &image.Paletted{Pix: []uint8{0}, Stride: 1, Rect: image.Rect(0, 0, 1, 1), Palette: src.Palette}
+	view := withPaletteSource(img, pixel)
+	var remap [MaxPaletteSize]uint8
+	for i := 0; i < len(src.Palette) && i < MaxPaletteSize; i++ {
+		pixel.Pix[0] = uint8(i)
+		remap[i] = uint8(index.Nearest(PixelColor(view, 0, 0)))
+	}
+
+	return &remap, true
+}
+
+// writeNearestRow writes to row <y> of <out> the palette indices of the nearest colors of <colors>,
+// the colors of the pixels of the row from its left edge (see PaletteIndex.NearestBatch).
+func writeNearestRow(out *image.Paletted, y int, colors []color.RGBA, index *PaletteIndex) {
+	i := out.PixOffset(out.Rect.Min.X, y)
+	index.NearestBatch(colors, out.Pix[i:i+len(colors)])
+}
+
+// rowBuffers keeps the row buffers of the ditherers, as *[]color.RGBA, across the images they map.
+var rowBuffers sync.Pool
+
+// getRowBuffer returns a buffer of <width> colors from rowBuffers, or a new one; it is put back once the row band is done.
+func getRowBuffer(width int) *[]color.RGBA {
+	if buf, ok := rowBuffers.Get().(*[]color.RGBA); ok && cap(*buf) >= width {
+		*buf = (*buf)[:width]
+		return buf
+	}
+
+	row := make([]color.RGBA, width)
+	return &row
+}
+
+//
+// 			Bayer dithering.
+//
+
+// FrameDitherer is implemented by the ditherers whose thresholds can move from frame to frame of an animation,
+// which spreads the dithering pattern over time (see TemporalOffset).
+type FrameDitherer interface {
+	Ditherer
+	// ForFrame returns the ditherer of a given frame, counted from 0.
+	ForFrame(frame int) Ditherer
+}
+
+// TemporalOffset returns the shift of the threshold matrices of a given frame of an animation.
+// The shifts of consecutive frames move along a diagonal which visits different thresholds
+// at every pixel, so that the frames average out to the source colors.
+func TemporalOffset(frame int) image.Point {
+	return image.Pt(3*frame, 5*frame)
+}
+
+// BayerDitherer applies ordered dithering with a Bayer matrix.
+// See https://en.wikipedia.org/wiki/Ordered_dithering
+type BayerDitherer struct {
+	// MatSize is the size of the Bayer matrix, a power of two (see CheckBayerMatSize).
+	MatSize int
+	// Offset shifts the threshold matrix, e.g. to align the patterns of tiles quantized separately,
+	// or from frame to frame of an animation (see TemporalOffset).
+	Offset image.Point
+	// Orientation turns and mirrors the threshold matrix.
+	Orientation Orientation
+	// Threads is the maximum number of goroutines working on an image; 0 means GOMAXPROCS.
+	Threads int
+	// Metric is the color metric used to find the nearest palette colors; nil means RGBMetric.
+	Metric ColorMetric
+	// Linear makes the offsets applied in linear light instead of sRGB.
+	Linear bool
+	// Strength scales the offsets of each channel; nil means FullDitherStrength.
+	Strength *DitherStrength
+	// Luminance makes the offsets applied to the luma only, preserving the hues (see OffsetPixelLuminance).
+	Luminance bool
+	// Lab makes the offsets applied to the CIELAB lightness (see OffsetPixelLab).
+	Lab bool
+	// Indexes, if not nil, caches the palette indices across images (see PaletteIndexCache).
+	Indexes *PaletteIndexCache
+	// Progress is notified of the rows processed, if not nil.
+	Progress ProgressFunc
+}
+
+// Dither implements the Ditherer interface. It returns nil if MatSize is invalid, which NewDitherer
+// and DitherContext report as an error.
+func (d BayerDitherer) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	out, _ := d.DitherContext(context.Background(), img, palette)
+	return out
+}
+
+// ForFrame implements the FrameDitherer interface.
+func (d BayerDitherer) ForFrame(frame int) Ditherer {
+	d.Offset = d.Offset.Add(TemporalOffset(frame))
+	return d
+}
+
+// DitherContext implements the ContextDitherer interface.
+func (d BayerDitherer) DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	// Create the resulting image; undefined pixel colors for now.
+	out := image.NewPaletted(img.Bounds(), ColorPalette(palette))
+	if err := d.DitherInto(ctx, out, img, palette); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// DitherInto implements the IntoDitherer interface.
+func (d BayerDitherer) DitherInto(ctx context.Context, out *image.Paletted, img image.Image, palette []color.RGBA) error {
+	matrix, err := BayerMatrix(d.MatSize)
+	if err != nil {
+		return err
+	}
+
+	index := d.Indexes.Index(palette, d.Metric)
+	offsetPixel := offsetFunc(d.Linear, d.Luminance, d.Lab)
+	strength := d.Strength.orFull()
+	rows := NewRowProgress(d.Progress, "dither", img.Bounds().Dy())
+
+	// Compute its pixels by applying dithering to the source image.
+	// Each pixel is processed independently, so row bands are processed in parallel.
+	return ParallelRowsContext(ctx, img.Bounds(), d.Threads, func(minY, maxY int) {
+		buf := getRowBuffer(img.Bounds().Dx())
+		defer rowBuffers.Put(buf)
+		row := *buf
+		for y := minY; y < maxY; y++ {
+			for i := range row {
+				x := img.Bounds().Min.X + i
+
+				// Get the pixel color in the source image.
+				c := PixelColor(img, x, y)
+
+				// Apply Bayer dithering to it.
+				row[i] = offsetPixel(c, matrix.Coefficient(d.Orientation.Point(x+d.Offset.X, y+d.Offset.Y)), len(palette), strength)
+			}
+
+			// Find an approximated color in the palette for the whole row, and write the indices in the result image.
+			writeNearestRow(out, y, row, index)
+		}
+		rows.Done(maxY - minY)
+	})
+}
+
+// MaxBayerMatSize is the size of the largest Bayer matrix.
+const MaxBayerMatSize = 256
+
+// CheckBayerMatSize returns an error if a Bayer matrix size is not a power of two in [2, MaxBayerMatSize].
+func CheckBayerMatSize(bayerMatSize int) error {
+	if bayerMatSize < 2 || bayerMatSize > MaxBayerMatSize || bayerMatSize&(bayerMatSize-1) != 0 {
+		return fmt.Errorf("invalid Bayer matrix size %d (a power of two from 2 to %d expected)", bayerMatSize, MaxBayerMatSize)
+	}
+
+	return nil
+}
+
+// BayerCoefficient returns the Bayer matrix coefficient for a given pixel coordinate, in [-0.5, 0.5).
+// The size of the matrix must be a power of two (see CheckBayerMatSize); other sizes are rounded
+// down to a power of two, and sizes below 2 give a matrix of size 2.
+func BayerCoefficient(x, y int, bayerMatSize int) float64 {
+	// The matrix M' of size 2n is made of four copies of the matrix M of size n:
+	//   | 4M + 0   4M + 2 |
+	//   | 4M + 3   4M + 1 |
+	// So the lowest bits of the coordinates select an offset of the matrix of size 2,
+	// whose weight is the largest one; the next bits select an offset four times lighter, and so on.
+	base := [2][2]int{{0, 2}, {3, 1}}
+	rank, n := 0, 1
+	for ; 2*n <= bayerMatSize || n == 1; n *= 2 {
+		rank = 4*rank + base[y&1][x&1]
+		x >>= 1
+		y >>= 1
+	}
+
+	return float64(rank)/float64(n*n) - 0.5
+}
+
+// BayerDitherPixel transforms a pixel color using Bayer dithering, with a matrix of size <bayerMatSize>.
+func BayerDitherPixel(c color.RGBA, x, y int, paletteSize int, bayerMatSize int) color.RGBA {
+	return OffsetPixel(c, BayerCoefficient(x, y, bayerMatSize), paletteSize, FullDitherStrength)
+}
+
+// BayerDitherPixelLinear transforms a pixel color using Bayer dithering, like BayerDitherPixel,
+// but the offset is added to the linear-light channel values.
+func BayerDitherPixelLinear(c color.RGBA, x, y int, paletteSize int, bayerMatSize int) color.RGBA {
+	return OffsetPixelLinear(c, BayerCoefficient(x, y, bayerMatSize), paletteSize, FullDitherStrength)
+}
+
+// OffsetPixel adds the offset of an ordered dithering to a pixel color.
+// The coefficient <coef>, in [-0.5, 0.5), is scaled to the distance between two palette colors,
+// and then to the strength of each channel.
+func OffsetPixel(c color.RGBA, coef float64, paletteSize int, strength DitherStrength) color.RGBA {
+	R := 255. / (float64(paletteSize))
+	k := R * coef
+
+	// Manually add the color offset to each channel value.
+	// We work with floats because the offset can be negative.
+	// Do not work with uint8!
+	// The color is premultiplied, so its channels cannot exceed its alpha.
+	r := float64(c.R) + k*strength[0]
+	g := float64(c.G) + k*strength[1]
+	b := float64(c.B) + k*strength[2]
+	a := float64(c.A)
+
+	return color.RGBA{
+		uint8(ClampF64(r, 0., a)),
+		uint8(ClampF64(g, 0., a)),
+		uint8(ClampF64(b, 0., a)),
+		c.A,
+	}
+}
+
+// OffsetPixelLinear adds the offset of an ordered dithering to the linear-light channel values of a pixel color.
+// The offset range is the whole linear range divided by the palette size, scaled to the strength of each channel.
+func OffsetPixelLinear(c color.RGBA, coef float64, paletteSize int, strength DitherStrength) color.RGBA {
+	k := coef / float64(paletteSize)
+
+	return color.RGBA{
+		ClampU8(LinearToSRGB(SRGBToLinear(c.R)+k*strength[0]), 0, c.A),
+		ClampU8(LinearToSRGB(SRGBToLinear(c.G)+k*strength[1]), 0, c.A),
+		ClampU8(LinearToSRGB(SRGBToLinear(c.B)+k*strength[2]), 0, c.A),
+		c.A,
+	}
+}
+
+// OffsetPixelLuminance adds the offset of an ordered dithering to the luma of a pixel color only,
+// preserving its hue: the color is mixed with black to lower its luma, and with white to raise it.
+// Unlike OffsetPixel, it does not speckle saturated areas with other hues.
+// The strength applied is the luma of <strength>.
+func OffsetPixelLuminance(c color.RGBA, coef float64, paletteSize int, strength DitherStrength) color.RGBA {
+	k := 255. / float64(paletteSize) * coef * luma(strength[0], strength[1], strength[2])
+	rgb := offsetLuminance([3]float64{float64(c.R), float64(c.G), float64(c.B)}, k, float64(c.A))
+
+	return color.RGBA{
+		uint8(ClampF64(rgb[0], 0., float64(c.A))),
+		uint8(ClampF64(rgb[1], 0., float64(c.A))),
+		uint8(ClampF64(rgb[2], 0., float64(c.A))),
+		c.A,
+	}
+}
+
+// OffsetPixelLuminanceLinear is OffsetPixelLuminance in linear light.
+func OffsetPixelLuminanceLinear(c color.RGBA, coef float64, paletteSize int, strength DitherStrength) color.RGBA {
+	k := coef / float64(paletteSize) * luma(strength[0], strength[1], strength[2])
+	a := SRGBToLinear(c.A)
+	rgb := offsetLuminance([3]float64{SRGBToLinear(c.R), SRGBToLinear(c.G), SRGBToLinear(c.B)}, k, a)
+
+	return color.RGBA{
+		ClampU8(LinearToSRGB(rgb[0]), 0, c.A),
+		ClampU8(LinearToSRGB(rgb[1]), 0, c.A),
+		ClampU8(LinearToSRGB(rgb[2]), 0, c.A),
+		c.A,
+	}
+}
+
+// offsetLuminance adds <k> to the luma of a color whose channels are in [0, <max>],
+// by mixing it with black or white.
+func offsetLuminance(rgb [3]float64, k, max float64) [3]float64 {
+	y := luma(rgb[0], rgb[1], rgb[2])
+	target := ClampF64(y+k, 0, max)
+
+	for i := range rgb {
+		if target < y {
+			rgb[i] *= target / y
+		} else if target > y {
+			rgb[i] += (max - rgb[i]) * (target - y) / (max - y)
+		}
+	}
+
+	return rgb
+}
+
+// luma returns the luma of a color, with the Rec. 709 coefficients.
+func luma(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// OffsetPixelLab adds the offset of an ordered dithering to the CIELAB lightness of a pixel color, keeping its a* and b*.
+// Where the new lightness leaves the sRGB gamut, the chroma is reduced until the color fits instead of clipping
+// its channels, which would shift its hue near the gamut edges and fringe grayish images with rainbow colors.
+// The offset range is the whole L* range divided by the palette size; the strength applied is the luma of <strength>.
+func OffsetPixelLab(c color.RGBA, coef float64, paletteSize int, strength DitherStrength) color.RGBA {
+	if c.A == 0 {
+		return c
+	}
+
+	lab := RGBToLab(Opaque(c))
+	lab[0] = ClampF64(lab[0]+100./float64(paletteSize)*coef*luma(strength[0], strength[1], strength[2]), 0., 100.)
+	rgb := LabToLinearRGB(lab)
+	if !inGamut(rgb) {
+		// Bisect the largest chroma scale keeping the color in the gamut; the gray of the same lightness is in it.
+		lo, hi := 0., 1.
+		for i := 0; i < 16; i++ {
+			m := (lo + hi) / 2.
+			if inGamut(LabToLinearRGB([3]float64{lab[0], lab[1] * m, lab[2] * m})) {
+				lo = m
+			} else {
+				hi = m
+			}
+		}
+		rgb = LabToLinearRGB([3]float64{lab[0], lab[1] * lo, lab[2] * lo})
+	}
+
+	// The color is premultiplied back.
+	a := uint32(c.A)
+	return color.RGBA{
+		uint8((uint32(LinearToSRGB(rgb[0]))*a + 127) / 255),
+		uint8((uint32(LinearToSRGB(rgb[1]))*a + 127) / 255),
+		uint8((uint32(LinearToSRGB(rgb[2]))*a + 127) / 255),
+		c.A,
+	}
+}
+
+// inGamut reports whether linear-light channel values are in [0, 1], up to rounding errors.
+func inGamut(rgb [3]float64) bool {
+	const epsilon = 1e-6
+	for _, v := range rgb {
+		if v < -epsilon || v > 1.+epsilon {
+			return false
+		}
+	}
+
+	return true
+}
+
+// offsetFunc returns the function adding the offset of an ordered dithering to a pixel color,
+// in linear light or not, to the luma only or to each channel, or to the CIELAB lightness.
+func offsetFunc(linear, luminance, lab bool) func(c color.RGBA, coef float64, paletteSize int, strength DitherStrength) color.RGBA {
+	switch {
+	case lab:
+		return OffsetPixelLab
+	case linear && luminance:
+		return OffsetPixelLuminanceLinear
+	case luminance:
+		return OffsetPixelLuminance
+	case linear:
+		return OffsetPixelLinear
+	default:
+		return OffsetPixel
+	}
+}
+
+//
+// 			Dithering strength.
+//
+
+// DitherStrength scales the dithering offsets of the red, green and blue channels.
+// Each strength is in [0, 1]: 1 is the full offset, 0 disables the dithering of a channel.
+type DitherStrength [3]float64
+
+// FullDitherStrength applies the full offsets to every channel.
+var FullDitherStrength = DitherStrength{1, 1, 1}
+
+// orFull returns the strength, or FullDitherStrength if it is nil.
+func (s *DitherStrength) orFull() DitherStrength {
+	if s == nil {
+		return FullDitherStrength
+	}
+
+	return *s
+}
+
+// ParseDitherStrength parses a strength shared by all the channels, e.g. "0.5",
+// or the comma-separated strengths of the red, green and blue channels, e.g. "1,0.5,0.5".
+func ParseDitherStrength(s string) (DitherStrength, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 1 && len(fields) != 3 {
+		return DitherStrength{}, fmt.Errorf("invalid dithering strength %q (one or three values expected)", s)
+	}
+
+	var strength DitherStrength
+	for i := range strength {
+		field := fields[0]
+		if len(fields) == 3 {
+			field = fields[i]
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || v < 0 || v > 1 {
+			return DitherStrength{}, fmt.Errorf("invalid dithering strength %q (a value in [0, 1] expected)", field)
+		}
+		strength[i] = v
+	}
+
+	return strength, nil
+}
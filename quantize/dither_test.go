@@ -0,0 +1,46 @@
+package quantize
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// A paletted image of fewer than 256 colors, e.g. a GIF frame, is remapped by its palette entries.
+func TestNoDithererRemapsSmallPalettes(t *testing.T) {
+	src := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{
+		color.RGBA{0, 0, 0, 255}, color.RGBA{90, 90, 90, 255}, color.RGBA{170, 170, 170, 255}, color.RGBA{255, 255, 255, 255},
+	})
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i % len(src.Palette))
+	}
+	palette := []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}}
+
+	for _, img := range []image.Image{src, opaqueImage{src}} {
+		out, err := NoDitherer{}.DitherContext(context.Background(), img, palette)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				if got, want := int(out.ColorIndexAt(x, y)), NearestColorIndex(PixelColor(src, x, y), palette); got != want {
+					t.Fatalf("%T: pixel (%d, %d) mapped to %d, want %d", img, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestNewDithererRejectsInvalidBayerSizes(t *testing.T) {
+	for _, name := range []string{"bayer", "adaptive-bayer", "ordered"} {
+		for _, size := range []int{0, 3, 2 * MaxBayerMatSize} {
+			if _, err := NewDitherer(name, DitherOptions{BayerMatSize: size}); err == nil {
+				t.Errorf("%s: Bayer matrix size %d accepted", name, size)
+			}
+		}
+		if _, err := NewDitherer(name, DitherOptions{BayerMatSize: 8}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
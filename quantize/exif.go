@@ -0,0 +1,244 @@
+package quantize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+//
+// 			EXIF metadata.
+//
+
+// EXIF orientations, see Orient.
+const (
+	OrientationNormal = 1
+	// OrientationTag is the tag of the orientation in the first IFD of the EXIF metadata.
+	OrientationTag = 0x0112
+)
+
+// ExifData returns the EXIF metadata (a TIFF structure) of an encoded JPEG or PNG image, or nil if it has none.
+func ExifData(data []byte) []byte {
+	// PNG images store it in an eXIf chunk.
+	if bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		for i := 8; i+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			kind := string(data[i+4 : i+8])
+			if length < 0 || i+12+length > len(data) || kind == "IDAT" {
+				return nil
+			}
+			if kind == "eXIf" {
+				return data[i+8 : i+8+length]
+			}
+			i += 12 + length
+		}
+		return nil
+	}
+
+	// JPEG images store it in an APP1 segment starting with "Exif\0\0".
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return nil
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		// The image data starts at the SOS segment.
+		if marker == 0xDA || i+2+length > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i += 2 + length
+	}
+
+	return nil
+}
+
+// orientationValue returns the position of the orientation value in EXIF metadata, and its byte order.
+// The position is negative if the metadata has no valid orientation.
+func orientationValue(exif []byte) (int, binary.ByteOrder) {
+	if len(exif) < 8 {
+		return -1, nil
+	}
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return -1, nil
+	}
+
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 0 || ifd+2 > len(exif) {
+		return -1, nil
+	}
+	entries := int(order.Uint16(exif[ifd:]))
+	for k := 0; k < entries; k++ {
+		entry := ifd + 2 + 12*k
+		if entry+12 > len(exif) {
+			break
+		}
+		// The orientation is a SHORT (type 3) stored in the value field of its entry.
+		if order.Uint16(exif[entry:]) == OrientationTag && order.Uint16(exif[entry+2:]) == 3 {
+			return entry + 8, order
+		}
+	}
+
+	return -1, nil
+}
+
+// ExifOrientation returns the orientation (1 to 8) given by EXIF metadata, or OrientationNormal if it has none.
+func ExifOrientation(exif []byte) int {
+	pos, order := orientationValue(exif)
+	if pos < 0 {
+		return OrientationNormal
+	}
+	if o := int(order.Uint16(exif[pos:])); o >= 1 && o <= 8 {
+		return o
+	}
+
+	return OrientationNormal
+}
+
+// WithNormalOrientation returns a copy of EXIF metadata whose orientation is OrientationNormal,
+// for images which were rotated according to it.
+func WithNormalOrientation(exif []byte) []byte {
+	exif = append([]byte(nil), exif...)
+	if pos, order := orientationValue(exif); pos >= 0 {
+		order.PutUint16(exif[pos:], OrientationNormal)
+	}
+
+	return exif
+}
+
+// Orient rotates and flips an image so that it is displayed upright according to an EXIF orientation (1 to 8).
+// The image is returned as is for OrientationNormal.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= OrientationNormal || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// source returns the coordinates, relative to the bounds, of the source pixel of each pixel of the result.
+	var source func(x, y int) (int, int)
+	switch orientation {
+	case 2: // Mirrored horizontally.
+		source = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // Rotated by 180°.
+		source = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // Mirrored vertically.
+		source = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // Transposed.
+		source = func(x, y int) (int, int) { return y, x }
+	case 6: // To be rotated by 90° clockwise.
+		source = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // Transversed.
+		source = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // To be rotated by 90° counterclockwise.
+		source = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	// The width and the height are swapped by the orientations from 5 on.
+	outW, outH := w, h
+	if orientation >= 5 {
+		outW, outH = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, outW, outH))
+	for y := 0; y < outH; y++ {
+		for x := 0; x < outW; x++ {
+			sx, sy := source(x, y)
+			out.SetRGBA(x, y, PixelColor(img, b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+
+	return out
+}
+
+// OrientRect returns the rectangle which a rectangle of an image of a given size covers once the image is turned
+// upright by Orient. The coordinates are relative to the top left corner of the image; the rectangle is first clipped
+// to the image.
+func OrientRect(r image.Rectangle, size image.Point, orientation int) image.Rectangle {
+	r = r.Intersect(image.Rectangle{Max: size})
+	if r.Empty() || orientation <= OrientationNormal || orientation > 8 {
+		return r
+	}
+
+	// target returns the coordinates of the pixel of the result of each source pixel, as Orient's source reversed.
+	w, h := size.X, size.Y
+	var target func(x, y int) (int, int)
+	switch orientation {
+	case 2:
+		target = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3:
+		target = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4:
+		target = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5:
+		target = func(x, y int) (int, int) { return y, x }
+	case 6:
+		target = func(x, y int) (int, int) { return h - 1 - y, x }
+	case 7:
+		target = func(x, y int) (int, int) { return h - 1 - y, w - 1 - x }
+	case 8:
+		target = func(x, y int) (int, int) { return y, w - 1 - x }
+	}
+
+	// The corner pixels are mapped, image.Rect ordering their coordinates.
+	x0, y0 := target(r.Min.X, r.Min.Y)
+	x1, y1 := target(r.Max.X-1, r.Max.Y-1)
+	r = image.Rect(x0, y0, x1, y1)
+	r.Max = r.Max.Add(image.Pt(1, 1))
+
+	return r
+}
+
+// InsertPNGChunk returns an encoded PNG image with an additional chunk right after its header (IHDR) chunk.
+func InsertPNGChunk(data []byte, kind string, chunk []byte) []byte {
+	// The 8 bytes signature is followed by the header chunk, whose data is 13 bytes long.
+	const headerEnd = 8 + 12 + 13
+
+	var buf bytes.Buffer
+	buf.Write(data[:headerEnd])
+	writePNGChunk(&buf, kind, chunk)
+	buf.Write(data[headerEnd:])
+
+	return buf.Bytes()
+}
+
+// PNGChunk is an ancillary chunk of a PNG file, e.g. eXIf for the EXIF metadata.
+type PNGChunk struct {
+	Kind string
+	Data []byte
+}
+
+// WritePNGWithChunks saves an image as a PNG file with additional chunks, inserted right after its header.
+// The image is written to the standard output if IsStdio(filepath).
+func WritePNGWithChunks(img image.Image, filepath string, chunks []PNGChunk) error {
+	var buf bytes.Buffer
+	if err := EncodePNG(&buf, img); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	for i := len(chunks) - 1; i >= 0; i-- {
+		data = InsertPNGChunk(data, chunks[i].Kind, chunks[i].Data)
+	}
+
+	outputFile, err := CreateOutputFile(filepath)
+	if err != nil {
+		return err
+	}
+
+	_, err = outputFile.Write(data)
+
+	// Don't forget to close files
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,242 @@
+package quantize
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+//
+// 			Source code and raw exports.
+//
+
+// The paletted images can be exported as a C header ("h"), a Go source file ("go") or raw binary ("bin"),
+// to be embedded in firmware or ROM builds. The pixels are palette indices, packed on ExportOptions.BitDepth bits
+// with the first pixel in the highest bits, and each row is padded to a multiple of ExportOptions.RowAlign bytes.
+// The raw binary holds the pixels only; its palette can be saved as a raw ACT file with -save-palette.
+
+// ExportOptions are the options of the source code and raw exports.
+// The zero value packs the pixels on the smallest bit depth holding the palette, with rows padded to whole bytes.
+type ExportOptions struct {
+	// BitDepth is the number of bits per pixel: 1, 2, 4 or 8; 0 means the smallest one holding the palette.
+	BitDepth int
+	// RowAlign is the number of bytes each row is a multiple of; 0 means 1.
+	RowAlign int
+	// Name is the base name of the identifiers of the source code; "image" if empty.
+	Name string
+	// Package is the package of the Go source; "main" if empty.
+	Package string
+}
+
+// exportFormats holds the export formats, indexed by name (see ExportOptions.Encoder).
+var exportFormats = map[string]func(ExportOptions, io.Writer, *image.Paletted) error{
+	"h":   ExportOptions.encodeC,
+	"go":  ExportOptions.encodeGo,
+	"bin": ExportOptions.encodeRaw,
+}
+
+func init() {
+	// The export formats are output formats too, with the default options.
+	for name := range exportFormats {
+		encoders[name] = ExportOptions{}.Encoder(name)
+	}
+}
+
+// IsExportFormat reports whether an output format is one of the source code and raw exports.
+func IsExportFormat(format string) bool {
+	_, ok := exportFormats[format]
+	return ok
+}
+
+// Encoder returns the encoder of an export format with these options, or nil if the format is not an export one.
+// The encoder only accepts paletted images.
+func (o ExportOptions) Encoder(format string) ImageEncoder {
+	encode, ok := exportFormats[format]
+	if !ok {
+		return nil
+	}
+
+	return func(w io.Writer, img image.Image) error {
+		paletted, ok := img.(*image.Paletted)
+		if !ok {
+			return fmt.Errorf("the %s format requires a paletted image", format)
+		}
+		return encode(o, w, paletted)
+	}
+}
+
+// ExportNameFromFilePath returns the base name of a filepath without its extension, as an export name.
+func ExportNameFromFilePath(filePath string) string {
+	if IsStdio(filePath) {
+		return ""
+	}
+
+	return strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+}
+
+// PackIndices packs the palette indices of an image on a bit depth (1, 2, 4 or 8), the first pixel in the highest bits,
+// each row being padded to a multiple of <rowAlign> bytes. It returns the packed pixels and the number of bytes of a row.
+func PackIndices(img *image.Paletted, bitDepth, rowAlign int) ([]byte, int, error) {
+	switch bitDepth {
+	case 1, 2, 4, 8:
+	default:
+		return nil, 0, fmt.Errorf("invalid bit depth %d (available: [1 2 4 8])", bitDepth)
+	}
+	if len(img.Palette) > 1<<bitDepth {
+		return nil, 0, fmt.Errorf("the palette has too many colors (%d) for %d bits per pixel", len(img.Palette), bitDepth)
+	}
+	rowAlign = ClampBelowInt(rowAlign, 1)
+
+	b := img.Bounds()
+	stride := (b.Dx()*bitDepth + 7) / 8
+	stride = (stride + rowAlign - 1) / rowAlign * rowAlign
+	data := make([]byte, stride*b.Dy())
+	perByte := 8 / bitDepth
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := data[(y-b.Min.Y)*stride:]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := x - b.Min.X
+			shift := 8 - bitDepth*(i%perByte+1)
+			row[i/perByte] |= img.ColorIndexAt(x, y) << shift
+		}
+	}
+
+	return data, stride, nil
+}
+
+// bitDepth returns the bit depth of the export of an image.
+func (o ExportOptions) bitDepth(img *image.Paletted) int {
+	if o.BitDepth != 0 {
+		return o.BitDepth
+	}
+
+	depth := 1
+	for len(img.Palette) > 1<<depth {
+		depth *= 2
+	}
+	return depth
+}
+
+// nameWords splits the export name into lowercase words made of letters and digits.
+// A name starting with a digit gets the "image" prefix, so that it makes valid identifiers.
+func (o ExportOptions) nameWords() []string {
+	words := strings.FieldsFunc(strings.ToLower(o.Name), func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+	})
+	if len(words) == 0 {
+		return []string{"image"}
+	}
+	if unicode.IsDigit(rune(words[0][0])) {
+		words = append([]string{"image"}, words...)
+	}
+
+	return words
+}
+
+// encodeRaw writes the packed pixels only.
+func (o ExportOptions) encodeRaw(w io.Writer, img *image.Paletted) error {
+	data, _, err := PackIndices(img, o.bitDepth(img), o.RowAlign)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// encodeC writes a C header declaring the size, the palette (non-premultiplied RGBA) and the packed pixels of an image.
+func (o ExportOptions) encodeC(w io.Writer, img *image.Paletted) error {
+	depth := o.bitDepth(img)
+	data, stride, err := PackIndices(img, depth, o.RowAlign)
+	if err != nil {
+		return err
+	}
+
+	name := strings.Join(o.nameWords(), "_")
+	macro := strings.ToUpper(name)
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "// %dx%d image, %d colors, %d bits per pixel, %d bytes per row.\n", b.Dx(), b.Dy(), len(img.Palette), depth, stride)
+	fmt.Fprintf(bw, "#ifndef %s_H\n#define %s_H\n\n#include <stdint.h>\n\n", macro, macro)
+	fmt.Fprintf(bw, "#define %s_WIDTH %d\n", macro, b.Dx())
+	fmt.Fprintf(bw, "#define %s_HEIGHT %d\n", macro, b.Dy())
+	fmt.Fprintf(bw, "#define %s_BPP %d\n", macro, depth)
+	fmt.Fprintf(bw, "#define %s_STRIDE %d\n", macro, stride)
+	fmt.Fprintf(bw, "#define %s_PALETTE_SIZE %d\n\n", macro, len(img.Palette))
+
+	fmt.Fprintf(bw, "static const uint8_t %s_palette[%d][4] = {\n", name, len(img.Palette))
+	writePaletteEntries(bw, img.Palette)
+	fmt.Fprintf(bw, "};\n\n")
+	fmt.Fprintf(bw, "static const uint8_t %s_pixels[%d] = {\n", name, len(data))
+	writeHexBytes(bw, data)
+	fmt.Fprintf(bw, "};\n\n#endif\n")
+
+	return bw.Flush()
+}
+
+// encodeGo writes a Go source file declaring the size, the palette and the packed pixels of an image.
+func (o ExportOptions) encodeGo(w io.Writer, img *image.Paletted) error {
+	depth := o.bitDepth(img)
+	data, stride, err := PackIndices(img, depth, o.RowAlign)
+	if err != nil {
+		return err
+	}
+
+	pkg := o.Package
+	if pkg == "" {
+		pkg = "main"
+	}
+	name := ""
+	for _, word := range o.nameWords() {
+		name += strings.ToUpper(word[:1]) + word[1:]
+	}
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "// Code generated by image-quantization. DO NOT EDIT.\n\npackage %s\n\nimport \"image/color\"\n\n", pkg)
+	fmt.Fprintf(bw, "// %dx%d image, %d colors, %d bits per pixel, %d bytes per row.\n\n", b.Dx(), b.Dy(), len(img.Palette), depth, stride)
+	fmt.Fprintf(bw, "const %sWidth = %d\n", name, b.Dx())
+	fmt.Fprintf(bw, "const %sHeight = %d\n", name, b.Dy())
+	fmt.Fprintf(bw, "const %sBitDepth = %d\n", name, depth)
+	fmt.Fprintf(bw, "const %sStride = %d\n\n", name, stride)
+
+	// The palette colors are premultiplied, as color.RGBA requires.
+	fmt.Fprintf(bw, "var %sPalette = []color.RGBA{\n", name)
+	for _, c := range img.Palette {
+		r, g, b, a := c.RGBA()
+		fmt.Fprintf(bw, "\t{0x%02x, 0x%02x, 0x%02x, 0x%02x},\n", r>>8, g>>8, b>>8, a>>8)
+	}
+	fmt.Fprintf(bw, "}\n\n")
+	fmt.Fprintf(bw, "var %sPixels = []byte{\n", name)
+	writeHexBytes(bw, data)
+	fmt.Fprintf(bw, "}\n")
+
+	return bw.Flush()
+}
+
+// writePaletteEntries writes the non-premultiplied RGBA values of palette colors as C array rows.
+func writePaletteEntries(w io.Writer, palette color.Palette) {
+	for _, c := range palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		fmt.Fprintf(w, "\t{0x%02x, 0x%02x, 0x%02x, 0x%02x},\n", n.R, n.G, n.B, n.A)
+	}
+}
+
+// writeHexBytes writes bytes as hexadecimal literals, 16 per line, each followed by a comma.
+func writeHexBytes(w io.Writer, data []byte) {
+	for i := 0; i < len(data); i += 16 {
+		line := data[i:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+		values := make([]string, len(line))
+		for k, v := range line {
+			values[k] = fmt.Sprintf("0x%02x,", v)
+		}
+		fmt.Fprintf(w, "\t%s\n", strings.Join(values, " "))
+	}
+}
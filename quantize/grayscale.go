@@ -0,0 +1,209 @@
+package quantize
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+//
+// 			Grayscale quantization.
+//
+
+// In grayscale mode (PaletteOptions.Grayscale), the pixels are converted to their luma (see Gray)
+// before anything else. The palette is then a ramp of gray levels (see GrayRamp), unless one is
+// supplied, and the image is dithered along a single axis by a GrayDitherer.
+
+// Gray returns the gray color whose luma is the luma of a given color (Rec. 709 coefficients).
+// If <linear> is set, the luma is computed in linear light, which gives the relative luminance.
+// The alpha value is kept.
+func Gray(c color.RGBA, linear bool) color.RGBA {
+	var y uint8
+	if linear {
+		y = LinearToSRGB(luma(SRGBToLinear(c.R), SRGBToLinear(c.G), SRGBToLinear(c.B)))
+	} else {
+		y = uint8(math.Round(luma(float64(c.R), float64(c.G), float64(c.B))))
+	}
+	y = ClampU8(y, 0, c.A)
+
+	return color.RGBA{y, y, y, c.A}
+}
+
+// GrayRamp returns <n> gray levels evenly spread from black to white (in sRGB).
+func GrayRamp(n int) []color.RGBA {
+	n = ClampBelowInt(n, 2)
+
+	ramp := make([]color.RGBA, n)
+	for i := range ramp {
+		y := uint8(math.Round(255. * float64(i) / float64(n-1)))
+		ramp[i] = color.RGBA{y, y, y, 255}
+	}
+
+	return ramp
+}
+
+// grayImage shows an image with all its pixels converted to gray (see Gray).
+type grayImage struct {
+	image.Image
+	linear bool
+}
+
+func (grayImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (img grayImage) At(x, y int) color.Color {
+	return Gray(PixelColor(img.Image, x, y), img.linear)
+}
+
+// GrayDitherer maps each pixel to the palette color whose luma is the nearest one,
+// after applying ordered dithering or error diffusion to the luma only.
+// Unlike the other ditherers, it does a one-dimensional search, which suits gray palettes.
+type GrayDitherer struct {
+	// Matrix is the threshold map of the ordered dithering; nil means no ordered dithering.
+	Matrix ThresholdMatrix
+	// Offset shifts the threshold matrix.
+	Offset image.Point
+	// Orientation turns and mirrors the threshold matrix.
+	Orientation Orientation
+	// Diffusion makes the Floyd–Steinberg error diffusion applied instead of the ordered dithering.
+	Diffusion bool
+	// Levels are the gray levels the palette colors stand for, e.g. the stops of a duotone ramp (see DuotoneLevels);
+	// nil means their luma.
+	Levels []uint8
+	// Bias is added to the gray levels (in [0, 255]) before they are mapped to the palette.
+	// With a black and white palette, 128 - t makes t the threshold of white pixels.
+	Bias float64
+	// Threads is the maximum number of goroutines working on an image; 0 means GOMAXPROCS.
+	Threads int
+	// Linear makes the luma computed and the offsets applied in linear light instead of sRGB.
+	Linear bool
+	// Strength scales the offsets; the luma of its channel strengths is used. nil means FullDitherStrength.
+	Strength *DitherStrength
+	// DiffusionOptions are the options of the error diffusion.
+	DiffusionOptions
+	// Progress is notified of the rows processed, if not nil.
+	Progress ProgressFunc
+}
+
+// Dither implements the Ditherer interface.
+func (d GrayDitherer) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	out, _ := d.DitherContext(context.Background(), img, palette)
+	return out
+}
+
+// DitherContext implements the ContextDitherer interface.
+func (d GrayDitherer) DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	if d.Levels != nil && len(d.Levels) != len(palette) {
+		return nil, fmt.Errorf("%d gray levels given for %d palette colors", len(d.Levels), len(palette))
+	}
+	paletteLevel := func(i int) uint8 {
+		if d.Levels != nil {
+			return d.Levels[i]
+		}
+		return Gray(palette[i], d.Linear).R
+	}
+
+	// Find the nearest palette color of every gray level once and for all.
+	var nearest [256]uint8
+	for v := range nearest {
+		best := math.Inf(1)
+		for i := range palette {
+			if dist := math.Abs(float64(paletteLevel(i)) - float64(v)); dist < best {
+				best = dist
+				nearest[v] = uint8(i)
+			}
+		}
+	}
+
+	strength := d.Strength.orFull()
+	s := luma(strength[0], strength[1], strength[2])
+
+	// The gray levels are handled in [0, 255], in linear light or not.
+	toValue := func(v uint8) float64 { return float64(v) }
+	toLevel := func(v float64) uint8 { return uint8(math.Round(ClampF64(v, 0., 255.))) }
+	if d.Linear {
+		toValue = func(v uint8) float64 { return SRGBToLinear(v) * 255. }
+		toLevel = func(v float64) uint8 { return LinearToSRGB(v / 255.) }
+	}
+	level := func(x, y int) uint8 {
+		return uint8(ClampF64(math.Round(float64(Gray(PixelColor(img, x, y), d.Linear).R)+d.Bias), 0., 255.))
+	}
+
+	out := image.NewPaletted(img.Bounds(), ColorPalette(palette))
+	rows := NewRowProgress(d.Progress, "dither", img.Bounds().Dy())
+
+	if d.Diffusion {
+		errs := newDiffusionRows(img.Bounds().Dx(), 1)
+		for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			start, end, dir := d.columns(img.Bounds(), y)
+			for x := start; x != end; x += dir {
+				v := ClampF64(toValue(level(x, y))+d.clamp(errs.at(x - img.Bounds().Min.X))[0], 0., 255.)
+				i := nearest[toLevel(v)]
+				out.SetColorIndex(x, y, i)
+				errs.spreadTo(x-img.Bounds().Min.X, []float64{(v - toValue(paletteLevel(int(i)))) * s}, dir, d.keep(img, x, y))
+			}
+
+			errs.advance()
+			rows.Done(1)
+		}
+
+		return out, nil
+	}
+
+	k := s / float64(len(palette))
+	err := ParallelRowsContext(ctx, img.Bounds(), d.Threads, func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+				v := level(x, y)
+				if d.Matrix != nil {
+					v = toLevel(toValue(v) + 255.*d.Matrix.Coefficient(d.Orientation.Point(x+d.Offset.X, y+d.Offset.Y))*k)
+				}
+				out.SetColorIndex(x, y, nearest[v])
+			}
+		}
+		rows.Done(maxY - minY)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+//
+// 			Duotone.
+//
+
+// In duotone mode, the image luma is mapped onto a ramp of two or more colors, from the first one for black
+// to the last one for white, and dithered between adjacent colors. Unlike the mapping to a palette,
+// the ramp is ordered: the colors stand for evenly spaced gray levels whatever their own luma.
+
+// ParseDuotone parses the colors of a duotone ramp, e.g. "#102030,#f0e0c0" (see ParseHexColors).
+func ParseDuotone(s string) ([]color.RGBA, error) {
+	colors, err := ParseHexColors(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(colors) < 2 || len(colors) > MaxPaletteSize {
+		return nil, fmt.Errorf("invalid duotone %q (between 2 and %d colors expected)", s, MaxPaletteSize)
+	}
+
+	return colors, nil
+}
+
+// DuotoneLevels returns the gray levels the <n> colors of a duotone ramp stand for, evenly spread from black to white.
+func DuotoneLevels(n int) []uint8 {
+	levels := make([]uint8, n)
+	for i := range levels {
+		levels[i] = uint8(math.Round(255. * float64(i) / float64(ClampBelowInt(n-1, 1))))
+	}
+
+	return levels
+}
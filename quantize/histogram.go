@@ -0,0 +1,321 @@
+package quantize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+//
+// 			Color histograms.
+//
+
+// A histogram counts the pixel colors of an image in bins: the colors whose channels have the same highest
+// bits fall in the same bin. Palettes are generated from the weighted mean colors of the bins, which takes
+// O(bins) memory instead of O(pixels), and is much faster on large images (see HistogramQuantizer).
+
+// WeightedColor is a color standing for a number of pixels.
+type WeightedColor struct {
+	Color  color.RGBA
+	Weight float64
+}
+
+// CountColors returns the distinct colors of <pixels>, in their order of appearance, weighted by their number of pixels.
+func CountColors(pixels []color.RGBA) []WeightedColor {
+	counts := map[color.RGBA]float64{}
+	var colors []WeightedColor
+	for _, c := range pixels {
+		if _, ok := counts[c]; !ok {
+			colors = append(colors, WeightedColor{Color: c})
+		}
+		counts[c]++
+	}
+	for i := range colors {
+		colors[i].Weight = counts[colors[i].Color]
+	}
+
+	return colors
+}
+
+// Histogram counts colors in bins of 2^(8-Bits) values per channel.
+type Histogram struct {
+	// Bits is the number of bits per channel kept to select a bin; 8 counts every distinct color.
+	Bits int
+
+	bins  map[uint32]*histogramBin
+	total int
+
+	// distinct holds the first distinct colors, as long as there are at most MaxPaletteSize of them.
+	distinct  []color.RGBA
+	seen      map[color.RGBA]bool
+	overflown bool
+}
+
+// histogramBin accumulates the colors of a bin.
+type histogramBin struct {
+	count int
+	sum   [4]uint64
+}
+
+// NewHistogram creates an empty histogram keeping <bits> bits per channel (clamped to [1, 8]).
+func NewHistogram(bits int) *Histogram {
+	return &Histogram{
+		Bits: ClampBelowInt(ClampAboveInt(bits, 8), 1),
+		bins: map[uint32]*histogramBin{},
+		seen: map[color.RGBA]bool{},
+	}
+}
+
+// Add counts a color.
+func (h *Histogram) Add(c color.RGBA) {
+	h.AddN(c, 1)
+}
+
+// AddN counts a color <n> times, e.g. for a pixel of weight n.
+func (h *Histogram) AddN(c color.RGBA, n int) {
+	shift := 8 - h.Bits
+	key := uint32(c.R>>shift)<<24 | uint32(c.G>>shift)<<16 | uint32(c.B>>shift)<<8 | uint32(c.A>>shift)
+	bin, ok := h.bins[key]
+	if !ok {
+		bin = &histogramBin{}
+		h.bins[key] = bin
+	}
+	bin.count += n
+	bin.sum[0] += uint64(n) * uint64(c.R)
+	bin.sum[1] += uint64(n) * uint64(c.G)
+	bin.sum[2] += uint64(n) * uint64(c.B)
+	bin.sum[3] += uint64(n) * uint64(c.A)
+	h.total += n
+
+	if !h.overflown && !h.seen[c] {
+		if len(h.distinct) == MaxPaletteSize {
+			h.overflown, h.seen = true, nil
+		} else {
+			h.seen[c] = true
+			h.distinct = append(h.distinct, c)
+		}
+	}
+}
+
+// Total returns the number of colors counted.
+func (h *Histogram) Total() int {
+	return h.total
+}
+
+// DistinctColors returns the distinct colors counted, in their order of appearance,
+// if there are at most <max> of them (and at most MaxPaletteSize). Otherwise it returns false.
+func (h *Histogram) DistinctColors(max int) ([]color.RGBA, bool) {
+	if h.overflown || len(h.distinct) > max {
+		return nil, false
+	}
+
+	return h.distinct, true
+}
+
+// Colors returns the mean color of each bin, weighted by its number of colors.
+// They are sorted by bin, so that the result does not depend on the map order.
+func (h *Histogram) Colors() []WeightedColor {
+	keys := make([]uint32, 0, len(h.bins))
+	for key := range h.bins {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	colors := make([]WeightedColor, len(keys))
+	for i, key := range keys {
+		bin := h.bins[key]
+		n := uint64(bin.count)
+		colors[i] = WeightedColor{
+			Color:  color.RGBA{uint8(bin.sum[0] / n), uint8(bin.sum[1] / n), uint8(bin.sum[2] / n), uint8(bin.sum[3] / n)},
+			Weight: float64(bin.count),
+		}
+	}
+
+	return colors
+}
+
+// Pixels returns about <max> pixel colors distributed like the counted colors,
+// for the algorithms which need pixels (see RefinePalette).
+func (h *Histogram) Pixels(max int) []color.RGBA {
+	scale := 1.
+	if h.total > max {
+		scale = float64(max) / float64(h.total)
+	}
+
+	var pixels []color.RGBA
+	for _, wc := range h.Colors() {
+		n := int(wc.Weight*scale + 0.5)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			pixels = append(pixels, wc.Color)
+		}
+	}
+
+	return pixels
+}
+
+// PaletteHistogram counts the pixels colors of an image which take part in the palette generation,
+// as PalettePixels collects them, in a histogram keeping opts.HistogramBits bits per channel.
+func PaletteHistogram(img image.Image, opts PaletteOptions) *Histogram {
+	h := NewHistogram(opts.HistogramBits)
+	AddToHistogram(h, img, opts)
+
+	return h
+}
+
+// AddToHistogram counts the pixels colors of an image in an existing histogram, e.g. for all the frames of an animation.
+// The pixels are counted opts.Weights times, if set; the pixels of the opts.Keep colors are left out.
+func AddToHistogram(h *Histogram, img image.Image, opts PaletteOptions) {
+	kept := colorSet(opts.Keep)
+	opts.Sampling.EachAt(img, func(x, y int, c color.RGBA) {
+		if c, ok := paletteColor(c, opts); ok && !kept[c] {
+			if n := pixelWeight(opts.Weights, x, y); n > 0 {
+				h.AddN(c, n)
+			}
+		}
+	})
+}
+
+// PaletteFromHistogram generates a color palette from a histogram, as PaletteFromPixels does from pixels.
+// The quantizer works on the weighted colors of the histogram if it is a HistogramQuantizer;
+// otherwise it gets pixels distributed like them.
+func PaletteFromHistogram(h *Histogram, paletteMaxSize int, opts PaletteOptions) []color.RGBA {
+	if opts.Fixed != nil {
+		return opts.Fixed
+	}
+	if minDeltaE := opts.ColorblindDeltaE; minDeltaE > 0 {
+		opts.ColorblindDeltaE = 0
+		return SeparateForColorblindness(PaletteFromHistogram(h, paletteMaxSize, opts), minDeltaE, opts.Keep)
+	}
+	if opts.Keep != nil {
+		return KeepColors(paletteMaxSize, opts, func(size int, opts PaletteOptions) []color.RGBA {
+			return PaletteFromHistogram(h, size, opts)
+		})
+	}
+
+	if opts.Progress != nil {
+		opts.Progress("palette", 0)
+		defer opts.Progress("palette", 1)
+	}
+
+	paletteMaxSize = ClampBelowInt(paletteMaxSize, 2)
+	paletteMaxSize = ClampAboveInt(paletteMaxSize, MaxPaletteSize)
+
+	if h.Total() == 0 {
+		return []color.RGBA{{0, 0, 0, 255}}
+	}
+	if opts.Exact {
+		if colors, ok := h.DistinctColors(paletteMaxSize); ok {
+			return colors
+		}
+	}
+	if opts.Grayscale {
+		return GrayRamp(paletteMaxSize)
+	}
+
+	quantizer := opts.Quantizer
+	if quantizer == nil {
+		quantizer = MedianCutQuantizer{}
+	}
+	var palette []color.RGBA
+	if hq, ok := quantizer.(HistogramQuantizer); ok {
+		palette = hq.PaletteFromHistogram(h.Colors(), paletteMaxSize, opts)
+	} else {
+		palette = quantizer.Palette(h.Pixels(h.Total()), paletteMaxSize, opts)
+	}
+
+	if opts.Refine {
+		palette = RefinePalette(palette, h.Pixels(maxRefineSamples), opts.MergeDeltaE)
+	}
+
+	return palette
+}
+
+// weightedMean computes the mean color of weighted colors, in linear light or not.
+// The alpha channel is averaged too (see meanAlpha); with <linear>, the mean color channels are clamped to it.
+func weightedMean(colors []WeightedColor, linear bool) color.RGBA {
+	var r, g, b, a, n float64
+	opaque := true
+	for _, wc := range colors {
+		if linear {
+			r += SRGBToLinear(wc.Color.R) * wc.Weight
+			g += SRGBToLinear(wc.Color.G) * wc.Weight
+			b += SRGBToLinear(wc.Color.B) * wc.Weight
+		} else {
+			r += float64(wc.Color.R) * wc.Weight
+			g += float64(wc.Color.G) * wc.Weight
+			b += float64(wc.Color.B) * wc.Weight
+		}
+		a += float64(wc.Color.A) * wc.Weight
+		n += wc.Weight
+		opaque = opaque && wc.Color.A == 255
+	}
+
+	alpha := meanAlpha(a, n, opaque)
+	if !linear {
+		return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), alpha}
+	}
+
+	return color.RGBA{
+		ClampU8(LinearToSRGB(r/n), 0, alpha),
+		ClampU8(LinearToSRGB(g/n), 0, alpha),
+		ClampU8(LinearToSRGB(b/n), 0, alpha),
+		alpha,
+	}
+}
+
+// meanAlpha returns the mean alpha value of colors, given the (weighted) sum of their alpha values and their total weight,
+// rounded to the nearest value. The mean of opaque colors is opaque, whatever the rounding errors of fractional weights.
+func meanAlpha(sum, n float64, opaque bool) uint8 {
+	if opaque {
+		return 255
+	}
+
+	return uint8(math.Min(sum/n+0.5, 255))
+}
+
+// HistogramCache keeps the palette histograms of images, so that the palettes generated from the same image
+// with the same pixel selection, e.g. with several palette sizes or algorithms, share one histogram.
+// A nil cache counts the pixels on each call. It can be used by several goroutines.
+type HistogramCache struct {
+	mu         sync.Mutex
+	histograms map[histogramKey]*Histogram
+}
+
+// histogramKey identifies an image and the options selecting its pixels (see AddToHistogram).
+type histogramKey struct {
+	img  image.Image
+	opts string
+}
+
+// NewHistogramCache creates an empty cache.
+func NewHistogramCache() *HistogramCache {
+	return &HistogramCache{histograms: map[histogramKey]*Histogram{}}
+}
+
+// Histogram returns the histogram of an image, as PaletteHistogram counts it.
+// The image must not be modified afterwards; the images which cannot be compared, e.g. struct values
+// holding slices, are counted on each call.
+func (c *HistogramCache) Histogram(img image.Image, opts PaletteOptions) *Histogram {
+	if c == nil || !reflect.TypeOf(img).Comparable() {
+		return PaletteHistogram(img, opts)
+	}
+
+	key := histogramKey{img, fmt.Sprintf("%d|%#v|%v|%d|%t|%t|%t|%#v", opts.HistogramBits, opts.Sampling, opts.Keep,
+		opts.AlphaThreshold, opts.Alpha4D, opts.Grayscale, opts.Linear, opts.Weights)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok := c.histograms[key]; ok {
+		return h
+	}
+	h := PaletteHistogram(img, opts)
+	c.histograms[key] = h
+
+	return h
+}
@@ -0,0 +1,269 @@
+package quantize
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+//
+// 			ICC color profiles.
+//
+
+// ICCProfile is an RGB matrix/TRC color profile: the colors of an image are converted to the XYZ space (D50)
+// by applying a tone curve to each channel, then a matrix.
+type ICCProfile struct {
+	// Matrix converts the linear RGB values to XYZ; its columns are the XYZ values of the primaries.
+	Matrix [3][3]float64
+	// Curves are the tone curves of the red, green and blue channels, from encoded values to linear values in [0, 1].
+	Curves [3]func(v float64) float64
+}
+
+// xyzD50ToLinearSRGB converts XYZ (D50) values to linear sRGB values, with a Bradford chromatic adaptation.
+var xyzD50ToLinearSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// srgbToXYZD50 is the matrix of the sRGB profile.
+var srgbToXYZD50 = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// ICCProfileData returns the ICC profile embedded in an encoded JPEG or PNG image, or nil if it has none.
+func ICCProfileData(data []byte) []byte {
+	// PNG images store it compressed in an iCCP chunk, after its name.
+	if bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		for i := 8; i+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			kind := string(data[i+4 : i+8])
+			if length < 0 || i+12+length > len(data) || kind == "IDAT" {
+				return nil
+			}
+			if kind == "iCCP" {
+				chunk := data[i+8 : i+8+length]
+				name := bytes.IndexByte(chunk, 0)
+				if name < 0 || name+2 > len(chunk) {
+					return nil
+				}
+				r, err := zlib.NewReader(bytes.NewReader(chunk[name+2:]))
+				if err != nil {
+					return nil
+				}
+				profile, err := io.ReadAll(r)
+				if err != nil {
+					return nil
+				}
+				return profile
+			}
+			i += 12 + length
+		}
+		return nil
+	}
+
+	// JPEG images split it between APP2 segments starting with "ICC_PROFILE\0", a sequence number and the number of segments.
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return nil
+	}
+	var parts [][]byte
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE2 && len(segment) >= 14 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")) {
+			seq, count := int(segment[12]), int(segment[13])
+			if parts == nil {
+				parts = make([][]byte, count)
+			}
+			if seq >= 1 && seq <= len(parts) {
+				parts[seq-1] = segment[14:]
+			}
+		}
+		i += 2 + length
+	}
+
+	return bytes.Join(parts, nil)
+}
+
+// ParseICCProfile parses an RGB matrix/TRC ICC profile, the kind of Display P3, Adobe RGB and sRGB profiles.
+// Other profiles, e.g. lookup table based ones, are not supported.
+func ParseICCProfile(data []byte) (*ICCProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("not an ICC profile")
+	}
+	if string(data[16:20]) != "RGB " {
+		return nil, fmt.Errorf("unsupported %q color space of the ICC profile", string(data[16:20]))
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for k := 0; k < count && 132+12*(k+1) <= len(data); k++ {
+		entry := data[132+12*k:]
+		offset, size := int(binary.BigEndian.Uint32(entry[4:])), int(binary.BigEndian.Uint32(entry[8:]))
+		if offset >= 0 && size >= 0 && offset+size <= len(data) {
+			tags[string(entry[:4])] = data[offset : offset+size]
+		}
+	}
+
+	p := &ICCProfile{}
+	for i, prefix := range [3]string{"r", "g", "b"} {
+		xyz, ok := tags[prefix+"XYZ"]
+		if !ok || len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, fmt.Errorf("unsupported ICC profile: no %sXYZ matrix column", prefix)
+		}
+		for j := 0; j < 3; j++ {
+			p.Matrix[j][i] = s15Fixed16(xyz[8+4*j:])
+		}
+
+		curve, err := parseToneCurve(tags[prefix+"TRC"])
+		if err != nil {
+			return nil, fmt.Errorf("unsupported ICC profile: %sTRC: %w", prefix, err)
+		}
+		p.Curves[i] = curve
+	}
+
+	return p, nil
+}
+
+// s15Fixed16 decodes a signed 15.16 fixed point number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseToneCurve parses a curveType ("curv") or parametricCurveType ("para") tone curve.
+func parseToneCurve(tag []byte) (func(v float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("missing tone curve")
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+2*n {
+			return nil, fmt.Errorf("truncated tone curve")
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		// The table is linearly interpolated.
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			x := ClampF64(v, 0., 1.) * float64(n-1)
+			i := int(x)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			return table[i] + (x-float64(i))*(table[i+1]-table[i])
+		}, nil
+
+	case "para":
+		kind := binary.BigEndian.Uint16(tag[8:])
+		counts := [5]int{1, 3, 4, 5, 7}
+		if kind > 4 || len(tag) < 12+4*counts[kind] {
+			return nil, fmt.Errorf("unsupported parametric tone curve")
+		}
+		// The parameters are g, a, b, c, d, e and f; the missing ones make the curve Y = (aX + b)^g.
+		prm := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for i := 0; i < counts[kind]; i++ {
+			prm[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := prm[0], prm[1], prm[2], prm[3], prm[4], prm[5], prm[6]
+		switch kind {
+		case 1, 2:
+			// The curve is c below -b/a.
+			d = -b / a
+			e = c
+			f = c
+			c = 0
+		}
+		return func(v float64) float64 {
+			if v < d {
+				return c*v + f
+			}
+			return math.Pow(math.Max(a*v+b, 0), g) + e
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported tone curve type %q", string(tag[:4]))
+}
+
+// toSRGB returns the lookup tables converting the encoded values of the profile to linear values,
+// and the matrix converting them to linear sRGB.
+func (p *ICCProfile) toSRGB() (luts [3][256]float64, m [3][3]float64) {
+	for i := range luts {
+		for v := range luts[i] {
+			luts[i][v] = p.Curves[i](float64(v) / 255)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += xyzD50ToLinearSRGB[i][k] * p.Matrix[k][j]
+			}
+		}
+	}
+
+	return luts, m
+}
+
+// IsSRGB reports whether the profile is (close enough to) the sRGB profile, so that the images need no conversion.
+func (p *ICCProfile) IsSRGB() bool {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(p.Matrix[i][j]-srgbToXYZD50[i][j]) > 0.002 {
+				return false
+			}
+		}
+	}
+	luts, _ := p.toSRGB()
+	for i := range luts {
+		for v := range luts[i] {
+			if math.Abs(luts[i][v]-SRGBToLinear(uint8(v))) > 0.5/255 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// ConvertToSRGB converts an image whose colors are described by an ICC profile to sRGB, the working space of the program.
+// The colors out of the sRGB gamut are clipped.
+func ConvertToSRGB(img image.Image, p *ICCProfile) *image.NRGBA {
+	luts, m := p.toSRGB()
+
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r, g, bl := luts[0][c.R], luts[1][c.G], luts[2][c.B]
+			out.SetNRGBA(x-b.Min.X, y-b.Min.Y, color.NRGBA{
+				LinearToSRGB(m[0][0]*r + m[0][1]*g + m[0][2]*bl),
+				LinearToSRGB(m[1][0]*r + m[1][1]*g + m[1][2]*bl),
+				LinearToSRGB(m[2][0]*r + m[2][1]*g + m[2][2]*bl),
+				c.A,
+			})
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,245 @@
+package quantize
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//
+// 			Image file read/write functions.
+//
+
+// ImageEncoder writes an image to <w> in a given file format.
+type ImageEncoder func(w io.Writer, img image.Image) error
+
+// encoders holds the supported output file formats, indexed by name.
+var encoders = map[string]ImageEncoder{
+	"png": EncodePNG,
+	"gif": EncodeGIF,
+	"pbm": EncodePBM,
+}
+
+// FormatNames returns the names of all the supported output formats, sorted alphabetically.
+func FormatNames() []string {
+	var names []string
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// formatAliases maps alternative file extensions to their format name.
+var formatAliases = map[string]string{
+	"jpg": "jpeg",
+	"tif": "tiff",
+}
+
+// formatExtensions maps the formats whose files use another extension than their name to this extension.
+var formatExtensions = map[string]string{}
+
+// FormatExtension returns the file extension of a format, without the dot.
+func FormatExtension(format string) string {
+	if ext, ok := formatExtensions[format]; ok {
+		return ext
+	}
+
+	return format
+}
+
+// buildTagFormats maps the output formats which only some builds support to the build tag which adds them.
+var buildTagFormats = map[string]string{
+	"bmp":  "ximage",
+	"tiff": "ximage",
+}
+
+// unknownFormatError reports an output format which cannot be written, naming the build tag which adds it if any.
+func unknownFormatError(format string) error {
+	if tag, ok := buildTagFormats[format]; ok {
+		return fmt.Errorf("the %s format is only written by the builds with the %q tag (go build -tags %s)", format, tag, tag)
+	}
+
+	return fmt.Errorf("unknown image format %q (available: %v)", format, FormatNames())
+}
+
+// FormatFromFilePath returns the output format given by the extension of a filepath; a filepath without extension,
+// e.g. the standard output, gets PNG. An extension of no supported output format is an error,
+// rather than a PNG file with a misleading name.
+func FormatFromFilePath(path string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if ext == "" {
+		return "png", nil
+	}
+	if alias, ok := formatAliases[ext]; ok {
+		ext = alias
+	}
+	if _, ok := encoders[ext]; !ok {
+		return "", unknownFormatError(ext)
+	}
+
+	return ext, nil
+}
+
+// WritableFormat returns the format of an input filepath if images can be written in it, PNG otherwise,
+// e.g. for a JPEG or WebP input.
+func WritableFormat(path string) string {
+	if format, err := FormatFromFilePath(path); err == nil {
+		return format
+	}
+
+	return "png"
+}
+
+// StdioPath is the filepath meaning the standard input (for reading) or the standard output (for writing).
+// An empty filepath means the same.
+const StdioPath = "-"
+
+// IsStdio reports whether a filepath designates the standard input or output.
+func IsStdio(filePath string) bool {
+	return filePath == "" || filePath == StdioPath
+}
+
+// ReadInputFile returns the whole content of a file, or of the standard input if IsStdio(filePath).
+// The content is read at once because the standard input cannot be read twice,
+// whereas the image format has to be detected before decoding.
+func ReadInputFile(filePath string) ([]byte, error) {
+	if IsStdio(filePath) {
+		return io.ReadAll(os.Stdin)
+	}
+
+	return os.ReadFile(filePath)
+}
+
+// CreateOutputFile creates a file, or returns the standard output if IsStdio(filePath).
+// Closing the standard output this way does nothing.
+func CreateOutputFile(filePath string) (io.WriteCloser, error) {
+	if IsStdio(filePath) {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+
+	return os.Create(filePath)
+}
+
+// nopWriteCloser is a writer with a Close method that does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// GetImageFromFilePath returns an image.Image object from an image filepath.
+// The standard input is read if IsStdio(filePath).
+func GetImageFromFilePath(filePath string) (image.Image, error) {
+	data, err := ReadInputFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeImage(data)
+}
+
+// DecodeImage decodes an image from its encoded content, whatever its registered format.
+func DecodeImage(data []byte) (image.Image, error) {
+	image, _, err := image.Decode(bytes.NewReader(data))
+	return image, err
+}
+
+// WriteImageToFile saves an image to a file with a given format (see FormatNames).
+// A paletted image is written as an indexed image (with its palette and the smallest possible bit depth).
+// The image is written to the standard output if IsStdio(filepath).
+func WriteImageToFile(img image.Image, filepath string, format string) error {
+	encode, ok := encoders[format]
+	if !ok {
+		return unknownFormatError(format)
+	}
+
+	return WriteImageWithEncoder(img, filepath, encode)
+}
+
+// WriteImageToFilePath saves an image to a file; its format is given by its extension (see FormatFromFilePath).
+func WriteImageToFilePath(img image.Image, filePath string) error {
+	format, err := FormatFromFilePath(filePath)
+	if err != nil {
+		return err
+	}
+
+	return WriteImageToFile(img, filePath, format)
+}
+
+// WriteImageWithEncoder saves an image to a file with a given encoder, e.g. an ExportOptions one.
+// The image is written to the standard output if IsStdio(filepath).
+func WriteImageWithEncoder(img image.Image, filepath string, encode ImageEncoder) error {
+	outputFile, err := CreateOutputFile(filepath)
+	if err != nil {
+		return err
+	}
+
+	// Encode takes a writer interface and an image interface
+	// We pass it the File and the image
+	err = encode(outputFile, img)
+
+	// Don't forget to close files
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// EncodePNG writes an image as a PNG.
+// A paletted image gets a PLTE chunk.
+func EncodePNG(w io.Writer, img image.Image) error {
+	return png.Encode(w, img)
+}
+
+// EncodeGIF writes an image as a GIF.
+// A paletted image keeps its own palette, which must have at most 256 colors.
+// Any other image is quantized by the standard library to the Plan 9 palette.
+func EncodeGIF(w io.Writer, img image.Image) error {
+	return gif.Encode(w, img, nil)
+}
+
+// EncodePBM writes an image as a binary PBM (Netpbm bitmap), with one bit per pixel.
+// The pixels whose gray level is below 128 are black; transparent pixels are white.
+func EncodePBM(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P4\n%d %d\n", b.Dx(), b.Dy())
+
+	// Each row is padded to a whole number of bytes; the first pixel is the highest bit.
+	row := make([]byte, (b.Dx()+7)/8)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for i := range row {
+			row[i] = 0
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := PixelColor(img, x, y)
+			if c.A >= 128 && Gray(Opaque(c), false).R < 128 {
+				i := x - b.Min.X
+				row[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ImageFormat returns the format of an encoded image ("png", "gif", "jpeg"...), as detected from its content.
+func ImageFormat(data []byte) (string, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	return format, err
+}
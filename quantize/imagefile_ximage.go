@@ -0,0 +1,37 @@
+//go:build ximage
+
+package quantize
+
+// This file adds the image formats provided by golang.org/x/image.
+// It is only compiled with the "ximage" build tag so that the default build
+// keeps depending on the standard library only:
+//
+//	go build -tags ximage
+
+import (
+	"image"
+	"io"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+func init() {
+	// Importing the packages registers their decoders with image.Decode.
+	// The encoders are registered here; WebP is an input-only format.
+	encoders["bmp"] = EncodeBMP
+	encoders["tiff"] = EncodeTIFF
+}
+
+// EncodeBMP writes an image as a BMP.
+// A paletted image is written as an 8-bit indexed BMP.
+func EncodeBMP(w io.Writer, img image.Image) error {
+	return bmp.Encode(w, img)
+}
+
+// EncodeTIFF writes an image as a Deflate-compressed TIFF.
+// A paletted image is written as an indexed TIFF.
+func EncodeTIFF(w io.Writer, img image.Image) error {
+	return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+}
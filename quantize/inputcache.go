@@ -0,0 +1,62 @@
+package quantize
+
+import (
+	"image"
+	"sync"
+)
+
+//
+// 			Input cache.
+//
+
+// InputCache keeps the content and the decoded image of the input files, so that a file processed
+// several times, e.g. by a sweep, is read and decoded once; the standard input can then be processed
+// several times too. A nil cache reads and decodes the files on each call. It can be used by several goroutines.
+type InputCache struct {
+	mu     sync.Mutex
+	data   map[string][]byte
+	images map[string]image.Image
+}
+
+// NewInputCache creates an empty cache.
+func NewInputCache() *InputCache {
+	return &InputCache{data: map[string][]byte{}, images: map[string]image.Image{}}
+}
+
+// ReadInputFile returns the whole content of a file, as ReadInputFile does.
+func (c *InputCache) ReadInputFile(filePath string) ([]byte, error) {
+	if c == nil {
+		return ReadInputFile(filePath)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if data, ok := c.data[filePath]; ok {
+		return data, nil
+	}
+	data, err := ReadInputFile(filePath)
+	if err == nil {
+		c.data[filePath] = data
+	}
+
+	return data, err
+}
+
+// DecodeImage decodes the content of a file, as DecodeImage does. The image must not be modified.
+func (c *InputCache) DecodeImage(filePath string, data []byte) (image.Image, error) {
+	if c == nil {
+		return DecodeImage(data)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if img, ok := c.images[filePath]; ok {
+		return img, nil
+	}
+	img, err := DecodeImage(data)
+	if err == nil {
+		c.images[filePath] = img
+	}
+
+	return img, err
+}
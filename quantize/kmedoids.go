@@ -0,0 +1,244 @@
+package quantize
+
+import (
+	"image/color"
+	"math"
+	"sort"
+)
+
+//
+// 			K-medoids.
+//
+
+// Median cut splits the colors into buckets of the same weight, which is a fair guess with many palette colors,
+// but a poor one with 2 to 8: a large flat background takes several buckets, and the details get averaged
+// into muddy colors. With so few colors, the total mapping error can be minimized directly: the k-medoids
+// algorithm (PAM) picks the palette among the colors of the image, swapping a palette color for another
+// color as long as it lowers the error.
+
+// MaxKMedoidsPaletteSize is the largest palette size the KMedoidsQuantizer optimizes;
+// the larger palettes are generated by median cut.
+const MaxKMedoidsPaletteSize = 8
+
+// maxKMedoidsColors bounds the number of colors the medoids are chosen among. The distances between all
+// of them are kept, so the colors of larger histograms are merged into coarser bins first.
+const maxKMedoidsColors = 1024
+
+// maxKMedoidsSwaps bounds the number of swaps of the k-medoids optimization, and of steps of the polishing
+// of its palette, which converge long before.
+const maxKMedoidsSwaps = 100
+
+// KMedoidsQuantizer chooses the palette colors minimizing the total squared distance, according to opts.Metric
+// (nil means RGBMetric), of the weighted colors to their nearest palette color: a greedy choice is improved
+// by the best swaps of a palette color for another color, until none lowers the error. The palette colors
+// are then moved to the mean colors of the colors mapped to them, as long as that lowers the error further.
+// Palettes of more than MaxKMedoidsPaletteSize colors are generated by MedianCutQuantizer.
+type KMedoidsQuantizer struct{}
+
+// Palette implements the Quantizer interface.
+func (q KMedoidsQuantizer) Palette(pixels []color.RGBA, size int, opts PaletteOptions) []color.RGBA {
+	if size > MaxKMedoidsPaletteSize {
+		return MedianCutQuantizer{}.Palette(pixels, size, opts)
+	}
+
+	return q.PaletteFromHistogram(CountColors(pixels), size, opts)
+}
+
+// PaletteFromHistogram implements the HistogramQuantizer interface.
+func (KMedoidsQuantizer) PaletteFromHistogram(colors []WeightedColor, size int, opts PaletteOptions) []color.RGBA {
+	if size > MaxKMedoidsPaletteSize {
+		return MedianCutQuantizer{}.PaletteFromHistogram(colors, size, opts)
+	}
+
+	metric := opts.Metric
+	if metric == nil {
+		metric = RGBMetric{}
+	}
+	colors = coarsenColors(colors, maxKMedoidsColors)
+	size = ClampAboveInt(size, len(colors))
+	points := make([]ColorPoint, len(colors))
+	for i, wc := range colors {
+		points[i] = metric.Point(wc.Color)
+	}
+	n := len(colors)
+	distances := make([]float64, n*n)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			d := metric.Distance(points[i], points[j])
+			distances[i*n+j], distances[j*n+i] = d*d, d*d
+		}
+	}
+
+	medoids := kMedoids(colors, distances, size)
+
+	palette := make([]color.RGBA, len(medoids))
+	for i, m := range medoids {
+		palette[i] = colors[m].Color
+	}
+
+	return polishPalette(colors, palette, opts, metric)
+}
+
+// kMedoids returns the indices of the <k> colors, among <colors>, minimizing the total weighted distance of the colors
+// to their nearest chosen color, given the distances between all the colors (row by row). The colors are chosen
+// greedily, then swapped for others (PAM). The nearest and second nearest medoids of each color are kept,
+// so that the costs of the swaps of all the medoids for a color are computed together in O(colors) (FastPAM1).
+func kMedoids(colors []WeightedColor, distances []float64, k int) []int {
+	n := len(colors)
+	nearest, second := make([]float64, n), make([]float64, n)
+	nearestMedoid := make([]int, n)
+	isMedoid := make([]bool, n)
+	var medoids []int
+
+	update := func() {
+		for j := range colors {
+			nearest[j], second[j] = math.Inf(1), math.Inf(1)
+			for i, m := range medoids {
+				d := distances[j*n+m]
+				if d < nearest[j] {
+					nearest[j], second[j], nearestMedoid[j] = d, nearest[j], i
+				} else if d < second[j] {
+					second[j] = d
+				}
+			}
+		}
+	}
+
+	// Greedy choice: each new medoid lowers the error the most.
+	for j := range nearest {
+		nearest[j] = math.Inf(1)
+	}
+	for len(medoids) < k {
+		best, bestCost := -1, math.Inf(1)
+		for c := range colors {
+			if isMedoid[c] {
+				continue
+			}
+			cost := 0.
+			for j, wc := range colors {
+				cost += wc.Weight * math.Min(nearest[j], distances[j*n+c])
+			}
+			if cost < bestCost {
+				best, bestCost = c, cost
+			}
+		}
+		medoids, isMedoid[best] = append(medoids, best), true
+		update()
+	}
+
+	// Swaps: the best swap of a medoid for another color, as long as it lowers the error.
+	for swaps := 0; swaps < maxKMedoidsSwaps; swaps++ {
+		bestMedoid, bestColor, bestDelta := -1, -1, -1e-9
+		deltas := make([]float64, len(medoids))
+		for c := range colors {
+			if isMedoid[c] {
+				continue
+			}
+			// The change of error of the colors which move to c whatever medoid it replaces is shared;
+			// the colors of the replaced medoid which stay away from c move to their second nearest medoid.
+			shared := 0.
+			for i := range deltas {
+				deltas[i] = 0
+			}
+			for j, wc := range colors {
+				if d := distances[j*n+c]; d < nearest[j] {
+					shared += wc.Weight * (d - nearest[j])
+				} else {
+					deltas[nearestMedoid[j]] += wc.Weight * (math.Min(d, second[j]) - nearest[j])
+				}
+			}
+			for i, delta := range deltas {
+				if shared+delta < bestDelta {
+					bestMedoid, bestColor, bestDelta = i, c, shared+delta
+				}
+			}
+		}
+		if bestMedoid < 0 {
+			break
+		}
+		isMedoid[medoids[bestMedoid]], isMedoid[bestColor] = false, true
+		medoids[bestMedoid] = bestColor
+		update()
+	}
+
+	return medoids
+}
+
+// polishPalette moves the palette colors to the mean colors of the weighted colors mapped to them (a k-means step),
+// as long as this lowers the total squared distance, according to <metric>, of the colors to their nearest palette color.
+func polishPalette(colors []WeightedColor, palette []color.RGBA, opts PaletteOptions, metric ColorMetric) []color.RGBA {
+	space, isSpace := opts.Metric.(SpaceMetric)
+	points := make([]ColorPoint, len(colors))
+	for i, wc := range colors {
+		points[i] = metric.Point(wc.Color)
+	}
+	total := func(palette []color.RGBA) (float64, [][]WeightedColor) {
+		paletteColorPoints := make([]ColorPoint, len(palette))
+		for i, c := range palette {
+			paletteColorPoints[i] = metric.Point(c)
+		}
+		clusters := make([][]WeightedColor, len(palette))
+		sum := 0.
+		for j, wc := range colors {
+			best, bestD := 0, math.Inf(1)
+			for i, p := range paletteColorPoints {
+				if d := metric.Distance(points[j], p); d < bestD {
+					best, bestD = i, d
+				}
+			}
+			clusters[best] = append(clusters[best], wc)
+			sum += wc.Weight * bestD * bestD
+		}
+		return sum, clusters
+	}
+
+	err, clusters := total(palette)
+	for step := 0; step < maxKMedoidsSwaps; step++ {
+		next := make([]color.RGBA, len(palette))
+		for i, cluster := range clusters {
+			next[i] = palette[i]
+			if len(cluster) > 0 {
+				next[i] = bucketMean(cluster, opts.Linear, space, isSpace)
+			}
+		}
+		nextErr, nextClusters := total(next)
+		if nextErr >= err {
+			break
+		}
+		palette, err, clusters = next, nextErr, nextClusters
+	}
+
+	return palette
+}
+
+// coarsenColors merges weighted colors into bins of fewer and fewer bits per channel, as a Histogram does,
+// until there are at most <max> of them. Each bin gets the weighted mean color of its colors.
+// The colors are returned as they are if there are few enough of them.
+func coarsenColors(colors []WeightedColor, max int) []WeightedColor {
+	for bits := 7; len(colors) > max && bits > 0; bits-- {
+		shift := 8 - bits
+		bins := map[uint32][]WeightedColor{}
+		var keys []uint32
+		for _, wc := range colors {
+			c := wc.Color
+			key := uint32(c.R>>shift)<<24 | uint32(c.G>>shift)<<16 | uint32(c.B>>shift)<<8 | uint32(c.A>>shift)
+			if _, ok := bins[key]; !ok {
+				keys = append(keys, key)
+			}
+			bins[key] = append(bins[key], wc)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		merged := make([]WeightedColor, len(keys))
+		for i, key := range keys {
+			weight := 0.
+			for _, wc := range bins[key] {
+				weight += wc.Weight
+			}
+			merged[i] = WeightedColor{weightedMean(bins[key], false), weight}
+		}
+		colors = merged
+	}
+
+	return colors
+}
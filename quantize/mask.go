@@ -0,0 +1,75 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+)
+
+//
+// 			Region masks.
+//
+
+// RegionMask selects the pixels of an image which are quantized, the others passing through untouched:
+// the gray levels of a mask image of the same size tell how much of each pixel is quantized, from none for black
+// to all for white, so that soft edges blend the quantized and the source pixels. Invert swaps both parts.
+type RegionMask struct {
+	Mask   image.Image
+	Invert bool
+}
+
+// Level returns how much of the pixel located at column x and row y is quantized, from 0 (none) to 255 (all).
+// The coordinates are relative to the bounds of the mask, whose top left corner stands for the (0, 0) pixel.
+func (m RegionMask) Level(x, y int) uint8 {
+	min := m.Mask.Bounds().Min
+	level := Gray(PixelColor(m.Mask, min.X+x, min.Y+y), false).R
+	if m.Invert {
+		level = 255 - level
+	}
+
+	return level
+}
+
+// Transform returns the region mask of an image turned upright by Orient, then scaled down by ScaleDown
+// with a given filter, the mask following the image.
+func (m RegionMask) Transform(orientation, factor int, filter string) *RegionMask {
+	m.Mask = ScaleDown(Orient(m.Mask, orientation), factor, filter, false)
+	return &m
+}
+
+// Weights returns the pixel weights of the palette generation restricted to the pixels mostly quantized,
+// which then get their <weights> (nil means 1); the other pixels weigh 0.
+func (m RegionMask) Weights(weights PixelWeights) PixelWeights {
+	return maskedWeights{m, weights}
+}
+
+// maskedWeights are pixel weights restricted to the pixels of a region mask.
+type maskedWeights struct {
+	mask    RegionMask
+	weights PixelWeights
+}
+
+// Weight implements the PixelWeights interface.
+func (w maskedWeights) Weight(x, y int) int {
+	if w.mask.Level(x, y) < 128 {
+		return 0
+	}
+
+	return pixelWeight(w.weights, x, y)
+}
+
+// ApplyRegionMask returns the true-color image made of the quantized pixels of an image inside a region mask
+// and of its source pixels outside, blended by the levels of the mask. Both images must have the same bounds.
+func ApplyRegionMask(src image.Image, quantized *image.Paletted, m RegionMask) *image.RGBA {
+	b := src.Bounds()
+	out := image.NewRGBA(b)
+	blend := func(s, q uint8, t int) uint8 { return uint8((int(s)*(255-t) + int(q)*t + 127) / 255) }
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			s, q := PixelColor(src, x, y), PixelColor(quantized, x, y)
+			t := int(m.Level(x-b.Min.X, y-b.Min.Y))
+			out.SetRGBA(x, y, color.RGBA{blend(s.R, q.R, t), blend(s.G, q.G, t), blend(s.B, q.B, t), blend(s.A, q.A, t)})
+		}
+	}
+
+	return out
+}
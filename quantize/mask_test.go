@@ -0,0 +1,63 @@
+package quantize
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessFileScalesRegionMaskDown(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	mask := image.NewGray(img.Rect)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+			if x >= 8 {
+				mask.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	in := filepath.Join(dir, "in.png")
+	if err := WriteImageToFile(img, in, "png"); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := NewSettings(WithPaletteSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.ScaleDown, settings.ScaleFilter = 2, ScaleNearest
+	settings.Mask = &RegionMask{Mask: mask}
+	out := filepath.Join(dir, "out.png")
+	if err := ProcessFile(context.Background(), in, out, settings); err != nil {
+		t.Fatal(err)
+	}
+	result, err := GetImageFromFilePath(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := result.Bounds().Size(); size != image.Pt(8, 8) {
+		t.Fatalf("output size %v, want (8,8)", size)
+	}
+
+	// The left half passes through, the right half is quantized to 2 colors.
+	small := ScaleDown(img, 2, ScaleNearest, false)
+	quantized := map[color.RGBA]bool{}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			c := PixelColor(result, x, y)
+			if x < 4 && c != PixelColor(small, x, y) {
+				t.Errorf("pixel (%d,%d) outside the mask: %v, want %v", x, y, c, PixelColor(small, x, y))
+			}
+			if x >= 4 {
+				quantized[c] = true
+			}
+		}
+	}
+	if len(quantized) > 2 {
+		t.Errorf("%d colors inside the mask, want at most 2", len(quantized))
+	}
+}
@@ -0,0 +1,372 @@
+package quantize
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//
+// 			Ordered dithering with threshold matrices.
+//
+
+// ThresholdMatrix is the threshold map of an ordered dithering, tiled over the image.
+// Its values are normalized: they are in [0, 1), and its rows have the same length.
+type ThresholdMatrix [][]float64
+
+// Coefficient returns the dithering offset of a given pixel coordinate, in [-0.5, 0.5).
+func (m ThresholdMatrix) Coefficient(x, y int) float64 {
+	row := m[mod(y, len(m))]
+	return row[mod(x, len(row))] - 0.5
+}
+
+// mod returns the remainder of the euclidean division of <x> by <n>, which is never negative.
+func mod(x, n int) int {
+	return ((x % n) + n) % n
+}
+
+// Orientation turns and mirrors the threshold pattern of an ordered dithering, e.g. to decorrelate the patterns
+// of neighboring tiles quantized separately. The zero value keeps the pattern as it is.
+type Orientation struct {
+	// Rotation is the number of quarter turns clockwise of the pattern.
+	Rotation int
+	// FlipX and FlipY mirror the turned pattern horizontally and vertically.
+	FlipX, FlipY bool
+}
+
+// Point returns the coordinates in the threshold pattern of the pixel (<x>, <y>) of the turned and mirrored pattern.
+// A matrix tiled from (0, 0) turns and mirrors as a whole: its cells stay in place.
+func (o Orientation) Point(x, y int) (int, int) {
+	if o.FlipX {
+		x = -1 - x
+	}
+	if o.FlipY {
+		y = -1 - y
+	}
+	for i := mod(o.Rotation, 4); i > 0; i-- {
+		x, y = y, -1-x
+	}
+
+	return x, y
+}
+
+// ParseOrientation parses a rotation of the threshold pattern in degrees (0, 90, 180 or 270, clockwise),
+// and its mirroring: "" for none, "h" for horizontal, "v" for vertical or "hv" for both.
+func ParseOrientation(degrees int, flip string) (Orientation, error) {
+	if degrees%90 != 0 {
+		return Orientation{}, fmt.Errorf("invalid rotation %d of the dithering pattern (0, 90, 180 or 270 expected)", degrees)
+	}
+	o := Orientation{Rotation: mod(degrees/90, 4)}
+	switch strings.ToLower(flip) {
+	case "":
+	case "h":
+		o.FlipX = true
+	case "v":
+		o.FlipY = true
+	case "hv", "vh":
+		o.FlipX, o.FlipY = true, true
+	default:
+		return Orientation{}, fmt.Errorf("invalid flip %q of the dithering pattern (h, v or hv expected)", flip)
+	}
+
+	return o, nil
+}
+
+// ParseDitherOffset parses a shift of the threshold pattern given as "x,y", e.g. "2,3".
+func ParseDitherOffset(s string) (image.Point, error) {
+	xs, ys, ok := strings.Cut(s, ",")
+	x, errX := strconv.Atoi(strings.TrimSpace(xs))
+	y, errY := strconv.Atoi(strings.TrimSpace(ys))
+	if !ok || errX != nil || errY != nil {
+		return image.Point{}, fmt.Errorf("invalid dithering offset %q (x,y expected, e.g. 2,3)", s)
+	}
+
+	return image.Pt(x, y), nil
+}
+
+// NewThresholdMatrix normalizes a matrix of arbitrary values, e.g. the ranks of a Bayer matrix:
+// its minimum value becomes 0 and its maximum value becomes 1 - 1/n, where n is the number of values.
+// An error is returned if the matrix is empty or not rectangular.
+func NewThresholdMatrix(values [][]float64) (ThresholdMatrix, error) {
+	if len(values) == 0 || len(values[0]) == 0 {
+		return nil, fmt.Errorf("the threshold matrix is empty")
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for i, row := range values {
+		if len(row) != len(values[0]) {
+			return nil, fmt.Errorf("the threshold matrix row %d has %d values instead of %d", i+1, len(row), len(values[0]))
+		}
+		for _, v := range row {
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+		}
+	}
+
+	// A constant matrix applies no offset.
+	n := float64(len(values) * len(values[0]))
+	m := make(ThresholdMatrix, len(values))
+	for i, row := range values {
+		m[i] = make([]float64, len(row))
+		for j, v := range row {
+			if max == min {
+				m[i][j] = 0.5
+			} else {
+				m[i][j] = (v - min) / (max - min) * (1 - 1/n)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// GetThresholdMatrixFromFilePath reads a threshold matrix from a file (see DecodeThresholdMatrix).
+func GetThresholdMatrixFromFilePath(filePath string) (ThresholdMatrix, error) {
+	data, err := ReadInputFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeThresholdMatrix(data)
+}
+
+// DecodeThresholdMatrix reads a threshold matrix, which is either an image whose pixels
+// gray levels are the values, or a plain text file with one row of numbers per line.
+// The values are normalized by NewThresholdMatrix.
+func DecodeThresholdMatrix(data []byte) (ThresholdMatrix, error) {
+	if _, err := ImageFormat(data); err == nil {
+		img, err := DecodeImage(data)
+		if err != nil {
+			return nil, err
+		}
+		return NewThresholdMatrix(grayLevels(img))
+	}
+
+	var values [][]float64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "//") {
+			continue
+		}
+
+		var row []float64
+		for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("threshold matrix: line %d: invalid value %q", line, field)
+			}
+			row = append(row, v)
+		}
+		values = append(values, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewThresholdMatrix(values)
+}
+
+// grayLevels returns the gray levels of the pixels of an image, row by row.
+func grayLevels(img image.Image) [][]float64 {
+	var values [][]float64
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		var row []float64
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			row = append(row, float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y))
+		}
+		values = append(values, row)
+	}
+
+	return values
+}
+
+// OrderedDitherer applies ordered dithering with any threshold matrix,
+// e.g. halftone dots, diagonal lines or blue noise.
+type OrderedDitherer struct {
+	// Matrix is the threshold map.
+	Matrix ThresholdMatrix
+	// Noise, if not nil, gives the offsets instead of Matrix, e.g. InterleavedGradientNoise.
+	Noise ThresholdFunc
+	// Offset shifts the threshold matrix, e.g. to align the patterns of tiles quantized separately,
+	// or from frame to frame of an animation (see TemporalOffset).
+	Offset image.Point
+	// Orientation turns and mirrors the threshold matrix.
+	Orientation Orientation
+	// Threads is the maximum number of goroutines working on an image; 0 means GOMAXPROCS.
+	Threads int
+	// Metric is the color metric used to find the nearest palette colors; nil means RGBMetric.
+	Metric ColorMetric
+	// Linear makes the offsets applied in linear light instead of sRGB.
+	Linear bool
+	// Strength scales the offsets of each channel; nil means FullDitherStrength.
+	Strength *DitherStrength
+	// Luminance makes the offsets applied to the luma only, preserving the hues (see OffsetPixelLuminance).
+	Luminance bool
+	// Lab makes the offsets applied to the CIELAB lightness (see OffsetPixelLab).
+	Lab bool
+	// Indexes, if not nil, caches the palette indices across images (see PaletteIndexCache).
+	Indexes *PaletteIndexCache
+	// Progress is notified of the rows processed, if not nil.
+	Progress ProgressFunc
+}
+
+// Dither implements the Ditherer interface.
+func (d OrderedDitherer) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	out, _ := d.DitherContext(context.Background(), img, palette)
+	return out
+}
+
+// ForFrame implements the FrameDitherer interface.
+func (d OrderedDitherer) ForFrame(frame int) Ditherer {
+	d.Offset = d.Offset.Add(TemporalOffset(frame))
+	return d
+}
+
+// DitherContext implements the ContextDitherer interface.
+func (d OrderedDitherer) DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	out := image.NewPaletted(img.Bounds(), ColorPalette(palette))
+	if err := d.DitherInto(ctx, out, img, palette); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// DitherInto implements the IntoDitherer interface.
+func (d OrderedDitherer) DitherInto(ctx context.Context, out *image.Paletted, img image.Image, palette []color.RGBA) error {
+	coefficient := d.Noise
+	if coefficient == nil {
+		if len(d.Matrix) == 0 {
+			return fmt.Errorf("the threshold matrix is empty")
+		}
+		coefficient = d.Matrix.Coefficient
+	}
+
+	index := d.Indexes.Index(palette, d.Metric)
+	offsetPixel := offsetFunc(d.Linear, d.Luminance, d.Lab)
+	strength := d.Strength.orFull()
+	rows := NewRowProgress(d.Progress, "dither", img.Bounds().Dy())
+
+	return ParallelRowsContext(ctx, img.Bounds(), d.Threads, func(minY, maxY int) {
+		buf := getRowBuffer(img.Bounds().Dx())
+		defer rowBuffers.Put(buf)
+		row := *buf
+		for y := minY; y < maxY; y++ {
+			for i := range row {
+				x := img.Bounds().Min.X + i
+				row[i] = offsetPixel(PixelColor(img, x, y), coefficient(d.Orientation.Point(x+d.Offset.X, y+d.Offset.Y)), len(palette), strength)
+			}
+			writeNearestRow(out, y, row, index)
+		}
+		rows.Done(maxY - minY)
+	})
+}
+
+// bayerMatrices holds the Bayer matrices computed so far, by size.
+var bayerMatrices sync.Map
+
+// BayerMatrix returns the Bayer threshold matrix of a given size, a power of two (see CheckBayerMatSize).
+// The matrices are computed once and shared by the callers, which must not modify them.
+func BayerMatrix(size int) (ThresholdMatrix, error) {
+	if err := CheckBayerMatSize(size); err != nil {
+		return nil, err
+	}
+	if m, ok := bayerMatrices.Load(size); ok {
+		return m.(ThresholdMatrix), nil
+	}
+
+	m := make(ThresholdMatrix, size)
+	for y := range m {
+		m[y] = make([]float64, size)
+		for x := range m[y] {
+			m[y][x] = BayerCoefficient(x, y, size) + 0.5
+		}
+	}
+	bayerMatrices.Store(size, m)
+
+	return m, nil
+}
+
+//
+// 			Patterned dithering styles.
+//
+
+// PatternSize is the side of the threshold matrices of the patterned dithering styles.
+const PatternSize = 8
+
+// patterns holds the threshold matrices of the patterned dithering styles, which are also ditherers, indexed by name.
+// Each matrix ranks its cells by their distance to the center of the pattern: the dark pixels grow
+// from the centers as the colors get darker.
+var patterns = map[string]func() ThresholdMatrix{
+	// Round dots on a 45° screen, the look of printed halftones.
+	"halftone": func() ThresholdMatrix {
+		return rankMatrix(PatternSize, PatternSize, func(x, y float64) float64 {
+			return math.Min(math.Hypot(x, y), math.Hypot(math.Abs(x)-PatternSize/2, math.Abs(y)-PatternSize/2))
+		})
+	},
+	// Diamonds on a 45° screen, which make a diagonal checkerboard in the middle tones.
+	"checker": func() ThresholdMatrix {
+		return rankMatrix(PatternSize, PatternSize, func(x, y float64) float64 {
+			return math.Min(math.Abs(x)+math.Abs(y), PatternSize-math.Abs(x)-math.Abs(y))
+		})
+	},
+	"lines-h": func() ThresholdMatrix {
+		return rankMatrix(1, PatternSize, func(x, y float64) float64 { return math.Abs(y) })
+	},
+	"lines-v": func() ThresholdMatrix {
+		return rankMatrix(PatternSize, 1, func(x, y float64) float64 { return math.Abs(x) })
+	},
+	"lines-diagonal": func() ThresholdMatrix {
+		return rankMatrix(PatternSize, PatternSize, func(x, y float64) float64 {
+			d := math.Mod(x+y+2*PatternSize, PatternSize)
+			return math.Min(d, PatternSize-d)
+		})
+	},
+}
+
+// PatternNames returns the names of the patterned dithering styles, sorted alphabetically.
+func PatternNames() []string {
+	var names []string
+	for name := range patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// rankMatrix returns the threshold matrix of a given size whose cells are ranked by a distance.
+// <distance> gets the coordinates of the cell centers relative to the center of the matrix;
+// the ties are broken in raster order.
+func rankMatrix(width, height int, distance func(x, y float64) float64) ThresholdMatrix {
+	type cell struct {
+		i int
+		d float64
+	}
+	cells := make([]cell, width*height)
+	for i := range cells {
+		x, y := float64(i%width)+0.5-float64(width)/2, float64(i/width)+0.5-float64(height)/2
+		cells[i] = cell{i, distance(x, y)}
+	}
+	sort.SliceStable(cells, func(a, b int) bool { return cells[a].d < cells[b].d })
+
+	values := make([][]float64, height)
+	for y := range values {
+		values[y] = make([]float64, width)
+	}
+	for rank, c := range cells {
+		values[c.i/width][c.i%width] = float64(rank)
+	}
+	m, _ := NewThresholdMatrix(values)
+
+	return m
+}
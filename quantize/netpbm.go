@@ -0,0 +1,522 @@
+package quantize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//
+// 			Netpbm and farbfeld images.
+//
+
+// The Netpbm formats (PBM, PGM, PPM and PAM) and farbfeld are the raw images of the Unix pipelines, e.g. of the netpbm
+// tools and of the suckless ones: a short header, then the samples row by row, uncompressed. They are read row by row,
+// so that they can be streamed like PNG images (see RowReader), and decoded as a whole by image.Decode.
+// Only the binary Netpbm variants (P4 to P7) are supported. The 16-bit samples give 16-bit images (see -deep).
+
+// Magic strings of the supported formats.
+const (
+	farbfeldMagic = "farbfeld"
+	pbmMagic      = "P4"
+	pgmMagic      = "P5"
+	ppmMagic      = "P6"
+	pamMagic      = "P7"
+)
+
+func init() {
+	image.RegisterFormat("farbfeld", farbfeldMagic, decodeRowImage, decodeRowImageConfig)
+	for _, magic := range []string{pbmMagic, pgmMagic, ppmMagic, pamMagic} {
+		image.RegisterFormat(netpbmFormats[magic], magic, decodeRowImage, decodeRowImageConfig)
+	}
+
+	encoders["pgm"] = EncodePGM
+	encoders["ppm"] = EncodePPM
+	encoders["pam"] = EncodePAM
+	encoders["farbfeld"] = EncodeFarbfeld
+	formatAliases["ff"] = "farbfeld"
+	formatExtensions["farbfeld"] = "ff"
+	formatAliases["pnm"] = "ppm"
+}
+
+// netpbmFormats holds the format names of the Netpbm magic strings.
+var netpbmFormats = map[string]string{pbmMagic: "pbm", pgmMagic: "pgm", ppmMagic: "ppm", pamMagic: "pam"}
+
+// RowReader decodes an image row by row, so that it can be processed by bands of rows (see StreamQuantizePNGFile).
+type RowReader interface {
+	// Size returns the size of the image.
+	Size() (width, height int)
+	// ReadRow decodes the next row of the image into <row>, which must hold as many colors as the image is wide.
+	// The colors are premultiplied, as PixelColor returns them.
+	ReadRow(row []color.RGBA) error
+}
+
+// NewRowReader reads the header of a PNG, Netpbm or farbfeld image from <r>, according to its first bytes,
+// and returns its row reader.
+func NewRowReader(r io.Reader) (RowReader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(farbfeldMagic))
+	switch {
+	case bytes.HasPrefix(magic, []byte(pngSignature)):
+		return NewPNGRowReader(br)
+	case string(magic) == farbfeldMagic:
+		return NewFarbfeldRowReader(br)
+	case len(magic) >= 2 && netpbmFormats[string(magic[:2])] != "":
+		return NewNetpbmRowReader(br)
+	}
+
+	return nil, fmt.Errorf("not a PNG, Netpbm or farbfeld image")
+}
+
+// deepRowReader is implemented by the row readers of the Netpbm and farbfeld images,
+// which decode the rows with their 16-bit values.
+type deepRowReader interface {
+	RowReader
+	// Deep reports whether the samples of the image have more than 8 bits.
+	Deep() bool
+	// readRow decodes the next row of the image into <row>. The samples of the images which are not Deep
+	// are rounded to 8 bits, and repeated in the low bytes.
+	readRow(row []color.NRGBA64) error
+}
+
+// readRowColors decodes the next row of an image with a deepRowReader, as RowReader.ReadRow does.
+// The colors are converted as PixelColor converts the pixels of the images decodeRowImage returns.
+func readRowColors(r deepRowReader, row []color.RGBA, deep []color.NRGBA64) error {
+	if err := r.readRow(deep); err != nil {
+		return err
+	}
+	for x, c := range deep {
+		if r.Deep() {
+			cr, cg, cb, ca := c.RGBA()
+			row[x] = color.RGBA{to8(cr), to8(cg), to8(cb), to8(ca)}
+		} else {
+			row[x] = nrgbaColor(uint8(c.R>>8), uint8(c.G>>8), uint8(c.B>>8), uint8(c.A>>8))
+		}
+	}
+
+	return nil
+}
+
+// decodeRowImage decodes a whole Netpbm or farbfeld image for image.Decode: an *image.NRGBA64
+// if its samples have more than 8 bits, an *image.NRGBA otherwise.
+func decodeRowImage(r io.Reader) (image.Image, error) {
+	rr, err := NewRowReader(r)
+	if err != nil {
+		return nil, err
+	}
+	dr, ok := rr.(deepRowReader)
+	if !ok {
+		return nil, fmt.Errorf("not a Netpbm or farbfeld image")
+	}
+
+	width, height := dr.Size()
+	row := make([]color.NRGBA64, width)
+	var img interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	if dr.Deep() {
+		img = image.NewNRGBA64(image.Rect(0, 0, width, height))
+	} else {
+		img = image.NewNRGBA(image.Rect(0, 0, width, height))
+	}
+	for y := 0; y < height; y++ {
+		if err := dr.readRow(row); err != nil {
+			return nil, err
+		}
+		for x, c := range row {
+			if dr.Deep() {
+				img.(*image.NRGBA64).SetNRGBA64(x, y, c)
+			} else {
+				img.(*image.NRGBA).SetNRGBA(x, y, color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)})
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// decodeRowImageConfig reads the size and color model of a Netpbm or farbfeld image for image.DecodeConfig.
+func decodeRowImageConfig(r io.Reader) (image.Config, error) {
+	rr, err := NewRowReader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	dr, ok := rr.(deepRowReader)
+	if !ok {
+		return image.Config{}, fmt.Errorf("not a Netpbm or farbfeld image")
+	}
+
+	width, height := dr.Size()
+	model := color.NRGBAModel
+	if dr.Deep() {
+		model = color.NRGBA64Model
+	}
+
+	return image.Config{ColorModel: model, Width: width, Height: height}, nil
+}
+
+//
+// 			Netpbm images.
+//
+
+// NetpbmRowReader decodes a binary Netpbm image row by row: a PBM bitmap (P4), a PGM gray image (P5), a PPM RGB image
+// (P6) or a PAM image (P7) of 1 to 4 channels, gray, gray and alpha, RGB or RGB and alpha, whatever its tuple type.
+// The samples are scaled from [0, maxval] to 8 or 16 bits.
+type NetpbmRowReader struct {
+	Width, Height int
+
+	magic   string
+	depth   int
+	maxval  int
+	r       *bufio.Reader
+	samples []byte
+	deep    []color.NRGBA64
+	rows    int
+}
+
+// maxNetpbmSize bounds the width and height of the Netpbm and farbfeld images, against corrupt headers.
+const maxNetpbmSize = 1 << 20
+
+// NewNetpbmRowReader reads the header of a binary Netpbm image from <r>.
+func NewNetpbmRowReader(r io.Reader) (*NetpbmRowReader, error) {
+	br := bufio.NewReader(r)
+	var magic [2]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil || netpbmFormats[string(magic[:])] == "" {
+		return nil, fmt.Errorf("not a binary Netpbm image")
+	}
+
+	p := &NetpbmRowReader{magic: string(magic[:]), r: br, maxval: 1}
+	var err error
+	switch p.magic {
+	case pamMagic:
+		err = p.readPAMHeader()
+	case pbmMagic:
+		p.depth = 1
+		p.Width, p.Height = readNetpbmInt(br), readNetpbmInt(br)
+	default:
+		p.depth = 1
+		if p.magic == ppmMagic {
+			p.depth = 3
+		}
+		p.Width, p.Height, p.maxval = readNetpbmInt(br), readNetpbmInt(br), readNetpbmInt(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// A single whitespace character separates the header from the samples, except in PAM images.
+	if p.magic != pamMagic {
+		if b, err := br.ReadByte(); err != nil || !isNetpbmSpace(b) {
+			return nil, fmt.Errorf("invalid %s header", strings.ToUpper(netpbmFormats[p.magic]))
+		}
+	}
+	if p.Width <= 0 || p.Height <= 0 || p.Width > maxNetpbmSize || p.Height > maxNetpbmSize ||
+		p.maxval <= 0 || p.maxval > 65535 || p.depth < 1 || p.depth > 4 {
+		return nil, fmt.Errorf("invalid %s header: %dx%d, depth %d, maxval %d",
+			strings.ToUpper(netpbmFormats[p.magic]), p.Width, p.Height, p.depth, p.maxval)
+	}
+
+	if p.magic == pbmMagic {
+		p.samples = make([]byte, (p.Width+7)/8)
+	} else {
+		p.samples = make([]byte, p.Width*p.depth*p.sampleSize())
+	}
+
+	return p, nil
+}
+
+// readPAMHeader reads the header lines of a PAM image, up to ENDHDR.
+func (p *NetpbmRowReader) readPAMHeader() error {
+	for {
+		line, err := p.r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("invalid PAM header: %w", err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			return nil
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		// TUPLTYPE is not needed: the depth tells the channels.
+		v, _ := strconv.Atoi(fields[1])
+		switch fields[0] {
+		case "WIDTH":
+			p.Width = v
+		case "HEIGHT":
+			p.Height = v
+		case "DEPTH":
+			p.depth = v
+		case "MAXVAL":
+			p.maxval = v
+		}
+	}
+}
+
+// readNetpbmInt reads a decimal number of a Netpbm header, after whitespace and comments. It returns -1 on errors.
+func readNetpbmInt(r *bufio.Reader) int {
+	b, err := r.ReadByte()
+	for ; err == nil && (isNetpbmSpace(b) || b == '#'); b, err = r.ReadByte() {
+		if b == '#' {
+			if _, err := r.ReadString('\n'); err != nil {
+				return -1
+			}
+		}
+	}
+
+	v := -1
+	for ; err == nil && b >= '0' && b <= '9' && v < maxNetpbmSize*65536; b, err = r.ReadByte() {
+		v = ClampBelowInt(v, 0)*10 + int(b-'0')
+	}
+	if err == nil {
+		r.UnreadByte()
+	}
+
+	return v
+}
+
+// isNetpbmSpace reports whether a byte is a whitespace character of a Netpbm header.
+func isNetpbmSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// sampleSize returns the number of bytes of a sample: 2 if maxval is more than 255, 1 otherwise.
+func (p *NetpbmRowReader) sampleSize() int {
+	if p.maxval > 255 {
+		return 2
+	}
+
+	return 1
+}
+
+// Size implements the RowReader interface.
+func (p *NetpbmRowReader) Size() (int, int) {
+	return p.Width, p.Height
+}
+
+// Deep reports whether the samples of the image have more than 8 bits.
+func (p *NetpbmRowReader) Deep() bool {
+	return p.maxval > 255
+}
+
+// ReadRow implements the RowReader interface.
+func (p *NetpbmRowReader) ReadRow(row []color.RGBA) error {
+	if len(p.deep) != len(row) {
+		p.deep = make([]color.NRGBA64, len(row))
+	}
+	return readRowColors(p, row, p.deep)
+}
+
+// readRow decodes the next row of the image, as the deepRowReader interface requires.
+func (p *NetpbmRowReader) readRow(row []color.NRGBA64) error {
+	format := strings.ToUpper(netpbmFormats[p.magic])
+	if p.rows == p.Height {
+		return fmt.Errorf("no more %s rows (%d)", format, p.Height)
+	}
+	if len(row) != p.Width {
+		return fmt.Errorf("a %s row has %d pixels, not %d", format, p.Width, len(row))
+	}
+	if _, err := io.ReadFull(p.r, p.samples); err != nil {
+		return fmt.Errorf("reading %s row %d: %w", format, p.rows, err)
+	}
+	p.rows++
+
+	// In PBM images, 1 is black.
+	if p.magic == pbmMagic {
+		for x := range row {
+			v := uint16(0xffff)
+			if p.samples[x/8]&(0x80>>(x%8)) != 0 {
+				v = 0
+			}
+			row[x] = color.NRGBA64{v, v, v, 0xffff}
+		}
+		return nil
+	}
+
+	size := p.sampleSize()
+	sample := func(i int) uint16 {
+		v := int(p.samples[i*size])
+		if size == 2 {
+			v = v<<8 | int(p.samples[i*size+1])
+		}
+		v = ClampAboveInt(v, p.maxval)
+		if p.Deep() {
+			return uint16((v*65535 + p.maxval/2) / p.maxval)
+		}
+		return uint16((v*255+p.maxval/2)/p.maxval) * 0x101
+	}
+	for x := range row {
+		i := x * p.depth
+		switch p.depth {
+		case 1:
+			v := sample(i)
+			row[x] = color.NRGBA64{v, v, v, 0xffff}
+		case 2:
+			v := sample(i)
+			row[x] = color.NRGBA64{v, v, v, sample(i + 1)}
+		case 3:
+			row[x] = color.NRGBA64{sample(i), sample(i + 1), sample(i + 2), 0xffff}
+		default:
+			row[x] = color.NRGBA64{sample(i), sample(i + 1), sample(i + 2), sample(i + 3)}
+		}
+	}
+
+	return nil
+}
+
+// EncodePGM writes an image as a binary PGM (Netpbm gray image), with 8 bits per pixel.
+// The pixels are converted to gray; transparent pixels are black.
+func EncodePGM(w io.Writer, img image.Image) error {
+	return encodeNetpbm(w, img, fmt.Sprintf("P5\n%d %d\n255\n", img.Bounds().Dx(), img.Bounds().Dy()), func(c color.RGBA, samples []byte) []byte {
+		return append(samples, Gray(Opaque(c), false).R)
+	})
+}
+
+// EncodePPM writes an image as a binary PPM (Netpbm RGB image), with 8 bits per channel.
+// The alpha channel is dropped: the translucent pixels keep their colors, the transparent ones are black.
+func EncodePPM(w io.Writer, img image.Image) error {
+	return encodeNetpbm(w, img, fmt.Sprintf("P6\n%d %d\n255\n", img.Bounds().Dx(), img.Bounds().Dy()), func(c color.RGBA, samples []byte) []byte {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		return append(samples, n.R, n.G, n.B)
+	})
+}
+
+// EncodePAM writes an image as a PAM (Netpbm arbitrary map) of tuple type RGB_ALPHA, with 8 bits per channel.
+func EncodePAM(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	header := fmt.Sprintf("P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n", b.Dx(), b.Dy())
+	return encodeNetpbm(w, img, header, func(c color.RGBA, samples []byte) []byte {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		return append(samples, n.R, n.G, n.B, n.A)
+	})
+}
+
+// encodeNetpbm writes a header then the samples of each pixel of an image, row by row, as given by <samples>.
+func encodeNetpbm(w io.Writer, img image.Image, header string, samples func(c color.RGBA, samples []byte) []byte) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(header)
+
+	b := img.Bounds()
+	var row []byte
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row = row[:0]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			row = samples(PixelColor(img, x, y), row)
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+//
+// 			Farbfeld images.
+//
+
+// FarbfeldRowReader decodes a farbfeld image row by row: the "farbfeld" magic string, its width and height
+// as big-endian 32-bit numbers, then 16-bit big-endian RGBA samples, not premultiplied.
+// See https://tools.suckless.org/farbfeld/.
+type FarbfeldRowReader struct {
+	Width, Height int
+
+	r       io.Reader
+	samples []byte
+	deep    []color.NRGBA64
+	rows    int
+}
+
+// NewFarbfeldRowReader reads the header of a farbfeld image from <r>.
+func NewFarbfeldRowReader(r io.Reader) (*FarbfeldRowReader, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[:8]) != farbfeldMagic {
+		return nil, fmt.Errorf("not a farbfeld image")
+	}
+	width, height := binary.BigEndian.Uint32(header[8:]), binary.BigEndian.Uint32(header[12:])
+	if width == 0 || height == 0 || width > maxNetpbmSize || height > maxNetpbmSize {
+		return nil, fmt.Errorf("invalid farbfeld image size %dx%d", width, height)
+	}
+
+	return &FarbfeldRowReader{Width: int(width), Height: int(height), r: r, samples: make([]byte, 8*width)}, nil
+}
+
+// Size implements the RowReader interface.
+func (p *FarbfeldRowReader) Size() (int, int) {
+	return p.Width, p.Height
+}
+
+// Deep reports whether the samples of the image have more than 8 bits, which they always have.
+func (p *FarbfeldRowReader) Deep() bool {
+	return true
+}
+
+// ReadRow implements the RowReader interface.
+func (p *FarbfeldRowReader) ReadRow(row []color.RGBA) error {
+	if len(p.deep) != len(row) {
+		p.deep = make([]color.NRGBA64, len(row))
+	}
+	return readRowColors(p, row, p.deep)
+}
+
+// readRow decodes the next row of the image, as the deepRowReader interface requires.
+func (p *FarbfeldRowReader) readRow(row []color.NRGBA64) error {
+	if p.rows == p.Height {
+		return fmt.Errorf("no more farbfeld rows (%d)", p.Height)
+	}
+	if len(row) != p.Width {
+		return fmt.Errorf("a farbfeld row has %d pixels, not %d", p.Width, len(row))
+	}
+	if _, err := io.ReadFull(p.r, p.samples); err != nil {
+		return fmt.Errorf("reading farbfeld row %d: %w", p.rows, err)
+	}
+	p.rows++
+
+	for x := range row {
+		s := p.samples[8*x : 8*x+8 : 8*x+8]
+		row[x] = color.NRGBA64{
+			binary.BigEndian.Uint16(s[0:]), binary.BigEndian.Uint16(s[2:]),
+			binary.BigEndian.Uint16(s[4:]), binary.BigEndian.Uint16(s[6:]),
+		}
+	}
+
+	return nil
+}
+
+// EncodeFarbfeld writes an image as a farbfeld image. The 8-bit channels are scaled to 16 bits.
+func EncodeFarbfeld(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	var header [16]byte
+	copy(header[:], farbfeldMagic)
+	binary.BigEndian.PutUint32(header[8:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(header[12:], uint32(b.Dy()))
+	bw.Write(header[:])
+
+	row := make([]byte, 8*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			s := row[8*(x-b.Min.X):]
+			binary.BigEndian.PutUint16(s[0:], c.R)
+			binary.BigEndian.PutUint16(s[2:], c.G)
+			binary.BigEndian.PutUint16(s[4:], c.B)
+			binary.BigEndian.PutUint16(s[6:], c.A)
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
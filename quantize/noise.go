@@ -0,0 +1,40 @@
+package quantize
+
+import "math"
+
+//
+// 			Noise dithering.
+//
+
+// The noise ditherers are ordered ditherers whose offsets are computed for each pixel instead of being
+// read from a tiled matrix. They are as cheap as the Bayer dithering, without its crosshatch pattern.
+
+// ThresholdFunc returns the dithering offset of a given pixel coordinate, in [-0.5, 0.5),
+// as ThresholdMatrix.Coefficient does.
+type ThresholdFunc func(x, y int) float64
+
+// InterleavedGradientNoise is the interleaved gradient noise of Jorge Jimenez, a noise with little low-frequency content,
+// which looks like blue noise.
+// See https://www.iryoku.com/next-generation-post-processing-in-call-of-duty-advanced-warfare
+func InterleavedGradientNoise(x, y int) float64 {
+	f := 0.06711056*float64(x) + 0.00583715*float64(y)
+	v := 52.9829189 * (f - math.Floor(f))
+	return v - math.Floor(v) - 0.5
+}
+
+// WhiteNoise returns a white noise: an offset picked at random for each pixel, always the same one
+// for a given pixel and seed, whatever the order in which the pixels are processed. 0 means DefaultSeed.
+func WhiteNoise(seed int64) ThresholdFunc {
+	if seed == 0 {
+		seed = DefaultSeed
+	}
+
+	return func(x, y int) float64 {
+		// The coordinates and the seed are hashed with the SplitMix64 finalizer.
+		h := uint64(seed) ^ uint64(uint32(x)) ^ uint64(uint32(y))<<32
+		h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+		h = (h ^ h>>27) * 0x94d049bb133111eb
+		h ^= h >> 31
+		return float64(h>>11)/(1<<53) - 0.5
+	}
+}
@@ -0,0 +1,142 @@
+package quantize
+
+import (
+	"image"
+	"math"
+)
+
+//
+// 			Outline preservation.
+//
+
+// The lines of scanned drawings and cel-shaded images are dithered like the rest of the image: their pixels
+// get mixed with lighter colors, and the lines look broken. The outline preservation detects the strong edges
+// of the source image, and snaps the pixels on their dark side, the lines themselves, to a dark palette color,
+// without dithering.
+
+// OutlinePixels returns, row by row, whether each pixel of an image is on the dark side of a strong edge:
+// the magnitude of the Sobel gradient of the luma, in [0, 255] per pixel, reaches <threshold> in its 3x3
+// neighborhood (it reaches about 1020 on a black and white edge, 4 times the luma step), and the luma of the pixel
+// is below the mean luma of that neighborhood by at least threshold/16, so that the shading of the flat areas
+// near the edges does not count. The pixels beyond the borders repeat the border pixels.
+// The pixels whose alpha is below <alphaThreshold> are not outline pixels, and do not count in the gradient
+// or the mean luma of their neighbors: they repeat the pixel at the center of the neighborhood instead.
+// The luma of the translucent pixels is that of their opaque version.
+func OutlinePixels(img image.Image, threshold float64, alphaThreshold uint8, threads int) []bool {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	lumas := make([]float64, w*h)
+	visible := make([]bool, w*h)
+	ParallelRows(b, threads, func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c, i := PixelColor(img, x, y), (y-b.Min.Y)*w+x-b.Min.X
+				if IsTransparent(c, alphaThreshold) {
+					continue
+				}
+				c = Opaque(c)
+				lumas[i], visible[i] = luma(float64(c.R), float64(c.G), float64(c.B)), true
+			}
+		}
+	})
+	index := func(x, y int) int {
+		return ClampBelowInt(ClampAboveInt(y, h-1), 0)*w + ClampBelowInt(ClampAboveInt(x, w-1), 0)
+	}
+
+	magnitudes := make([]float64, w*h)
+	ParallelRows(b, threads, func(minY, maxY int) {
+		for y := minY - b.Min.Y; y < maxY-b.Min.Y; y++ {
+			for x := 0; x < w; x++ {
+				if !visible[y*w+x] {
+					continue
+				}
+				center := lumas[y*w+x]
+				at := func(nx, ny int) float64 {
+					if i := index(nx, ny); visible[i] {
+						return lumas[i]
+					}
+					return center
+				}
+				gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+				gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+				magnitudes[y*w+x] = math.Hypot(gx, gy)
+			}
+		}
+	})
+
+	// The gradient vanishes in the middle of the thin lines, so the strongest gradient around each pixel is used.
+	outline := make([]bool, w*h)
+	ParallelRows(b, threads, func(minY, maxY int) {
+		for y := minY - b.Min.Y; y < maxY-b.Min.Y; y++ {
+			for x := 0; x < w; x++ {
+				if !visible[y*w+x] {
+					continue
+				}
+				var magnitude, sum, n float64
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						if i := index(x+dx, y+dy); visible[i] {
+							sum += lumas[i]
+							n++
+							magnitude = math.Max(magnitude, magnitudes[i])
+						}
+					}
+				}
+				outline[y*w+x] = magnitude >= threshold && lumas[y*w+x] <= sum/n-threshold/16
+			}
+		}
+	})
+
+	return outline
+}
+
+// SnapOutlines maps the outline pixels of a source image (see OutlinePixels) to dark palette colors in its quantized
+// image, without dithering: each one gets the nearest opaque palette color, according to <metric> (nil means RGBMetric),
+// among those which are not lighter than the pixel, or the darkest opaque palette color if none is.
+// The pixels whose alpha is below <alphaThreshold> are left alone. It returns the number of outline pixels.
+func SnapOutlines(out *image.Paletted, img image.Image, threshold float64, alphaThreshold uint8, metric ColorMetric, threads int) int {
+	if metric == nil {
+		metric = RGBMetric{}
+	}
+	palette := PaletteColors(out.Palette)
+	lumas := make([]float64, len(palette))
+	points := make([]ColorPoint, len(palette))
+	darkest := -1
+	for i, c := range palette {
+		lumas[i] = luma(float64(c.R), float64(c.G), float64(c.B))
+		points[i] = metric.Point(c)
+		if c.A == 255 && (darkest < 0 || lumas[i] < lumas[darkest]) {
+			darkest = i
+		}
+	}
+	if darkest < 0 {
+		return 0
+	}
+
+	b := img.Bounds()
+	outline := OutlinePixels(img, threshold, alphaThreshold, threads)
+	count := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !outline[(y-b.Min.Y)*b.Dx()+x-b.Min.X] {
+				continue
+			}
+			c := PixelColor(img, x, y)
+			o := Opaque(c)
+			l, p := luma(float64(o.R), float64(o.G), float64(o.B)), metric.Point(c)
+			best, bestD := darkest, math.Inf(1)
+			for i := range palette {
+				if palette[i].A != 255 || lumas[i] > l {
+					continue
+				}
+				if d := metric.Distance(p, points[i]); d < bestD {
+					best, bestD = i, d
+				}
+			}
+			out.SetColorIndex(x, y, uint8(best))
+			count++
+		}
+	}
+
+	return count
+}
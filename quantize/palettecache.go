@@ -0,0 +1,96 @@
+package quantize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+)
+
+//
+// 			Palette cache.
+//
+
+// PaletteCache keeps the palettes generated from the input files in a directory, so that a new run on the same file
+// with the same palette settings, e.g. while tweaking the dithering options, skips the palette generation.
+// Each palette is stored in a file named after the hash of the content of the input file and of the settings,
+// holding the premultiplied RGBA channels of its colors, so that it is read back exactly.
+// A nil cache keeps nothing. It can be used by several goroutines, and several processes.
+type PaletteCache struct {
+	Dir string
+}
+
+// NewPaletteCache creates a cache in a directory, which is created if needed.
+func NewPaletteCache(dir string) (*PaletteCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating palette cache: %w", err)
+	}
+
+	return &PaletteCache{Dir: dir}, nil
+}
+
+// PaletteCacheKey returns the key of the palette generated from the content of an input file with some settings,
+// which describe everything the palette depends on.
+func PaletteCacheKey(data []byte, settings string) string {
+	h := sha256.New()
+	h.Write(data)
+	h.Write([]byte{0})
+	h.Write([]byte(settings))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// path returns the filepath of the palette of a key.
+func (c *PaletteCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".rgba")
+}
+
+// Get returns the palette of a key, if it is in the cache.
+func (c *PaletteCache) Get(key string) ([]color.RGBA, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.path(key))
+	if err != nil || len(data) == 0 || len(data)%4 != 0 || len(data) > 4*MaxPaletteSize {
+		return nil, false
+	}
+	palette := make([]color.RGBA, len(data)/4)
+	for i := range palette {
+		palette[i] = color.RGBA{data[4*i], data[4*i+1], data[4*i+2], data[4*i+3]}
+	}
+
+	return palette, true
+}
+
+// Put stores the palette of a key in the cache. The file is written under a temporary name then renamed,
+// so that a concurrent Get never reads a partial palette.
+func (c *PaletteCache) Put(key string, palette []color.RGBA) error {
+	if c == nil {
+		return nil
+	}
+
+	data := make([]byte, 0, 4*len(palette))
+	for _, col := range palette {
+		data = append(data, col.R, col.G, col.B, col.A)
+	}
+	tmp, err := os.CreateTemp(c.Dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("writing palette cache: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing palette cache: %w", err)
+	}
+
+	return nil
+}
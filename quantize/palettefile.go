@@ -0,0 +1,408 @@
+package quantize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// 			Palette file read/write functions.
+//
+
+// PaletteDecoder reads a palette from <r> in a given file format.
+type PaletteDecoder func(r io.Reader) ([]color.RGBA, error)
+
+// PaletteEncoder writes a palette to <w> in a given file format.
+type PaletteEncoder func(w io.Writer, palette []color.RGBA) error
+
+// PaletteFormat gathers the decoding and encoding functions of a palette file format.
+type PaletteFormat struct {
+	Decode PaletteDecoder
+	Encode PaletteEncoder
+}
+
+// paletteFormats holds the supported palette file formats, indexed by name.
+var paletteFormats = map[string]PaletteFormat{
+	"gpl":  {DecodeGPL, EncodeGPL},
+	"pal":  {DecodePAL, EncodePAL},
+	"act":  {DecodeACT, EncodeACT},
+	"hex":  {DecodeHex, EncodeHex},
+	"json": {DecodePaletteJSON, EncodePaletteJSON},
+}
+
+// PaletteFormatNames returns the names of all the supported palette file formats, sorted alphabetically.
+func PaletteFormatNames() []string {
+	var names []string
+	for name := range paletteFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// PaletteFormatFromFilePath guesses a palette file format from the extension of a filepath.
+// Plain text files (".txt") are lists of hex colors, and ".aseprite" files are Aseprite files as ".ase" ones.
+func PaletteFormatFromFilePath(path string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	switch ext {
+	case "txt":
+		ext = "hex"
+	case "aseprite":
+		ext = "ase"
+	}
+	if _, ok := paletteFormats[ext]; !ok {
+		return "", fmt.Errorf("unknown palette file format %q (available: %v)", ext, PaletteFormatNames())
+	}
+
+	return ext, nil
+}
+
+// GetPaletteFromFilePath reads a palette file; its format is given by its extension.
+func GetPaletteFromFilePath(filePath string) ([]color.RGBA, error) {
+	format, err := PaletteFormatFromFilePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return DecodePalette(f, format)
+}
+
+// WritePaletteToFile saves a palette to a file; its format is given by its extension.
+func WritePaletteToFile(palette []color.RGBA, filePath string) error {
+	format, err := PaletteFormatFromFilePath(filePath)
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = EncodePalette(outputFile, palette, format)
+
+	// Don't forget to close files
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// DecodePalette reads a palette in a given file format (see PaletteFormatNames).
+// The palette must contain between 1 and MaxPaletteSize colors.
+func DecodePalette(r io.Reader, format string) ([]color.RGBA, error) {
+	f, ok := paletteFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown palette file format %q (available: %v)", format, PaletteFormatNames())
+	}
+
+	palette, err := f.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(palette) == 0 || len(palette) > MaxPaletteSize {
+		return nil, fmt.Errorf("a palette must have between 1 and %d colors, not %d", MaxPaletteSize, len(palette))
+	}
+
+	return palette, nil
+}
+
+// EncodePalette writes a palette in a given file format (see PaletteFormatNames).
+// Only the JSON and ACT formats can store transparency: the other ones write
+// translucent colors as opaque ones.
+func EncodePalette(w io.Writer, palette []color.RGBA, format string) error {
+	f, ok := paletteFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown palette file format %q (available: %v)", format, PaletteFormatNames())
+	}
+	if len(palette) == 0 || len(palette) > MaxPaletteSize {
+		return fmt.Errorf("a palette must have between 1 and %d colors, not %d", MaxPaletteSize, len(palette))
+	}
+
+	return f.Encode(w, palette)
+}
+
+// DecodeGPL reads a GIMP palette (.gpl).
+// It starts with a "GIMP Palette" line and a few optional header lines ("Name: ...", "Columns: ...");
+// then each line holds a color as three decimal numbers, optionally followed by its name.
+// Lines starting with '#' are comments.
+func DecodeGPL(r io.Reader) ([]color.RGBA, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "GIMP Palette" {
+		return nil, fmt.Errorf("gpl: missing \"GIMP Palette\" header")
+	}
+
+	var palette []color.RGBA
+	for line := 2; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") ||
+			strings.HasPrefix(text, "Name:") || strings.HasPrefix(text, "Columns:") {
+			continue
+		}
+
+		c, err := parseDecimalColor(strings.Fields(text))
+		if err != nil {
+			return nil, fmt.Errorf("gpl: line %d: %v", line, err)
+		}
+		palette = append(palette, c)
+	}
+
+	return palette, scanner.Err()
+}
+
+// EncodeGPL writes a GIMP palette (.gpl); each color is named after its hex code.
+func EncodeGPL(w io.Writer, palette []color.RGBA) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "GIMP Palette\nName: image-quantization\nColumns: %d\n#\n", ClampAboveInt(len(palette), 16))
+	for _, c := range palette {
+		c = Opaque(c)
+		fmt.Fprintf(&b, "%3d %3d %3d\t%s\n", c.R, c.G, c.B, FormatHexColor(c))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// DecodePAL reads a .pal palette, which is either a JASC (Paint Shop Pro) text palette
+// or a Microsoft RIFF binary palette.
+func DecodePAL(r io.Reader) ([]color.RGBA, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte("RIFF")) {
+		return decodeRIFFPAL(data)
+	}
+
+	return decodeJASCPAL(data)
+}
+
+// EncodePAL writes a JASC (Paint Shop Pro) text palette (.pal).
+func EncodePAL(w io.Writer, palette []color.RGBA) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "JASC-PAL\r\n0100\r\n%d\r\n", len(palette))
+	for _, c := range palette {
+		c = Opaque(c)
+		fmt.Fprintf(&b, "%d %d %d\r\n", c.R, c.G, c.B)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// decodeJASCPAL reads a JASC palette: the "JASC-PAL" and "0100" lines, the number of colors,
+// then one color per line as three decimal numbers.
+func decodeJASCPAL(data []byte) ([]color.RGBA, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) < 3 || strings.TrimSpace(lines[0]) != "JASC-PAL" {
+		return nil, fmt.Errorf("pal: missing \"JASC-PAL\" header")
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(lines[2]))
+	if err != nil || count < 0 || count > len(lines)-3 {
+		return nil, fmt.Errorf("pal: invalid color count %q", strings.TrimSpace(lines[2]))
+	}
+
+	var palette []color.RGBA
+	for i := 0; i < count; i++ {
+		c, err := parseDecimalColor(strings.Fields(lines[3+i]))
+		if err != nil {
+			return nil, fmt.Errorf("pal: line %d: %v", 4+i, err)
+		}
+		palette = append(palette, c)
+	}
+
+	return palette, nil
+}
+
+// decodeRIFFPAL reads a Microsoft RIFF palette: a "PAL " RIFF file whose "data" chunk holds
+// a version number, the number of colors and the colors as R, G, B, flags bytes.
+func decodeRIFFPAL(data []byte) ([]color.RGBA, error) {
+	if len(data) < 12 || string(data[8:12]) != "PAL " {
+		return nil, fmt.Errorf("pal: not a RIFF palette")
+	}
+
+	// Look for the "data" chunk.
+	for chunk := data[12:]; len(chunk) >= 8; {
+		id := string(chunk[:4])
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+		if size > len(chunk)-8 {
+			break
+		}
+		body := chunk[8 : 8+size]
+
+		if id == "data" {
+			if len(body) < 4 {
+				break
+			}
+			count := int(binary.LittleEndian.Uint16(body[2:4]))
+			if len(body) < 4+4*count {
+				break
+			}
+
+			var palette []color.RGBA
+			for i := 0; i < count; i++ {
+				e := body[4+4*i:]
+				palette = append(palette, color.RGBA{e[0], e[1], e[2], 255})
+			}
+			return palette, nil
+		}
+
+		// Chunks are padded to an even size.
+		chunk = chunk[8+size+size%2:]
+	}
+
+	return nil, fmt.Errorf("pal: invalid RIFF palette")
+}
+
+// DecodeACT reads an Adobe Color Table (.act): 256 RGB triplets, optionally followed by
+// the number of used colors and the transparent color index, both as big-endian 16-bit integers.
+func DecodeACT(r io.Reader) ([]color.RGBA, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 768 && len(data) != 772 {
+		return nil, fmt.Errorf("act: invalid file size %d (768 or 772 bytes expected)", len(data))
+	}
+
+	count := 256
+	if len(data) == 772 {
+		count = int(binary.BigEndian.Uint16(data[768:770]))
+		if count == 0 || count > 256 {
+			count = 256
+		}
+	}
+
+	var palette []color.RGBA
+	for i := 0; i < count; i++ {
+		palette = append(palette, color.RGBA{data[3*i], data[3*i+1], data[3*i+2], 255})
+	}
+
+	return palette, nil
+}
+
+// EncodeACT writes an Adobe Color Table (.act) with its optional trailer.
+// The first fully transparent color, if any, is recorded as the transparent color.
+func EncodeACT(w io.Writer, palette []color.RGBA) error {
+	data := make([]byte, 772)
+	transparent := 0xffff
+	for i, c := range palette {
+		if c.A == 0 && transparent == 0xffff {
+			transparent = i
+		}
+		c = Opaque(c)
+		data[3*i], data[3*i+1], data[3*i+2] = c.R, c.G, c.B
+	}
+	binary.BigEndian.PutUint16(data[768:770], uint16(len(palette)))
+	binary.BigEndian.PutUint16(data[770:772], uint16(transparent))
+
+	_, err := w.Write(data)
+	return err
+}
+
+// DecodeHex reads a plain text list of hex colors (see ParseHexColor), one per line.
+// Empty lines and lines starting with ';' or "//" are ignored.
+func DecodeHex(r io.Reader) ([]color.RGBA, error) {
+	scanner := bufio.NewScanner(r)
+
+	var palette []color.RGBA
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, ";") || strings.HasPrefix(text, "//") {
+			continue
+		}
+
+		c, err := ParseHexColor(text)
+		if err != nil {
+			return nil, fmt.Errorf("hex: line %d: %v", line, err)
+		}
+		palette = append(palette, c)
+	}
+
+	return palette, scanner.Err()
+}
+
+// EncodeHex writes a plain text list of hex colors, one per line, without the leading '#'.
+func EncodeHex(w io.Writer, palette []color.RGBA) error {
+	var b strings.Builder
+	for _, c := range palette {
+		b.WriteString(strings.TrimPrefix(FormatHexColor(Opaque(c)), "#"))
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// DecodePaletteJSON reads a JSON array of hex colors (see ParseHexColor).
+func DecodePaletteJSON(r io.Reader) ([]color.RGBA, error) {
+	var hexColors []string
+	if err := json.NewDecoder(r).Decode(&hexColors); err != nil {
+		return nil, fmt.Errorf("json: %v", err)
+	}
+
+	var palette []color.RGBA
+	for _, hex := range hexColors {
+		c, err := ParseHexColor(hex)
+		if err != nil {
+			return nil, fmt.Errorf("json: %v", err)
+		}
+		palette = append(palette, c)
+	}
+
+	return palette, nil
+}
+
+// EncodePaletteJSON writes a JSON array of hex colors (see FormatHexColor).
+func EncodePaletteJSON(w io.Writer, palette []color.RGBA) error {
+	var hexColors []string
+	for _, c := range palette {
+		hexColors = append(hexColors, FormatHexColor(c))
+	}
+
+	data, err := json.MarshalIndent(hexColors, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// parseDecimalColor parses a color given as (at least) three decimal channel values.
+func parseDecimalColor(fields []string) (color.RGBA, error) {
+	if len(fields) < 3 {
+		return color.RGBA{}, fmt.Errorf("three channel values expected")
+	}
+
+	var channels [3]uint8
+	for i := range channels {
+		v, err := strconv.ParseUint(fields[i], 10, 8)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid channel value %q", fields[i])
+		}
+		channels[i] = uint8(v)
+	}
+
+	return color.RGBA{channels[0], channels[1], channels[2], 255}, nil
+}
@@ -0,0 +1,67 @@
+package quantize
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func TestPaletteFormatsRoundTrip(t *testing.T) {
+	opaque := []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}, {18, 52, 86, 255}, {200, 16, 46, 255}, {1, 2, 3, 255}}
+	translucent := append([]color.RGBA{{0, 0, 0, 0}, {64, 32, 0, 128}}, opaque...)
+
+	for _, test := range []struct {
+		format  string
+		palette []color.RGBA
+		want    []color.RGBA
+	}{
+		{"gpl", opaque, opaque},
+		{"pal", opaque, opaque},
+		{"act", opaque, opaque},
+		{"hex", opaque, opaque},
+		{"json", opaque, opaque},
+		{"ase", opaque, opaque},
+		// Only JSON keeps the alpha channel; the other formats write the opaque colors.
+		{"json", translucent, translucent},
+		{"gpl", translucent, append([]color.RGBA{{0, 0, 0, 255}, {127, 63, 0, 255}}, opaque...)},
+		{"hex", translucent, append([]color.RGBA{{0, 0, 0, 255}, {127, 63, 0, 255}}, opaque...)},
+		{"act", translucent, append([]color.RGBA{{0, 0, 0, 255}, {127, 63, 0, 255}}, opaque...)},
+	} {
+		var buf bytes.Buffer
+		if err := EncodePalette(&buf, test.palette, test.format); err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		got, err := DecodePalette(&buf, test.format)
+		if err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.format, got, test.want)
+		}
+	}
+}
+
+// The palettes shared as PNG strips (see Swatch) are read back from the middle of their cells.
+func TestSwatchPNGRoundTrip(t *testing.T) {
+	palette := []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}, {18, 52, 86, 255}, {200, 16, 46, 255}, {0, 0, 0, 0}}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, Swatch(palette, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := image.Rect(0, 0, len(palette)*SwatchCellSize, SwatchCellSize); img.Bounds() != want {
+		t.Fatalf("bounds %v, want %v", img.Bounds(), want)
+	}
+	for i, c := range palette {
+		if got := PixelColor(img, i*SwatchCellSize+SwatchCellSize/2, SwatchCellSize/2); got != c {
+			t.Errorf("color %d: got %v, want %v", i, got, c)
+		}
+	}
+}
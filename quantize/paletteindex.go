@@ -0,0 +1,316 @@
+package quantize
+
+import (
+	"image/color"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+//
+// 			Nearest color lookup acceleration.
+//
+
+// PaletteIndex finds the nearest palette color of a given color faster than a linear scan of the palette.
+// It is a k-d tree of the palette colors, built once per palette; building it is cheap
+// compared to mapping all the pixels of an image.
+// With the RGB metric, the results are the same as NearestColorIndex's, ties included.
+// Metrics which are not Euclidean (see ColorMetric) cannot use the tree; the palette
+// colors are then scanned, but their coordinates are still computed only once.
+// A PaletteIndex is read-only once built, so it can be used by several goroutines.
+type PaletteIndex struct {
+	palette []color.RGBA
+	metric  ColorMetric
+	points  []ColorPoint
+	nodes   []kdNode
+	// channels holds the palette channels as a structure of arrays for NearestBatch,
+	// if the metric is RGBMetric or RGBAMetric.
+	channels *paletteChannels
+}
+
+// kdNode is a node of the k-d tree of a PaletteIndex.
+// Its children are indices in the node slice; -1 means no child.
+type kdNode struct {
+	point       ColorPoint
+	index       int
+	axis        int
+	left, right int
+}
+
+// NewPaletteIndex builds the index of a palette for a given color metric; nil means RGBMetric.
+// The palette must not be modified afterwards.
+func NewPaletteIndex(palette []color.RGBA, metric ColorMetric) *PaletteIndex {
+	if metric == nil {
+		metric = RGBMetric{}
+	}
+
+	p := &PaletteIndex{palette: palette, metric: metric}
+	for _, c := range palette {
+		p.points = append(p.points, metric.Point(c))
+	}
+
+	if metric.IsEuclidean() {
+		indices := make([]int, len(palette))
+		for i := range indices {
+			indices[i] = i
+		}
+		p.build(indices)
+	}
+	switch metric.(type) {
+	case RGBMetric:
+		p.channels = newPaletteChannels(palette, false)
+	case RGBAMetric:
+		p.channels = newPaletteChannels(palette, true)
+	}
+
+	return p
+}
+
+// PaletteIndexCacheSize is the number of palette indices kept by a PaletteIndexCache.
+const PaletteIndexCacheSize = 8
+
+// PaletteIndexCache keeps the indices of the last palettes mapped, so that the images mapped to the same palette,
+// e.g. the frames of an animation, share its index instead of building it again.
+// A nil cache builds a new index on each call. It can be used by several goroutines.
+type PaletteIndexCache struct {
+	mu sync.Mutex
+	// indices holds the indices of each palette, keyed by its colors (see appendPaletteKey), for the metrics used with it;
+	// count is their number, and key the buffer of the key of the palette looked up.
+	indices map[string][]*PaletteIndex
+	count   int
+	key     []byte
+}
+
+// NewPaletteIndexCache creates an empty cache.
+func NewPaletteIndexCache() *PaletteIndexCache {
+	return &PaletteIndexCache{indices: map[string][]*PaletteIndex{}}
+}
+
+// Index returns the index of a palette for a given color metric; nil means RGBMetric (see NewPaletteIndex).
+// The palette must not be modified afterwards.
+func (c *PaletteIndexCache) Index(palette []color.RGBA, metric ColorMetric) *PaletteIndex {
+	if c == nil {
+		return NewPaletteIndex(palette, metric)
+	}
+
+	if metric == nil {
+		metric = RGBMetric{}
+	}
+
+	// The lookup does not allocate: the key is built in a buffer, which the map is indexed by without copying it.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key = appendPaletteKey(c.key[:0], palette)
+	for _, index := range c.indices[string(c.key)] {
+		if reflect.DeepEqual(index.metric, metric) {
+			return index
+		}
+	}
+
+	// The cache is emptied when it is full: callers usually switch to a new set of palettes.
+	if c.count >= PaletteIndexCacheSize {
+		c.indices = map[string][]*PaletteIndex{}
+		c.count = 0
+	}
+	index := NewPaletteIndex(palette, metric)
+	key := string(c.key)
+	c.indices[key] = append(c.indices[key], index)
+	c.count++
+
+	return index
+}
+
+// appendPaletteKey appends the channels of the colors of a palette to <key>, which identifies the palette.
+func appendPaletteKey(key []byte, palette []color.RGBA) []byte {
+	for _, c := range palette {
+		key = append(key, c.R, c.G, c.B, c.A)
+	}
+
+	return key
+}
+
+// build adds the subtree of a set of palette colors and returns the position of its root node (-1 for an empty set).
+// The colors are split along the axis where they are the most spread out.
+func (p *PaletteIndex) build(indices []int) int {
+	if len(indices) == 0 {
+		return -1
+	}
+
+	axis, maxSpread := 0, -1.
+	for a := range p.points[indices[0]] {
+		min, max := p.points[indices[0]][a], p.points[indices[0]][a]
+		for _, i := range indices {
+			min = math.Min(min, p.points[i][a])
+			max = math.Max(max, p.points[i][a])
+		}
+		if max-min > maxSpread {
+			axis, maxSpread = a, max-min
+		}
+	}
+
+	sort.Slice(indices, func(i, j int) bool {
+		return p.points[indices[i]][axis] < p.points[indices[j]][axis]
+	})
+	median := len(indices) / 2
+
+	n := len(p.nodes)
+	p.nodes = append(p.nodes, kdNode{
+		point: p.points[indices[median]],
+		index: indices[median],
+		axis:  axis,
+	})
+
+	// The slices are copied because sorting them in the recursive calls reorders them.
+	left := p.build(append([]int(nil), indices[:median]...))
+	right := p.build(append([]int(nil), indices[median+1:]...))
+	p.nodes[n].left = left
+	p.nodes[n].right = right
+
+	return n
+}
+
+// Palette returns the indexed palette.
+func (p *PaletteIndex) Palette() []color.RGBA {
+	return p.palette
+}
+
+// Metric returns the color metric of the index.
+func (p *PaletteIndex) Metric() ColorMetric {
+	return p.metric
+}
+
+// Nearest returns the index of the palette color that is the closest to a given color,
+// according to the metric of the index.
+func (p *PaletteIndex) Nearest(c color.RGBA) int {
+	q := p.metric.Point(c)
+
+	if p.nodes == nil {
+		best, bestD := 0, p.metric.Distance(q, p.points[0])
+		for i := 1; i < len(p.points); i++ {
+			if d := p.metric.Distance(q, p.points[i]); d < bestD {
+				best, bestD = i, d
+			}
+		}
+
+		return best
+	}
+
+	best, bestD := -1, 0.
+	p.search(0, q, &best, &bestD)
+
+	return best
+}
+
+// NearestColor returns the palette color that is the closest to a given color.
+func (p *PaletteIndex) NearestColor(c color.RGBA) color.RGBA {
+	return p.palette[p.Nearest(c)]
+}
+
+// search looks for a closer color than the current best one in the subtree rooted at node <n>.
+// Distances are squared Euclidean distances.
+func (p *PaletteIndex) search(n int, q ColorPoint, best *int, bestD *float64) {
+	if n < 0 {
+		return
+	}
+	node := &p.nodes[n]
+
+	d := 0.
+	for i := range q {
+		d += (q[i] - node.point[i]) * (q[i] - node.point[i])
+	}
+	// On ties the lowest palette index wins, like in NearestColorIndex.
+	if *best < 0 || d < *bestD || (d == *bestD && node.index < *best) {
+		*best, *bestD = node.index, d
+	}
+
+	// Visit the half-space containing the query first, then the other one
+	// if it may contain a color at least as close as the best one.
+	diff := q[node.axis] - node.point[node.axis]
+	near, far := node.left, node.right
+	if diff >= 0 {
+		near, far = far, near
+	}
+	p.search(near, q, best, bestD)
+	if diff*diff <= *bestD {
+		p.search(far, q, best, bestD)
+	}
+}
+
+//
+// 			Batch lookup.
+//
+
+// The mapping pass of the ditherers looks up the colors of a whole row at once with NearestBatch. With the RGB
+// and RGBA metrics, the small palettes are then scanned as a structure of arrays of integer channels:
+// the loop over the palette makes no call and no conversion to floating point, and its distances are exact,
+// which beats the k-d tree and its point conversions up to BatchScanSize colors.
+
+// BatchScanSize is the largest palette size for which NearestBatch scans the palette instead of searching the k-d tree.
+const BatchScanSize = 64
+
+// paletteChannels holds the channels of the colors of a palette, one slice per channel.
+type paletteChannels struct {
+	r, g, b, a []int32
+	alpha      bool
+}
+
+// newPaletteChannels splits the colors of a palette into channels; <alpha> makes the alpha channel count in the distances.
+func newPaletteChannels(palette []color.RGBA, alpha bool) *paletteChannels {
+	n := len(palette)
+	ch := &paletteChannels{r: make([]int32, n), g: make([]int32, n), b: make([]int32, n), a: make([]int32, n), alpha: alpha}
+	for i, c := range palette {
+		ch.r[i], ch.g[i], ch.b[i], ch.a[i] = int32(c.R), int32(c.G), int32(c.B), int32(c.A)
+	}
+
+	return ch
+}
+
+// nearest returns the index of the nearest palette color of a color, the lowest one on ties.
+// The squared distances are integers, so the result is the same as the k-d tree's.
+func (ch *paletteChannels) nearest(c color.RGBA) int {
+	r, g, b, a := int32(c.R), int32(c.G), int32(c.B), int32(c.A)
+	if !ch.alpha {
+		a = 0
+	}
+	pr, pg, pb, pa := ch.r, ch.g[:len(ch.r)], ch.b[:len(ch.r)], ch.a[:len(ch.r)]
+
+	best, bestD := 0, int32(math.MaxInt32)
+	for i := range pr {
+		dr, dg, db := r-pr[i], g-pg[i], b-pb[i]
+		d := dr*dr + dg*dg + db*db
+		if ch.alpha {
+			da := a - pa[i]
+			d += da * da
+		}
+		if d < bestD {
+			best, bestD = i, d
+		}
+	}
+
+	return best
+}
+
+// NearestBatch writes to <indices> the palette index of the nearest color of each color of <colors>, as Nearest does;
+// <indices> must be at least as long as <colors>. The palette must have at most 256 colors.
+// The runs of identical colors, e.g. the flat areas of an image, are looked up once.
+func (p *PaletteIndex) NearestBatch(colors []color.RGBA, indices []uint8) {
+	if len(colors) == 0 {
+		return
+	}
+	indices = indices[:len(colors)]
+
+	nearest := p.Nearest
+	if p.channels != nil && len(p.palette) <= BatchScanSize {
+		nearest = p.channels.nearest
+	}
+
+	last := colors[0]
+	lastIndex := uint8(nearest(last))
+	for i, c := range colors {
+		if c != last {
+			last, lastIndex = c, uint8(nearest(c))
+		}
+		indices[i] = lastIndex
+	}
+}
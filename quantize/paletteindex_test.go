@@ -0,0 +1,68 @@
+package quantize
+
+import (
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// linearNearest returns the index of the first nearest palette color of a color according to a metric, scanning the palette.
+func linearNearest(c color.RGBA, palette []color.RGBA, metric ColorMetric) int {
+	q := metric.Point(c)
+	best, bestD := 0, math.Inf(1)
+	for i, p := range palette {
+		if d := metric.Distance(q, metric.Point(p)); d < bestD {
+			best, bestD = i, d
+		}
+	}
+
+	return best
+}
+
+func TestPaletteIndexMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomColor := func() color.RGBA {
+		a := uint8(rng.Intn(256))
+		return color.RGBA{uint8(rng.Intn(int(a) + 1)), uint8(rng.Intn(int(a) + 1)), uint8(rng.Intn(int(a) + 1)), a}
+	}
+
+	metrics := []ColorMetric{RGBMetric{}, RGBAMetric{}, DE76Metric{}, DE2000Metric{}, YCbCrMetric{}, OKLabMetric{}, OKLCHMetric{},
+		HSVMetric{}, HSLMetric{}, WeightedMetric{Metric: RGBMetric{}, Weights: [3]float64{3, 6, 1}}}
+	// The sizes around BatchScanSize use both NearestBatch paths.
+	for _, size := range []int{1, 2, 7, BatchScanSize, BatchScanSize + 1, MaxPaletteSize} {
+		palette := make([]color.RGBA, size)
+		for i := range palette {
+			palette[i] = randomColor()
+		}
+		// Some duplicates make ties, and the palette colors themselves are looked up too.
+		if size > 2 {
+			palette[size-1] = palette[0]
+		}
+		colors := append([]color.RGBA(nil), palette...)
+		for i := 0; i < 250; i++ {
+			c := randomColor()
+			colors = append(colors, c, c)
+		}
+
+		for _, metric := range metrics {
+			index := NewPaletteIndex(palette, metric)
+			batch := make([]uint8, len(colors))
+			index.NearestBatch(colors, batch)
+			for i, c := range colors {
+				want := linearNearest(c, palette, metric)
+				wantD := metric.Distance(metric.Point(c), metric.Point(palette[want]))
+				for _, got := range []int{index.Nearest(c), int(batch[i])} {
+					// A tie may be broken otherwise, but the distance must be the smallest one.
+					if d := metric.Distance(metric.Point(c), metric.Point(palette[got])); got != want && d > wantD+1e-9*math.Max(1, wantD) {
+						t.Fatalf("%T, %d colors: %v mapped to %d at distance %v, want %d at distance %v", metric, size, c, got, d, want, wantD)
+					}
+				}
+				// With the RGB metric, the ties are broken as by NearestColorIndex.
+				if _, ok := metric.(RGBMetric); ok && (index.Nearest(c) != NearestColorIndex(c, palette) || int(batch[i]) != NearestColorIndex(c, palette)) {
+					t.Fatalf("%d colors: %v mapped to %d and %d, want %d", size, c, index.Nearest(c), batch[i], NearestColorIndex(c, palette))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package quantize
+
+import (
+	"image/color"
+	"math"
+)
+
+//
+// 			Palette refinement.
+//
+
+// maxRefineSamples is the maximum number of pixels RefinePalette looks at.
+const maxRefineSamples = 1 << 16
+
+// RefinePalette improves a palette generated from some pixels:
+//   - the duplicated colors are removed,
+//   - the colors closer than <mergeDeltaE> (CIE 1976 ΔE) are merged, if mergeDeltaE > 0,
+//   - the freed slots are given to the pixel colors which are the worst represented by the palette.
+//
+// The palette keeps its size, unless the pixels do not have enough distinct colors to fill it.
+func RefinePalette(palette []color.RGBA, pixels []color.RGBA, mergeDeltaE float64) []color.RGBA {
+	size := len(palette)
+
+	// Remove the exact duplicates.
+	var refined []color.RGBA
+	seen := map[color.RGBA]bool{}
+	for _, c := range palette {
+		if !seen[c] {
+			seen[c] = true
+			refined = append(refined, c)
+		}
+	}
+
+	// Merge the closest pairs of colors until no pair is closer than the threshold.
+	for mergeDeltaE > 0 && len(refined) > 1 {
+		i, j, d := closestColors(refined)
+		if d >= mergeDeltaE {
+			break
+		}
+
+		refined[i] = MeanColorOfRange([]color.RGBA{refined[i], refined[j]}, 0, 2)
+		refined = append(refined[:j], refined[j+1:]...)
+	}
+
+	// Give the freed slots to the worst represented colors.
+	samples := samplePixels(pixels, maxRefineSamples)
+	for len(refined) < size {
+		c, ok := worstRepresentedColor(refined, samples)
+		if !ok || containsColor(refined, c) {
+			break
+		}
+		refined = append(refined, c)
+	}
+
+	return refined
+}
+
+// closestColors returns the indices i < j of the two closest colors of a palette, and their ΔE76 distance.
+func closestColors(palette []color.RGBA) (int, int, float64) {
+	labs := make([][3]float64, len(palette))
+	for i, c := range palette {
+		labs[i] = RGBToLab(c)
+	}
+
+	bestI, bestJ, bestD := 0, 1, math.Inf(1)
+	for i := range labs {
+		for j := i + 1; j < len(labs); j++ {
+			d := EuclideanDistance(ColorPoint{labs[i][0], labs[i][1], labs[i][2]}, ColorPoint{labs[j][0], labs[j][1], labs[j][2]})
+			if d < bestD {
+				bestI, bestJ, bestD = i, j, d
+			}
+		}
+	}
+
+	return bestI, bestJ, bestD
+}
+
+// worstRepresentedColor looks for the palette color whose pixels have the largest total error,
+// and returns the mean color of those of its pixels which are farther than average.
+// It returns false if every pixel color is in the palette.
+func worstRepresentedColor(palette []color.RGBA, pixels []color.RGBA) (color.RGBA, bool) {
+	index := NewPaletteIndex(palette, nil)
+	metric := RGBMetric{}
+
+	nearest := make([]int, len(pixels))
+	distances := make([]float64, len(pixels))
+	errors := make([]float64, len(palette))
+	counts := make([]int, len(palette))
+	for k, c := range pixels {
+		i := index.Nearest(c)
+		d := metric.Distance(metric.Point(c), metric.Point(palette[i]))
+		nearest[k], distances[k] = i, d
+		errors[i] += d * d
+		counts[i]++
+	}
+
+	worst := 0
+	for i := range errors {
+		if errors[i] > errors[worst] {
+			worst = i
+		}
+	}
+	if errors[worst] == 0 {
+		return color.RGBA{}, false
+	}
+
+	// The farthest pixels of the cluster form a new one.
+	meanDistance := 0.
+	for k := range pixels {
+		if nearest[k] == worst {
+			meanDistance += distances[k]
+		}
+	}
+	meanDistance /= float64(counts[worst])
+
+	var far []color.RGBA
+	for k, c := range pixels {
+		if nearest[k] == worst && distances[k] > meanDistance {
+			far = append(far, c)
+		}
+	}
+
+	return MeanColorOfRange(far, 0, len(far)), true
+}
+
+// samplePixels returns at most <max> pixels, evenly picked from a slice.
+func samplePixels(pixels []color.RGBA, max int) []color.RGBA {
+	if len(pixels) <= max {
+		return pixels
+	}
+
+	samples := make([]color.RGBA, 0, max)
+	step := float64(len(pixels)) / float64(max)
+	for i := 0; i < max; i++ {
+		samples = append(samples, pixels[int(float64(i)*step)])
+	}
+
+	return samples
+}
+
+// containsColor reports whether a palette contains a given color.
+func containsColor(palette []color.RGBA, c color.RGBA) bool {
+	for _, p := range palette {
+		if p == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+// 			Shared palettes.
+//
+
+// The sprites of a sheet, the tiles of a set or the images of a game are often drawn with one palette, though
+// they are made separately. Their colors are pooled, each input weighing as much as the others whatever its size,
+// so that a small sprite gets its colors next to a large background, and the palette is generated from the pool.
+// It is then polished by k-means steps, which lower the total error of the inputs.
+
+// MergePalettes generates one palette of at most <size> colors for several inputs, e.g. the histograms of images
+// (see Histogram.Colors) or the colors of palettes, with opts.Quantizer (nil means MedianCutQuantizer).
+// The weights of each input are scaled so that every input weighs as much as the heaviest one.
+// The palette colors are then moved to the mean colors of the colors mapped to them, as long as this lowers
+// the total squared distance of the inputs to the palette, according to opts.Metric (nil means RGBMetric).
+// If the inputs have at most <size> distinct colors, they are the palette.
+func MergePalettes(inputs [][]WeightedColor, size int, opts PaletteOptions) []color.RGBA {
+	heaviest := 0.
+	totals := make([]float64, len(inputs))
+	for i, input := range inputs {
+		for _, wc := range input {
+			totals[i] += wc.Weight
+		}
+		heaviest = math.Max(heaviest, totals[i])
+	}
+
+	// The colors shared by several inputs are pooled.
+	var pool []WeightedColor
+	positions := map[color.RGBA]int{}
+	for i, input := range inputs {
+		for _, wc := range input {
+			if wc.Weight <= 0 {
+				continue
+			}
+			w := wc.Weight * heaviest / totals[i]
+			if k, ok := positions[wc.Color]; ok {
+				pool[k].Weight += w
+			} else {
+				positions[wc.Color] = len(pool)
+				pool = append(pool, WeightedColor{wc.Color, w})
+			}
+		}
+	}
+	if len(pool) == 0 {
+		return []color.RGBA{{0, 0, 0, 255}}
+	}
+	if len(pool) <= size {
+		palette := make([]color.RGBA, len(pool))
+		for i, wc := range pool {
+			palette[i] = wc.Color
+		}
+		return palette
+	}
+
+	quantizer := opts.Quantizer
+	if quantizer == nil {
+		quantizer = MedianCutQuantizer{}
+	}
+	var palette []color.RGBA
+	if hq, ok := quantizer.(HistogramQuantizer); ok {
+		palette = hq.PaletteFromHistogram(append([]WeightedColor(nil), pool...), size, opts)
+	} else {
+		palette = quantizer.Palette(weightedPixels(pool, maxRefineSamples), size, opts)
+	}
+
+	metric := opts.Metric
+	if metric == nil {
+		metric = RGBMetric{}
+	}
+
+	return polishPalette(pool, palette, opts, metric)
+}
+
+// weightedPixels returns about <max> pixel colors distributed like weighted colors, each color getting at least one.
+func weightedPixels(colors []WeightedColor, max int) []color.RGBA {
+	total := 0.
+	for _, wc := range colors {
+		total += wc.Weight
+	}
+
+	var pixels []color.RGBA
+	for _, wc := range colors {
+		n := ClampBelowInt(int(wc.Weight/total*float64(max)+0.5), 1)
+		for i := 0; i < n; i++ {
+			pixels = append(pixels, wc.Color)
+		}
+	}
+
+	return pixels
+}
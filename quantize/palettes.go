@@ -0,0 +1,142 @@
+package quantize
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// 			Built-in palettes.
+//
+
+// namedPalettes holds the built-in fixed palettes, indexed by name.
+var namedPalettes = map[string][]color.RGBA{
+	// The four shades of green of the original Game Boy screen.
+	"gameboy": mustParseHexColors("0f380f 306230 8bac0f 9bbc0f"),
+	// The NES (2C02) palette, without its duplicated blacks.
+	"nes": mustParseHexColors(`
+		7c7c7c 0000fc 0000bc 4428bc 940084 a80020 a81000 881400 503000 007800 006800 005800 004058 000000
+		bcbcbc 0078f8 0058f8 6844fc d800cc e40058 f83800 e45c10 ac7c00 00b800 00a800 00a844 008888
+		f8f8f8 3cbcfc 6888fc 9878f8 f878f8 f85898 f87858 fca044 f8b800 b8f818 58d854 58f898 00e8d8 787878
+		fcfcfc a4e4fc b8b8f8 d8b8f8 f8b8f8 f8a4c0 f0d0b0 fce0a8 f8d878 d8f878 b8f8b8 b8f8d8 00fcfc f8d8f8`),
+	// The sixteen colors of the PICO-8 fantasy console.
+	"pico8": mustParseHexColors(`
+		000000 1d2b53 7e2553 008751 ab5236 5f574f c2c3c7 fff1e8
+		ff004d ffa300 ffec27 00e436 29adff 83769c ff77a8 ffccaa`),
+	// The sixteen colors of the CGA text modes (also the default EGA palette).
+	"cga": mustParseHexColors(`
+		000000 0000aa 00aa00 00aaaa aa0000 aa00aa aa5500 aaaaaa
+		555555 5555ff 55ff55 55ffff ff5555 ff55ff ffff55 ffffff`),
+	// The CGA graphics mode palette 1, high intensity: black, cyan, magenta and white.
+	"cga1": mustParseHexColors("000000 55ffff ff55ff ffffff"),
+	// The 64 colors of the EGA: two bits per channel.
+	"ega": colorCube(0x00, 0x55, 0xaa, 0xff),
+	// The 216 "web-safe" colors.
+	"websafe": colorCube(0x00, 0x33, 0x66, 0x99, 0xcc, 0xff),
+}
+
+// NamedPalette returns the built-in palette registered under <name>.
+func NamedPalette(name string) ([]color.RGBA, error) {
+	palette, ok := namedPalettes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown palette %q (available: %v)", name, PaletteNames())
+	}
+
+	return palette, nil
+}
+
+// PaletteNames returns the names of all the built-in palettes, sorted alphabetically.
+func PaletteNames() []string {
+	var names []string
+	for name := range namedPalettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// colorCube returns all the colors whose channels take the given levels, red varying the slowest.
+func colorCube(levels ...uint8) []color.RGBA {
+	var palette []color.RGBA
+	for _, r := range levels {
+		for _, g := range levels {
+			for _, b := range levels {
+				palette = append(palette, color.RGBA{r, g, b, 255})
+			}
+		}
+	}
+
+	return palette
+}
+
+// ParseHexColor parses a color written as "#rrggbb" or "rrggbb" (case insensitive).
+// The shorthand "#rgb" form is accepted too, as well as "#rrggbbaa" for a translucent color
+// (the channels are then not premultiplied).
+func ParseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q", s)
+	}
+
+	// The channels are premultiplied with rounding, as FormatHexColor divides them, so that colors survive a round trip.
+	a := uint32(v & 0xff)
+	premultiply := func(c uint32) uint8 { return uint8((c&0xff*a + 127) / 255) }
+	return color.RGBA{premultiply(uint32(v >> 24)), premultiply(uint32(v >> 16)), premultiply(uint32(v >> 8)), uint8(a)}, nil
+}
+
+// FormatHexColor writes a color as "#rrggbb", or "#rrggbbaa" if it is translucent (see ParseHexColor).
+func FormatHexColor(c color.RGBA) string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+
+	if c.A == 0 {
+		return "#00000000"
+	}
+	a := uint32(c.A)
+	divide := func(v uint8) uint8 { return uint8(ClampAboveInt(int((uint32(v)*255+a/2)/a), 255)) }
+	return fmt.Sprintf("#%02x%02x%02x%02x", divide(c.R), divide(c.G), divide(c.B), c.A)
+}
+
+// ParseHexColors parses a list of hex colors (see ParseHexColor) separated by spaces or commas.
+func ParseHexColors(s string) ([]color.RGBA, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+
+	var colors []color.RGBA
+	for _, field := range fields {
+		c, err := ParseHexColor(field)
+		if err != nil {
+			return nil, err
+		}
+		colors = append(colors, c)
+	}
+
+	return colors, nil
+}
+
+// mustParseHexColors is ParseHexColors for the built-in palettes; it panics on invalid colors.
+func mustParseHexColors(s string) []color.RGBA {
+	colors, err := ParseHexColors(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return colors
+}
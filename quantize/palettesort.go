@@ -0,0 +1,93 @@
+package quantize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+)
+
+//
+// 			Palette ordering.
+//
+
+// Palette orders of the indexed PNG images (see SortPalette).
+const (
+	// OrderKeep keeps the palette as generated.
+	OrderKeep = "keep"
+	// OrderLuma sorts the colors from the darkest to the lightest.
+	OrderLuma = "luma"
+	// OrderUsage sorts the colors from the most used to the least used.
+	OrderUsage = "usage"
+)
+
+// CheckPaletteOrder returns an error if a palette order is unknown.
+func CheckPaletteOrder(order string) error {
+	switch order {
+	case OrderKeep, OrderLuma, OrderUsage:
+		return nil
+	}
+
+	return fmt.Errorf("unknown palette order %q (available: [%s %s %s])", order, OrderKeep, OrderLuma, OrderUsage)
+}
+
+// SortPalette returns a paletted image whose palette is reordered, the pixels keeping their colors.
+// Unless the order is OrderKeep, the unused colors are dropped, so that the PNG encoder can pick a smaller bit depth,
+// and the transparent colors come first, so that the tRNS chunk of the PNG is as short as possible.
+// Ties are broken by the original order. The image is returned as is with OrderKeep.
+func SortPalette(img *image.Paletted, order string) *image.Paletted {
+	if order == OrderKeep {
+		return img
+	}
+
+	counts := make([]int, len(img.Palette))
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			counts[img.ColorIndexAt(x, y)]++
+		}
+	}
+
+	var indices []int
+	for i, n := range counts {
+		if n > 0 {
+			indices = append(indices, i)
+		}
+	}
+	lumas := make([]float64, len(img.Palette))
+	for i, c := range img.Palette {
+		o := Opaque(color.RGBAModel.Convert(c).(color.RGBA))
+		lumas[i] = luma(SRGBToLinear(o.R), SRGBToLinear(o.G), SRGBToLinear(o.B))
+	}
+	transparent := func(i int) bool {
+		_, _, _, a := img.Palette[i].RGBA()
+		return a == 0
+	}
+
+	sort.SliceStable(indices, func(i, j int) bool {
+		a, b := indices[i], indices[j]
+		if transparent(a) != transparent(b) {
+			return transparent(a)
+		}
+		if order == OrderUsage {
+			return counts[a] > counts[b]
+		}
+		return lumas[a] < lumas[b]
+	})
+
+	// The pixels are mapped from the old indices to the new ones.
+	palette := make(color.Palette, len(indices))
+	newIndex := make([]uint8, len(img.Palette))
+	for k, i := range indices {
+		palette[k] = img.Palette[i]
+		newIndex[i] = uint8(k)
+	}
+	out := image.NewPaletted(b, palette)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			out.SetColorIndex(x, y, newIndex[img.ColorIndexAt(x, y)])
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,69 @@
+package quantize
+
+import (
+	"context"
+	"image"
+	"runtime"
+	"sync"
+)
+
+//
+// 			Parallel processing functions.
+//
+
+// ParallelRows calls <process> on horizontal bands of the rectangle <r>, concurrently.
+// Each call receives the rows [minY; maxY) of one band; the bands cover r exactly once.
+// At most <threads> goroutines are used; threads <= 0 means runtime.GOMAXPROCS(0).
+// <process> must only write to pixels of its own band.
+func ParallelRows(r image.Rectangle, threads int, process func(minY, maxY int)) {
+	ParallelRowsContext(context.Background(), r, threads, process)
+}
+
+// ParallelRowsContext is ParallelRows, but it stops processing new bands once <ctx> is canceled.
+// It then returns ctx.Err(), and some bands may not have been processed.
+// With a single thread, the bands are processed by the calling goroutine.
+func ParallelRowsContext(ctx context.Context, r image.Rectangle, threads int, process func(minY, maxY int)) error {
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	threads = ClampBelowInt(ClampAboveInt(threads, r.Dy()), 1)
+
+	// Use several bands per goroutine so that a slow band does not keep all the others waiting.
+	// This also lets the cancellation be noticed quickly.
+	bandHeight := ClampBelowInt(r.Dy()/(threads*4), 1)
+
+	if threads == 1 {
+		for minY := r.Min.Y; minY < r.Max.Y; minY += bandHeight {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			process(minY, ClampAboveInt(minY+bandHeight, r.Max.Y))
+		}
+		return nil
+	}
+
+	bands := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for minY := range bands {
+				process(minY, ClampAboveInt(minY+bandHeight, r.Max.Y))
+			}
+		}()
+	}
+
+	var err error
+	for minY := r.Min.Y; minY < r.Max.Y && err == nil; minY += bandHeight {
+		select {
+		case bands <- minY:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	close(bands)
+	wg.Wait()
+
+	return err
+}
@@ -0,0 +1,225 @@
+package quantize
+
+import (
+	"image/color"
+	"math"
+	"sort"
+)
+
+//
+// 			Principal axis cut.
+//
+
+// Median cut splits the colors along the red channel, and the variants along the longest channel of a box.
+// The colors of most images spread along a diagonal of the RGB cube, e.g. from dark blue to light yellow,
+// which such axis-aligned cuts slice across. The principal axis cut splits each box of colors along
+// the direction of its largest variance, at the point which minimizes the error of the two halves,
+// always splitting the box with the largest error next.
+
+// pcaIterations is the number of power iterations computing the principal axis of a box.
+const pcaIterations = 32
+
+// PCAQuantizer splits the colors into boxes: the box of the largest total squared error is split in two
+// along its principal axis, the direction of the largest variance of its colors, where the total squared error of
+// the halves is the lowest, until there are <size> boxes. Each palette color is the mean color of a box.
+// If opts.Metric is a SpaceMetric, the colors are split in its color space; otherwise in RGB, or in RGBA
+// with opts.Alpha4D.
+type PCAQuantizer struct{}
+
+// pcaBox is a box of weighted colors, with their points and the total squared error of the box.
+type pcaBox struct {
+	colors []WeightedColor
+	points []ColorPoint
+	error  float64
+}
+
+// Palette implements the Quantizer interface.
+func (q PCAQuantizer) Palette(pixels []color.RGBA, size int, opts PaletteOptions) []color.RGBA {
+	return q.PaletteFromHistogram(CountColors(pixels), size, opts)
+}
+
+// PaletteFromHistogram implements the HistogramQuantizer interface.
+func (PCAQuantizer) PaletteFromHistogram(colors []WeightedColor, size int, opts PaletteOptions) []color.RGBA {
+	m, space := opts.Metric.(SpaceMetric)
+	points := make([]ColorPoint, len(colors))
+	for i, wc := range colors {
+		if space {
+			points[i] = m.Point(wc.Color)
+		} else {
+			points[i] = ColorPoint{float64(wc.Color.R), float64(wc.Color.G), float64(wc.Color.B)}
+			if opts.Alpha4D {
+				points[i][3] = float64(wc.Color.A)
+			}
+		}
+	}
+
+	boxes := []pcaBox{{colors, points, boxError(colors, points)}}
+	for len(boxes) < size {
+		// The box of the largest error is split next.
+		worst := -1
+		for i, box := range boxes {
+			if len(box.colors) > 1 && box.error > 0 && (worst < 0 || box.error > boxes[worst].error) {
+				worst = i
+			}
+		}
+		if worst < 0 {
+			break
+		}
+
+		first, second := splitBox(boxes[worst])
+		boxes[worst] = first
+		boxes = append(boxes, second)
+	}
+
+	palette := make([]color.RGBA, len(boxes))
+	for i, box := range boxes {
+		palette[i] = bucketMean(box.colors, opts.Linear, m, space)
+	}
+
+	return palette
+}
+
+// boxError returns the total squared distance of weighted points to their weighted mean.
+func boxError(colors []WeightedColor, points []ColorPoint) float64 {
+	var sum ColorPoint
+	var weight, squares float64
+	for i, wc := range colors {
+		for k, x := range points[i] {
+			sum[k] += wc.Weight * x
+			squares += wc.Weight * x * x
+		}
+		weight += wc.Weight
+	}
+
+	return squaredError(sum, squares, weight)
+}
+
+// squaredError returns the total squared distance of weighted points to their mean,
+// given the weighted sum of the points, of their squared coordinates and of their weights.
+func squaredError(sum ColorPoint, squares, weight float64) float64 {
+	if weight <= 0 {
+		return 0
+	}
+	for _, x := range sum {
+		squares -= x * x / weight
+	}
+
+	return math.Max(squares, 0)
+}
+
+// principalAxis returns the unit direction of the largest variance of weighted points, by power iteration
+// on their covariance matrix, starting from the coordinate axis of the largest variance.
+func principalAxis(colors []WeightedColor, points []ColorPoint) ColorPoint {
+	var mean ColorPoint
+	weight := 0.
+	for i, wc := range colors {
+		for k, x := range points[i] {
+			mean[k] += wc.Weight * x
+		}
+		weight += wc.Weight
+	}
+	for k := range mean {
+		mean[k] /= weight
+	}
+
+	var covariance [4][4]float64
+	for i, wc := range colors {
+		for k := range mean {
+			for l := range mean {
+				covariance[k][l] += wc.Weight * (points[i][k] - mean[k]) * (points[i][l] - mean[l])
+			}
+		}
+	}
+
+	var axis ColorPoint
+	largest := 0
+	for k := range covariance {
+		if covariance[k][k] > covariance[largest][largest] {
+			largest = k
+		}
+	}
+	axis[largest] = 1
+	for iteration := 0; iteration < pcaIterations; iteration++ {
+		var next ColorPoint
+		norm := 0.
+		for k := range covariance {
+			for l := range covariance {
+				next[k] += covariance[k][l] * axis[l]
+			}
+			norm += next[k] * next[k]
+		}
+		if norm = math.Sqrt(norm); norm == 0 {
+			break
+		}
+		for k := range next {
+			next[k] /= norm
+		}
+		axis = next
+	}
+
+	return axis
+}
+
+// splitBox splits a box of at least two distinct points in two along its principal axis (see principalAxis),
+// where the sum of the errors of the two halves is the lowest.
+func splitBox(box pcaBox) (pcaBox, pcaBox) {
+	axis := principalAxis(box.colors, box.points)
+	n := len(box.colors)
+	order := make([]int, n)
+	projections := make([]float64, n)
+	for i, p := range box.points {
+		order[i] = i
+		for k, x := range p {
+			projections[i] += x * axis[k]
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return projections[order[i]] < projections[order[j]] })
+
+	// The sums of the points before each split, and in total.
+	var totalSum ColorPoint
+	var totalSquares, totalWeight float64
+	for i, wc := range box.colors {
+		for k, x := range box.points[i] {
+			totalSum[k] += wc.Weight * x
+			totalSquares += wc.Weight * x * x
+		}
+		totalWeight += wc.Weight
+	}
+	var sum, rest ColorPoint
+	var squares, weight float64
+	best, bestError := n/2, math.Inf(1)
+	for i := 0; i < n-1; i++ {
+		j := order[i]
+		for k, x := range box.points[j] {
+			sum[k] += box.colors[j].Weight * x
+			squares += box.colors[j].Weight * x * x
+		}
+		weight += box.colors[j].Weight
+		// The split goes between different projections.
+		if projections[j] == projections[order[i+1]] {
+			continue
+		}
+		for k := range rest {
+			rest[k] = totalSum[k] - sum[k]
+		}
+		e := squaredError(sum, squares, weight) + squaredError(rest, totalSquares-squares, totalWeight-weight)
+		if e < bestError {
+			best, bestError = i+1, e
+		}
+	}
+
+	halves := [2]pcaBox{}
+	for i, j := range order {
+		h := 0
+		if i >= best {
+			h = 1
+		}
+		halves[h].colors = append(halves[h].colors, box.colors[j])
+		halves[h].points = append(halves[h].points, box.points[j])
+	}
+	for h := range halves {
+		halves[h].error = boxError(halves[h].colors, halves[h].points)
+	}
+
+	return halves[0], halves[1]
+}
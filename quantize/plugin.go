@@ -0,0 +1,94 @@
+package quantize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"plugin"
+	"strings"
+)
+
+//
+// 			Plugins.
+//
+
+// Third-party palette generation and dithering algorithms are added without changing the program by Go plugins
+// (see https://pkg.go.dev/plugin), loaded with the -plugin flag. A plugin importing this package would have to be built
+// against the very same version of it as the program, so it exports a Register function whose parameters
+// only use types of the standard library instead (the programs using this package as a library call
+// RegisterQuantizer and RegisterDitherer directly):
+//
+//	func Register(
+//		registerQuantizer func(name string, palette func(pixels []color.RGBA, size int) []color.RGBA),
+//		registerDitherer func(name string, dither func(img image.Image, palette []color.RGBA) *image.Paletted),
+//	)
+//
+// Register is called with functions registering the algorithms of the plugin (see RegisterQuantizer and RegisterDitherer),
+// which are then selected by name with -algo and -dither, like the built-in ones. A plugin is built
+// with "go build -buildmode=plugin", with the Go version of the program.
+
+// PluginRegisterFunc is the type of the Register function of a plugin.
+type PluginRegisterFunc = func(
+	registerQuantizer func(name string, palette func(pixels []color.RGBA, size int) []color.RGBA),
+	registerDitherer func(name string, dither func(img image.Image, palette []color.RGBA) *image.Paletted),
+)
+
+// QuantizerFunc is a palette generation algorithm given as a function, e.g. by a plugin.
+// It returns at most <size> colors representing <pixels>, and may be called concurrently.
+type QuantizerFunc func(pixels []color.RGBA, size int) []color.RGBA
+
+// Palette implements the Quantizer interface; the options are ignored.
+func (f QuantizerFunc) Palette(pixels []color.RGBA, size int, _ PaletteOptions) []color.RGBA {
+	return f(pixels, size)
+}
+
+// DitherFunc is a dithering algorithm given as a function, e.g. by a plugin.
+type DitherFunc func(img image.Image, palette []color.RGBA) *image.Paletted
+
+// Dither implements the Ditherer interface.
+func (f DitherFunc) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	return f(img, palette)
+}
+
+// LoadPlugins loads the comma-separated plugin files of <paths> and registers their algorithms.
+// Loading a plugin again registers its algorithms again, which is harmless.
+func LoadPlugins(paths string) error {
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if err := LoadPlugin(path); err != nil {
+			return fmt.Errorf("loading plugin %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadPlugin loads a plugin file and registers its algorithms by calling its Register function.
+func LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	symbol, err := p.Lookup("Register")
+	if err != nil {
+		return err
+	}
+	register, ok := symbol.(PluginRegisterFunc)
+	if !ok {
+		return fmt.Errorf("its Register function is a %T, not a %T", symbol, PluginRegisterFunc(nil))
+	}
+
+	register(
+		func(name string, palette func(pixels []color.RGBA, size int) []color.RGBA) {
+			RegisterQuantizer(name, func() Quantizer { return QuantizerFunc(palette) })
+		},
+		func(name string, dither func(img image.Image, palette []color.RGBA) *image.Paletted) {
+			// The ditherers of the plugins have no options.
+			RegisterDitherer(name, func(DitherOptions) (Ditherer, error) { return DitherFunc(dither), nil })
+		},
+	)
+
+	return nil
+}
@@ -0,0 +1,342 @@
+package quantize
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image/color"
+	"io"
+)
+
+//
+// 			Row by row PNG decoding.
+//
+
+// PNGRowReader decodes a PNG image row by row, so that an image larger than the memory can be processed
+// by bands of rows (see StreamQuantizePNGFile). Only the non-interlaced images are supported, with any color type
+// and bit depth; the 16-bit channels are rounded to 8 bits.
+type PNGRowReader struct {
+	Width, Height int
+	// Chunks holds the types of the chunks found before the image data, e.g. "iCCP" or "eXIf".
+	Chunks map[string]bool
+
+	colorType, depth int
+	palette          []color.RGBA
+	// transparent is the raw value of the transparent color of the gray and RGB images (see the tRNS chunk), if any.
+	transparent []byte
+	idat        *pngDataReader
+	zr          io.ReadCloser
+	// bpp is the number of bytes per complete pixel (at least 1), the distance the filters look back.
+	bpp       int
+	cur, prev []byte
+	rows      int
+}
+
+// Depth returns the bit depth of the samples of the image: 1, 2, 4, 8 or 16.
+func (p *PNGRowReader) Depth() int {
+	return p.depth
+}
+
+// Size implements the RowReader interface.
+func (p *PNGRowReader) Size() (int, int) {
+	return p.Width, p.Height
+}
+
+// pngChannels holds the number of channels of each PNG color type.
+var pngChannels = map[int]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}
+
+// NewPNGRowReader reads the header of a PNG image from <r>, up to its first IDAT chunk.
+// An error is returned if the image is not a PNG, or is interlaced.
+func NewPNGRowReader(r io.Reader) (*PNGRowReader, error) {
+	br := bufio.NewReader(r)
+	var signature [8]byte
+	if _, err := io.ReadFull(br, signature[:]); err != nil || string(signature[:]) != pngSignature {
+		return nil, fmt.Errorf("not a PNG image")
+	}
+
+	p := &PNGRowReader{Chunks: map[string]bool{}}
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return nil, fmt.Errorf("reading PNG chunk: %w", err)
+		}
+		length, kind := binary.BigEndian.Uint32(header[:4]), string(header[4:])
+		if kind == "IDAT" {
+			if p.Width == 0 {
+				return nil, fmt.Errorf("PNG image data before its header")
+			}
+			p.idat = &pngDataReader{r: br, left: length, crc: crc32.Update(0, crc32.IEEETable, header[4:])}
+			break
+		}
+
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, fmt.Errorf("reading PNG chunk %s: %w", kind, err)
+		}
+		data, sum := data[:length], binary.BigEndian.Uint32(data[length:])
+		if crc32.Update(crc32.ChecksumIEEE(header[4:]), crc32.IEEETable, data) != sum {
+			return nil, fmt.Errorf("invalid checksum of PNG chunk %s", kind)
+		}
+		p.Chunks[kind] = true
+		if err := p.parseChunk(kind, data); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if p.zr, err = zlib.NewReader(p.idat); err != nil {
+		return nil, fmt.Errorf("reading PNG image data: %w", err)
+	}
+	bits := pngChannels[p.colorType] * p.depth
+	p.bpp = (bits + 7) / 8
+	p.cur = make([]byte, 1+(p.Width*bits+7)/8)
+	p.prev = make([]byte, len(p.cur))
+
+	return p, nil
+}
+
+// parseChunk reads the chunks of a PNGRowReader which describe the image: IHDR, PLTE and tRNS.
+func (p *PNGRowReader) parseChunk(kind string, data []byte) error {
+	switch kind {
+	case "IHDR":
+		if len(data) != 13 {
+			return fmt.Errorf("invalid PNG header")
+		}
+		p.Width, p.Height = int(binary.BigEndian.Uint32(data[0:])), int(binary.BigEndian.Uint32(data[4:]))
+		p.depth, p.colorType = int(data[8]), int(data[9])
+		if _, ok := pngChannels[p.colorType]; !ok || p.Width <= 0 || p.Height <= 0 {
+			return fmt.Errorf("invalid PNG header")
+		}
+		if data[12] != 0 {
+			return fmt.Errorf("interlaced PNG images cannot be decoded row by row")
+		}
+	case "PLTE":
+		for i := 0; i+2 < len(data); i += 3 {
+			p.palette = append(p.palette, color.RGBA{data[i], data[i+1], data[i+2], 255})
+		}
+	case "tRNS":
+		if p.colorType != 3 {
+			p.transparent = data
+			break
+		}
+		for i, a := range data {
+			if i < len(p.palette) {
+				c := p.palette[i]
+				p.palette[i] = nrgbaColor(c.R, c.G, c.B, a)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadRow implements the RowReader interface.
+func (p *PNGRowReader) ReadRow(row []color.RGBA) error {
+	if p.rows == p.Height {
+		return fmt.Errorf("no more PNG rows (%d)", p.Height)
+	}
+	if len(row) != p.Width {
+		return fmt.Errorf("a PNG row has %d pixels, not %d", p.Width, len(row))
+	}
+
+	p.prev, p.cur = p.cur, p.prev
+	if _, err := io.ReadFull(p.zr, p.cur); err != nil {
+		return fmt.Errorf("reading PNG row %d: %w", p.rows, err)
+	}
+	if err := p.unfilter(); err != nil {
+		return err
+	}
+	p.rows++
+
+	line := p.cur[1:]
+	for x := range row {
+		row[x] = p.pixel(line, x)
+	}
+
+	return nil
+}
+
+// unfilter reverses the filter of the current row, given by its first byte.
+func (p *PNGRowReader) unfilter() error {
+	cur, prev := p.cur[1:], p.prev[1:]
+	if p.rows == 0 {
+		for i := range prev {
+			prev[i] = 0
+		}
+	}
+
+	switch p.cur[0] {
+	case 0:
+	case 1:
+		for i := p.bpp; i < len(cur); i++ {
+			cur[i] += cur[i-p.bpp]
+		}
+	case 2:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case 3:
+		for i := range cur {
+			left := 0
+			if i >= p.bpp {
+				left = int(cur[i-p.bpp])
+			}
+			cur[i] += uint8((left + int(prev[i])) / 2)
+		}
+	case 4:
+		for i := range cur {
+			var a, c int
+			if i >= p.bpp {
+				a, c = int(cur[i-p.bpp]), int(prev[i-p.bpp])
+			}
+			cur[i] += paeth(a, int(prev[i]), c)
+		}
+	default:
+		return fmt.Errorf("invalid PNG filter %d in row %d", p.cur[0], p.rows)
+	}
+
+	return nil
+}
+
+// paeth is the predictor of the Paeth filter: the neighbor (left, up or upper left) closest to left + up - upper left.
+func paeth(a, b, c int) uint8 {
+	abs := func(v int) int {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	pa, pb, pc := abs(b-c), abs(a-c), abs(a+b-2*c)
+	switch {
+	case pa <= pb && pa <= pc:
+		return uint8(a)
+	case pb <= pc:
+		return uint8(b)
+	default:
+		return uint8(c)
+	}
+}
+
+// pixel returns the color of the pixel at column <x> of an unfiltered row.
+func (p *PNGRowReader) pixel(line []byte, x int) color.RGBA {
+	// Samples narrower than a byte are packed from the highest bits; they are scaled to 8 bits.
+	if p.depth < 8 {
+		bit := x * p.depth
+		v := line[bit/8] >> (8 - p.depth - bit%8) & (1<<p.depth - 1)
+		if p.colorType == 3 {
+			return p.paletteColor(v)
+		}
+		if p.transparent != nil && len(p.transparent) == 2 && uint16(v) == binary.BigEndian.Uint16(p.transparent) {
+			return color.RGBA{}
+		}
+		g := uint8(int(v) * 255 / (1<<p.depth - 1))
+		return color.RGBA{g, g, g, 255}
+	}
+
+	size := p.depth / 8
+	samples := line[x*p.bpp : (x+1)*p.bpp]
+	if p.transparent != nil && (p.colorType == 0 || p.colorType == 2) && p.isTransparent(samples, size) {
+		return color.RGBA{}
+	}
+
+	// The 16-bit channels are premultiplied then rounded to 8 bits, as deepPixelColor does.
+	if size == 2 {
+		s16 := func(i int) uint16 { return binary.BigEndian.Uint16(samples[2*i:]) }
+		var c color.NRGBA64
+		switch p.colorType {
+		case 0:
+			c = color.NRGBA64{s16(0), s16(0), s16(0), 0xffff}
+		case 2:
+			c = color.NRGBA64{s16(0), s16(1), s16(2), 0xffff}
+		case 4:
+			c = color.NRGBA64{s16(0), s16(0), s16(0), s16(1)}
+		default:
+			c = color.NRGBA64{s16(0), s16(1), s16(2), s16(3)}
+		}
+		r, g, b, a := c.RGBA()
+		return color.RGBA{to8(r), to8(g), to8(b), to8(a)}
+	}
+
+	s := func(i int) uint8 { return samples[i] }
+	switch p.colorType {
+	case 0:
+		return color.RGBA{s(0), s(0), s(0), 255}
+	case 2:
+		return color.RGBA{s(0), s(1), s(2), 255}
+	case 3:
+		return p.paletteColor(s(0))
+	case 4:
+		return nrgbaColor(s(0), s(0), s(0), s(1))
+	default:
+		return nrgbaColor(s(0), s(1), s(2), s(3))
+	}
+}
+
+// isTransparent reports whether the samples of a gray or RGB pixel are the transparent color of the tRNS chunk,
+// whose values are 16-bit whatever the bit depth.
+func (p *PNGRowReader) isTransparent(samples []byte, size int) bool {
+	for i := 0; i < len(samples)/size; i++ {
+		if 2*i+1 >= len(p.transparent) {
+			return false
+		}
+		v := uint16(samples[i*size])
+		if size == 2 {
+			v = v<<8 | uint16(samples[i*size+1])
+		}
+		if v != binary.BigEndian.Uint16(p.transparent[2*i:]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// paletteColor returns the color of a palette index; the indices out of the palette are opaque black.
+func (p *PNGRowReader) paletteColor(i uint8) color.RGBA {
+	if int(i) >= len(p.palette) {
+		return color.RGBA{0, 0, 0, 255}
+	}
+
+	return p.palette[i]
+}
+
+// pngDataReader reads the data of consecutive IDAT chunks, checking their CRC.
+type pngDataReader struct {
+	r    *bufio.Reader
+	left uint32
+	crc  uint32
+}
+
+// Read implements the io.Reader interface.
+func (d *pngDataReader) Read(b []byte) (int, error) {
+	for d.left == 0 {
+		var footer [4]byte
+		if _, err := io.ReadFull(d.r, footer[:]); err != nil {
+			return 0, err
+		}
+		if binary.BigEndian.Uint32(footer[:]) != d.crc {
+			return 0, fmt.Errorf("invalid checksum of PNG chunk IDAT")
+		}
+
+		var header [8]byte
+		if _, err := io.ReadFull(d.r, header[:]); err != nil {
+			return 0, err
+		}
+		if string(header[4:]) != "IDAT" {
+			return 0, io.EOF
+		}
+		d.left = binary.BigEndian.Uint32(header[:4])
+		d.crc = crc32.Update(0, crc32.IEEETable, header[4:])
+	}
+
+	if uint32(len(b)) > d.left {
+		b = b[:d.left]
+	}
+	n, err := d.r.Read(b)
+	d.left -= uint32(n)
+	d.crc = crc32.Update(d.crc, crc32.IEEETable, b[:n])
+
+	return n, err
+}
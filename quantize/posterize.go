@@ -0,0 +1,139 @@
+package quantize
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+//
+// 			Posterization.
+//
+
+// In posterize mode, no palette is generated: each channel is reduced to a number of evenly spaced levels,
+// and the palette is the grid of their combinations. The ordered ditherings then offset each channel
+// by up to a level step, and the pixels are mapped to the grid by rounding, without any nearest color search.
+
+// MaxPosterizeLevels is the maximum number of levels per channel, whose grid fits in a palette.
+const MaxPosterizeLevels = 6
+
+// CheckPosterizeLevels returns an error if a number of levels per channel is not between 2 and MaxPosterizeLevels.
+func CheckPosterizeLevels(levels int) error {
+	if levels < 2 || levels > MaxPosterizeLevels {
+		return fmt.Errorf("invalid posterize levels %d (between 2 and %d expected)", levels, MaxPosterizeLevels)
+	}
+
+	return nil
+}
+
+// PosterizePalette returns the grid of the combinations of <levels> evenly spaced sRGB levels per channel,
+// the red one varying the slowest.
+func PosterizePalette(levels int) []color.RGBA {
+	level := func(i int) uint8 { return uint8(math.Round(255. * float64(i) / float64(levels-1))) }
+
+	var palette []color.RGBA
+	for r := 0; r < levels; r++ {
+		for g := 0; g < levels; g++ {
+			for b := 0; b < levels; b++ {
+				palette = append(palette, color.RGBA{level(r), level(g), level(b), 255})
+			}
+		}
+	}
+
+	return palette
+}
+
+// Posterize reduces each channel of an image to <levels> levels (see PosterizePalette).
+// The ordered ditherings are applied per channel (see PosterizeDitherer); the other ditherers map the pixels
+// to the grid as to any palette. The transparency is handled as by ApplyPalette.
+func Posterize(ctx context.Context, img image.Image, levels int, opts PaletteOptions, ditherer Ditherer) (*image.Paletted, error) {
+	if err := CheckPosterizeLevels(levels); err != nil {
+		return nil, err
+	}
+
+	return ApplyPalette(ctx, img, PosterizePalette(levels), opts, posterizeDitherer(ditherer, levels))
+}
+
+// posterizeDitherer returns the PosterizeDitherer counterpart of an ordered ditherer or of a NoDitherer,
+// or the ditherer itself.
+func posterizeDitherer(d Ditherer, levels int) Ditherer {
+	switch d := d.(type) {
+	case BayerDitherer:
+		matrix, err := BayerMatrix(d.MatSize)
+		if err != nil {
+			return d
+		}
+		return PosterizeDitherer{Levels: levels, Thresholds: matrix.Coefficient, Threads: d.Threads, Strength: d.Strength, Progress: d.Progress}
+	case OrderedDitherer:
+		thresholds := d.Noise
+		if thresholds == nil {
+			thresholds = d.Matrix.Coefficient
+		}
+		return PosterizeDitherer{Levels: levels, Thresholds: thresholds, Threads: d.Threads, Strength: d.Strength, Progress: d.Progress}
+	case NoDitherer:
+		return PosterizeDitherer{Levels: levels, Threads: d.Threads, Progress: d.Progress}
+	}
+
+	return d
+}
+
+// PosterizeDitherer maps the pixels to a PosterizePalette grid by rounding each channel to its nearest level,
+// after adding the offset of an ordered dithering, up to a level step.
+type PosterizeDitherer struct {
+	// Levels is the number of levels per channel of the grid.
+	Levels int
+	// Thresholds gives the dithering offsets; nil means no dithering.
+	Thresholds ThresholdFunc
+	// Threads is the maximum number of goroutines working on an image; 0 means GOMAXPROCS.
+	Threads int
+	// Strength scales the offsets of each channel; nil means FullDitherStrength.
+	Strength *DitherStrength
+	// Progress is notified of the rows processed, if not nil.
+	Progress ProgressFunc
+}
+
+// Dither implements the Ditherer interface.
+func (d PosterizeDitherer) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	out, _ := d.DitherContext(context.Background(), img, palette)
+	return out
+}
+
+// DitherContext implements the ContextDitherer interface.
+// The palette must be the PosterizePalette of d.Levels.
+func (d PosterizeDitherer) DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	if len(palette) != d.Levels*d.Levels*d.Levels {
+		return nil, fmt.Errorf("the palette is not a grid of %d levels per channel", d.Levels)
+	}
+
+	out := image.NewPaletted(img.Bounds(), ColorPalette(palette))
+	strength := d.Strength.orFull()
+	rows := NewRowProgress(d.Progress, "dither", img.Bounds().Dy())
+	steps := float64(d.Levels - 1)
+
+	err := ParallelRowsContext(ctx, img.Bounds(), d.Threads, func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+				c := PixelColor(img, x, y)
+				coef := 0.
+				if d.Thresholds != nil {
+					coef = d.Thresholds(x, y)
+				}
+
+				i := 0
+				for k, v := range [3]uint8{c.R, c.G, c.B} {
+					level := math.Round(float64(v)/255.*steps + coef*strength[k])
+					i = i*d.Levels + int(ClampF64(level, 0., steps))
+				}
+				out.SetColorIndex(x, y, uint8(i))
+			}
+		}
+		rows.Done(maxY - minY)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,157 @@
+package quantize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//
+// 			Pre-filters.
+//
+
+// A slight sharpening before the quantization keeps the fine details, which the palette and the dithering
+// tend to wash out, while a slight blur removes the noise of photos and scans, which wastes palette slots
+// and makes the dithering grainy. The filters work on the premultiplied sRGB channels.
+
+// Pre-filter names, see ParsePrefilters.
+const (
+	// PrefilterUnsharp sharpens an image by unsharp masking, its value being the amount (see UnsharpMask).
+	PrefilterUnsharp = "unsharp"
+	// PrefilterGaussian blurs an image, its value being the standard deviation of the Gaussian, in pixels (see GaussianBlur).
+	PrefilterGaussian = "gaussian"
+)
+
+// UnsharpSigma is the standard deviation, in pixels, of the blur subtracted by the unsharp pre-filter.
+const UnsharpSigma = 1.
+
+// maxPrefilterValue bounds the values of the pre-filters.
+const maxPrefilterValue = 20.
+
+// Prefilter is a filter applied to the images before they are quantized.
+type Prefilter struct {
+	Name  string
+	Value float64
+}
+
+// String returns the pre-filter as ParsePrefilters reads it.
+func (f Prefilter) String() string {
+	return f.Name + "=" + strconv.FormatFloat(f.Value, 'g', -1, 64)
+}
+
+// ParsePrefilters parses comma-separated pre-filters given as "name=value", e.g. "gaussian=1.2,unsharp=0.5",
+// applied in this order. An empty string gives no pre-filter.
+func ParsePrefilters(s string) ([]Prefilter, error) {
+	var filters []Prefilter
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(field, "=")
+		if name != PrefilterUnsharp && name != PrefilterGaussian {
+			return nil, fmt.Errorf("unknown pre-filter %q (available: [%s %s])", name, PrefilterGaussian, PrefilterUnsharp)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v <= 0 || v > maxPrefilterValue {
+			return nil, fmt.Errorf("invalid pre-filter %q (a value in (0, %g] expected after %q)", field, maxPrefilterValue, name+"=")
+		}
+		filters = append(filters, Prefilter{Name: name, Value: v})
+	}
+
+	return filters, nil
+}
+
+// ApplyPrefilters applies pre-filters to an image, in order, with up to <threads> goroutines (0 means GOMAXPROCS).
+// The image is returned as is without pre-filters.
+func ApplyPrefilters(img image.Image, filters []Prefilter, threads int) image.Image {
+	for _, f := range filters {
+		switch f.Name {
+		case PrefilterUnsharp:
+			img = UnsharpMask(img, f.Value, UnsharpSigma, threads)
+		case PrefilterGaussian:
+			img = GaussianBlur(img, f.Value, threads)
+		}
+	}
+
+	return img
+}
+
+// gaussianKernel returns the normalized weights of a Gaussian of standard deviation <sigma>,
+// from -radius to +radius, the radius being 3 sigma rounded up.
+func gaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	return kernel
+}
+
+// GaussianBlur blurs an image with a Gaussian of standard deviation <sigma>, in pixels, with up to <threads> goroutines.
+// The Gaussian is applied horizontally then vertically; the pixels beyond the borders repeat the border pixels.
+func GaussianBlur(img image.Image, sigma float64, threads int) *image.RGBA {
+	kernel := gaussianKernel(sigma)
+	radius := len(kernel) / 2
+	b := img.Bounds()
+
+	convolve := func(src image.Image, dx, dy int) *image.RGBA {
+		out := image.NewRGBA(b)
+		ParallelRows(b, threads, func(minY, maxY int) {
+			for y := minY; y < maxY; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					var sum [4]float64
+					for i, w := range kernel {
+						sx := ClampBelowInt(ClampAboveInt(x+(i-radius)*dx, b.Max.X-1), b.Min.X)
+						sy := ClampBelowInt(ClampAboveInt(y+(i-radius)*dy, b.Max.Y-1), b.Min.Y)
+						c := PixelColor(src, sx, sy)
+						sum[0] += w * float64(c.R)
+						sum[1] += w * float64(c.G)
+						sum[2] += w * float64(c.B)
+						sum[3] += w * float64(c.A)
+					}
+					a := math.Round(ClampF64(sum[3], 0., 255.))
+					out.SetRGBA(x, y, color.RGBA{
+						uint8(math.Round(ClampF64(sum[0], 0., a))),
+						uint8(math.Round(ClampF64(sum[1], 0., a))),
+						uint8(math.Round(ClampF64(sum[2], 0., a))),
+						uint8(a),
+					})
+				}
+			}
+		})
+		return out
+	}
+
+	return convolve(convolve(img, 1, 0), 0, 1)
+}
+
+// UnsharpMask sharpens an image by adding <amount> times its difference with its Gaussian blur of standard deviation
+// <sigma> (see GaussianBlur), with up to <threads> goroutines. The alpha channel is kept.
+func UnsharpMask(img image.Image, amount, sigma float64, threads int) *image.RGBA {
+	blurred := GaussianBlur(img, sigma, threads)
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	ParallelRows(b, threads, func(minY, maxY int) {
+		sharpen := func(c, blur, a uint8) uint8 {
+			return uint8(math.Round(ClampF64(float64(c)+amount*(float64(c)-float64(blur)), 0., float64(a))))
+		}
+		for y := minY; y < maxY; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c, blur := PixelColor(img, x, y), blurred.RGBAAt(x, y)
+				out.SetRGBA(x, y, color.RGBA{sharpen(c.R, blur.R, c.A), sharpen(c.G, blur.G, c.A), sharpen(c.B, blur.B, c.A), c.A})
+			}
+		}
+	})
+
+	return out
+}
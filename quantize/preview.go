@@ -0,0 +1,104 @@
+package quantize
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+//
+// 			Terminal preview.
+//
+
+// DefaultPreviewWidth is the default width, in characters, of the terminal previews.
+const DefaultPreviewWidth = 80
+
+// TrueColorTerminal reports whether the terminal announces 24-bit color support in the COLORTERM environment variable.
+func TrueColorTerminal() bool {
+	colorTerm := os.Getenv("COLORTERM")
+	return colorTerm == "truecolor" || colorTerm == "24bit"
+}
+
+// WriteTerminalPreview draws a downscaled version of an image on a terminal with ANSI escape codes.
+// Each character is an upper half block showing two pixels: the top one as its foreground color
+// and the bottom one as its background color.
+// The image is scaled down to <width> characters (it is never scaled up); each character cell averages the pixels it covers.
+// The colors are written as 24-bit colors if <trueColor> is set, otherwise as the nearest colors of the 256-color palette.
+func WriteTerminalPreview(w io.Writer, img image.Image, width int, trueColor bool) error {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+	width = ClampBelowInt(ClampAboveInt(width, bounds.Dx()), 1)
+	height := ClampBelowInt(bounds.Dy()*width/bounds.Dx(), 1)
+
+	// cell returns the mean color of the pixels of the image covered by a pixel of the preview.
+	cell := func(x, y int) color.RGBA {
+		x0, x1 := bounds.Min.X+x*bounds.Dx()/width, bounds.Min.X+(x+1)*bounds.Dx()/width
+		y0, y1 := bounds.Min.Y+y*bounds.Dy()/height, bounds.Min.Y+(y+1)*bounds.Dy()/height
+		var r, g, b, n int
+		for py := y0; py < ClampBelowInt(y1, y0+1); py++ {
+			for px := x0; px < ClampBelowInt(x1, x0+1); px++ {
+				c := PixelColor(img, px, py)
+				r, g, b, n = r+int(c.R), g+int(c.G), b+int(c.B), n+1
+			}
+		}
+		return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255}
+	}
+
+	// The foreground (38) and background (48) colors of the characters.
+	sgr := func(layer int, c color.RGBA) string {
+		if trueColor {
+			return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", layer, c.R, c.G, c.B)
+		}
+		return fmt.Sprintf("\x1b[%d;5;%dm", layer, ANSI256Index(c))
+	}
+
+	bw := bufio.NewWriter(w)
+	for y := 0; y < height; y += 2 {
+		for x := 0; x < width; x++ {
+			bw.WriteString(sgr(38, cell(x, y)))
+			if y+1 < height {
+				bw.WriteString(sgr(48, cell(x, y+1)))
+			} else {
+				bw.WriteString("\x1b[49m")
+			}
+			bw.WriteString("▀")
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+
+	return bw.Flush()
+}
+
+// ansiCubeLevels are the levels of the channels of the 6x6x6 color cube of the 256-color palette.
+var ansiCubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// ANSI256Index returns the index of the color of the 256-color terminal palette nearest to an opaque color.
+// Only the color cube (16-231) and the gray ramp (232-255) are considered, as the first 16 colors depend on the terminal.
+func ANSI256Index(c color.RGBA) int {
+	// level returns the index of the cube level nearest to a channel value.
+	level := func(v uint8) int {
+		best := 0
+		for i, l := range ansiCubeLevels {
+			if d, bestD := int(v)-l, int(v)-ansiCubeLevels[best]; d*d < bestD*bestD {
+				best = i
+			}
+		}
+		return best
+	}
+	r, g, b := level(c.R), level(c.G), level(c.B)
+	cube := color.RGBA{uint8(ansiCubeLevels[r]), uint8(ansiCubeLevels[g]), uint8(ansiCubeLevels[b]), 255}
+
+	// The gray ramp goes from 8 to 238 by steps of 10.
+	gray := ClampBelowInt(ClampAboveInt((int(c.R)+int(c.G)+int(c.B))/3-3, 230)/10, 0)
+	grayLevel := uint8(8 + 10*gray)
+
+	if ColorDistance(c, color.RGBA{grayLevel, grayLevel, grayLevel, 255}) < ColorDistance(c, cube) {
+		return 232 + gray
+	}
+	return 16 + 36*r + 6*g + b
+}
@@ -0,0 +1,164 @@
+package quantize
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+)
+
+//
+// 			Reusable processor.
+//
+
+// Processor generates palettes and maps images to them with fixed options.
+// Callers processing many images with one palette, e.g. the frames of a video, generate it once with Palette
+// and map each image with Apply. The palette indices are kept across the calls (see PaletteIndexCache).
+// A Processor can be used by several goroutines.
+type Processor struct {
+	settings Settings
+	ditherer Ditherer
+	// mu guards target, the palette of the last paletted image of ToPaletted, which the next ones usually share.
+	mu     sync.Mutex
+	target *palettedTarget
+}
+
+// palettedTarget is the palette of a paletted image, split into the colors the pixels are mapped to, and the index
+// in the palette of each of them and of the transparent pixels (-1 if there is no fully transparent color).
+// It is read-only once built, so that the goroutines mapping images to the same palette share it.
+type palettedTarget struct {
+	palette     color.Palette
+	colors      []color.RGBA
+	indices     [MaxPaletteSize]uint8
+	transparent int
+}
+
+// newPalettedTarget splits the palette of a paletted image (see palettedTarget).
+func newPalettedTarget(palette color.Palette) (*palettedTarget, error) {
+	t := &palettedTarget{palette: append(color.Palette(nil), palette...), transparent: -1}
+	for i, c := range PaletteColors(palette) {
+		if c.A == 0 {
+			if t.transparent < 0 {
+				t.transparent = i
+			}
+			continue
+		}
+		t.indices[len(t.colors)] = uint8(i)
+		t.colors = append(t.colors, c)
+	}
+	if len(t.colors) == 0 {
+		return nil, fmt.Errorf("the palette of the paletted image has no visible color")
+	}
+
+	return t, nil
+}
+
+// paletted returns the split palette of a paletted image, from the last one if the palette is the same.
+func (p *Processor) paletted(palette color.Palette) (*palettedTarget, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t := p.target; t != nil && len(t.palette) == len(palette) {
+		same := true
+		for i := range palette {
+			same = same && t.palette[i] == palette[i]
+		}
+		if same {
+			return t, nil
+		}
+	}
+
+	t, err := newPalettedTarget(palette)
+	if err == nil {
+		p.target = t
+	}
+	return t, err
+}
+
+// NewProcessor creates a processor with options applied over DefaultOptions (see Image).
+func NewProcessor(opts ...Option) (*Processor, error) {
+	settings, err := NewSettings(opts...)
+	if err != nil {
+		return nil, err
+	}
+	settings.Dither.Indexes = NewPaletteIndexCache()
+	ditherer, err := settings.newDitherer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Processor{settings: settings, ditherer: ditherer}, nil
+}
+
+// Palette generates one palette from the pixels of one or several images (see PaletteFromImages).
+// It has at most the palette size of the options, minus one if an image has transparent pixels.
+func (p *Processor) Palette(imgs ...image.Image) []color.RGBA {
+	return PaletteFromImages(imgs, p.settings.PaletteMaxSize, p.settings.Palette)
+}
+
+// Apply maps and dithers an image to a palette, e.g. one returned by Palette (see ApplyPalette).
+func (p *Processor) Apply(img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	return p.ApplyContext(context.Background(), img, palette)
+}
+
+// ApplyContext is Apply, but it can be canceled through <ctx>.
+func (p *Processor) ApplyContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	return ApplyPalette(ctx, img, palette, p.settings.Palette, p.ditherer)
+}
+
+// ToPaletted maps and dithers an image to the palette of a paletted image of the same bounds, e.g. the frame buffer
+// of a GIF encoder or of a game engine, and writes the palette indices into its pixels instead of allocating an image.
+// The fully transparent colors of the palette are kept for the transparent pixels: the first one gets them,
+// and the other pixels are mapped to the other colors. Without such a color, the transparent pixels are mapped
+// as opaque ones. The ordered ditherings, the Floyd-Steinberg dithering and the mapping without dithering write
+// into the image directly (see IntoDitherer); the others go through an image of their own. Mapping many images
+// to the same palette this way allocates almost nothing: the palette index, the split palette and the row buffers
+// are kept across the calls.
+func (p *Processor) ToPaletted(dst *image.Paletted, src image.Image) error {
+	return p.ToPalettedContext(context.Background(), dst, src)
+}
+
+// ToPalettedContext is ToPaletted, but it can be canceled through <ctx>.
+func (p *Processor) ToPalettedContext(ctx context.Context, dst *image.Paletted, src image.Image) error {
+	if len(dst.Palette) == 0 || len(dst.Palette) > MaxPaletteSize {
+		return fmt.Errorf("invalid palette size %d of the paletted image (1 to %d)", len(dst.Palette), MaxPaletteSize)
+	}
+	t, err := p.paletted(dst.Palette)
+	if err != nil {
+		return err
+	}
+
+	img := src
+	if !p.settings.Palette.Alpha4D {
+		img = opaqueImage{src}
+	}
+	if p.settings.Palette.Grayscale {
+		img = grayImage{img, p.settings.Palette.Linear}
+	}
+	if err := DitherInto(ctx, p.ditherer, dst, img, t.colors); err != nil {
+		return err
+	}
+	if t.transparent < 0 && len(t.colors) == len(dst.Palette) {
+		return nil
+	}
+
+	// The indices in t.colors are turned into indices in dst.Palette.
+	b, alphaThreshold := dst.Rect, p.settings.Palette.AlphaThreshold
+	return ParallelRowsContext(ctx, b, p.settings.Dither.Threads, func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			row := dst.Pix[dst.PixOffset(b.Min.X, y):dst.PixOffset(b.Max.X, y)]
+			for i := range row {
+				if t.transparent >= 0 && IsTransparent(PixelColor(src, b.Min.X+i, y), alphaThreshold) {
+					row[i] = uint8(t.transparent)
+				} else {
+					row[i] = t.indices[row[i]]
+				}
+			}
+		}
+	})
+}
+
+// Quantize generates the palette of an image and maps the image to it, as Image does.
+func (p *Processor) Quantize(ctx context.Context, img image.Image) (*image.Paletted, error) {
+	return QuantizeImageContext(ctx, img, p.settings.PaletteMaxSize, p.settings.Palette, p.ditherer)
+}
@@ -0,0 +1,200 @@
+package quantize
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// 			Progress reporting.
+//
+
+// ProgressFunc is notified of the advancement of a processing.
+// <phase> names the current step ("palette", "dither"...) and <fraction> is the part of it
+// which is done, from 0 when it starts to 1 when it ends.
+// It may be called from several goroutines, but never concurrently.
+type ProgressFunc func(phase string, fraction float64)
+
+// RowProgress reports the progress of a phase processing the rows of an image,
+// possibly in parallel (see ParallelRows). It reports at most once per percent.
+// A nil ProgressFunc makes it do nothing, as does a nil RowProgress.
+type RowProgress struct {
+	progress ProgressFunc
+	phase    string
+	total    int
+
+	mu      sync.Mutex
+	done    int
+	percent int
+}
+
+// NewRowProgress starts reporting the progress of a phase processing <rows> rows.
+// Without <progress>, it returns nil, so that the processings of many small images allocate nothing.
+func NewRowProgress(progress ProgressFunc, phase string, rows int) *RowProgress {
+	if progress == nil {
+		return nil
+	}
+	progress(phase, 0)
+
+	return &RowProgress{progress: progress, phase: phase, total: rows}
+}
+
+// Done records that <rows> more rows are processed.
+func (p *RowProgress) Done(rows int) {
+	if p == nil || p.progress == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done += rows
+	if p.total <= 0 || p.done >= p.total {
+		if p.percent < 100 {
+			p.percent = 100
+			p.progress(p.phase, 1)
+		}
+		return
+	}
+
+	if percent := p.done * 100 / p.total; percent > p.percent {
+		p.percent = percent
+		p.progress(p.phase, float64(p.done)/float64(p.total))
+	}
+}
+
+// IsTerminal reports whether a file is a terminal (a character device).
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ProgressBar returns a ProgressFunc drawing a live progress bar of each phase on <w>,
+// usually a terminal.
+func ProgressBar(w io.Writer) ProgressFunc {
+	const width = 40
+
+	return func(phase string, fraction float64) {
+		filled := int(ClampF64(fraction, 0., 1.) * width)
+		fmt.Fprintf(w, "\r%-8s [%s%s] %3d%%", phase, strings.Repeat("#", filled), strings.Repeat(" ", width-filled), int(fraction*100))
+		if fraction >= 1 {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// VerboseProgress returns a ProgressFunc writing the duration of each phase on <w> when it ends.
+// The reports are then forwarded to <next>, if not nil.
+func VerboseProgress(w io.Writer, next ProgressFunc) ProgressFunc {
+	var start time.Time
+
+	return func(phase string, fraction float64) {
+		if next != nil {
+			next(phase, fraction)
+		}
+
+		if fraction <= 0 {
+			start = time.Now()
+		} else if fraction >= 1 {
+			fmt.Fprintf(w, "%s: done in %v\n", phase, time.Since(start).Round(time.Millisecond))
+		}
+	}
+}
+
+// PhaseTimes records the total duration of each phase of one or several processings.
+type PhaseTimes struct {
+	mu        sync.Mutex
+	starts    map[string]time.Time
+	durations map[string]time.Duration
+}
+
+// NewPhaseTimes creates an empty record of phase durations.
+func NewPhaseTimes() *PhaseTimes {
+	return &PhaseTimes{starts: map[string]time.Time{}, durations: map[string]time.Duration{}}
+}
+
+// Progress returns a ProgressFunc recording the duration of each phase, which adds up when a phase is repeated,
+// e.g. for the frames of an animation. The reports are then forwarded to <next>, if not nil.
+func (t *PhaseTimes) Progress(next ProgressFunc) ProgressFunc {
+	return func(phase string, fraction float64) {
+		if next != nil {
+			next(phase, fraction)
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if fraction <= 0 {
+			t.starts[phase] = time.Now()
+		} else if start, ok := t.starts[phase]; ok && fraction >= 1 {
+			t.durations[phase] += time.Since(start)
+			delete(t.starts, phase)
+		}
+	}
+}
+
+// Durations returns the total duration of each phase which ended.
+func (t *PhaseTimes) Durations() map[string]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	durations := make(map[string]time.Duration, len(t.durations))
+	for phase, d := range t.durations {
+		durations[phase] = d
+	}
+
+	return durations
+}
+
+// Add adds a duration to a phase, e.g. for a phase which reports no progress, like the decoding of an image.
+// A nil record does nothing.
+func (t *PhaseTimes) Add(phase string, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.durations[phase] += d
+}
+
+// TimingPhases holds the main phases of the processing of an image, in their order.
+var TimingPhases = []string{"decode", "histogram", "palette", "dither", "encode"}
+
+// WriteTimings writes on one line the duration of each phase of the processing of an image named <name>,
+// in the order of TimingPhases then alphabetically, followed by the total duration of the processing.
+func WriteTimings(w io.Writer, name string, t *PhaseTimes, total time.Duration) error {
+	durations := t.Durations()
+	var phases []string
+	for phase := range durations {
+		phases = append(phases, phase)
+	}
+	rank := func(phase string) int {
+		for i, p := range TimingPhases {
+			if p == phase {
+				return i
+			}
+		}
+		return len(TimingPhases)
+	}
+	sort.Slice(phases, func(i, j int) bool {
+		if ri, rj := rank(phases[i]), rank(phases[j]); ri != rj {
+			return ri < rj
+		}
+		return phases[i] < phases[j]
+	})
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s:", name)
+	for _, phase := range phases {
+		fmt.Fprintf(&line, " %s %v,", phase, durations[phase].Round(time.Microsecond))
+	}
+	fmt.Fprintf(&line, " total %v\n", total.Round(time.Microsecond))
+
+	_, err := io.WriteString(w, line.String())
+	return err
+}
@@ -0,0 +1,284 @@
+package quantize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+)
+
+//
+// 			Quality measures.
+//
+
+// QualityMetrics measures how close a quantized image is to its source image.
+type QualityMetrics struct {
+	// MSE is the mean squared error of the RGB channels, in [0, 255²].
+	MSE float64 `json:"mse"`
+	// PSNR is the peak signal-to-noise ratio, in dB; it is +Inf for identical images.
+	PSNR float64 `json:"psnr"`
+	// MeanDeltaE is the mean CIE 1976 color difference of the pixels.
+	MeanDeltaE float64 `json:"mean_delta_e"`
+	// P95DeltaE is the 95th percentile of the color differences of the pixels.
+	P95DeltaE float64 `json:"p95_delta_e"`
+	// SSIM is the mean structural similarity of the lumas, computed on 8x8 windows, in [-1, 1].
+	SSIM float64 `json:"ssim"`
+}
+
+// CompareImages measures the quality of a quantized image with respect to its source image.
+// Both images must have the same size; their pixels are compared premultiplied by their alpha.
+func CompareImages(orig, quantized image.Image) (QualityMetrics, error) {
+	ob, qb := orig.Bounds(), quantized.Bounds()
+	if ob.Empty() {
+		return QualityMetrics{}, fmt.Errorf("the images are empty")
+	}
+	if ob.Dx() != qb.Dx() || ob.Dy() != qb.Dy() {
+		return QualityMetrics{}, fmt.Errorf("the images have different sizes (%dx%d and %dx%d)", ob.Dx(), ob.Dy(), qb.Dx(), qb.Dy())
+	}
+
+	w, h := ob.Dx(), ob.Dy()
+	lumas := [2][]float64{make([]float64, w*h), make([]float64, w*h)}
+	metric := DE76Metric{}
+	deltaEs := make([]float64, 0, w*h)
+	squares, deltaE := 0., 0.
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := PixelColor(orig, ob.Min.X+x, ob.Min.Y+y)
+			q := PixelColor(quantized, qb.Min.X+x, qb.Min.Y+y)
+
+			for _, d := range [3]float64{float64(c.R) - float64(q.R), float64(c.G) - float64(q.G), float64(c.B) - float64(q.B)} {
+				squares += d * d
+			}
+			de := metric.Distance(metric.Point(c), metric.Point(q))
+			deltaE += de
+			deltaEs = append(deltaEs, de)
+			lumas[0][y*w+x] = luma(float64(c.R), float64(c.G), float64(c.B))
+			lumas[1][y*w+x] = luma(float64(q.R), float64(q.G), float64(q.B))
+		}
+	}
+
+	n := float64(w * h)
+	sort.Float64s(deltaEs)
+	m := QualityMetrics{
+		MSE:        squares / (3 * n),
+		MeanDeltaE: deltaE / n,
+		P95DeltaE:  deltaEs[int(0.95*float64(len(deltaEs)-1))],
+		SSIM:       ssim(lumas[0], lumas[1], w, h),
+	}
+	m.PSNR = 10 * math.Log10(255*255/m.MSE)
+
+	return m, nil
+}
+
+// ssim computes the mean structural similarity of two planes of a given size, on 8x8 windows moved by 4 pixels.
+// See https://en.wikipedia.org/wiki/Structural_similarity
+func ssim(a, b []float64, w, h int) float64 {
+	const (
+		window = 8
+		step   = 4
+		c1     = (0.01 * 255) * (0.01 * 255)
+		c2     = (0.03 * 255) * (0.03 * 255)
+	)
+
+	// Images smaller than a window are a single window.
+	ww, wh := ClampAboveInt(window, w), ClampAboveInt(window, h)
+
+	total, count := 0., 0
+	for y0 := 0; y0+wh <= h; y0 += step {
+		for x0 := 0; x0+ww <= w; x0 += step {
+			var sa, sb, saa, sbb, sab float64
+			for y := y0; y < y0+wh; y++ {
+				for x := x0; x < x0+ww; x++ {
+					va, vb := a[y*w+x], b[y*w+x]
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+
+			k := float64(ww * wh)
+			ma, mb := sa/k, sb/k
+			va, vb := saa/k-ma*ma, sbb/k-mb*mb
+			cov := sab/k - ma*mb
+			total += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			count++
+		}
+	}
+	if count == 0 {
+		return 1
+	}
+
+	return total / float64(count)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+// JSON has no infinity: identical images get a null PSNR.
+func (m QualityMetrics) MarshalJSON() ([]byte, error) {
+	type metrics QualityMetrics
+	v := struct {
+		metrics
+		PSNR *float64 `json:"psnr"`
+	}{metrics: metrics(m)}
+	if !math.IsInf(m.PSNR, 0) {
+		v.PSNR = &m.PSNR
+	}
+
+	return json.Marshal(v)
+}
+
+// String formats the metrics for humans.
+func (m QualityMetrics) String() string {
+	return fmt.Sprintf("PSNR %.2f dB, MSE %.2f, mean ΔE %.2f, SSIM %.4f", m.PSNR, m.MSE, m.MeanDeltaE, m.SSIM)
+}
+
+// WriteQualityReport writes the metrics of an image to <w>, as text or as a JSON object (on one line).
+func WriteQualityReport(w io.Writer, name string, m QualityMetrics, asJSON bool) error {
+	if !asJSON {
+		_, err := fmt.Fprintf(w, "%s: %v\n", name, m)
+		return err
+	}
+
+	// JSON has no infinity: identical images get a null PSNR.
+	report := struct {
+		File string   `json:"file"`
+		MSE  float64  `json:"mse"`
+		PSNR *float64 `json:"psnr"`
+		DE   float64  `json:"mean_delta_e"`
+		P95  float64  `json:"p95_delta_e"`
+		SSIM float64  `json:"ssim"`
+	}{File: name, MSE: m.MSE, DE: m.MeanDeltaE, P95: m.P95DeltaE, SSIM: m.SSIM}
+	if !math.IsInf(m.PSNR, 0) {
+		report.PSNR = &m.PSNR
+	}
+
+	return json.NewEncoder(w).Encode(report)
+}
+
+//
+// 			Comparison images.
+//
+
+// HeatmapMaxDeltaE is the ΔE drawn in white in the error heatmaps; larger differences are clamped to it.
+const HeatmapMaxDeltaE = 20.
+
+// ComparisonImage returns an image showing side by side a source image, its quantized version and,
+// if <heatmap> is set, a heatmap of their differences (see ErrorHeatmap).
+// Both images must have the same size.
+func ComparisonImage(orig, quantized image.Image, heatmap bool) (*image.RGBA, error) {
+	ob, qb := orig.Bounds(), quantized.Bounds()
+	if ob.Dx() != qb.Dx() || ob.Dy() != qb.Dy() {
+		return nil, fmt.Errorf("the images have different sizes (%dx%d and %dx%d)", ob.Dx(), ob.Dy(), qb.Dx(), qb.Dy())
+	}
+
+	panels := []image.Image{orig, quantized}
+	if heatmap {
+		panels = append(panels, ErrorHeatmap(orig, quantized))
+	}
+
+	w, h := ob.Dx(), ob.Dy()
+	out := image.NewRGBA(image.Rect(0, 0, w*len(panels), h))
+	for i, panel := range panels {
+		draw.Draw(out, image.Rect(i*w, 0, (i+1)*w, h), panel, panel.Bounds().Min, draw.Src)
+	}
+
+	return out, nil
+}
+
+// ErrorHeatmap returns an image whose pixels show the CIE 1976 ΔE between the pixels of two images of the same size,
+// going from black (no difference) to red, yellow and white (HeatmapMaxDeltaE or more).
+func ErrorHeatmap(orig, quantized image.Image) *image.RGBA {
+	ob, qb := orig.Bounds(), quantized.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, ob.Dx(), ob.Dy()))
+	metric := DE76Metric{}
+	for y := 0; y < ob.Dy(); y++ {
+		for x := 0; x < ob.Dx(); x++ {
+			c := PixelColor(orig, ob.Min.X+x, ob.Min.Y+y)
+			q := PixelColor(quantized, qb.Min.X+x, qb.Min.Y+y)
+			de := metric.Distance(metric.Point(c), metric.Point(q))
+			out.SetRGBA(x, y, heatColor(de/HeatmapMaxDeltaE))
+		}
+	}
+
+	return out
+}
+
+// heatColor returns the color of a value in [0, 1] of a heatmap: black, red, yellow then white.
+func heatColor(t float64) color.RGBA {
+	t = 3 * ClampF64(t, 0., 1.)
+	channel := func(s float64) uint8 { return uint8(ClampF64(255*s, 0., 255.)) }
+
+	return color.RGBA{channel(t), channel(t - 1), channel(t - 2), 255}
+}
+
+//
+// 			Quality targets.
+//
+
+// ΔE statistics of a quality target, see QuantizeToTarget.
+const (
+	// TargetMean bounds the mean ΔE of the pixels.
+	TargetMean = "mean"
+	// TargetP95 bounds the 95th percentile ΔE of the pixels, so that few pixels are far from their color.
+	TargetP95 = "p95"
+)
+
+// QuantizeToTarget quantizes and dithers an image with the smallest palette, of at most <paletteMaxSize> colors,
+// whose ΔE statistic <stat> (TargetMean or TargetP95) compared to the image stays under <maxDeltaE>.
+// The sizes are searched by bisection, which assumes that larger palettes give smaller errors.
+// The largest palette is used if no size reaches the target.
+// <try>, if not nil, is called with each palette size tried and its quality.
+func QuantizeToTarget(ctx context.Context, img image.Image, paletteMaxSize int, paletteOpts PaletteOptions, ditherer Ditherer,
+	maxDeltaE float64, stat string, try func(size int, m QualityMetrics)) (*image.Paletted, error) {
+	if stat != TargetMean && stat != TargetP95 {
+		return nil, fmt.Errorf("unknown quality target %q (available: [%s %s])", stat, TargetMean, TargetP95)
+	}
+
+	// quantize returns the image quantized with a palette size and whether it reaches the target.
+	quantize := func(size int) (*image.Paletted, bool, error) {
+		out, err := QuantizeImageContext(ctx, img, size, paletteOpts, ditherer)
+		if err != nil {
+			return nil, false, err
+		}
+		m, err := CompareImages(img, out)
+		if err != nil {
+			return nil, false, err
+		}
+		if try != nil {
+			try(size, m)
+		}
+
+		deltaE := m.MeanDeltaE
+		if stat == TargetP95 {
+			deltaE = m.P95DeltaE
+		}
+		return out, deltaE <= maxDeltaE, nil
+	}
+
+	best, ok, err := quantize(paletteMaxSize)
+	if err != nil || !ok {
+		return best, err
+	}
+
+	low, high := 1, paletteMaxSize-1
+	for low <= high {
+		size := (low + high) / 2
+		out, ok, err := quantize(size)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			best, high = out, size-1
+		} else {
+			low = size + 1
+		}
+	}
+
+	return best, nil
+}
@@ -0,0 +1,383 @@
+package quantize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+)
+
+//
+// 			Palette generation algorithms.
+//
+
+// Quantizer is implemented by every palette generation algorithm.
+// Palette returns at most <size> colors representing <pixels>, which is not empty.
+// The pixels may be reordered. The options which do not concern the algorithm are ignored.
+// Palette may be called concurrently, on different pixels, in batch mode.
+type Quantizer interface {
+	Palette(pixels []color.RGBA, size int, opts PaletteOptions) []color.RGBA
+}
+
+// HistogramQuantizer is implemented by the algorithms which can generate a palette from the weighted colors
+// of a histogram (see Histogram), which is much faster than from all the pixels of a large image.
+// PaletteFromHistogram returns at most <size> colors representing <colors>, which is not empty.
+type HistogramQuantizer interface {
+	Quantizer
+	PaletteFromHistogram(colors []WeightedColor, size int, opts PaletteOptions) []color.RGBA
+}
+
+// QuantizerFactory creates a palette generation algorithm. The options of a palette generation are given
+// to each Palette call, so the factory has none, unlike a DithererFactory.
+type QuantizerFactory func() Quantizer
+
+// quantizers holds the registered palette generation algorithms, indexed by name.
+var quantizers = map[string]QuantizerFactory{}
+
+func init() {
+	RegisterQuantizer("mediancut", func() Quantizer { return MedianCutQuantizer{} })
+	RegisterQuantizer("popularity", func() Quantizer { return PopularityQuantizer{} })
+	RegisterQuantizer("kmedoids", func() Quantizer { return KMedoidsQuantizer{} })
+	RegisterQuantizer("pca", func() Quantizer { return PCAQuantizer{} })
+}
+
+// RegisterQuantizer makes a palette generation algorithm available under a given name.
+// Registering a name twice replaces the previous algorithm.
+func RegisterQuantizer(name string, factory QuantizerFactory) {
+	quantizers[name] = factory
+}
+
+// NewQuantizer creates the palette generation algorithm registered under <name>.
+func NewQuantizer(name string) (Quantizer, error) {
+	factory, ok := quantizers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown palette algorithm %q (available: %v)", name, QuantizerNames())
+	}
+
+	return factory(), nil
+}
+
+// QuantizerNames returns the names of all the registered palette generation algorithms, sorted alphabetically.
+func QuantizerNames() []string {
+	var names []string
+	for name := range quantizers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+//
+// 			Median cut.
+//
+
+// MedianCutQuantizer sorts the pixels by their red channel and splits them into buckets
+// of the same size. Each palette color is the mean color of a bucket.
+// If opts.Metric is a HueMetric, the pixels are sorted by hue instead, and averaged in its color space;
+// if it is another SpaceMetric, they are sorted by their first coordinate, e.g. the OKLab lightness.
+// The algorithm is described here: https://en.wikipedia.org/wiki/Median_cut
+type MedianCutQuantizer struct{}
+
+// Palette implements the Quantizer interface.
+func (MedianCutQuantizer) Palette(pixels []color.RGBA, size int, opts PaletteOptions) []color.RGBA {
+	if m, ok := opts.Metric.(SpaceMetric); ok {
+		return medianCutSpace(pixels, size, m)
+	}
+
+	// Sort the pixels according to the red color channel.
+	SortByRed(pixels)
+
+	// If the image is very very small, its number of pixels may be less than the
+	// input parameter size. In this case we must adjust the palette size.
+	estimatedPaletteSize := ClampAboveInt(size, len(pixels))
+
+	// Determine the palette colors. Each color is defined as the mean value of the pixels colors in a bucket.
+	// A bucket is a range of pixels. All buckets have the same size except for the last one which has, most of the time,
+	// a smaller size.
+	bucketSize := len(pixels) / estimatedPaletteSize
+	mean := MeanColorOfRange
+	if opts.Linear {
+		mean = MeanColorOfRangeLinear
+	}
+	var palette []color.RGBA
+	for i := 0; i < estimatedPaletteSize; i++ {
+		// Compute the mean color of bucket #i.
+		begin := i * bucketSize
+		end := ClampAboveInt(begin+bucketSize, len(pixels))
+		c := mean(pixels, begin, end)
+
+		// Note here that this "append" may add a duplicated color in the palette.
+		// RefinePalette gets rid of them if requested.
+		palette = append(palette, c)
+	}
+
+	return palette
+}
+
+// medianCutSpace is the median cut of the color spaces of a SpaceMetric: the pixels are sorted by spaceKey,
+// and the mean color of each bucket is the mean of their points (whose hue, if any, is circular).
+func medianCutSpace(pixels []color.RGBA, size int, m SpaceMetric) []color.RGBA {
+	keys := make(map[color.RGBA]float64)
+	for _, c := range pixels {
+		if _, ok := keys[c]; !ok {
+			keys[c] = spaceKey(m, c)
+		}
+	}
+	sort.SliceStable(pixels, func(i, j int) bool { return keys[pixels[i]] < keys[pixels[j]] })
+
+	estimatedPaletteSize := ClampAboveInt(size, len(pixels))
+	bucketSize := len(pixels) / estimatedPaletteSize
+
+	var palette []color.RGBA
+	for i := 0; i < estimatedPaletteSize; i++ {
+		begin := i * bucketSize
+		end := ClampAboveInt(begin+bucketSize, len(pixels))
+
+		var mean ColorPoint
+		for _, c := range pixels[begin:end] {
+			p := m.Point(c)
+			for k := range mean {
+				mean[k] += p[k]
+			}
+		}
+		for k := range mean {
+			mean[k] /= float64(end - begin)
+		}
+
+		palette = append(palette, m.Color(mean))
+	}
+
+	return palette
+}
+
+// PaletteFromHistogram implements the HistogramQuantizer interface.
+// The buckets hold the same weight; a color may be split between two buckets.
+func (MedianCutQuantizer) PaletteFromHistogram(colors []WeightedColor, size int, opts PaletteOptions) []color.RGBA {
+	m, space := opts.Metric.(SpaceMetric)
+	if space {
+		keys := make([]float64, len(colors))
+		for i, wc := range colors {
+			keys[i] = spaceKey(m, wc.Color)
+		}
+		sort.Stable(byKey{colors, keys})
+	} else {
+		sort.SliceStable(colors, func(i, j int) bool { return colors[i].Color.R < colors[j].Color.R })
+	}
+
+	total := 0.
+	for _, wc := range colors {
+		total += wc.Weight
+	}
+	estimatedPaletteSize := ClampAboveInt(size, int(total))
+	bucketWeight := total / float64(estimatedPaletteSize)
+
+	var palette []color.RGBA
+	var bucket []WeightedColor
+	capacity := bucketWeight
+	for _, wc := range colors {
+		for wc.Weight > 0 {
+			// The last bucket gets what floating point errors leave.
+			last := len(palette) == estimatedPaletteSize-1
+			w := wc.Weight
+			if w > capacity && !last {
+				w = capacity
+			}
+			bucket = append(bucket, WeightedColor{wc.Color, w})
+			wc.Weight -= w
+			capacity -= w
+
+			if capacity <= 1e-9 && !last {
+				palette = append(palette, bucketMean(bucket, opts.Linear, m, space))
+				bucket, capacity = bucket[:0], bucketWeight
+			}
+		}
+	}
+	if len(bucket) > 0 {
+		palette = append(palette, bucketMean(bucket, opts.Linear, m, space))
+	}
+
+	return palette
+}
+
+// spaceKey returns the median cut sort key of a color in the color space of a SpaceMetric:
+// its hue for a HueMetric, its first coordinate otherwise.
+func spaceKey(m SpaceMetric, c color.RGBA) float64 {
+	if h, ok := m.(HueMetric); ok {
+		return h.Hue(c)
+	}
+
+	return m.Point(c)[0]
+}
+
+// bucketMean returns the mean color of a bucket of weighted colors, in the color space of a SpaceMetric if <space> is set.
+func bucketMean(bucket []WeightedColor, linear bool, m SpaceMetric, space bool) color.RGBA {
+	if !space {
+		return weightedMean(bucket, linear)
+	}
+
+	var mean ColorPoint
+	total := 0.
+	for _, wc := range bucket {
+		p := m.Point(wc.Color)
+		for k := range mean {
+			mean[k] += p[k] * wc.Weight
+		}
+		total += wc.Weight
+	}
+	for k := range mean {
+		mean[k] /= total
+	}
+
+	return m.Color(mean)
+}
+
+// byKey sorts weighted colors by keys.
+type byKey struct {
+	colors []WeightedColor
+	keys   []float64
+}
+
+func (s byKey) Len() int           { return len(s.colors) }
+func (s byKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s byKey) Swap(i, j int) {
+	s.colors[i], s.colors[j] = s.colors[j], s.colors[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+//
+// 			Popularity.
+//
+
+// PopularityQuantizer reduces the pixel colors to 5 bits per channel and keeps the most frequent ones.
+// Each palette color is the mean color of the pixels reduced to one of them.
+// It is fast, and well suited to images which already have few distinct colors, like pixel art.
+type PopularityQuantizer struct{}
+
+// popularityBin accumulates the pixels whose colors are reduced to the same 5-bit color.
+type popularityBin struct {
+	key    uint32
+	pixels []color.RGBA
+}
+
+// Palette implements the Quantizer interface.
+func (PopularityQuantizer) Palette(pixels []color.RGBA, size int, opts PaletteOptions) []color.RGBA {
+	// Build the histogram of the reduced colors.
+	bins := map[uint32]*popularityBin{}
+	for _, c := range pixels {
+		key := uint32(c.R>>3)<<15 | uint32(c.G>>3)<<10 | uint32(c.B>>3)<<5 | uint32(c.A>>3)
+		bin, ok := bins[key]
+		if !ok {
+			bin = &popularityBin{key: key}
+			bins[key] = bin
+		}
+		bin.pixels = append(bin.pixels, c)
+	}
+
+	// Sort the bins from the most frequent to the least frequent.
+	// Ties are broken by the reduced colors, so that the result does not depend on the map order.
+	var sorted []*popularityBin
+	for _, bin := range bins {
+		sorted = append(sorted, bin)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].pixels) != len(sorted[j].pixels) {
+			return len(sorted[i].pixels) > len(sorted[j].pixels)
+		}
+		return sorted[i].key < sorted[j].key
+	})
+
+	mean := MeanColorOfRange
+	if opts.Linear {
+		mean = MeanColorOfRangeLinear
+	}
+	var palette []color.RGBA
+	for i := 0; i < len(sorted) && i < size; i++ {
+		palette = append(palette, mean(sorted[i].pixels, 0, len(sorted[i].pixels)))
+	}
+
+	return palette
+}
+
+// PaletteFromHistogram implements the HistogramQuantizer interface.
+func (PopularityQuantizer) PaletteFromHistogram(colors []WeightedColor, size int, opts PaletteOptions) []color.RGBA {
+	bins := map[uint32][]WeightedColor{}
+	weights := map[uint32]float64{}
+	var keys []uint32
+	for _, wc := range colors {
+		c := wc.Color
+		key := uint32(c.R>>3)<<15 | uint32(c.G>>3)<<10 | uint32(c.B>>3)<<5 | uint32(c.A>>3)
+		if _, ok := bins[key]; !ok {
+			keys = append(keys, key)
+		}
+		bins[key] = append(bins[key], wc)
+		weights[key] += wc.Weight
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if weights[keys[i]] != weights[keys[j]] {
+			return weights[keys[i]] > weights[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	var palette []color.RGBA
+	for i := 0; i < len(keys) && i < size; i++ {
+		palette = append(palette, weightedMean(bins[keys[i]], opts.Linear))
+	}
+
+	return palette
+}
+
+//
+// 			Exact palettes.
+//
+
+// DistinctColors returns the distinct colors of <pixels>, in their order of appearance,
+// if there are at most <max> of them. Otherwise it returns false.
+func DistinctColors(pixels []color.RGBA, max int) ([]color.RGBA, bool) {
+	var colors []color.RGBA
+	seen := map[color.RGBA]bool{}
+	for _, c := range pixels {
+		if seen[c] {
+			continue
+		}
+		if len(colors) == max {
+			return nil, false
+		}
+		seen[c] = true
+		colors = append(colors, c)
+	}
+
+	return colors, true
+}
+
+// ExactPaletted maps an image to a palette which contains all its colors, pixel by pixel.
+// The pixels colors are seen as PalettePixels sees them: the transparent pixels are ignored
+// (their index is left to 0) and, unless opts.Alpha4D is set, the other ones are made opaque,
+// and converted to gray in grayscale mode.
+// It returns false if a color of the image is missing in the palette.
+func ExactPaletted(img image.Image, palette []color.RGBA, opts PaletteOptions) (*image.Paletted, bool) {
+	indices := map[color.RGBA]uint8{}
+	for i := len(palette) - 1; i >= 0; i-- {
+		indices[palette[i]] = uint8(i)
+	}
+
+	out := image.NewPaletted(img.Bounds(), ColorPalette(palette))
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			c, ok := paletteColor(PixelColor(img, x, y), opts)
+			if !ok {
+				continue
+			}
+
+			i, ok := indices[c]
+			if !ok {
+				return nil, false
+			}
+			out.SetColorIndex(x, y, i)
+		}
+	}
+
+	return out, true
+}
@@ -0,0 +1,89 @@
+package quantize
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+)
+
+//
+// 			Color ramps.
+//
+
+// A ramp expands a few key colors, e.g. the darkest, the base and the lightest shade of a material,
+// to a palette of evenly spaced shades for pixel art. The shades between two key colors are interpolated
+// in a color space: OKLab and CIELAB keep the lightness steps even and the hues steady, where sRGB muddies them.
+
+// RampInterpolator returns the color at <t> (0 to 1) between two colors.
+type RampInterpolator func(c1, c2 color.RGBA, t float64) color.RGBA
+
+// rampInterpolators holds the color spaces the ramps are interpolated in, indexed by name.
+var rampInterpolators = map[string]RampInterpolator{
+	"rgb": func(c1, c2 color.RGBA, t float64) color.RGBA {
+		return LinearGradient(1.-t, c1, t, c2)
+	},
+	"lab": func(c1, c2 color.RGBA, t float64) color.RGBA {
+		return interpolateLab(RGBToLab(c1), RGBToLab(c2), t, LabToLinearRGB)
+	},
+	"oklab": func(c1, c2 color.RGBA, t float64) color.RGBA {
+		return interpolateLab(RGBToOKLab(c1), RGBToOKLab(c2), t, OKLabToLinearRGB)
+	},
+}
+
+// DefaultRampSpace is the color space the ramps are interpolated in by default.
+const DefaultRampSpace = "oklab"
+
+// RampSpaceNames returns the names of the color spaces of the ramps, sorted alphabetically.
+func RampSpaceNames() []string {
+	var names []string
+	for name := range rampInterpolators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// interpolateLab interpolates linearly between two colors of a Lab-like space, then converts the result
+// to sRGB with <toLinear>; the colors out of the sRGB gamut are clipped.
+func interpolateLab(lab1, lab2 [3]float64, t float64, toLinear func([3]float64) [3]float64) color.RGBA {
+	var lab [3]float64
+	for i := range lab {
+		lab[i] = lab1[i] + t*(lab2[i]-lab1[i])
+	}
+	rgb := toLinear(lab)
+
+	return color.RGBA{LinearToSRGB(rgb[0]), LinearToSRGB(rgb[1]), LinearToSRGB(rgb[2]), 255}
+}
+
+// ExpandRamp expands at least two key colors to a ramp of <n> colors, interpolated in a color space (see RampSpaceNames).
+// The key colors are kept, in their order, spread as evenly as possible along the ramp: the first one begins it
+// and the last one ends it. The ramp colors are opaque.
+func ExpandRamp(keys []color.RGBA, n int, space string) ([]color.RGBA, error) {
+	interpolate, ok := rampInterpolators[space]
+	if !ok {
+		return nil, fmt.Errorf("unknown ramp color space %q (available: %v)", space, RampSpaceNames())
+	}
+	if len(keys) < 2 {
+		return nil, fmt.Errorf("a ramp needs at least 2 key colors (%d given)", len(keys))
+	}
+	if n < len(keys) || n > MaxPaletteSize {
+		return nil, fmt.Errorf("invalid ramp size %d (between the %d key colors and %d expected)", n, len(keys), MaxPaletteSize)
+	}
+
+	// The key colors get the slots closest to even positions, and the slots in between are interpolated.
+	ramp := make([]color.RGBA, n)
+	slot := func(k int) int {
+		return int(math.Round(float64(k) * float64(n-1) / float64(len(keys)-1)))
+	}
+	for k := 0; k+1 < len(keys); k++ {
+		begin, end := slot(k), slot(k+1)
+		for i := begin; i <= end; i++ {
+			ramp[i] = interpolate(Opaque(keys[k]), Opaque(keys[k+1]), float64(i-begin)/float64(end-begin))
+		}
+		ramp[begin], ramp[end] = Opaque(keys[k]), Opaque(keys[k+1])
+	}
+
+	return ramp, nil
+}
@@ -0,0 +1,78 @@
+package quantize
+
+import (
+	"encoding/json"
+	"image/color"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+//
+// 			Machine-readable run results.
+//
+
+// RunResult describes the processing of an image file, for the build pipelines (see WriteRunResult).
+type RunResult struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Frames is the number of frames of an animated GIF; it is omitted for still images.
+	Frames int `json:"frames,omitempty"`
+	// Palette holds the hex colors of the palette (the global palette, or the first frame's one, of an animated GIF).
+	Palette []string `json:"palette"`
+	// Algorithm is the palette generation algorithm; it is omitted when no palette is generated,
+	// e.g. with a fixed palette or a posterization.
+	Algorithm string `json:"algorithm,omitempty"`
+	Dither    string `json:"dither"`
+	// TimingsMS holds the duration of each processing phase, and of the whole processing ("total"), in milliseconds.
+	TimingsMS map[string]float64 `json:"timings_ms"`
+	// Quality compares the output image to the input image; it is omitted for animated GIFs and streamed images.
+	Quality *QualityMetrics `json:"quality,omitempty"`
+}
+
+// NewRunResult creates the result of the processing of an image file, given its palette.
+func NewRunResult(input, output, format string, width, height int, palette []color.RGBA) RunResult {
+	r := RunResult{Input: input, Output: output, Format: format, Width: width, Height: height, Palette: make([]string, len(palette))}
+	for i, c := range palette {
+		r.Palette[i] = FormatHexColor(c)
+	}
+
+	return r
+}
+
+// SetTimings sets the durations of the phases and of the whole processing, started at <start>.
+// <phases> may be nil.
+func (r *RunResult) SetTimings(phases *PhaseTimes, start time.Time) {
+	r.TimingsMS = map[string]float64{"total": milliseconds(time.Since(start))}
+	if phases != nil {
+		for phase, d := range phases.Durations() {
+			r.TimingsMS[phase] = milliseconds(d)
+		}
+	}
+}
+
+// milliseconds converts a duration to milliseconds, rounded to the microsecond.
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// resultMu serializes the results written by the files processed concurrently in batch mode.
+var resultMu sync.Mutex
+
+// WriteRunResult writes a result to <w> as a JSON object, on one line.
+// It can be called from several goroutines.
+func WriteRunResult(w io.Writer, r RunResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	resultMu.Lock()
+	defer resultMu.Unlock()
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,146 @@
+package quantize
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+)
+
+//
+// 			Riemersma dithering.
+//
+
+// RiemersmaDitherer applies the Riemersma dithering: the pixels are visited along a Hilbert curve,
+// and the errors of the last pixels visited are added to each pixel, the most recent ones weighing the most.
+// The curve has no main direction, so the result has fewer directional artifacts than the scanline error diffusion.
+// See https://www.compuphase.com/riemer.htm
+// The pixels depend on the previous ones, so an image is processed by a single goroutine.
+type RiemersmaDitherer struct {
+	// Metric is the color metric used to find the nearest palette colors; nil means RGBMetric.
+	Metric ColorMetric
+	// Linear makes the error computed and added in linear light instead of sRGB.
+	Linear bool
+	// Strength scales the error added in each channel; nil means FullDitherStrength.
+	Strength *DitherStrength
+	// Indexes, if not nil, caches the palette indices across images (see PaletteIndexCache).
+	Indexes *PaletteIndexCache
+	// Progress is notified of the rows processed (a row being as many pixels as the width of the image), if not nil.
+	Progress ProgressFunc
+}
+
+// The errors of the RiemersmaHistory last pixels are kept; the weight of the oldest one is 1/RiemersmaRatio
+// of the weight of the most recent one, which is 1.
+const (
+	RiemersmaHistory = 16
+	RiemersmaRatio   = 16.
+)
+
+// riemersmaWeights are the weights of the errors of the history, from the oldest to the most recent.
+var riemersmaWeights = func() (weights [RiemersmaHistory]float64) {
+	for i := range weights {
+		weights[i] = math.Pow(RiemersmaRatio, float64(i)/float64(RiemersmaHistory-1)) / RiemersmaRatio
+	}
+
+	return weights
+}()
+
+// Dither implements the Ditherer interface.
+func (d RiemersmaDitherer) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	out, _ := d.DitherContext(context.Background(), img, palette)
+	return out
+}
+
+// DitherContext implements the ContextDitherer interface.
+func (d RiemersmaDitherer) DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	b := img.Bounds()
+	out := image.NewPaletted(b, ColorPalette(palette))
+	index := d.Indexes.Index(palette, d.Metric)
+	strength := d.Strength.orFull()
+	rows := NewRowProgress(d.Progress, "dither", b.Dy())
+
+	// The channel values are handled in [0, 255], in linear light or not.
+	toValue := func(v uint8) float64 { return float64(v) }
+	toChannel := func(v float64) uint8 { return uint8(math.Round(ClampF64(v, 0., 255.))) }
+	if d.Linear {
+		toValue = func(v uint8) float64 { return SRGBToLinear(v) * 255. }
+		toChannel = func(v float64) uint8 { return LinearToSRGB(v / 255.) }
+	}
+
+	// The Hilbert curve covers the smallest power of two square holding the image; the points out of it are skipped.
+	size := 1
+	for size < b.Dx() || size < b.Dy() {
+		size *= 2
+	}
+
+	// The history is a ring buffer whose oldest error is at <next>.
+	var history [RiemersmaHistory][3]float64
+	next, visited := 0, 0
+	for i := 0; i < size*size; i++ {
+		hx, hy := hilbertPoint(size, i)
+		if hx >= b.Dx() || hy >= b.Dy() {
+			continue
+		}
+		x, y := b.Min.X+hx, b.Min.Y+hy
+
+		var e [3]float64
+		for k, w := range riemersmaWeights {
+			h := history[(next+k)%RiemersmaHistory]
+			for c := range e {
+				e[c] += h[c] * w
+			}
+		}
+
+		// The color is premultiplied, so its channels cannot exceed its alpha.
+		c := PixelColor(img, x, y)
+		alpha := toValue(c.A)
+		orig := [3]float64{toValue(c.R), toValue(c.G), toValue(c.B)}
+		v := [3]float64{
+			ClampF64(orig[0]+e[0], 0., alpha),
+			ClampF64(orig[1]+e[1], 0., alpha),
+			ClampF64(orig[2]+e[2], 0., alpha),
+		}
+
+		n := index.Nearest(color.RGBA{toChannel(v[0]), toChannel(v[1]), toChannel(v[2]), c.A})
+		out.SetColorIndex(x, y, uint8(n))
+
+		// The error is the difference between the original color and its palette color.
+		p := palette[n]
+		history[next] = [3]float64{
+			(orig[0] - toValue(p.R)) * strength[0],
+			(orig[1] - toValue(p.G)) * strength[1],
+			(orig[2] - toValue(p.B)) * strength[2],
+		}
+		next = (next + 1) % RiemersmaHistory
+
+		if visited++; visited%b.Dx() == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			rows.Done(1)
+		}
+	}
+
+	return out, nil
+}
+
+// hilbertPoint returns the coordinates of the point at distance <d> along the Hilbert curve filling a square
+// whose side <size> is a power of two.
+// See https://en.wikipedia.org/wiki/Hilbert_curve
+func hilbertPoint(size, d int) (x, y int) {
+	for s := 1; s < size; s *= 2 {
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+		if ry == 0 {
+			if rx == 1 {
+				x, y = s-1-x, s-1-y
+			}
+			x, y = y, x
+		}
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+
+	return x, y
+}
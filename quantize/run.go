@@ -0,0 +1,1312 @@
+package quantize
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings gathers the processing settings of the images (see ProcessFile), e.g. those given on the command line.
+type Settings struct {
+	PaletteMaxSize int
+	// AutoPaletteSize makes the palette size estimated for each image instead of PaletteMaxSize (see withAutoPaletteSize).
+	AutoPaletteSize bool
+	Palette         PaletteOptions
+	// Algorithm is the name of the palette generation algorithm (see NewQuantizer).
+	Algorithm string
+	// DitherName is the name of the dithering algorithm (see NewDitherer).
+	DitherName string
+	Dither     DitherOptions
+	// Format is the output image format; it is inferred from the output filepath if empty.
+	Format string
+	// Background, if not nil, is the color of a matte the images are composited over (see FlattenImage).
+	Background *color.RGBA
+	// Inputs, if not nil, keeps the input files read and decoded, e.g. for the runs of a sweep.
+	Inputs *InputCache
+	// PaletteCache, if not nil, keeps the palettes generated from the input files across runs (see imagePalette).
+	PaletteCache *PaletteCache
+	// Mask, if not nil, restricts the quantization to a region of the images, the other pixels passing through
+	// untouched; the palette is generated from that region (see RegionMask).
+	Mask *RegionMask
+	// DitherAlpha makes the alpha channel of the translucent pixels dithered with the DitherName algorithm
+	// (see PaletteOptions.AlphaDither).
+	DitherAlpha bool
+	// Posterize, if positive, makes each channel reduced to this number of levels instead of generating a palette (see Posterize).
+	Posterize int
+	// Duotone makes the palette colors stand for evenly spaced gray levels in grayscale mode (see DuotoneLevels).
+	Duotone bool
+	// GrayBias is added to the gray levels in grayscale mode, e.g. to set the threshold of the black and white mode.
+	GrayBias float64
+	// Animation holds the options of the frames of the animated GIFs and PNGs (see AnimationOptions).
+	Animation AnimationOptions
+	// Frame is the number of the image in an image sequence, counted from 0, for the temporal offset of the ditherer.
+	Frame int
+	// SavePalette is the filepath where the palette of the result is saved, if not empty.
+	SavePalette string
+	// Swatch is the filepath where the palette of the result is drawn, SwatchColumns cells per row,
+	// with hex labels if SwatchLabels is set, if not empty (see Swatch).
+	Swatch        string
+	SwatchColumns int
+	SwatchLabels  bool
+	// AutoRotate makes the images turned upright according to their EXIF orientation (see Orient).
+	AutoRotate bool
+	// InvertCMYK makes the CMYK values of the CMYK images inverted before they are converted to RGB (see CMYKToRGB).
+	InvertCMYK bool
+	// KeepMetadata makes the EXIF metadata of the images copied to the PNG output images.
+	KeepMetadata bool
+	// ScaleDown divides the size of the images by a factor before quantizing them, with the ScaleFilter filter (see ScaleDown);
+	// ScaleUp multiplies the size of the output images by a factor (see ScaleUp).
+	ScaleDown   int
+	ScaleFilter string
+	ScaleUp     int
+	// Prefilters are applied to the images before they are quantized, after ScaleDown (see ApplyPrefilters).
+	Prefilters []Prefilter
+	// Despeckle makes the isolated pixels of the quantized images replaced by their neighbors' color (see Despeckle).
+	Despeckle bool
+	// Outline, if positive, makes the pixels on the dark side of the edges whose gradient reaches it
+	// snapped to dark palette colors, after the despeckling (see SnapOutlines).
+	Outline float64
+	// Wrap makes the images dithered as if they were tiled, for seamless textures (see WrapDitherer).
+	Wrap bool
+	// Tiles, if not nil, restricts each tile of the images to a few colors of the palette (see ApplyTilePalettes),
+	// or gives each tile its own palette if its Colors is 0 (see ApplyCellPalettes);
+	// TileJSON is the filepath where the colors of each tile are saved, if not empty.
+	Tiles    *TileLayout
+	TileJSON string
+	// PNGOrder is the palette order of the indexed PNG images (see SortPalette).
+	PNGOrder string
+	// Export holds the options of the source code and raw formats (see ExportOptions).
+	Export ExportOptions
+	// Deep makes the channels of 16-bit images dithered down to 8 bits (see DitherDeepImage).
+	Deep bool
+	// ConvertProfile makes the images with an embedded ICC profile converted to sRGB (see ConvertToSRGB).
+	ConvertProfile bool
+	// TargetDeltaE, if positive, makes the palette size the smallest one, up to PaletteMaxSize,
+	// whose TargetStat ΔE stays under it (see QuantizeToTarget).
+	TargetDeltaE float64
+	TargetStat   string
+	// Compare is the filepath where the input and output images are drawn side by side, if not empty;
+	// CompareHeatmap adds the heatmap of their differences (see ComparisonImage).
+	Compare        string
+	CompareHeatmap bool
+	// Preview makes the output images drawn on the standard error, PreviewWidth characters wide (see WriteTerminalPreview).
+	Preview      bool
+	PreviewWidth int
+	// Report makes the quality of each quantized image printed to the standard error (see CompareImages);
+	// ReportJSON makes it printed as JSON.
+	Report, ReportJSON bool
+	// Stats makes the palette usage of each quantized image printed to the standard error (see PaletteUsageOf);
+	// StatsJSON makes it printed as JSON. StatsStrip is the filepath where the usage strip is drawn, if not empty (see UsageStrip).
+	Stats, StatsJSON bool
+	StatsStrip       string
+	// Stream makes the images dithered and written band by band (see StreamQuantizePNG).
+	Stream bool
+	// Verbose makes details about the images and the duration of each phase printed to the standard error.
+	Verbose bool
+	// Timing makes the duration of each phase of each image printed to the standard error (see WriteTimings).
+	Timing bool
+	// JSON makes a RunResult printed to the standard output for each image, timed by Timings.
+	JSON    bool
+	Timings *PhaseTimes
+	// ProgressBar makes a live progress bar drawn on the standard error for large images (see LargeImagePixels).
+	ProgressBar bool
+	// Quiet makes nothing but errors printed, e.g. not the estimated palette sizes.
+	Quiet bool
+}
+
+// PaletteSizeName returns the palette size as given on the command line, a number or PaletteSizeAuto,
+// e.g. for the output filename templates (see ExpandOutputTemplate).
+func (s Settings) PaletteSizeName() string {
+	if s.AutoPaletteSize {
+		return PaletteSizeAuto
+	}
+	return strconv.Itoa(s.PaletteMaxSize)
+}
+
+// withAutoPaletteSize returns a copy of the settings whose palette size is estimated from some images, e.g. the frames
+// of an animation, if AutoPaletteSize is set (see AutoPaletteSizeOf). The size is printed to the standard error, unless Quiet is set.
+func (s Settings) withAutoPaletteSize(name string, imgs []image.Image) Settings {
+	if !s.AutoPaletteSize {
+		return s
+	}
+
+	s.PaletteMaxSize = AutoPaletteSizeOf(imgs, s.Palette)
+	if !s.Quiet {
+		fmt.Fprintf(os.Stderr, "%s: palette size %d\n", name, s.PaletteMaxSize)
+	}
+
+	return s
+}
+
+// LargeImagePixels is the number of pixels from which an image gets a progress bar.
+const LargeImagePixels = 2000 * 1000
+
+// withProgress returns a copy of the settings reporting the progress of the processing
+// of an image with a given number of pixels, as requested by the Verbose and ProgressBar settings.
+// It also creates the ditherer, with its own palette index cache.
+func (s Settings) withProgress(pixels int) (Settings, Ditherer, error) {
+	var progress ProgressFunc
+	if s.ProgressBar && pixels >= LargeImagePixels {
+		progress = ProgressBar(os.Stderr)
+	}
+	if s.Verbose {
+		progress = VerboseProgress(os.Stderr, progress)
+	}
+	if s.Timings != nil {
+		progress = s.Timings.Progress(progress)
+	}
+
+	s.Palette.Progress = progress
+	s.Dither.Progress = progress
+	// The frames of an animation mapped to the same palette share its index.
+	s.Dither.Indexes = NewPaletteIndexCache()
+	ditherer, err := s.newDitherer()
+	if err == nil && s.DitherAlpha {
+		s.Palette.AlphaDither, err = s.alphaDitherer()
+	}
+
+	return s, ditherer, err
+}
+
+// streamFile quantizes an image in streaming mode with <quantize> (see StreamQuantizePNG) and writes it to a PNG file.
+// It returns the palette of the image.
+func (s Settings) streamFile(outFilepath, format string, quantize func(w io.Writer, ditherer Ditherer, progress ProgressFunc) ([]color.RGBA, error)) ([]color.RGBA, error) {
+	if format != "png" {
+		return nil, fmt.Errorf("streaming mode only writes PNG images, not %s", format)
+	}
+
+	// The bands report their progress to the whole image rather than the ditherer.
+	progress := s.Dither.Progress
+	s.Dither.Progress = nil
+	ditherer, err := s.newDitherer()
+	if err != nil {
+		return nil, err
+	}
+
+	outputFile, err := CreateOutputFile(outFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("writing output image: %w", err)
+	}
+	w := bufio.NewWriter(outputFile)
+	palette, err := quantize(w, ditherer, progress)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("writing output image: %w", err)
+	}
+	s.logf("%d palette colors", len(palette))
+
+	if s.SavePalette != "" {
+		if err := WritePaletteToFile(palette, s.SavePalette); err != nil {
+			return nil, fmt.Errorf("saving palette: %w", err)
+		}
+	}
+	if err := s.writeSwatch(palette); err != nil {
+		return nil, err
+	}
+	s.logf("%s: streamed as png", outFilepath)
+
+	return palette, nil
+}
+
+// streamPNGFile quantizes a PNG, Netpbm or farbfeld file in streaming mode without decoding it as a whole
+// (see StreamQuantizePNGFile). It reports false, and leaves the file to the whole image decoding,
+// if the file is not an image decodable row by row,
+// or needs a processing of the whole image: an animation, a color profile or orientation to apply, a scaling...
+// The processing started at <start>.
+func (s Settings) streamPNGFile(ctx context.Context, srcFilepath, outFilepath, format string, start time.Time) (bool, error) {
+	if s.ScaleDown > 1 || s.Prefilters != nil || s.Mask != nil || s.AutoPaletteSize || s.Palette.Sampling.Mode == SampleRandom || s.Palette.Sampling.Mode == SampleProxy {
+		return false, nil
+	}
+	file, err := os.Open(srcFilepath)
+	if err != nil {
+		return false, fmt.Errorf("reading input image: %w", err)
+	}
+	r, err := NewRowReader(file)
+	file.Close()
+	if err != nil {
+		return false, nil
+	}
+	if p, ok := r.(*PNGRowReader); ok && (p.Chunks["acTL"] || (p.Chunks["iCCP"] && s.ConvertProfile) || (p.Chunks["eXIf"] && s.AutoRotate) || (p.Depth() == 16 && s.Deep)) {
+		return false, nil
+	}
+	if d, ok := r.(deepRowReader); ok && d.Deep() && s.Deep {
+		return false, nil
+	}
+	width, height := r.Size()
+	if mask, ok := s.Palette.Weights.(MaskWeights); ok && mask.Mask.Bounds().Size() != image.Pt(width, height) {
+		return true, fmt.Errorf("the weight mask is %v, not the %v size of the image", mask.Mask.Bounds().Size(), image.Pt(width, height))
+	}
+	s.logf("%s: %dx%d image, decoded by bands", srcFilepath, width, height)
+
+	s, _, err = s.withProgress(width * height)
+	if err != nil {
+		return true, err
+	}
+	palette, err := s.streamFile(outFilepath, format, func(w io.Writer, ditherer Ditherer, progress ProgressFunc) ([]color.RGBA, error) {
+		return StreamQuantizePNGFile(ctx, w, srcFilepath, s.Background, s.PaletteMaxSize, s.Palette, ditherer, progress)
+	})
+	if err != nil {
+		return true, err
+	}
+
+	return true, s.writeResult(NewRunResult(srcFilepath, outFilepath, format, width, height, palette), start)
+}
+
+// processAPNG quantizes the frames of an animated PNG (see TransformAPNG) and writes them as an animated PNG or GIF.
+// The processing started at <start>.
+func (s Settings) processAPNG(ctx context.Context, inData []byte, srcFilepath, outFilepath, format string, start time.Time) error {
+	inAPNG, err := DecodeAPNG(inData)
+	if err != nil {
+		return fmt.Errorf("decoding input animated PNG: %w", err)
+	}
+	s.Timings.Add("decode", time.Since(start))
+	if s.TargetDeltaE > 0 || s.Compare != "" || s.ScaleDown > 1 || s.ScaleUp > 1 || s.Stream || s.Prefilters != nil || s.Despeckle || s.Outline > 0 {
+		return fmt.Errorf("-target-de, -compare, -scale-down, -scale-up, -stream, -prefilter, -despeckle and -outline do not support animated PNGs")
+	}
+	if s.Tiles != nil || s.Posterize > 0 || s.Background != nil || s.Mask != nil {
+		return fmt.Errorf("-tiles, -grid, -posterize, -background and -mask do not support animated PNGs")
+	}
+	if format == "png" && s.Animation.PaletteMode == GIFPaletteLocal {
+		return fmt.Errorf("an animated PNG has a single palette: use -gif-palette %s, or write a GIF", GIFPaletteGlobal)
+	}
+	bounds := inAPNG.Frames[0].Bounds()
+	s.logf("%s: %dx%d animated PNG, %d frames", srcFilepath, bounds.Dx(), bounds.Dy(), len(inAPNG.Frames))
+	s = s.withAutoPaletteSize(srcFilepath, inAPNG.Frames)
+
+	s, ditherer, err := s.withProgress(bounds.Dx() * bounds.Dy() * len(inAPNG.Frames))
+	if err != nil {
+		return err
+	}
+	outAPNG, err := TransformAPNG(ctx, inAPNG, s.PaletteMaxSize, s.Palette, ditherer, s.Animation)
+	if err != nil {
+		return err
+	}
+
+	// Save the first frame's palette, which is the palette of all the frames in global mode.
+	palette := PaletteColors(outAPNG.Frames[0].(*image.Paletted).Palette)
+	if s.SavePalette != "" {
+		if err := WritePaletteToFile(palette, s.SavePalette); err != nil {
+			return fmt.Errorf("saving palette: %w", err)
+		}
+	}
+	if err := s.writeSwatch(palette); err != nil {
+		return err
+	}
+
+	for i, frame := range outAPNG.Frames {
+		name := fmt.Sprintf("%s[%d]", srcFilepath, i)
+		if s.Report || s.ReportJSON {
+			metrics, err := CompareImages(inAPNG.Frames[i], frame)
+			if err != nil {
+				return err
+			}
+			if err := WriteQualityReport(os.Stderr, name, metrics, s.ReportJSON); err != nil {
+				return err
+			}
+		}
+		if err := s.writeUsageStats(name, frame.(*image.Paletted), i == 0); err != nil {
+			return err
+		}
+	}
+
+	if s.Preview {
+		if err := WriteTerminalPreview(os.Stderr, outAPNG.Frames[0], s.PreviewWidth, TrueColorTerminal()); err != nil {
+			return err
+		}
+	}
+
+	encodeStart := time.Now()
+	if format == "gif" {
+		var outGIF *gif.GIF
+		outGIF, err = outAPNG.GIF()
+		if err == nil {
+			err = WriteGIFToFile(outGIF, outFilepath)
+		}
+	} else {
+		err = WriteAPNGToFile(outAPNG, outFilepath)
+	}
+	if err != nil {
+		return fmt.Errorf("writing output animation: %w", err)
+	}
+	s.Timings.Add("encode", time.Since(encodeStart))
+	s.logf("%s: written as an animated %s", outFilepath, strings.ToUpper(format))
+
+	result := NewRunResult(srcFilepath, outFilepath, format, bounds.Dx(), bounds.Dy(), palette)
+	result.Frames = len(outAPNG.Frames)
+	return s.writeResult(result, start)
+}
+
+// newDitherer creates the ditherer of the settings.
+// In grayscale mode, the ditherers without a GrayDitherer counterpart work on the gray image, with the gray palette.
+func (s Settings) newDitherer() (Ditherer, error) {
+	var d Ditherer
+	var err error
+	if s.Palette.Grayscale && IsGrayDithererName(s.DitherName) {
+		d = s.grayDitherer()
+	} else if d, err = NewDitherer(s.DitherName, s.Dither); err != nil {
+		return nil, err
+	}
+	if s.Wrap {
+		d = WrapDitherer{Ditherer: d}
+	}
+
+	return d, nil
+}
+
+// alphaDitherer creates the ditherer of the alpha channel: the DitherName algorithm, without the color options.
+// The alpha values are dithered as they are, since they already are proportions of coverage.
+func (s Settings) alphaDitherer() (Ditherer, error) {
+	opts := DitherOptions{BayerMatSize: s.Dither.BayerMatSize, Matrix: s.Dither.Matrix, Offset: s.Dither.Offset, Orientation: s.Dither.Orientation,
+		Threads: s.Dither.Threads, Seed: s.Dither.Seed}
+	opts.DiffusionOptions = s.Dither.DiffusionOptions
+
+	d, err := NewDitherer(s.DitherName, opts)
+	if err != nil || !s.Wrap {
+		return d, err
+	}
+
+	return WrapDitherer{Ditherer: d}, nil
+}
+
+// grayDitherer creates the ditherer of the grayscale mode, which applies the threshold matrix
+// of the ordered dithering (the Bayer matrix by default), or the error diffusion, as requested.
+func (s Settings) grayDitherer() GrayDitherer {
+	d := GrayDitherer{Offset: s.Dither.Offset, Orientation: s.Dither.Orientation, Threads: s.Dither.Threads, Linear: s.Dither.Linear,
+		Strength: s.Dither.Strength, Bias: s.GrayBias, Progress: s.Dither.Progress}
+	d.DiffusionOptions = s.Dither.DiffusionOptions
+	switch s.DitherName {
+	case "none":
+	case "floyd-steinberg":
+		d.Diffusion = true
+	default:
+		d.Matrix = s.Dither.Matrix
+		if pattern, ok := patterns[s.DitherName]; ok {
+			d.Matrix = pattern()
+		}
+		if d.Matrix == nil {
+			d.Matrix, _ = BayerMatrix(s.Dither.BayerMatSize)
+		}
+	}
+
+	if s.Duotone {
+		d.Levels = DuotoneLevels(len(s.Palette.Fixed))
+	}
+
+	return d
+}
+
+// IsGrayDithererName reports whether a ditherer has a GrayDitherer counterpart: the ordered ditherings
+// with a threshold matrix, the error diffusion and the plain mapping.
+func IsGrayDithererName(name string) bool {
+	_, pattern := patterns[name]
+	return pattern || name == "bayer" || name == "ordered" || name == "floyd-steinberg" || name == "none"
+}
+
+// writeSwatch draws the swatch of a palette, if requested.
+func (s Settings) writeSwatch(palette []color.RGBA) error {
+	if s.Swatch == "" {
+		return nil
+	}
+
+	if err := WriteImageToFilePath(Swatch(palette, s.SwatchColumns, s.SwatchLabels), s.Swatch); err != nil {
+		return fmt.Errorf("writing swatch: %w", err)
+	}
+	return nil
+}
+
+// writeUsageStats prints the palette usage of an image as requested, and draws its usage strip if <strip> is set.
+func (s Settings) writeUsageStats(name string, img *image.Paletted, strip bool) error {
+	if !s.Stats && !s.StatsJSON && (s.StatsStrip == "" || !strip) {
+		return nil
+	}
+
+	usage := PaletteUsageOf(img)
+	if s.Stats || s.StatsJSON {
+		if err := WriteUsageStats(os.Stderr, name, usage, s.StatsJSON); err != nil {
+			return err
+		}
+	}
+	if s.StatsStrip != "" && strip {
+		if err := WriteImageToFilePath(UsageStrip(usage), s.StatsStrip); err != nil {
+			return fmt.Errorf("writing usage strip: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// writeTrueColorCells writes the image <out> of the cells of <img> when their palettes have more colors together
+// than a paletted image can hold (see ApplyCellPalettes), as a true-color image: the steps which need a paletted
+// image (usage stats, scale-up, mask and palette saving) are skipped.
+func (s Settings) writeTrueColorCells(srcFilepath, outFilepath, format string, img image.Image, out *image.RGBA, palette []color.RGBA, chunks []PNGChunk, start time.Time) error {
+	if format != "png" && format != "bmp" && format != "tiff" {
+		return fmt.Errorf("the cell palettes have %d colors together, more than the %d of a paletted image, and the true-color image cannot be written in the %s format (available: [bmp png tiff])",
+			len(palette), MaxPaletteSize, format)
+	}
+	s.logf("%s: the cell palettes have %d colors together, more than %d: written as a true-color image", srcFilepath, len(palette), MaxPaletteSize)
+	if s.Stats || s.StatsJSON || s.StatsStrip != "" || s.ScaleUp > 1 || s.Mask != nil || s.SavePalette != "" {
+		s.logf("%s: the usage stats, scale-up, mask and palette saving are skipped for the true-color image", srcFilepath)
+	}
+
+	var metrics QualityMetrics
+	if s.Report || s.ReportJSON || s.JSON {
+		var err error
+		if metrics, err = CompareImages(img, out); err != nil {
+			return err
+		}
+	}
+	if s.Report || s.ReportJSON {
+		if err := WriteQualityReport(os.Stderr, srcFilepath, metrics, s.ReportJSON); err != nil {
+			return err
+		}
+	}
+	if err := s.writeSwatch(palette); err != nil {
+		return err
+	}
+	if s.Compare != "" {
+		comparison, err := ComparisonImage(img, out, s.CompareHeatmap)
+		if err == nil {
+			err = WriteImageToFilePath(comparison, s.Compare)
+		}
+		if err != nil {
+			return fmt.Errorf("writing comparison image: %w", err)
+		}
+	}
+	if s.Preview {
+		if err := WriteTerminalPreview(os.Stderr, out, s.PreviewWidth, TrueColorTerminal()); err != nil {
+			return err
+		}
+	}
+
+	encodeStart := time.Now()
+	var err error
+	if len(chunks) > 0 && format == "png" {
+		err = WritePNGWithChunks(out, outFilepath, chunks)
+	} else {
+		err = WriteImageToFile(out, outFilepath, format)
+	}
+	if err != nil {
+		return fmt.Errorf("writing output image: %w", err)
+	}
+	s.Timings.Add("encode", time.Since(encodeStart))
+	s.logf("%s: written as %s", outFilepath, format)
+
+	result := NewRunResult(srcFilepath, outFilepath, format, out.Bounds().Dx(), out.Bounds().Dy(), palette)
+	result.Quality = &metrics
+	return s.writeResult(result, start)
+}
+
+// writeResult completes the result of the processing of an image, started at <start>, and prints it, if requested.
+func (s Settings) writeResult(r RunResult, start time.Time) error {
+	if s.Timing {
+		if err := WriteTimings(os.Stderr, r.Input, s.Timings, time.Since(start)); err != nil {
+			return err
+		}
+	}
+	if !s.JSON {
+		return nil
+	}
+
+	if s.Palette.Fixed == nil && s.Posterize <= 0 {
+		r.Algorithm = s.Algorithm
+	}
+	r.Dither = s.DitherName
+	r.SetTimings(s.Timings, start)
+
+	return WriteRunResult(os.Stdout, r)
+}
+
+// imagePalette generates the palette of an image decoded from the input file data <inData>, as PaletteFromImage does.
+// The palette is read from the palette cache instead, if it was generated before from the same data and settings,
+// or stored there otherwise. The palettes weighted by a mask image are not cached.
+func (s Settings) imagePalette(inData []byte, img image.Image) []color.RGBA {
+	_, focus := s.Palette.Weights.(FocusWeights)
+	if s.PaletteCache == nil || s.Palette.Fixed != nil || (s.Palette.Weights != nil && !focus) {
+		return PaletteFromImage(img, s.PaletteMaxSize, s.Palette)
+	}
+
+	key := PaletteCacheKey(inData, s.paletteCacheSettings())
+	if palette, ok := s.PaletteCache.Get(key); ok {
+		s.logf("palette read from the cache (%s)", key[:12])
+		return palette
+	}
+	palette := PaletteFromImage(img, s.PaletteMaxSize, s.Palette)
+	if err := s.PaletteCache.Put(key, palette); err != nil {
+		s.logf("%v", err)
+	}
+
+	return palette
+}
+
+// paletteCacheSettings describes the settings the palette of an input file depends on, for its palette cache key:
+// the palette options, and the transformations of the image before the palette generation.
+func (s Settings) paletteCacheSettings() string {
+	o := s.Palette
+	background := ""
+	if s.Background != nil {
+		background = FormatHexColor(*s.Background)
+	}
+
+	return fmt.Sprintf("v1 size=%d algo=%s metric=%#v linear=%v alpha=%d alpha4d=%v gray=%v sampling=%#v bits=%d exact=%v refine=%v merge=%v colorblind=%v keep=%v weights=%#v background=%s scale=%d,%s prefilters=%v rotate=%v profile=%v deep=%v cmyk-invert=%v",
+		s.PaletteMaxSize, s.Algorithm, o.Metric, o.Linear, o.TransparencyThreshold(), o.Alpha4D, o.Grayscale, o.Sampling,
+		o.HistogramBits, o.Exact, o.Refine, o.MergeDeltaE, o.ColorblindDeltaE, o.Keep, o.Weights, background,
+		s.ScaleDown, s.ScaleFilter, s.Prefilters, s.AutoRotate, s.ConvertProfile, s.Deep, s.InvertCMYK)
+}
+
+// logf prints a detail to the standard error in verbose mode.
+func (s Settings) logf(format string, args ...interface{}) {
+	if s.Verbose {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// ProcessFile reads an image file, transforms it and writes the result to another file.
+// The standard input and output are used if IsStdio(srcFilepath) and IsStdio(outFilepath) respectively.
+// The processing stops early, returning ctx.Err(), if <ctx> is canceled.
+func ProcessFile(ctx context.Context, srcFilepath, outFilepath string, settings Settings) error {
+	start := time.Now()
+	format := settings.Format
+	if format == "" {
+		var err error
+		if format, err = FormatFromFilePath(outFilepath); err != nil {
+			return err
+		}
+	}
+	if settings.JSON || settings.Timing {
+		settings.Timings = NewPhaseTimes()
+	}
+
+	// A PNG, Netpbm or farbfeld file is streamed without being read as a whole, if possible.
+	if settings.Stream && format == "png" && !IsStdio(srcFilepath) {
+		if ok, err := settings.streamPNGFile(ctx, srcFilepath, outFilepath, format, start); ok {
+			return err
+		}
+	}
+
+	// Read the source image file; it may be the standard input.
+	inData, err := settings.Inputs.ReadInputFile(srcFilepath)
+	if err != nil {
+		return fmt.Errorf("reading input image: %w", err)
+	}
+
+	// An animated PNG written as a PNG or a GIF keeps all its frames.
+	inFormat, _ := ImageFormat(inData)
+	if inFormat == "png" && (format == "png" || format == "gif") && IsAPNG(inData) {
+		return settings.processAPNG(ctx, inData, srcFilepath, outFilepath, format, start)
+	}
+
+	// An animated GIF written as a GIF keeps all its frames.
+	if inFormat == "gif" && format == "gif" {
+		inGIF, err := DecodeGIF(inData)
+		if err != nil {
+			return fmt.Errorf("decoding input GIF: %w", err)
+		}
+		settings.Timings.Add("decode", time.Since(start))
+		if settings.TargetDeltaE > 0 || settings.Compare != "" {
+			return fmt.Errorf("-target-de and -compare do not support animated GIFs")
+		}
+		if settings.ScaleDown > 1 || settings.ScaleUp > 1 || settings.Prefilters != nil || settings.Despeckle || settings.Outline > 0 {
+			return fmt.Errorf("-scale-down, -scale-up, -prefilter, -despeckle and -outline do not support animated GIFs")
+		}
+		if settings.Tiles != nil || settings.Posterize > 0 || settings.Background != nil || settings.Mask != nil {
+			return fmt.Errorf("-tiles, -grid, -posterize, -background and -mask do not support animated GIFs")
+		}
+		settings.logf("%s: %dx%d animated GIF, %d frames", srcFilepath, inGIF.Config.Width, inGIF.Config.Height, len(inGIF.Image))
+		if settings.AutoPaletteSize {
+			frames := make([]image.Image, len(inGIF.Image))
+			for i, frame := range inGIF.Image {
+				frames[i] = frame
+			}
+			settings = settings.withAutoPaletteSize(srcFilepath, frames)
+		}
+
+		settings, ditherer, err := settings.withProgress(inGIF.Config.Width * inGIF.Config.Height * len(inGIF.Image))
+		if err != nil {
+			return err
+		}
+
+		outGIF, err := TransformGIF(ctx, inGIF, settings.PaletteMaxSize, settings.Palette, ditherer, settings.Animation)
+		if err != nil {
+			return err
+		}
+
+		// Save the global palette, or the first frame's one.
+		if settings.SavePalette != "" {
+			palette, ok := outGIF.Config.ColorModel.(color.Palette)
+			if !ok {
+				palette = outGIF.Image[0].Palette
+			}
+			if err := WritePaletteToFile(PaletteColors(palette), settings.SavePalette); err != nil {
+				return fmt.Errorf("saving palette: %w", err)
+			}
+		}
+		if err := settings.writeSwatch(PaletteColors(outGIF.Image[0].Palette)); err != nil {
+			return err
+		}
+
+		// Each frame is reported on its own.
+		if settings.Report || settings.ReportJSON {
+			for i := range outGIF.Image {
+				metrics, err := CompareImages(inGIF.Image[i], outGIF.Image[i])
+				if err != nil {
+					return err
+				}
+				if err := WriteQualityReport(os.Stderr, fmt.Sprintf("%s[%d]", srcFilepath, i), metrics, settings.ReportJSON); err != nil {
+					return err
+				}
+			}
+		}
+
+		for i, frame := range outGIF.Image {
+			if err := settings.writeUsageStats(fmt.Sprintf("%s[%d]", srcFilepath, i), frame, i == 0); err != nil {
+				return err
+			}
+		}
+
+		// The first frame is the only complete one.
+		if settings.Preview {
+			if err := WriteTerminalPreview(os.Stderr, outGIF.Image[0], settings.PreviewWidth, TrueColorTerminal()); err != nil {
+				return err
+			}
+		}
+
+		encodeStart := time.Now()
+		if err := WriteGIFToFile(outGIF, outFilepath); err != nil {
+			return fmt.Errorf("writing output GIF: %w", err)
+		}
+		settings.Timings.Add("encode", time.Since(encodeStart))
+		settings.logf("%s: written", outFilepath)
+
+		result := NewRunResult(srcFilepath, outFilepath, format, inGIF.Config.Width, inGIF.Config.Height, PaletteColors(outGIF.Image[0].Palette))
+		result.Frames = len(outGIF.Image)
+		return settings.writeResult(result, start)
+	}
+
+	// Decode the source image.
+	inImage, err := settings.Inputs.DecodeImage(srcFilepath, inData)
+	if err != nil {
+		return fmt.Errorf("decoding input image: %w", err)
+	}
+	settings.Timings.Add("decode", time.Since(start))
+
+	// The CMYK images are converted to RGB; their ICC profile, a CMYK one, is not supported.
+	if cmyk, ok := inImage.(*image.CMYK); ok {
+		inImage = CMYKToRGB(cmyk, settings.InvertCMYK, settings.Dither.Threads)
+		settings.logf("%s: converted from CMYK", srcFilepath)
+	}
+
+	// The images described by another color profile are converted to sRGB,
+	// and the PNG output is then tagged as sRGB.
+	var chunks []PNGChunk
+	if profileData := ICCProfileData(inData); settings.ConvertProfile && profileData != nil {
+		profile, err := ParseICCProfile(profileData)
+		if err != nil {
+			settings.logf("%s: ICC profile ignored: %v", srcFilepath, err)
+		} else if !profile.IsSRGB() {
+			inImage = ConvertToSRGB(inImage, profile)
+			chunks = append(chunks, PNGChunk{"sRGB", []byte{0}})
+			settings.logf("%s: converted from its ICC profile to sRGB", srcFilepath)
+		}
+	}
+
+	if settings.Deep && IsDeepImage(inImage) {
+		inImage = DitherDeepImage(inImage)
+		settings.logf("%s: 16-bit channels dithered down to 8 bits", srcFilepath)
+	}
+
+	// Photos are turned upright according to their EXIF orientation, and may be scaled down.
+	// So do the region mask and the weights of the pixels, which are given in the pixels of the input image.
+	inSize := inImage.Bounds().Size()
+	if mask, ok := settings.Palette.Weights.(MaskWeights); ok && mask.Mask.Bounds().Size() != inSize {
+		return fmt.Errorf("the weight mask is %v, not the %v size of the image", mask.Mask.Bounds().Size(), inSize)
+	}
+	if settings.Mask != nil && settings.Mask.Mask.Bounds().Size() != inSize {
+		return fmt.Errorf("the mask is %v, not the %v size of the image", settings.Mask.Mask.Bounds().Size(), inSize)
+	}
+	exif := ExifData(inData)
+	orientation := ExifOrientation(exif)
+	if !settings.AutoRotate {
+		orientation = OrientationNormal
+	}
+	if orientation != OrientationNormal {
+		inImage = Orient(inImage, orientation)
+		exif = WithNormalOrientation(exif)
+		settings.logf("%s: EXIF orientation %d applied", srcFilepath, orientation)
+	}
+	if settings.Background != nil && HasTransparentPixels(inImage, 255) {
+		inImage = FlattenImage(inImage, *settings.Background)
+		settings.logf("%s: flattened over %s", srcFilepath, FormatHexColor(*settings.Background))
+	}
+	bounds := inImage.Bounds()
+	settings.logf("%s: %dx%d image", srcFilepath, bounds.Dx(), bounds.Dy())
+	if settings.ScaleDown > 1 {
+		inImage = ScaleDown(inImage, settings.ScaleDown, settings.ScaleFilter, settings.Palette.Linear)
+		bounds = inImage.Bounds()
+		settings.logf("%s: scaled down to %dx%d", srcFilepath, bounds.Dx(), bounds.Dy())
+	}
+	if settings.Prefilters != nil {
+		inImage = ApplyPrefilters(inImage, settings.Prefilters, settings.Dither.Threads)
+		settings.logf("%s: pre-filtered with %v", srcFilepath, settings.Prefilters)
+	}
+	if size, ok := settings.thresholdMatrixSize(); settings.Wrap && ok && (bounds.Dx()%size.X != 0 || bounds.Dy()%size.Y != 0) {
+		settings.logf("%s: the %dx%d threshold matrix does not tile the image, whose dithering pattern will show seams", srcFilepath, size.X, size.Y)
+	}
+	settings.Palette.Weights = TransformWeights(settings.Palette.Weights, inSize, orientation, settings.ScaleDown, settings.ScaleFilter)
+	if settings.Mask != nil {
+		settings.Mask = settings.Mask.Transform(orientation, settings.ScaleDown, settings.ScaleFilter)
+		if settings.Stream || settings.ScaleUp > 1 {
+			return fmt.Errorf("-mask cannot be combined with -stream or -scale-up")
+		}
+		if format != "png" && format != "bmp" && format != "tiff" {
+			return fmt.Errorf("-mask writes true-color images, which the %s format cannot hold (available: [bmp png tiff])", format)
+		}
+		settings.Palette.Weights = settings.Mask.Weights(settings.Palette.Weights)
+	}
+	settings = settings.withAutoPaletteSize(srcFilepath, []image.Image{inImage})
+
+	// Process the image.
+	settings, ditherer, err := settings.withProgress(bounds.Dx() * bounds.Dy())
+	if err != nil {
+		return err
+	}
+	ditherer = settings.Animation.frameDitherer(ditherer, settings.Frame)
+
+	if settings.Stream {
+		palette, err := settings.streamFile(outFilepath, format, func(w io.Writer, ditherer Ditherer, progress ProgressFunc) ([]color.RGBA, error) {
+			return StreamQuantizePNG(ctx, w, inImage, settings.PaletteMaxSize, settings.Palette, ditherer, progress)
+		})
+		if err != nil {
+			return err
+		}
+		return settings.writeResult(NewRunResult(srcFilepath, outFilepath, format, bounds.Dx(), bounds.Dy(), palette), start)
+	}
+
+	var outImage *image.Paletted
+	if settings.TargetDeltaE > 0 {
+		outImage, err = QuantizeToTarget(ctx, inImage, settings.PaletteMaxSize, settings.Palette, ditherer,
+			settings.TargetDeltaE, settings.TargetStat, func(size int, m QualityMetrics) {
+				settings.logf("palette size %d: %v", size, m)
+			})
+	} else if settings.Posterize > 0 {
+		outImage, err = Posterize(ctx, inImage, settings.Posterize, settings.Palette, ditherer)
+	} else if settings.Tiles != nil {
+		var tiled image.Image
+		var tiles *TilePalettes
+		if settings.Tiles.Colors == 0 {
+			tiled, tiles, err = ApplyCellPalettes(ctx, inImage, *settings.Tiles, settings.PaletteMaxSize, settings.Palette, ditherer)
+		} else {
+			palette := settings.imagePalette(inData, inImage)
+			tiled, tiles, err = ApplyTilePalettes(ctx, inImage, palette, *settings.Tiles, settings.Palette, ditherer)
+		}
+		if err == nil && settings.TileJSON != "" {
+			if err := WriteTilePalettesToFile(tiles, settings.TileJSON); err != nil {
+				return fmt.Errorf("saving tile palettes: %w", err)
+			}
+		}
+		if trueColor, ok := tiled.(*image.RGBA); ok {
+			if settings.KeepMetadata && exif != nil {
+				chunks = append(chunks, PNGChunk{"eXIf", exif})
+			}
+			return settings.writeTrueColorCells(srcFilepath, outFilepath, format, inImage, trueColor, tiles.Palette, chunks, start)
+		}
+		outImage, _ = tiled.(*image.Paletted)
+	} else {
+		palette := settings.imagePalette(inData, inImage)
+		if err = ctx.Err(); err == nil {
+			outImage, err = ApplyPalette(ctx, inImage, palette, settings.Palette, ditherer)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if settings.Despeckle {
+		var replaced int
+		outImage, replaced = Despeckle(outImage)
+		settings.logf("%s: %d isolated pixels replaced", srcFilepath, replaced)
+	}
+	if settings.Outline > 0 {
+		snapped := SnapOutlines(outImage, inImage, settings.Outline, settings.Palette.AlphaThreshold, settings.Dither.Metric, settings.Dither.Threads)
+		settings.logf("%s: %d outline pixels snapped", srcFilepath, snapped)
+	}
+	settings.logf("%d palette colors", len(outImage.Palette))
+	if format == "png" {
+		outImage = SortPalette(outImage, settings.PNGOrder)
+	}
+
+	var metrics QualityMetrics
+	if settings.Report || settings.ReportJSON || settings.JSON {
+		metrics, err = CompareImages(inImage, outImage)
+		if err != nil {
+			return err
+		}
+	}
+	if settings.Report || settings.ReportJSON {
+		if err := WriteQualityReport(os.Stderr, srcFilepath, metrics, settings.ReportJSON); err != nil {
+			return err
+		}
+	}
+	if err := settings.writeUsageStats(srcFilepath, outImage, true); err != nil {
+		return err
+	}
+
+	// Save the palette.
+	if settings.SavePalette != "" {
+		err = WritePaletteToFile(PaletteColors(outImage.Palette), settings.SavePalette)
+		if err != nil {
+			return fmt.Errorf("saving palette: %w", err)
+		}
+	}
+	if err := settings.writeSwatch(PaletteColors(outImage.Palette)); err != nil {
+		return err
+	}
+
+	// Draw the comparison image.
+	if settings.Compare != "" {
+		comparison, err := ComparisonImage(inImage, outImage, settings.CompareHeatmap)
+		if err == nil {
+			err = WriteImageToFilePath(comparison, settings.Compare)
+		}
+		if err != nil {
+			return fmt.Errorf("writing comparison image: %w", err)
+		}
+	}
+
+	if settings.Preview {
+		if err := WriteTerminalPreview(os.Stderr, outImage, settings.PreviewWidth, TrueColorTerminal()); err != nil {
+			return err
+		}
+	}
+
+	outImage = ScaleUp(outImage, settings.ScaleUp)
+
+	// The pixels out of the mask are put back.
+	var written image.Image = outImage
+	if settings.Mask != nil {
+		written = ApplyRegionMask(inImage, outImage, *settings.Mask)
+	}
+
+	// Write the resulting image to a file.
+	encodeStart := time.Now()
+	if settings.KeepMetadata && exif != nil {
+		chunks = append(chunks, PNGChunk{"eXIf", exif})
+	}
+	if len(chunks) > 0 && format == "png" {
+		err = WritePNGWithChunks(written, outFilepath, chunks)
+	} else if IsExportFormat(format) {
+		export := settings.Export
+		if export.Name == "" {
+			export.Name = ExportNameFromFilePath(outFilepath)
+		}
+		err = WriteImageWithEncoder(outImage, outFilepath, export.Encoder(format))
+	} else {
+		err = WriteImageToFile(written, outFilepath, format)
+	}
+	if err != nil {
+		return fmt.Errorf("writing output image: %w", err)
+	}
+	settings.Timings.Add("encode", time.Since(encodeStart))
+	settings.logf("%s: written as %s", outFilepath, format)
+
+	result := NewRunResult(srcFilepath, outFilepath, format, bounds.Dx(), bounds.Dy(), PaletteColors(outImage.Palette))
+	result.Quality = &metrics
+	return settings.writeResult(result, start)
+}
+
+//
+// 			Image processing functions.
+//
+
+// TransformImage is the image processing function of this file.
+// The original image is not modified; a new, modified copy of it is created and returned.
+// The result is a paletted image so that it can be saved as an indexed image file.
+func TransformImage(img image.Image, paletteMaxSize int, paletteOpts PaletteOptions, ditherer Ditherer) (*image.Paletted, error) {
+	return QuantizeImageContext(context.Background(), img, paletteMaxSize, paletteOpts, ditherer)
+}
+
+// QuantizeImageContext is TransformImage, but it can be canceled through <ctx>,
+// e.g. with a timeout. It then returns ctx.Err() as soon as possible.
+func QuantizeImageContext(ctx context.Context, img image.Image, paletteMaxSize int, paletteOpts PaletteOptions, ditherer Ditherer) (*image.Paletted, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// We first extract a color palette from the source image.
+	palette := PaletteFromImage(img, paletteMaxSize, paletteOpts)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// We then apply the dithering with this color palette.
+	return ApplyPalette(ctx, img, palette, paletteOpts, ditherer)
+}
+
+//
+// 			Image functions.
+//
+
+// PixelColor returns the color of the pixel located at column x and row y in a given image.
+// The color is premultiplied by its alpha value.
+// The pixels of the most common image types (RGBA, NRGBA and the YCbCr images decoded from JPEG files)
+// are read directly from their buffers, which is much faster than going through the image.Image interface.
+// The channels of 16-bit images are rounded to 8 bits.
+func PixelColor(img image.Image, x, y int) color.RGBA {
+	if !(image.Point{x, y}).In(img.Bounds()) {
+		return color.RGBA{}
+	}
+
+	switch img := img.(type) {
+	case *image.RGBA:
+		i := img.PixOffset(x, y)
+		return color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+	case *image.NRGBA:
+		i := img.PixOffset(x, y)
+		return nrgbaColor(img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3])
+	case *image.YCbCr:
+		yi, ci := img.YOffset(x, y), img.COffset(x, y)
+		return ycbcrColor(img.Y[yi], img.Cb[ci], img.Cr[ci])
+	case opaqueImage:
+		return Opaque(PixelColor(img.Image, x, y))
+	case grayImage:
+		return Gray(PixelColor(img.Image, x, y), img.linear)
+	case ditheredDeepImage:
+		return ditheredDeepColor(img.Image, x, y)
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return deepPixelColor(img, x, y)
+	case *image.CMYK:
+		i := img.PixOffset(x, y)
+		return cmykColor(img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3])
+	}
+
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
+
+// nrgbaColor premultiplies a non-premultiplied color exactly as color.RGBAModel does,
+// without going through the color.Color interface.
+func nrgbaColor(r, g, b, a uint8) color.RGBA {
+	switch a {
+	case 255:
+		return color.RGBA{r, g, b, a}
+	case 0:
+		return color.RGBA{}
+	}
+
+	r32, g32, b32, a32 := color.NRGBA{R: r, G: g, B: b, A: a}.RGBA()
+	return color.RGBA{uint8(r32 >> 8), uint8(g32 >> 8), uint8(b32 >> 8), uint8(a32 >> 8)}
+}
+
+// ycbcrColor converts a YCbCr color to RGB exactly as color.RGBAModel does,
+// without going through the color.Color interface.
+func ycbcrColor(y, cb, cr uint8) color.RGBA {
+	r, g, b, _ := color.YCbCr{Y: y, Cb: cb, Cr: cr}.RGBA()
+	return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+}
+
+//
+// 			Palette functions.
+//
+
+// MaxPaletteSize is the maximum number of colors in a palette.
+// Paletted images store their color indices as bytes, hence the limit.
+const MaxPaletteSize = 256
+
+// PaletteOptions gathers the settings of the palette generation.
+type PaletteOptions struct {
+	// Linear makes the pixel colors averaged in linear light instead of sRGB.
+	// Averaging sRGB values gives colors which are too dark.
+	Linear bool
+	// AlphaThreshold is the alpha value below which pixels are transparent; 0 disables transparency.
+	// A palette entry is reserved for the transparent pixels (see ApplyPalette).
+	AlphaThreshold uint8
+	// AlphaDither, if not nil, dithers the alpha channel of the translucent pixels, whose alpha is at least AlphaThreshold
+	// but below 255, against the transparent palette entry, instead of making them opaque: the more transparent a pixel,
+	// the more likely it is to become transparent (see ApplyPalette).
+	AlphaDither Ditherer
+	// Alpha4D makes the palette generated in the 4D RGBA space, so that it can contain
+	// translucent colors. The colors must then be matched with RGBAMetric.
+	Alpha4D bool
+	// Quantizer is the algorithm generating the palette; nil means MedianCutQuantizer.
+	Quantizer Quantizer
+	// Metric is the color metric of the nearest color search; nil means RGBMetric.
+	// Some algorithms generate the palette in its color space (see SpaceMetric).
+	Metric ColorMetric
+	// Fixed is a palette used as is instead of generating one from the image, e.g. a built-in palette (see NamedPalette).
+	Fixed []color.RGBA
+	// Keep are colors always put first in the palette, e.g. brand colors, the other slots being generated
+	// from the pixels of other colors (see KeepColors). The pixels of these colors are mapped to them exactly.
+	Keep []color.RGBA
+	// Grayscale makes the pixels converted to gray, and the palette a ramp of gray levels (see GrayRamp).
+	// The image must then be dithered by a GrayDitherer.
+	Grayscale bool
+	// HistogramBits makes the pixel colors counted in a histogram keeping this number of bits per channel
+	// (see Histogram), instead of being collected in a slice; 0 disables the histogram.
+	HistogramBits int
+	// Sampling selects the pixels the palette is generated from; the zero value selects all of them.
+	Sampling Sampling
+	// Weights, if not nil, makes some pixels count more than others in the palette generation,
+	// e.g. the pixels of a region of interest.
+	Weights PixelWeights
+	// Exact makes the distinct pixel colors used as they are when they fit in the palette,
+	// and the image then mapped without dithering (see ExactPaletted).
+	Exact bool
+	// Refine makes the duplicated colors of a generated palette removed, and their slots
+	// given to other colors (see RefinePalette).
+	Refine bool
+	// MergeDeltaE is the CIE 1976 ΔE below which the colors of a refined palette are merged; 0 disables merging.
+	MergeDeltaE float64
+	// ColorblindDeltaE, if positive, is the CIE 1976 ΔE the generated palette colors are kept apart by as seen
+	// with protanopia and with deuteranopia (see SeparateForColorblindness).
+	ColorblindDeltaE float64
+	// Progress is notified of the start and the end of the "palette" phase, if not nil.
+	Progress ProgressFunc
+	// Histograms, if not nil, keeps the histograms of the images, e.g. to generate several palettes from one image.
+	Histograms *HistogramCache
+}
+
+// PaletteFromImage generates a color palette from a given iamge.
+// The number of colors in the palette is at most paletteMaxSize,
+// minus one if the image has transparent pixels (see ApplyPalette).
+// The palette can contain duplicated colors however, unless opts.Refine is set.
+// The algorithm is given by opts.Quantizer.
+func PaletteFromImage(img image.Image, paletteMaxSize int, opts PaletteOptions) []color.RGBA {
+	if opts.Fixed != nil {
+		return opts.Fixed
+	}
+
+	if HasTransparentPixels(img, opts.TransparencyThreshold()) {
+		paletteMaxSize--
+	}
+
+	if opts.HistogramBits > 0 {
+		if opts.Progress != nil {
+			opts.Progress("histogram", 0)
+		}
+		h := opts.Histograms.Histogram(img, opts)
+		if opts.Progress != nil {
+			opts.Progress("histogram", 1)
+		}
+		return PaletteFromHistogram(h, paletteMaxSize, opts)
+	}
+
+	return PaletteFromPixels(PalettePixels(img, opts), paletteMaxSize, opts)
+}
+
+// PaletteFromImages generates one color palette from the pixels of several images, e.g. the frames of an animation,
+// as PaletteFromImage does. The palette has at most paletteMaxSize colors, minus one if an image has transparent pixels.
+func PaletteFromImages(imgs []image.Image, paletteMaxSize int, opts PaletteOptions) []color.RGBA {
+	if opts.Fixed != nil {
+		return opts.Fixed
+	}
+
+	var pixels []color.RGBA
+	histogram := NewHistogram(opts.HistogramBits)
+	size := paletteMaxSize
+	if opts.Progress != nil && opts.HistogramBits > 0 {
+		opts.Progress("histogram", 0)
+	}
+	for _, img := range imgs {
+		if opts.HistogramBits > 0 {
+			AddToHistogram(histogram, img, opts)
+		} else {
+			pixels = append(pixels, PalettePixels(img, opts)...)
+		}
+		if size == paletteMaxSize && HasTransparentPixels(img, opts.TransparencyThreshold()) {
+			size--
+		}
+	}
+
+	if opts.HistogramBits > 0 {
+		if opts.Progress != nil {
+			opts.Progress("histogram", 1)
+		}
+		return PaletteFromHistogram(histogram, size, opts)
+	}
+	return PaletteFromPixels(pixels, size, opts)
+}
+
+// PaletteFromPixels generates a color palette from a slice of pixel colors, as PaletteFromImage does.
+// The pixels may come from several images (see PalettePixels); the slice may get reordered in place.
+func PaletteFromPixels(pixels []color.RGBA, paletteMaxSize int, opts PaletteOptions) []color.RGBA {
+	if opts.Fixed != nil {
+		return opts.Fixed
+	}
+	if minDeltaE := opts.ColorblindDeltaE; minDeltaE > 0 {
+		opts.ColorblindDeltaE = 0
+		return SeparateForColorblindness(PaletteFromPixels(pixels, paletteMaxSize, opts), minDeltaE, opts.Keep)
+	}
+	if opts.Keep != nil {
+		return KeepColors(paletteMaxSize, opts, func(size int, opts PaletteOptions) []color.RGBA {
+			return PaletteFromPixels(pixels, size, opts)
+		})
+	}
+
+	if opts.Progress != nil {
+		opts.Progress("palette", 0)
+		defer opts.Progress("palette", 1)
+	}
+
+	// Adjust some input here.
+	paletteMaxSize = ClampBelowInt(paletteMaxSize, 2)
+	paletteMaxSize = ClampAboveInt(paletteMaxSize, MaxPaletteSize)
+
+	// A fully transparent image still needs one color.
+	if len(pixels) == 0 {
+		return []color.RGBA{{0, 0, 0, 255}}
+	}
+
+	// The colors of an image which has few of them are kept as they are.
+	if opts.Exact {
+		if colors, ok := DistinctColors(pixels, paletteMaxSize); ok {
+			return colors
+		}
+	}
+
+	// A grayscale image only needs gray levels.
+	if opts.Grayscale {
+		return GrayRamp(paletteMaxSize)
+	}
+
+	quantizer := opts.Quantizer
+	if quantizer == nil {
+		quantizer = MedianCutQuantizer{}
+	}
+	palette := quantizer.Palette(pixels, paletteMaxSize, opts)
+
+	if opts.Refine {
+		palette = RefinePalette(palette, pixels, opts.MergeDeltaE)
+	}
+
+	return palette
+}
+
+// MeanColorOfRange computes the mean color of a range of colors stored in a slice.
+// The alpha channel is averaged too (see meanAlpha).
+func MeanColorOfRange(pixels []color.RGBA, begin, end int) color.RGBA {
+	r, g, b, a := 0., 0., 0., 0.
+	opaque := true
+	for j := begin; j < end; j++ {
+		r += float64(pixels[j].R)
+		g += float64(pixels[j].G)
+		b += float64(pixels[j].B)
+		a += float64(pixels[j].A)
+		opaque = opaque && pixels[j].A == 255
+	}
+
+	n := float64(end - begin)
+
+	return color.RGBA{
+		uint8(r / n),
+		uint8(g / n),
+		uint8(b / n),
+		meanAlpha(a, n, opaque),
+	}
+}
+
+// MeanColorOfRangeLinear computes the mean color of a range of colors stored in a slice,
+// like MeanColorOfRange, but the colors are averaged in linear light.
+// The alpha channel is averaged too (see meanAlpha); the mean color channels are clamped to it.
+func MeanColorOfRangeLinear(pixels []color.RGBA, begin, end int) color.RGBA {
+	r, g, b, a := 0., 0., 0., 0.
+	opaque := true
+	for j := begin; j < end; j++ {
+		r += SRGBToLinear(pixels[j].R)
+		g += SRGBToLinear(pixels[j].G)
+		b += SRGBToLinear(pixels[j].B)
+		a += float64(pixels[j].A)
+		opaque = opaque && pixels[j].A == 255
+	}
+
+	n := float64(end - begin)
+	alpha := meanAlpha(a, n, opaque)
+
+	return color.RGBA{
+		ClampU8(LinearToSRGB(r/n), 0, alpha),
+		ClampU8(LinearToSRGB(g/n), 0, alpha),
+		ClampU8(LinearToSRGB(b/n), 0, alpha),
+		alpha,
+	}
+}
+
+// RedSortedImagePixels collects and sorts all the pixels colors in a given image.
+// The colors are sorted in ascending order with respect to the red channel.
+func RedSortedImagePixels(img image.Image) []color.RGBA {
+	pixels := ImagePixels(img)
+	SortByRed(pixels)
+
+	return pixels
+}
+
+// SortByRed sorts colors in ascending order with respect to the red channel.
+func SortByRed(pixels []color.RGBA) {
+	sort.SliceStable(pixels, func(i, j int) bool { return pixels[i].R < pixels[j].R })
+}
+
+// ImagePixels collects all the pixels colors in a given image, row by row.
+func ImagePixels(img image.Image) []color.RGBA {
+	pixels := make([]color.RGBA, 0, img.Bounds().Dx()*img.Bounds().Dy())
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			pixels = append(pixels, PixelColor(img, x, y))
+		}
+	}
+
+	return pixels
+}
+
+// NearestColor returns the palette color that is the closest to a given color.
+// The distance in the color space is the Euclidean distance.
+func NearestColor(c color.RGBA, palette []color.RGBA) color.RGBA {
+	return palette[NearestColorIndex(c, palette)]
+}
+
+// NearestColorIndex returns the index of the palette color that is the closest to a given color.
+// The distance in the color space is the Euclidean distance.
+func NearestColorIndex(c color.RGBA, palette []color.RGBA) int {
+	minD := ColorDistance(c, palette[0])
+	nearest := 0
+
+	for i := 1; i < len(palette); i++ {
+		d := ColorDistance(c, palette[i])
+		if d < minD {
+			minD = d
+			nearest = i
+		}
+	}
+
+	return nearest
+}
+
+// PaletteColors converts a color.Palette, the type used by image.Paletted, into a palette.
+// It is the inverse of ColorPalette.
+func PaletteColors(p color.Palette) []color.RGBA {
+	palette := make([]color.RGBA, len(p))
+	for i, c := range p {
+		palette[i] = color.RGBAModel.Convert(c).(color.RGBA)
+	}
+
+	return palette
+}
+
+// ColorPalette converts a palette into a color.Palette, the type used by image.Paletted.
+func ColorPalette(palette []color.RGBA) color.Palette {
+	p := make(color.Palette, len(palette))
+	for i, c := range palette {
+		p[i] = c
+	}
+
+	return p
+}
@@ -0,0 +1,139 @@
+package quantize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+//
+// 			Pixel sampling.
+//
+
+// Sampling modes: the palette of a huge image can be generated from a part of its pixels only.
+// The whole image is still mapped to the palette.
+const (
+	// SampleAll keeps every pixel.
+	SampleAll = ""
+	// SampleEvery keeps one pixel out of N, in raster order.
+	SampleEvery = "every"
+	// SampleRandom keeps N pixels picked at random, always the same ones for a given image size and seed.
+	SampleRandom = "random"
+	// SampleProxy keeps the pixels of a proxy image, downscaled so that its width and height are at most N.
+	SampleProxy = "proxy"
+)
+
+// Sampling selects the pixels of an image from which its palette is generated.
+// The zero value keeps every pixel.
+type Sampling struct {
+	Mode string
+	N    int
+	// Seed is the seed of the SampleRandom mode; 0 means DefaultSeed.
+	Seed int64
+}
+
+// DefaultSeed is the seed of the random number generators when none is given.
+const DefaultSeed = 1
+
+// ParseSampling parses a sampling given as "mode:N", e.g. "every:4", "random:100000" or "proxy:1024".
+// An empty string or "all" keeps every pixel.
+func ParseSampling(s string) (Sampling, error) {
+	if s == "" || s == "all" {
+		return Sampling{}, nil
+	}
+
+	mode, value, _ := strings.Cut(s, ":")
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return Sampling{}, fmt.Errorf("invalid sampling %q (a positive number expected after %q)", s, mode+":")
+	}
+	switch mode {
+	case SampleEvery, SampleRandom, SampleProxy:
+		return Sampling{Mode: mode, N: n}, nil
+	}
+
+	return Sampling{}, fmt.Errorf("unknown sampling %q (available: [all %s:N %s:N %s:N])", mode, SampleEvery, SampleRandom, SampleProxy)
+}
+
+// Pixels collects the colors of the sampled pixels of an image.
+func (s Sampling) Pixels(img image.Image) []color.RGBA {
+	var pixels []color.RGBA
+	s.Each(img, func(c color.RGBA) {
+		pixels = append(pixels, c)
+	})
+
+	return pixels
+}
+
+// Each calls <fn> with the color of each sampled pixel of an image, without collecting them.
+func (s Sampling) Each(img image.Image, fn func(c color.RGBA)) {
+	s.EachAt(img, func(x, y int, c color.RGBA) { fn(c) })
+}
+
+// EachAt is Each, but <fn> also gets the coordinates of each sampled pixel.
+// In proxy mode, they are the coordinates of the top left pixel of the block of the image standing for the proxy pixel.
+func (s Sampling) EachAt(img image.Image, fn func(x, y int, c color.RGBA)) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	at := func(i int) {
+		x, y := b.Min.X+i%w, b.Min.Y+i/w
+		fn(x, y, PixelColor(img, x, y))
+	}
+
+	switch s.Mode {
+	case SampleEvery:
+		for i := 0; i < w*h; i += s.N {
+			at(i)
+		}
+		return
+	case SampleRandom:
+		if s.N >= w*h {
+			break
+		}
+		// The seed is fixed so that the palette of an image is always the same.
+		seed := s.Seed
+		if seed == 0 {
+			seed = DefaultSeed
+		}
+		r := rand.New(rand.NewSource(seed))
+		for k := 0; k < s.N; k++ {
+			at(r.Intn(w * h))
+		}
+		return
+	case SampleProxy:
+		proxy := ProxyImage(img, s.N)
+		pb := proxy.Bounds()
+		for y := pb.Min.Y; y < pb.Max.Y; y++ {
+			for x := pb.Min.X; x < pb.Max.X; x++ {
+				fn(b.Min.X+(x-pb.Min.X)*w/pb.Dx(), b.Min.Y+(y-pb.Min.Y)*h/pb.Dy(), PixelColor(proxy, x, y))
+			}
+		}
+		return
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			fn(x, y, PixelColor(img, x, y))
+		}
+	}
+}
+
+// ProxyImage downscales an image so that its width and height are at most <size>.
+// Each pixel of the proxy is the mean color of a square block of pixels (see ScaleDown).
+// The image is returned as is if it is small enough.
+func ProxyImage(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	longest := b.Dx()
+	if b.Dy() > longest {
+		longest = b.Dy()
+	}
+	factor := (longest + size - 1) / size
+	if factor <= 1 {
+		return img
+	}
+
+	return ScaleDown(img, factor, ScaleBox, false)
+}
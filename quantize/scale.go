@@ -0,0 +1,107 @@
+package quantize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+//
+// 			Scaling.
+//
+
+// Downscaling filters, see ScaleDown.
+const (
+	// ScaleBox makes each pixel the mean color of the block of pixels it replaces.
+	ScaleBox = "box"
+	// ScaleNearest makes each pixel the color of the center pixel of the block it replaces, which keeps the colors sharp.
+	ScaleNearest = "nearest"
+)
+
+// CheckScaleFilter returns an error if a downscaling filter is unknown.
+func CheckScaleFilter(filter string) error {
+	if filter != ScaleBox && filter != ScaleNearest {
+		return fmt.Errorf("unknown scaling filter %q (available: [%s %s])", filter, ScaleBox, ScaleNearest)
+	}
+
+	return nil
+}
+
+// CheckScaleFactor returns an error if a scaling factor is not a positive integer.
+func CheckScaleFactor(factor int) error {
+	if factor < 1 {
+		return fmt.Errorf("invalid scaling factor %d (a positive integer expected)", factor)
+	}
+
+	return nil
+}
+
+// ScaleDown divides the width and the height of an image by an integer factor (rounding up), with a given filter.
+// The box filter averages the colors in linear light if <linear> is set.
+// The image is returned as is for factors below 2.
+func ScaleDown(img image.Image, factor int, filter string, linear bool) image.Image {
+	if factor <= 1 {
+		return img
+	}
+
+	mean := MeanColorOfRange
+	if linear {
+		mean = MeanColorOfRangeLinear
+	}
+
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, (b.Dx()+factor-1)/factor, (b.Dy()+factor-1)/factor))
+	block := make([]color.RGBA, 0, factor*factor)
+	for py := 0; py < out.Rect.Dy(); py++ {
+		for px := 0; px < out.Rect.Dx(); px++ {
+			x0, y0 := b.Min.X+px*factor, b.Min.Y+py*factor
+			if filter == ScaleNearest {
+				x := ClampAboveInt(x0+factor/2, b.Max.X-1)
+				y := ClampAboveInt(y0+factor/2, b.Max.Y-1)
+				out.SetRGBA(px, py, PixelColor(img, x, y))
+				continue
+			}
+
+			block = block[:0]
+			for y := y0; y < y0+factor && y < b.Max.Y; y++ {
+				for x := x0; x < x0+factor && x < b.Max.X; x++ {
+					block = append(block, PixelColor(img, x, y))
+				}
+			}
+			out.SetRGBA(px, py, mean(block, 0, len(block)))
+		}
+	}
+
+	return out
+}
+
+// ScaleDownRect returns the rectangle of the pixels of an image scaled down by ScaleDown which cover a rectangle
+// of the image, the partly covered pixels included. The coordinates are relative to the top left corner of the image.
+func ScaleDownRect(r image.Rectangle, factor int) image.Rectangle {
+	if factor <= 1 {
+		return r
+	}
+
+	return image.Rect(r.Min.X/factor, r.Min.Y/factor, (r.Max.X+factor-1)/factor, (r.Max.Y+factor-1)/factor)
+}
+
+// ScaleUp multiplies the width and the height of a paletted image by an integer factor,
+// repeating each pixel (nearest neighbor), so that the palette is kept.
+// The image is returned as is for factors below 2.
+func ScaleUp(img *image.Paletted, factor int) *image.Paletted {
+	if factor <= 1 {
+		return img
+	}
+
+	b := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor), img.Palette)
+	for y := 0; y < out.Rect.Dy(); y++ {
+		row := out.Pix[y*out.Stride : y*out.Stride+out.Rect.Dx()]
+		src := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y/factor):]
+		for x := range row {
+			row[x] = src[x/factor]
+		}
+	}
+
+	return out
+}
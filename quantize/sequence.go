@@ -0,0 +1,165 @@
+package quantize
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+//
+// 			Image sequences.
+//
+
+// An image sequence is a set of numbered files designated by a printf-like pattern, e.g. "frame_%04d.png",
+// as written and read by ffmpeg. Its frames are quantized with one palette, generated from a sample of
+// the frames, so that the colors do not flicker from frame to frame.
+
+// sequenceVerb matches the number verb of a sequence pattern: %d, or %0Nd for numbers padded to N digits.
+var sequenceVerb = regexp.MustCompile(`%(0\d+)?d`)
+
+// DefaultSequenceSamples is the default number of frames a sequence palette is generated from.
+const DefaultSequenceSamples = 16
+
+// sequenceMaxStart is the largest number a sequence may start from, as with ffmpeg.
+const sequenceMaxStart = 4
+
+// IsSequenceInput reports whether an input filepath is the pattern of an image sequence,
+// i.e. whether it has one %d or %0Nd verb.
+func IsSequenceInput(srcFilepath string) bool {
+	return len(sequenceVerb.FindAllString(srcFilepath, -1)) == 1
+}
+
+// SequenceFiles lists the files of an image sequence: the numbers from the first existing one,
+// from 0 to sequenceMaxStart, to the last one before a missing number.
+// It also returns the first number.
+func SequenceFiles(pattern string) ([]string, int, error) {
+	if !IsSequenceInput(pattern) {
+		return nil, 0, fmt.Errorf("%q is not an image sequence pattern (e.g. frame_%%04d.png)", pattern)
+	}
+
+	exists := func(n int) bool {
+		info, err := os.Stat(sequencePath(pattern, n))
+		return err == nil && !info.IsDir()
+	}
+
+	start := 0
+	for start <= sequenceMaxStart && !exists(start) {
+		start++
+	}
+	var files []string
+	for n := start; exists(n); n++ {
+		files = append(files, sequencePath(pattern, n))
+	}
+	if len(files) == 0 {
+		return nil, 0, fmt.Errorf("no file of the sequence %q (from %d to %d)", pattern, 0, sequenceMaxStart)
+	}
+
+	return files, start, nil
+}
+
+// sequencePath returns the filepath of a number of a sequence pattern.
+// The other % signs of the pattern are kept as they are.
+func sequencePath(pattern string, n int) string {
+	return sequenceVerb.ReplaceAllStringFunc(pattern, func(verb string) string {
+		return fmt.Sprintf(verb, n)
+	})
+}
+
+// SequenceOutputFilepath computes the output filepath of the frame <n> of a sequence whose input file is <srcFilepath>.
+// If <out> is a sequence pattern, the frame gets its number; otherwise <out> is a directory
+// where the frame gets the input file name with the extension of the output format.
+func SequenceOutputFilepath(srcFilepath, out string, n int, format string) string {
+	if IsSequenceInput(out) {
+		return sequencePath(out, n)
+	}
+
+	name := filepath.Base(srcFilepath)
+	return filepath.Join(out, name[:len(name)-len(filepath.Ext(name))]+"."+format)
+}
+
+// SampleFiles returns at most <count> files evenly spaced in a list, the first and the last ones included.
+// All the files are returned if <count> is not positive.
+func SampleFiles(files []string, count int) []string {
+	if count <= 0 || count >= len(files) {
+		return files
+	}
+	if count == 1 {
+		return files[:1]
+	}
+
+	samples := make([]string, count)
+	for i := range samples {
+		samples[i] = files[i*(len(files)-1)/(count-1)]
+	}
+
+	return samples
+}
+
+// ProcessSequence transforms the frames of an image sequence (see SequenceFiles) with one palette, generated
+// from <samples> frames evenly spaced in the sequence, unless the settings give one.
+// The frames are numbered from 0 for the temporal offset of the ditherer (see AnimationOptions).
+// The output filepaths are computed by SequenceOutputFilepath. Up to <jobs> frames are processed concurrently,
+// and the failures are handled as ProcessBatch does.
+func ProcessSequence(ctx context.Context, pattern, out string, settings Settings, jobs, samples int) error {
+	files, start, err := SequenceFiles(pattern)
+	if err != nil {
+		return err
+	}
+	if IsStdio(out) {
+		return fmt.Errorf("sequence mode needs an output sequence pattern or directory")
+	}
+	if settings.Animation.Reuse > 0 {
+		return fmt.Errorf("-temporal-reuse does not support image sequences, whose frames are quantized independently")
+	}
+	dir, outFormat := out, ""
+	if IsSequenceInput(out) {
+		dir = filepath.Dir(out)
+		if outFormat, err = FormatFromFilePath(out); settings.Format == "" && err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	settings.logf("%s: %d frames, from %d", pattern, len(files), start)
+
+	if settings.Palette.Fixed == nil {
+		var frames []image.Image
+		for _, path := range SampleFiles(files, samples) {
+			img, err := GetImageFromFilePath(path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if cmyk, ok := img.(*image.CMYK); ok {
+				img = CMYKToRGB(cmyk, settings.InvertCMYK, settings.Dither.Threads)
+			}
+			if settings.Background != nil {
+				img = FlattenImage(img, *settings.Background)
+			}
+			img = ApplyPrefilters(img, settings.Prefilters, settings.Dither.Threads)
+			frames = append(frames, img)
+		}
+		settings = settings.withAutoPaletteSize(pattern, frames)
+		settings.Palette.Fixed = PaletteFromImages(frames, settings.PaletteMaxSize, settings.Palette)
+		settings.logf("%s: %d palette colors generated from %d frames", pattern, len(settings.Palette.Fixed), len(frames))
+	}
+
+	numbers := make(map[string]int, len(files))
+	for i, path := range files {
+		numbers[path] = start + i
+	}
+	return processFiles(ctx, files, jobs, func(path string) (string, Settings) {
+		frameSettings := settings
+		frameSettings.Frame = numbers[path] - start
+		if frameSettings.Format == "" {
+			frameSettings.Format = outFormat
+			if !IsSequenceInput(out) {
+				frameSettings.Format = WritableFormat(path)
+			}
+		}
+		return SequenceOutputFilepath(path, out, numbers[path], frameSettings.Format), frameSettings
+	})
+}
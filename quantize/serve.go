@@ -0,0 +1,162 @@
+package quantize
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+)
+
+//
+// 			HTTP service.
+//
+
+// ServeOptions are the limits of the HTTP service, see NewQuantizeHandler.
+type ServeOptions struct {
+	// MaxBodyBytes is the maximum size of an uploaded image file.
+	MaxBodyBytes int64
+	// MaxPixels is the maximum number of pixels of an uploaded image, which is checked before decoding it.
+	MaxPixels int
+	// MaxJobs is the maximum number of images quantized at once; the other requests wait for their turn.
+	MaxJobs int
+	// Threads is the maximum number of goroutines working on an image; 0 means one per CPU.
+	Threads int
+	// ErrorLog receives the errors which cannot be reported to the clients, e.g. the responses failing to be sent;
+	// nil means the standard logger.
+	ErrorLog *log.Logger
+}
+
+// NewQuantizeHandler returns the handler of the POST /quantize endpoint of the HTTP service.
+// The image file is the request body, or the "image" field of a multipart form.
+// The query parameters are the settings of the quantization (see SettingsFromParams).
+// The invalid requests get a 4xx status, the canceled ones a 503 status and the internal failures a 500 status.
+func NewQuantizeHandler(opts ServeOptions) http.Handler {
+	jobs := make(chan struct{}, ClampBelowInt(opts.MaxJobs, 1))
+	logger := opts.ErrorLog
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		settings, format, err := SettingsFromParams(r.URL.Query(), opts.Threads)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, err := readUploadedImage(r, opts.MaxBodyBytes)
+		if err == errTooLarge {
+			http.Error(w, fmt.Sprintf("the image file is larger than %d bytes", opts.MaxBodyBytes), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("reading image: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		// The size is checked before decoding, so that small files of huge images are rejected.
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("decoding image: %v", err), http.StatusBadRequest)
+			return
+		}
+		if opts.MaxPixels > 0 && config.Width*config.Height > opts.MaxPixels {
+			http.Error(w, fmt.Sprintf("the image has more than %d pixels", opts.MaxPixels), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Wait for a free job slot, unless the client goes away.
+		// The decoded images are held by the jobs only, which bounds the memory use.
+		select {
+		case jobs <- struct{}{}:
+			defer func() { <-jobs }()
+		case <-r.Context().Done():
+			http.Error(w, "request canceled", http.StatusServiceUnavailable)
+			return
+		}
+
+		img, err := DecodeImage(data)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("decoding image: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		ditherer, err := settings.newDitherer()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out, err := QuantizeImageContext(r.Context(), img, settings.PaletteMaxSize, settings.Palette, ditherer)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, "request canceled", http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			logger.Printf("quantizing %s: %v", r.URL, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The image is encoded before the response is started, so that an encoding error still gets its status.
+		var encoded bytes.Buffer
+		if err := encoders[format](&encoded, out); err != nil {
+			logger.Printf("encoding %s: %v", r.URL, err)
+			http.Error(w, fmt.Sprintf("encoding image: %v", err), http.StatusInternalServerError)
+			return
+		}
+		contentType := mime.TypeByExtension("." + format)
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		if _, err := w.Write(encoded.Bytes()); err != nil {
+			logger.Printf("sending %s: %v", r.URL, err)
+		}
+	})
+}
+
+// errTooLarge is returned by readUploadedImage when the image file exceeds the size limit.
+var errTooLarge = errors.New("the image file is too large")
+
+// readUploadedImage reads the image file of a request, at most <maxBytes> long (no limit if not positive):
+// the "image" field of a multipart form, or else the request body.
+func readUploadedImage(r *http.Request, maxBytes int64) ([]byte, error) {
+	var body io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, fmt.Errorf("no \"image\" field in the form")
+			} else if err != nil {
+				return nil, err
+			}
+			if part.FormName() == "image" {
+				body = part
+				break
+			}
+		}
+	}
+
+	if maxBytes <= 0 {
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err == nil && int64(len(data)) > maxBytes {
+		err = errTooLarge
+	}
+
+	return data, err
+}
@@ -0,0 +1,55 @@
+package quantize
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQuantizeHandler(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	var upload bytes.Buffer
+	if err := png.Encode(&upload, img); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewQuantizeHandler(ServeOptions{MaxJobs: 1})
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, test := range []struct {
+		query  string
+		ctx    context.Context
+		status int
+	}{
+		{"pal=4&dither=floyd-steinberg", context.Background(), http.StatusOK},
+		{"pal=4&dither=unknown", context.Background(), http.StatusBadRequest},
+		{"pal=0", context.Background(), http.StatusBadRequest},
+		{"pal=4", canceled, http.StatusServiceUnavailable},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/quantize?"+test.query, bytes.NewReader(upload.Bytes())).WithContext(test.ctx)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d (%s), want %d", test.query, w.Code, bytes.TrimSpace(w.Body.Bytes()), test.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		if out, err := png.Decode(w.Body); err != nil {
+			t.Errorf("%s: %v", test.query, err)
+		} else if out.Bounds() != img.Bounds() {
+			t.Errorf("%s: bounds %v, want %v", test.query, out.Bounds(), img.Bounds())
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package quantize
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+//
+// 			Streaming processing.
+//
+
+// In streaming mode, the palette is generated from a histogram of the image (see PaletteHistogram),
+// then the image is dithered by bands of rows which are encoded as soon as they are ready.
+// Neither the pixels slice nor the whole output image are held in memory; only the decoded input image is,
+// unless it is a PNG, Netpbm or farbfeld file, which is then decoded by bands too (see StreamQuantizePNGFile).
+
+// StreamBandHeight is the number of rows dithered at once in streaming mode.
+const StreamBandHeight = 64
+
+// StreamQuantizePNG quantizes an image as QuantizeImageContext does, but writes the result as a PNG
+// to <w> band by band instead of returning it. It returns the palette of the result.
+// The palette is always generated from a histogram (6 bits per channel if paletteOpts.HistogramBits is 0),
+// and the error diffusion does not cross the band boundaries.
+// The dithering progress is reported to <progress>, if not nil.
+func StreamQuantizePNG(ctx context.Context, w io.Writer, img image.Image, paletteMaxSize int, paletteOpts PaletteOptions, ditherer Ditherer, progress ProgressFunc) ([]color.RGBA, error) {
+	if paletteOpts.HistogramBits == 0 {
+		paletteOpts.HistogramBits = 6
+	}
+	palette := PaletteFromImage(img, paletteMaxSize, paletteOpts)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// The transparent color is the last entry of the palette of every band which has transparent pixels.
+	outPalette := append([]color.RGBA(nil), palette...)
+	if HasTransparentPixels(img, paletteOpts.TransparencyThreshold()) {
+		outPalette = append(outPalette, TransparentColor)
+	}
+
+	b := img.Bounds()
+	enc, err := NewPNGRowWriter(w, b.Dx(), b.Dy(), ColorPalette(outPalette))
+	if err != nil {
+		return nil, err
+	}
+	rows := NewRowProgress(progress, "dither", b.Dy())
+
+	for minY := b.Min.Y; minY < b.Max.Y; minY += StreamBandHeight {
+		band := subImage(img, image.Rect(b.Min.X, minY, b.Max.X, ClampAboveInt(minY+StreamBandHeight, b.Max.Y)))
+		if err := writeBand(ctx, enc, band, palette, paletteOpts, ditherer); err != nil {
+			return nil, err
+		}
+		rows.Done(band.Bounds().Dy())
+	}
+
+	return outPalette, enc.Close()
+}
+
+// StreamQuantizePNGFile is StreamQuantizePNG for a PNG, Netpbm or farbfeld file which is never decoded as a whole:
+// its rows are decoded by bands twice (see RowReader), to generate the palette from their histogram then to dither them,
+// so that only a band of the input image is held in memory, whatever its size.
+// The pixels are composited over the <background> color, if not nil, as by FlattenImage.
+// The Sampling of paletteOpts applies to each band; the random and proxy samplings are not supported.
+func StreamQuantizePNGFile(ctx context.Context, w io.Writer, srcFilepath string, background *color.RGBA, paletteMaxSize int, paletteOpts PaletteOptions, ditherer Ditherer, progress ProgressFunc) ([]color.RGBA, error) {
+	if paletteOpts.Sampling.Mode == SampleRandom || paletteOpts.Sampling.Mode == SampleProxy {
+		return nil, fmt.Errorf("the %s sampling cannot be applied to an image decoded by bands", paletteOpts.Sampling.Mode)
+	}
+	if paletteOpts.HistogramBits == 0 {
+		paletteOpts.HistogramBits = 6
+	}
+	prepare := func(band image.Image) image.Image {
+		if background != nil {
+			return FlattenImage(band, *background)
+		}
+		return band
+	}
+
+	// The first pass counts the colors and finds out whether the image has transparent pixels.
+	if paletteOpts.Progress != nil {
+		paletteOpts.Progress("histogram", 0)
+	}
+	histogram := NewHistogram(paletteOpts.HistogramBits)
+	transparent := false
+	width, height, err := eachBand(ctx, srcFilepath, func(band *image.RGBA) error {
+		img := prepare(band)
+		transparent = transparent || HasTransparentPixels(img, paletteOpts.TransparencyThreshold())
+		if paletteOpts.Fixed == nil {
+			AddToHistogram(histogram, img, paletteOpts)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if paletteOpts.Progress != nil {
+		paletteOpts.Progress("histogram", 1)
+	}
+	size := paletteMaxSize
+	if transparent {
+		size--
+	}
+	palette := PaletteFromHistogram(histogram, size, paletteOpts)
+	outPalette := append([]color.RGBA(nil), palette...)
+	if transparent {
+		outPalette = append(outPalette, TransparentColor)
+	}
+
+	enc, err := NewPNGRowWriter(w, width, height, ColorPalette(outPalette))
+	if err != nil {
+		return nil, err
+	}
+	rows := NewRowProgress(progress, "dither", height)
+	_, _, err = eachBand(ctx, srcFilepath, func(band *image.RGBA) error {
+		if err := writeBand(ctx, enc, prepare(band), palette, paletteOpts, ditherer); err != nil {
+			return err
+		}
+		rows.Done(band.Rect.Dy())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return outPalette, enc.Close()
+}
+
+// eachBand decodes a PNG, Netpbm or farbfeld file by bands of StreamBandHeight rows (see NewRowReader),
+// and calls <fn> on each one. The band image is reused from call to call. It returns the size of the image.
+func eachBand(ctx context.Context, srcFilepath string, fn func(band *image.RGBA) error) (int, int, error) {
+	file, err := os.Open(srcFilepath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	r, err := NewRowReader(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	width, height := r.Size()
+	buffer := image.NewRGBA(image.Rect(0, 0, width, ClampAboveInt(StreamBandHeight, height)))
+	row := make([]color.RGBA, width)
+	for minY := 0; minY < height; minY += StreamBandHeight {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+
+		maxY := ClampAboveInt(minY+StreamBandHeight, height)
+		band := &image.RGBA{Pix: buffer.Pix, Stride: buffer.Stride, Rect: image.Rect(0, minY, width, maxY)}
+		for y := minY; y < maxY; y++ {
+			if err := r.ReadRow(row); err != nil {
+				return 0, 0, err
+			}
+			for x, c := range row {
+				band.SetRGBA(x, y, c)
+			}
+		}
+		if err := fn(band); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return width, height, nil
+}
+
+// writeBand dithers a band of an image with a palette, as ApplyPalette does, and writes its rows to a PNG.
+func writeBand(ctx context.Context, enc *PNGRowWriter, band image.Image, palette []color.RGBA, paletteOpts PaletteOptions, ditherer Ditherer) error {
+	out, err := ApplyPalette(ctx, band, palette, paletteOpts, ditherer)
+	if err != nil {
+		return err
+	}
+
+	for y := out.Rect.Min.Y; y < out.Rect.Max.Y; y++ {
+		i := out.PixOffset(out.Rect.Min.X, y)
+		if err := enc.WriteRow(out.Pix[i : i+out.Rect.Dx()]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// subImage returns the part of an image inside a rectangle, sharing its pixels.
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+
+	return boundedImage{img, r}
+}
+
+// boundedImage shows the part of an image inside a rectangle.
+type boundedImage struct {
+	image.Image
+	rect image.Rectangle
+}
+
+func (img boundedImage) Bounds() image.Rectangle {
+	return img.rect
+}
+
+//
+// 			Row by row PNG encoding.
+//
+
+// PNGRowWriter writes a paletted PNG image row by row, with the smallest possible bit depth.
+// The compressed rows are written in IDAT chunks of at most pngChunkSize bytes as soon as they are full.
+type PNGRowWriter struct {
+	w             io.Writer
+	width, height int
+	depth         int
+	rows          int
+	idat          *bytes.Buffer
+	zw            *zlib.Writer
+	line          []byte
+}
+
+// pngChunkSize is the size of the IDAT chunks written by a PNGRowWriter.
+const pngChunkSize = 1 << 16
+
+// NewPNGRowWriter writes the header of a paletted PNG image of a given size to <w>.
+// The palette must have at most 256 colors.
+func NewPNGRowWriter(w io.Writer, width, height int, palette color.Palette) (*PNGRowWriter, error) {
+	if len(palette) == 0 || len(palette) > MaxPaletteSize {
+		return nil, fmt.Errorf("a PNG palette must have 1 to %d colors, not %d", MaxPaletteSize, len(palette))
+	}
+
+	depth := 8
+	switch {
+	case len(palette) <= 2:
+		depth = 1
+	case len(palette) <= 4:
+		depth = 2
+	case len(palette) <= 16:
+		depth = 4
+	}
+
+	p := &PNGRowWriter{w: w, width: width, height: height, depth: depth, idat: &bytes.Buffer{}}
+	p.line = make([]byte, 1+(width*depth+7)/8)
+	p.zw = zlib.NewWriter(p.idat)
+
+	if _, err := io.WriteString(w, "\x89PNG\r\n\x1a\n"); err != nil {
+		return nil, err
+	}
+
+	var header [13]byte
+	binary.BigEndian.PutUint32(header[0:], uint32(width))
+	binary.BigEndian.PutUint32(header[4:], uint32(height))
+	header[8] = byte(depth)
+	header[9] = 3 // Paletted color type.
+	if err := writePNGChunk(w, "IHDR", header[:]); err != nil {
+		return nil, err
+	}
+
+	// The palette is not premultiplied; the alpha values are in the tRNS chunk, up to the last translucent color.
+	plte := make([]byte, 0, 3*len(palette))
+	var trns []byte
+	for i, c := range palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		plte = append(plte, n.R, n.G, n.B)
+		if n.A != 255 {
+			trns = trns[:0]
+			for j := 0; j < i; j++ {
+				trns = append(trns, color.NRGBAModel.Convert(palette[j]).(color.NRGBA).A)
+			}
+			trns = append(trns, n.A)
+		}
+	}
+	if err := writePNGChunk(w, "PLTE", plte); err != nil {
+		return nil, err
+	}
+	if len(trns) > 0 {
+		if err := writePNGChunk(w, "tRNS", trns); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// WriteRow writes the next row of the image, given as palette indices.
+func (p *PNGRowWriter) WriteRow(indices []uint8) error {
+	if p.rows == p.height {
+		return fmt.Errorf("too many PNG rows (%d expected)", p.height)
+	}
+	if len(indices) != p.width {
+		return fmt.Errorf("a PNG row must have %d pixels, not %d", p.width, len(indices))
+	}
+
+	// The rows are not filtered, as paletted images compress better without filters.
+	for i := range p.line {
+		p.line[i] = 0
+	}
+	for x, index := range indices {
+		bit := x * p.depth
+		p.line[1+bit/8] |= index << (8 - p.depth - bit%8)
+	}
+	if _, err := p.zw.Write(p.line); err != nil {
+		return err
+	}
+	p.rows++
+
+	return p.flush(pngChunkSize)
+}
+
+// flush writes the compressed data as IDAT chunks of <size> bytes, as long as there are enough.
+func (p *PNGRowWriter) flush(size int) error {
+	for p.idat.Len() >= size && p.idat.Len() > 0 {
+		if err := writePNGChunk(p.w, "IDAT", p.idat.Next(size)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close ends the image. All its rows must have been written.
+func (p *PNGRowWriter) Close() error {
+	if p.rows != p.height {
+		return fmt.Errorf("missing PNG rows (%d written, %d expected)", p.rows, p.height)
+	}
+	if err := p.zw.Close(); err != nil {
+		return err
+	}
+	if err := p.flush(pngChunkSize); err != nil {
+		return err
+	}
+	if err := p.flush(p.idat.Len()); err != nil {
+		return err
+	}
+
+	return writePNGChunk(p.w, "IEND", nil)
+}
+
+// writePNGChunk writes a PNG chunk: its length, its type, its data and their CRC.
+func writePNGChunk(w io.Writer, kind string, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], kind)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+
+	for _, b := range [][]byte{header[:], data, footer[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+//
+// 			Palette swatches.
+//
+
+// The swatch cells are SwatchCellSize pixels wide, or SwatchLabeledCellSize with the hex labels of the colors.
+const (
+	SwatchCellSize        = 32
+	SwatchLabeledCellSize = 64
+)
+
+// Swatch draws the colors of a palette as square cells, in rows of <columns> cells (all in one row if not positive),
+// for documentation or for sharing palettes with designers.
+// If <labels> is set, each cell gets the hex code of its color, in black or white whichever contrasts more.
+// The translucent colors are drawn as they are, with their alpha.
+func Swatch(palette []color.RGBA, columns int, labels bool) *image.RGBA {
+	if columns <= 0 || columns > len(palette) {
+		columns = ClampBelowInt(len(palette), 1)
+	}
+	rows := (len(palette) + columns - 1) / columns
+	size := SwatchCellSize
+	if labels {
+		size = SwatchLabeledCellSize
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, columns*size, ClampBelowInt(rows, 1)*size))
+	for i, c := range palette {
+		cell := image.Rect(0, 0, size, size).Add(image.Pt(i%columns*size, i/columns*size))
+		draw.Draw(img, cell, image.NewUniform(c), image.Point{}, draw.Src)
+
+		if labels {
+			ink := color.RGBA{0, 0, 0, 255}
+			if o := Opaque(c); c.A < 128 || luma(SRGBToLinear(o.R), SRGBToLinear(o.G), SRGBToLinear(o.B)) < 0.18 {
+				ink = color.RGBA{255, 255, 255, 255}
+			}
+			// The label is centered at the bottom of the cell.
+			label := strings.TrimPrefix(FormatHexColor(c), "#")
+			width := len(label) * glyphAdvance * labelScale
+			drawLabel(img, label, cell.Min.X+(size-width)/2, cell.Max.Y-(glyphHeight+2)*labelScale, ink)
+		}
+	}
+
+	return img
+}
+
+// The labels are drawn with a built-in 3x5 pixel font of the hex digits, scaled up by labelScale.
+const (
+	glyphWidth   = 3
+	glyphHeight  = 5
+	glyphAdvance = glyphWidth + 1
+	labelScale   = 2
+)
+
+// hexGlyphs are the rows of the glyphs of the hex digits, the highest bit being the leftmost pixel.
+var hexGlyphs = map[rune][glyphHeight]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'a': {2, 5, 7, 5, 5},
+	'b': {6, 5, 6, 5, 6},
+	'c': {3, 4, 4, 4, 3},
+	'd': {6, 5, 5, 5, 6},
+	'e': {7, 4, 6, 4, 7},
+	'f': {7, 4, 6, 4, 4},
+}
+
+// drawLabel draws a string of hex digits whose top left corner is (x, y); the other characters are skipped.
+func drawLabel(img draw.Image, label string, x, y int, ink color.Color) {
+	for _, r := range label {
+		glyph := hexGlyphs[r]
+		for row, bits := range glyph {
+			for col := 0; col < glyphWidth; col++ {
+				if bits&(1<<(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				dot := image.Rect(0, 0, labelScale, labelScale).Add(image.Pt(x+col*labelScale, y+row*labelScale))
+				draw.Draw(img, dot, image.NewUniform(ink), image.Point{}, draw.Src)
+			}
+		}
+		x += glyphAdvance * labelScale
+	}
+}
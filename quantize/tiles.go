@@ -0,0 +1,262 @@
+package quantize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// 			Per-tile palettes.
+//
+
+// Old consoles and computers restrict each tile of the screen (e.g. 8x8 pixels) to a few colors of a global palette,
+// which makes the colors clash at the tile borders. ApplyTilePalettes emulates these constraints.
+// The sprites of a sprite sheet are drawn apart, each with its own palette: ApplyCellPalettes generates one per cell.
+
+// TileLayout splits an image into tiles restricted to a number of colors.
+type TileLayout struct {
+	Width, Height int
+	// Colors is the maximum number of colors of each tile; 0 gives each tile its own palette (see ApplyCellPalettes).
+	Colors int
+}
+
+// ParseTileSize parses a tile size given as "WxH", e.g. "8x8", or as a single number for square tiles.
+func ParseTileSize(s string) (width, height int, err error) {
+	ws, hs, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		hs = ws
+	}
+	width, errW := strconv.Atoi(ws)
+	height, errH := strconv.Atoi(hs)
+	if errW != nil || errH != nil || width < 1 || height < 1 {
+		return 0, 0, fmt.Errorf("invalid tile size %q (WxH expected, e.g. 8x8)", s)
+	}
+
+	return width, height, nil
+}
+
+// TilePalettes describes the colors of the tiles of an image, e.g. for game tools.
+type TilePalettes struct {
+	TileWidth  int `json:"tile_width"`
+	TileHeight int `json:"tile_height"`
+	Columns    int `json:"columns"`
+	Rows       int `json:"rows"`
+	// Palette is the global palette.
+	Palette []color.RGBA `json:"-"`
+	// Tiles holds the indices in the global palette of the colors of each tile, row by row.
+	Tiles [][]int `json:"tiles"`
+}
+
+// MarshalJSON writes the global palette as hex colors (see FormatHexColor), and the colors of each tile
+// as hex colors too ("tile_palettes"), for the tools which load a palette per tile.
+func (t *TilePalettes) MarshalJSON() ([]byte, error) {
+	type tilePalettes TilePalettes
+	palette := make([]string, len(t.Palette))
+	for i, c := range t.Palette {
+		palette[i] = FormatHexColor(c)
+	}
+	tiles := make([][]string, len(t.Tiles))
+	for i, colors := range t.Tiles {
+		tiles[i] = make([]string, len(colors))
+		for j, k := range colors {
+			tiles[i][j] = FormatHexColor(t.Palette[k])
+		}
+	}
+
+	return json.Marshal(struct {
+		Palette []string `json:"palette"`
+		*tilePalettes
+		TilePalettes [][]string `json:"tile_palettes"`
+	}{palette, (*tilePalettes)(t), tiles})
+}
+
+// WriteTilePalettesToFile saves the tile palettes of an image to a JSON file.
+func WriteTilePalettesToFile(t *TilePalettes, filePath string) error {
+	data, err := json.MarshalIndent(t, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filePath, append(data, '\n'), 0666)
+}
+
+// ApplyTilePalettes dithers an image with a global palette, as ApplyPalette does,
+// but each tile of the layout only gets the layout.Colors colors of the palette which are the nearest to the most of its pixels.
+// The error diffusion does not cross the tiles.
+// The result uses the global palette (plus TransparentColor if the image has transparent pixels).
+func ApplyTilePalettes(ctx context.Context, img image.Image, palette []color.RGBA, layout TileLayout, opts PaletteOptions, ditherer Ditherer) (*image.Paletted, *TilePalettes, error) {
+	if len(palette) == 0 {
+		return nil, nil, fmt.Errorf("the palette is empty")
+	}
+	if layout.Width < 1 || layout.Height < 1 || layout.Colors < 1 {
+		return nil, nil, fmt.Errorf("invalid tile layout %dx%d with %d colors", layout.Width, layout.Height, layout.Colors)
+	}
+
+	b := img.Bounds()
+	outPalette := ColorPalette(palette)
+	if HasTransparentPixels(img, opts.TransparencyThreshold()) {
+		outPalette = append(outPalette, TransparentColor)
+	}
+	out := image.NewPaletted(b, outPalette)
+
+	tiles := &TilePalettes{
+		TileWidth:  layout.Width,
+		TileHeight: layout.Height,
+		Columns:    (b.Dx() + layout.Width - 1) / layout.Width,
+		Rows:       (b.Dy() + layout.Height - 1) / layout.Height,
+		Palette:    palette,
+	}
+
+	index := NewPaletteIndex(palette, opts.Metric)
+	for y := b.Min.Y; y < b.Max.Y; y += layout.Height {
+		for x := b.Min.X; x < b.Max.X; x += layout.Width {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+
+			r := image.Rect(x, y, x+layout.Width, y+layout.Height).Intersect(b)
+			tile := subImage(img, r)
+			colors := tileColors(tile, index, layout.Colors, opts)
+
+			tilePalette := make([]color.RGBA, len(colors))
+			for i, k := range colors {
+				tilePalette[i] = palette[k]
+			}
+			tileOut, err := ApplyPalette(ctx, tile, tilePalette, opts, ditherer)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// The indices of the tile palette are turned into global ones; the last one may be the transparent one.
+			for ty := r.Min.Y; ty < r.Max.Y; ty++ {
+				for tx := r.Min.X; tx < r.Max.X; tx++ {
+					i := int(tileOut.ColorIndexAt(tx, ty))
+					if i < len(colors) {
+						out.SetColorIndex(tx, ty, uint8(colors[i]))
+					} else {
+						out.SetColorIndex(tx, ty, uint8(len(palette)))
+					}
+				}
+			}
+			tiles.Tiles = append(tiles.Tiles, colors)
+		}
+	}
+
+	return out, tiles, nil
+}
+
+// ApplyCellPalettes splits an image into the cells of the layout, e.g. the sprites of a sprite sheet, and dithers each cell
+// with its own palette of at most <paletteMaxSize> colors generated from its pixels, as PaletteFromImage and ApplyPalette do.
+// The error diffusion does not cross the cells.
+// The result is a paletted image using the colors of all the cell palettes (plus TransparentColor if a cell has
+// transparent pixels), or a true-color *image.RGBA if they are more than MaxPaletteSize; the cell palettes are the same.
+func ApplyCellPalettes(ctx context.Context, img image.Image, layout TileLayout, paletteMaxSize int, opts PaletteOptions, ditherer Ditherer) (image.Image, *TilePalettes, error) {
+	if layout.Width < 1 || layout.Height < 1 {
+		return nil, nil, fmt.Errorf("invalid cell size %dx%d", layout.Width, layout.Height)
+	}
+
+	b := img.Bounds()
+	out := image.NewPaletted(b, nil)
+	cells := &TilePalettes{
+		TileWidth:  layout.Width,
+		TileHeight: layout.Height,
+		Columns:    (b.Dx() + layout.Width - 1) / layout.Width,
+		Rows:       (b.Dy() + layout.Height - 1) / layout.Height,
+	}
+
+	// The colors of the cells are gathered in one palette, each color once.
+	indices := map[color.RGBA]int{}
+	var trueColor *image.RGBA
+	for y := b.Min.Y; y < b.Max.Y; y += layout.Height {
+		for x := b.Min.X; x < b.Max.X; x += layout.Width {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+
+			r := image.Rect(x, y, x+layout.Width, y+layout.Height).Intersect(b)
+			cell := subImage(img, r)
+			cellOut, err := ApplyPalette(ctx, cell, PaletteFromImage(cell, paletteMaxSize, opts), opts, ditherer)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			colors := make([]int, len(cellOut.Palette))
+			for i, c := range PaletteColors(cellOut.Palette) {
+				k, ok := indices[c]
+				if !ok {
+					k = len(cells.Palette)
+					indices[c] = k
+					cells.Palette = append(cells.Palette, c)
+				}
+				colors[i] = k
+			}
+			// When the colors do not fit in a paletted image any more, the cells done so far, which only use
+			// the first MaxPaletteSize colors, are copied to a true-color image, where the next cells go.
+			if trueColor == nil && len(cells.Palette) > MaxPaletteSize {
+				out.Palette = ColorPalette(cells.Palette)
+				trueColor = image.NewRGBA(b)
+				draw.Draw(trueColor, b, out, b.Min, draw.Src)
+			}
+			for cy := r.Min.Y; cy < r.Max.Y; cy++ {
+				for cx := r.Min.X; cx < r.Max.X; cx++ {
+					k := colors[cellOut.ColorIndexAt(cx, cy)]
+					if trueColor != nil {
+						trueColor.SetRGBA(cx, cy, cells.Palette[k])
+					} else {
+						out.SetColorIndex(cx, cy, uint8(k))
+					}
+				}
+			}
+			cells.Tiles = append(cells.Tiles, colors)
+		}
+	}
+	if trueColor != nil {
+		return trueColor, cells, nil
+	}
+	out.Palette = ColorPalette(cells.Palette)
+
+	return out, cells, nil
+}
+
+// tileColors returns the indices of the palette colors of a tile: the at most <max> colors
+// which are the nearest to the most pixels of the tile, in increasing order.
+func tileColors(tile image.Image, index *PaletteIndex, max int, opts PaletteOptions) []int {
+	counts := map[int]int{}
+	for y := tile.Bounds().Min.Y; y < tile.Bounds().Max.Y; y++ {
+		for x := tile.Bounds().Min.X; x < tile.Bounds().Max.X; x++ {
+			if c, ok := paletteColor(PixelColor(tile, x, y), opts); ok {
+				counts[index.Nearest(c)]++
+			}
+		}
+	}
+
+	var colors []int
+	for k := range counts {
+		colors = append(colors, k)
+	}
+	// Ties are broken by index, so that the result does not depend on the map order.
+	sort.Slice(colors, func(i, j int) bool {
+		if counts[colors[i]] != counts[colors[j]] {
+			return counts[colors[i]] > counts[colors[j]]
+		}
+		return colors[i] < colors[j]
+	})
+	if len(colors) > max {
+		colors = colors[:max]
+	}
+	// A fully transparent tile still needs a palette to be dithered.
+	if len(colors) == 0 {
+		colors = []int{0}
+	}
+	sort.Ints(colors)
+
+	return colors
+}
@@ -0,0 +1,51 @@
+package quantize
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestApplyCellPalettes(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), uint8((x + y) * 2), 255})
+		}
+	}
+	layout := TileLayout{Width: 16, Height: 16}
+
+	for _, test := range []struct {
+		paletteSize int
+		paletted    bool
+	}{
+		{8, true},   // 16 cells of 8 colors fit in a paletted image
+		{32, false}, // 16 cells of 32 colors do not
+	} {
+		out, cells, err := ApplyCellPalettes(context.Background(), img, layout, test.paletteSize, PaletteOptions{HistogramBits: 6}, NoDitherer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := out.(*image.Paletted); ok != test.paletted {
+			t.Fatalf("palette size %d: got a %T, paletted %v expected", test.paletteSize, out, test.paletted)
+		}
+		if len(cells.Tiles) != 16 {
+			t.Fatalf("palette size %d: %d cell palettes, want 16", test.paletteSize, len(cells.Tiles))
+		}
+
+		// Each pixel has a color of the palette of its cell.
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				c := PixelColor(out, x, y)
+				found := false
+				for _, k := range cells.Tiles[y/16*4+x/16] {
+					found = found || cells.Palette[k] == c
+				}
+				if !found {
+					t.Fatalf("palette size %d: pixel (%d, %d) of color %v out of the palette of its cell", test.paletteSize, x, y, c)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package quantize
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"text/tabwriter"
+)
+
+//
+// 			Palette usage statistics.
+//
+
+// PaletteUsage is the number of pixels mapped to a palette entry.
+type PaletteUsage struct {
+	Index  int
+	Color  color.RGBA
+	Pixels int
+	// Share is the fraction of the pixels of the image, in [0, 1].
+	Share float64
+}
+
+// PaletteUsageOf counts the pixels mapped to each entry of the palette of an image.
+// The unused entries have no pixels: they are wasted palette slots.
+func PaletteUsageOf(img *image.Paletted) []PaletteUsage {
+	usage := make([]PaletteUsage, len(img.Palette))
+	for i, c := range img.Palette {
+		usage[i] = PaletteUsage{Index: i, Color: color.RGBAModel.Convert(c).(color.RGBA)}
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if i := int(img.ColorIndexAt(x, y)); i < len(usage) {
+				usage[i].Pixels++
+			}
+		}
+	}
+	if total := b.Dx() * b.Dy(); total > 0 {
+		for i := range usage {
+			usage[i].Share = float64(usage[i].Pixels) / float64(total)
+		}
+	}
+
+	return usage
+}
+
+// WriteUsageStats writes the palette usage of an image to <w>, as a text table or as a JSON object (on one line).
+func WriteUsageStats(w io.Writer, name string, usage []PaletteUsage, asJSON bool) error {
+	if asJSON {
+		type entry struct {
+			Index  int     `json:"index"`
+			Color  string  `json:"color"`
+			Pixels int     `json:"pixels"`
+			Share  float64 `json:"share"`
+		}
+		stats := struct {
+			File    string  `json:"file"`
+			Unused  int     `json:"unused"`
+			Palette []entry `json:"palette"`
+		}{File: name}
+		for _, u := range usage {
+			if u.Pixels == 0 {
+				stats.Unused++
+			}
+			stats.Palette = append(stats.Palette, entry{u.Index, FormatHexColor(u.Color), u.Pixels, u.Share})
+		}
+		return json.NewEncoder(w).Encode(stats)
+	}
+
+	unused := 0
+	fmt.Fprintf(w, "%s: palette usage\n", name)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "index\tcolor\tpixels\tshare\t\n")
+	for _, u := range usage {
+		if u.Pixels == 0 {
+			unused++
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%.2f%%\t\n", u.Index, FormatHexColor(u.Color), u.Pixels, 100*u.Share)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "%s: %d of %d palette entries unused\n", name, unused, len(usage))
+	return err
+}
+
+// UsageStripWidth and UsageStripHeight are the size of the palette usage strips (see UsageStrip).
+const (
+	UsageStripWidth  = 512
+	UsageStripHeight = 32
+)
+
+// UsageStrip draws the palette colors side by side, each one as wide as its share of the pixels.
+// The unused colors do not show.
+func UsageStrip(usage []PaletteUsage) *image.RGBA {
+	strip := image.NewRGBA(image.Rect(0, 0, UsageStripWidth, UsageStripHeight))
+
+	// The edges are rounded from the cumulated shares, so that the widths add up to the strip width.
+	cumulated, left := 0., 0
+	for _, u := range usage {
+		cumulated += u.Share
+		right := int(cumulated*UsageStripWidth + 0.5)
+		draw.Draw(strip, image.Rect(left, 0, right, UsageStripHeight), image.NewUniform(u.Color), image.Point{}, draw.Src)
+		left = right
+	}
+
+	return strip
+}
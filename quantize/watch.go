@@ -0,0 +1,119 @@
+package quantize
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+//
+// 			Watch mode.
+//
+
+// With -watch, the input files are processed again each time they change, e.g. while an artist tweaks a source
+// image in an editor with the quantized result open in a viewer. The files are polled, which needs nothing
+// from the operating system, and a change is only handled once the file has stopped changing,
+// so that a file being saved is not read half-written.
+
+// WatchInterval is the delay between two checks of the watched files.
+const WatchInterval = 300 * time.Millisecond
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// stampFiles returns the stamps of some files; the missing files are left out.
+func stampFiles(paths []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			stamps[path] = fileStamp{info.ModTime(), info.Size()}
+		}
+	}
+
+	return stamps
+}
+
+// changedFiles returns the files of <current> which are new or differ from <previous>, in the order of <paths>.
+func changedFiles(paths []string, previous, current map[string]fileStamp) []string {
+	var changed []string
+	for _, path := range paths {
+		if stamp, ok := current[path]; ok && stamp != previous[path] {
+			changed = append(changed, path)
+		}
+	}
+
+	return changed
+}
+
+// Watch calls <run> with nil, then calls it again with the files which changed each time some of the files listed by
+// <files> change, until <ctx> is canceled; it then returns nil. The files are listed again on each check, so that
+// the new files of a directory are picked up. The files written by <run>, e.g. output files next to the input files,
+// do not trigger a new run. The failures are reported to the standard error, and the watch goes on.
+// <logf> prints the watch messages.
+func Watch(ctx context.Context, files func() ([]string, error), run func(changed []string) error, logf func(format string, args ...interface{})) error {
+	report := func(err error) {
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "image-quantization: %v\n", err)
+		}
+	}
+
+	report(run(nil))
+	// The stamps are taken after the run, so that its output files are not seen as changed.
+	paths, err := files()
+	report(err)
+	stamps := stampFiles(paths)
+	logf("watching %d files, press Ctrl+C to stop", len(paths))
+
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+	var pending map[string]fileStamp
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		paths, err := files()
+		if err != nil {
+			continue
+		}
+		current := stampFiles(paths)
+		if len(changedFiles(paths, stamps, current)) == 0 {
+			pending = nil
+			continue
+		}
+		// The files are still being written if they changed since the last check.
+		if pending == nil || len(changedFiles(paths, pending, current)) > 0 {
+			pending = current
+			continue
+		}
+
+		changed := changedFiles(paths, stamps, current)
+		for _, path := range changed {
+			logf("%s: changed", path)
+		}
+		report(run(changed))
+		paths, err = files()
+		report(err)
+		stamps, pending = stampFiles(paths), nil
+	}
+}
+
+// WatchedFiles lists the files of an input filepath: the files of a batch input (see BatchInputFiles)
+// or of an image sequence (see SequenceFiles), or the input file itself.
+func WatchedFiles(srcFilepath string) ([]string, error) {
+	if IsSequenceInput(srcFilepath) {
+		files, _, err := SequenceFiles(srcFilepath)
+		return files, err
+	}
+	if IsBatchInput(srcFilepath) {
+		return BatchInputFiles(srcFilepath)
+	}
+
+	return []string{srcFilepath}, nil
+}
@@ -0,0 +1,98 @@
+package quantize
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+//
+// 			Pixel weights.
+//
+
+// PixelWeights gives the weight of each pixel of an image in the palette generation (see PaletteOptions.Weights):
+// a pixel of weight n counts as n pixels of its color, and the pixels of weight 0 are left out (see RegionMask).
+type PixelWeights interface {
+	// Weight returns the weight, at least 0, of the pixel located at column x and row y.
+	Weight(x, y int) int
+}
+
+// DefaultFocusWeight is the default weight of the pixels of a region of interest.
+const DefaultFocusWeight = 8
+
+// FocusWeights gives the pixels of a rectangle a larger weight than the others, which weigh 1.
+type FocusWeights struct {
+	Rect image.Rectangle
+	// Focus is the weight of the pixels of the rectangle.
+	Focus int
+}
+
+// Weight implements the PixelWeights interface.
+func (w FocusWeights) Weight(x, y int) int {
+	if (image.Point{x, y}).In(w.Rect) {
+		return ClampBelowInt(w.Focus, 1)
+	}
+
+	return 1
+}
+
+// ParseFocusRect parses a rectangle given as "x,y,w,h", e.g. "100,50,200,300".
+func ParseFocusRect(s string) (image.Rectangle, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid rectangle %q (x,y,w,h expected)", s)
+	}
+
+	var v [4]int
+	for i, field := range fields {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || (i >= 2 && n <= 0) {
+			return image.Rectangle{}, fmt.Errorf("invalid rectangle %q (x,y,w,h expected, with a positive size)", s)
+		}
+		v[i] = n
+	}
+
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// MaskWeights weighs the pixels of an image by the gray levels of a mask image of the same size:
+// from 1 for black to Focus for white.
+type MaskWeights struct {
+	Mask  image.Image
+	Focus int
+}
+
+// Weight implements the PixelWeights interface.
+// The coordinates are relative to the bounds of the mask, whose top left corner stands for the (0, 0) pixel.
+func (w MaskWeights) Weight(x, y int) int {
+	min := w.Mask.Bounds().Min
+	level := Gray(PixelColor(w.Mask, min.X+x, min.Y+y), false).R
+
+	return 1 + (int(level)*(ClampBelowInt(w.Focus, 1)-1)+127)/255
+}
+
+// TransformWeights returns the weights of the pixels of an image turned upright by Orient, then scaled down
+// by ScaleDown with a given filter, from the weights of the pixels of the image of a given size:
+// the rectangle of FocusWeights and the mask of MaskWeights follow the image. Other weights are returned as is.
+func TransformWeights(weights PixelWeights, size image.Point, orientation, factor int, filter string) PixelWeights {
+	switch w := weights.(type) {
+	case FocusWeights:
+		w.Rect = ScaleDownRect(OrientRect(w.Rect, size, orientation), factor)
+		return w
+	case MaskWeights:
+		w.Mask = ScaleDown(Orient(w.Mask, orientation), factor, filter, false)
+		return w
+	}
+
+	return weights
+}
+
+// pixelWeight returns the weight of a pixel, 1 if <weights> is nil.
+func pixelWeight(weights PixelWeights, x, y int) int {
+	if weights == nil {
+		return 1
+	}
+
+	return weights.Weight(x, y)
+}
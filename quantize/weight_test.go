@@ -0,0 +1,81 @@
+package quantize
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestOrientRectFollowsOrient(t *testing.T) {
+	size := image.Pt(8, 4)
+	rect := image.Rect(5, 1, 7, 4)
+	mask := image.NewGray(image.Rectangle{Max: size})
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			mask.SetGray(x, y, color.Gray{255})
+		}
+	}
+
+	for orientation := OrientationNormal; orientation <= 8; orientation++ {
+		oriented := Orient(mask, orientation)
+		orientedRect := OrientRect(rect, size, orientation)
+		b := oriented.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if in, white := (image.Point{x, y}).In(orientedRect), PixelColor(oriented, x, y).R == 255; in != white {
+					t.Fatalf("orientation %d: pixel (%d,%d) in %v: %v, want %v", orientation, x, y, orientedRect, in, white)
+				}
+			}
+		}
+	}
+}
+
+func TestTransformWeightsWithScaleDown(t *testing.T) {
+	// The focus rectangle of an 8x4 image turned by 90° clockwise, then halved.
+	w := TransformWeights(FocusWeights{Rect: image.Rect(4, 0, 8, 2), Focus: 8}, image.Pt(8, 4), 6, 2, ScaleBox)
+	if got, want := w.(FocusWeights).Rect, image.Rect(1, 2, 2, 4); got != want {
+		t.Errorf("focus rectangle %v, want %v", got, want)
+	}
+}
+
+func TestProcessFileScalesWeightMaskDown(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	mask := image.NewGray(img.Rect)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+			mask.SetGray(x, y, color.Gray{uint8(x * 16)})
+		}
+	}
+	in := filepath.Join(dir, "in.png")
+	if err := WriteImageToFile(img, in, "png"); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := NewSettings(WithPaletteSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.ScaleDown, settings.ScaleFilter = 2, ScaleBox
+	settings.Palette.Weights = MaskWeights{Mask: mask, Focus: 8}
+	out := filepath.Join(dir, "out.png")
+	if err := ProcessFile(context.Background(), in, out, settings); err != nil {
+		t.Fatal(err)
+	}
+	result, err := GetImageFromFilePath(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := result.Bounds().Size(); size != image.Pt(8, 8) {
+		t.Errorf("output size %v, want (8,8)", size)
+	}
+
+	// The mask of the downscaled image is refused.
+	settings.Palette.Weights = MaskWeights{Mask: ScaleDown(mask, 2, ScaleBox, false), Focus: 8}
+	if err := ProcessFile(context.Background(), in, out, settings); err == nil {
+		t.Error("a weight mask of the size of the downscaled image accepted")
+	}
+}
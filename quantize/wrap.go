@@ -0,0 +1,108 @@
+package quantize
+
+import (
+	"context"
+	"image"
+	"image/color"
+)
+
+//
+// 			Seamless tiling.
+//
+
+// The textures of games are tiled over walls and floors, so their left column is drawn next to their right one,
+// and their top row next to their bottom one. The error diffusion starts from no error at the top left corner,
+// and reaches the borders with errors the pixels across the seams never get: the tiled output shows the seams.
+// The image is dithered as if it wrapped around: surrounded by margins of its opposite borders, whose diffused
+// errors flow into it as they would from its neighboring copies. The ordered ditherings look up their thresholds
+// at the pixel coordinates, so their patterns continue across the seams if the matrix tiles the image.
+
+// DefaultWrapMargin is the width of the margins of the WrapDitherer, which is plenty for the diffused errors to settle.
+const DefaultWrapMargin = 32
+
+// WrapDitherer dithers an image as if it were tiled: the Ditherer dithers the image surrounded by margins of Margin pixels
+// (0 means DefaultWrapMargin) taken from its opposite borders, then the margins are cut off.
+type WrapDitherer struct {
+	Ditherer
+	Margin int
+}
+
+// Dither implements the Ditherer interface.
+func (d WrapDitherer) Dither(img image.Image, palette []color.RGBA) *image.Paletted {
+	out, _ := d.DitherContext(context.Background(), img, palette)
+	return out
+}
+
+// ForFrame implements the FrameDitherer interface: the frames of the FrameDitherers get their own offset.
+func (d WrapDitherer) ForFrame(frame int) Ditherer {
+	if fd, ok := d.Ditherer.(FrameDitherer); ok {
+		d.Ditherer = fd.ForFrame(frame)
+	}
+
+	return d
+}
+
+// DitherContext implements the ContextDitherer interface.
+func (d WrapDitherer) DitherContext(ctx context.Context, img image.Image, palette []color.RGBA) (*image.Paletted, error) {
+	margin := d.Margin
+	if margin <= 0 {
+		margin = DefaultWrapMargin
+	}
+
+	b := img.Bounds()
+	if b.Empty() {
+		return DitherContext(ctx, d.Ditherer, img, palette)
+	}
+	padded := WrapImage(img, margin)
+	dithered, err := DitherContext(ctx, d.Ditherer, padded, palette)
+	if err != nil {
+		return nil, err
+	}
+
+	out := image.NewPaletted(b, dithered.Palette)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		copy(out.Pix[out.PixOffset(b.Min.X, y):out.PixOffset(b.Max.X, y)], dithered.Pix[dithered.PixOffset(b.Min.X, y):])
+	}
+
+	return out, nil
+}
+
+// WrapImage returns an image surrounded by margins of <margin> pixels on every side, as if it were tiled over the plane:
+// the pixels of the margins are those of the opposite borders. The pixels of the image keep their coordinates.
+func WrapImage(img image.Image, margin int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b.Inset(-margin))
+	for y := out.Rect.Min.Y; y < out.Rect.Max.Y; y++ {
+		sy := b.Min.Y + mod(y-b.Min.Y, b.Dy())
+		for x := out.Rect.Min.X; x < out.Rect.Max.X; x++ {
+			out.SetRGBA(x, y, PixelColor(img, b.Min.X+mod(x-b.Min.X, b.Dx()), sy))
+		}
+	}
+
+	return out
+}
+
+// thresholdMatrixSize returns the size of the threshold matrix of the ordered dithering of the settings, once turned,
+// or false if the ditherer has none, e.g. for the error diffusion or the noise ditherings.
+func (s Settings) thresholdMatrixSize() (image.Point, bool) {
+	var size image.Point
+	matrix := s.Dither.Matrix
+	if pattern, ok := patterns[s.DitherName]; ok {
+		matrix = pattern()
+	} else if s.DitherName != "ordered" {
+		matrix = nil
+	}
+	switch {
+	case matrix != nil:
+		size = image.Pt(len(matrix[0]), len(matrix))
+	case s.DitherName == "bayer" || s.DitherName == "adaptive-bayer" || s.DitherName == "ordered":
+		size = image.Pt(s.Dither.BayerMatSize, s.Dither.BayerMatSize)
+	default:
+		return image.Point{}, false
+	}
+	if s.Dither.Orientation.Rotation%2 != 0 {
+		size.X, size.Y = size.Y, size.X
+	}
+
+	return size, true
+}
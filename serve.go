@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"time"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Serve command.
+//
+
+// runServe parses the command line flags of the serve subcommand and runs the HTTP service until Ctrl+C.
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address the HTTP service listens to")
+	maxBytes := flags.Int64("max-bytes", 32<<20, "maximum size in bytes of an uploaded image file")
+	maxPixels := flags.Int("max-pixels", 50*1000*1000, "maximum number of pixels of an uploaded image")
+	maxJobs := flags.Int("max-jobs", runtime.NumCPU(), "maximum number of images quantized at once")
+	threads := flags.Int("threads", 0, "maximum number of goroutines working on an image; 0 means one per CPU")
+	flags.Parse(args)
+
+	mux := http.NewServeMux()
+	mux.Handle("/quantize", quantize.NewQuantizeHandler(quantize.ServeOptions{
+		MaxBodyBytes: *maxBytes,
+		MaxPixels:    *maxPixels,
+		MaxJobs:      *maxJobs,
+		Threads:      *threads,
+	}))
+	server := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	// Stop the service on Ctrl+C, letting the requests in progress finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	fmt.Fprintf(os.Stderr, "listening on %s\n", *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
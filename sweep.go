@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			Settings sweeps.
+//
+
+// A sweep quantizes one image with every combination of several values of some flags, e.g. "pal=4,8,16;dither=bayer8,fs",
+// writing an output file per combination, so that the settings can be compared side by side.
+// The input file is read and decoded once, and the palettes generated from the same pixels share their histogram.
+
+// SweepAxis is a flag of a sweep and its values.
+type SweepAxis struct {
+	Flag   string
+	Values []string
+}
+
+// sweepFixedFlags are the flags a sweep cannot vary.
+var sweepFixedFlags = map[string]bool{"in": true, "out": true, "sweep": true, "jobs": true}
+
+// ParseSweep parses a sweep given as semicolon-separated flags and their comma-separated values,
+// e.g. "pal=4,8,16;dither=bayer8,fs".
+func ParseSweep(s string) ([]SweepAxis, error) {
+	var axes []SweepAxis
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		name, values, ok := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "-")
+		if !ok || name == "" || strings.TrimSpace(values) == "" {
+			return nil, fmt.Errorf("invalid sweep %q (flag=value,value;flag=value... expected)", part)
+		}
+		if sweepFixedFlags[name] {
+			return nil, fmt.Errorf("a sweep cannot vary -%s", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("the sweep has several -%s axes", name)
+		}
+		seen[name] = true
+
+		axis := SweepAxis{Flag: name}
+		for _, value := range strings.Split(values, ",") {
+			axis.Values = append(axis.Values, strings.TrimSpace(value))
+		}
+		axes = append(axes, axis)
+	}
+
+	return axes, nil
+}
+
+// SweepCombinations returns every combination of the values of the axes of a sweep, one value per axis,
+// the values of the last axis varying the fastest.
+func SweepCombinations(axes []SweepAxis) [][]string {
+	combinations := [][]string{nil}
+	for _, axis := range axes {
+		var next [][]string
+		for _, combination := range combinations {
+			for _, value := range axis.Values {
+				next = append(next, append(append([]string(nil), combination...), value))
+			}
+		}
+		combinations = next
+	}
+
+	return combinations
+}
+
+// sweepDitherAliases maps short names of the -dither values of a sweep to the ditherer names.
+var sweepDitherAliases = map[string]string{"fs": "floyd-steinberg"}
+
+// sweepBayer matches the "bayerN" -dither value of a sweep, the Bayer ditherer with an N x N matrix.
+var sweepBayer = regexp.MustCompile(`^bayer(\d+)$`)
+
+// SweepArgs returns the command line flags setting a value of a sweep axis.
+// The -dither values may be shortened: "fs" for floyd-steinberg, and "bayerN" for bayer with -bay N.
+func SweepArgs(flag, value string) []string {
+	if flag == "dither" {
+		if name, ok := sweepDitherAliases[value]; ok {
+			value = name
+		}
+		if m := sweepBayer.FindStringSubmatch(value); m != nil {
+			return []string{"-dither=bayer", "-bay=" + m[1]}
+		}
+	}
+
+	return []string{"-" + flag + "=" + value}
+}
+
+// SweepOutputFilepath computes the output filepath of a combination of a sweep: each {flag} of <out>
+// is replaced by the value of its axis. If <out> names no axis, the flags and their values
+// are appended to the file name instead, e.g. out_pal-4_dither-fs.png.
+func SweepOutputFilepath(out string, axes []SweepAxis, combination []string) string {
+	path := out
+	for i, axis := range axes {
+		path = strings.ReplaceAll(path, "{"+axis.Flag+"}", combination[i])
+	}
+	if path != out {
+		return path
+	}
+
+	ext := filepath.Ext(out)
+	var suffix strings.Builder
+	for i, axis := range axes {
+		fmt.Fprintf(&suffix, "_%s-%s", axis.Flag, combination[i])
+	}
+	return strings.TrimSuffix(out, ext) + suffix.String() + ext
+}
+
+// runSweep runs the quantize subcommand once per combination of a sweep, with the command line <args>
+// followed by the flags of the combination and its output filepath. The runs share their input and histogram caches.
+func runSweep(args []string, flags *flag.FlagSet, sweep, srcFilepath, outFilepath string) error {
+	axes, err := ParseSweep(sweep)
+	if err != nil {
+		return err
+	}
+	for _, axis := range axes {
+		if flags.Lookup(axis.Flag) == nil {
+			return fmt.Errorf("unknown sweep flag -%s", axis.Flag)
+		}
+	}
+	if quantize.IsBatchInput(srcFilepath) || quantize.IsSequenceInput(srcFilepath) {
+		return fmt.Errorf("-sweep needs a single input file")
+	}
+	if quantize.IsStdio(outFilepath) {
+		return fmt.Errorf("-sweep writes a file per combination, so it needs an output filepath")
+	}
+
+	inputs, histograms := quantize.NewInputCache(), quantize.NewHistogramCache()
+	for _, combination := range SweepCombinations(axes) {
+		// The last occurrence of a flag wins, so the combination overrides the command line.
+		runArgs := append(append([]string(nil), args...), "-sweep=", "-cpuprofile=", "-memprofile=", "-out="+SweepOutputFilepath(outFilepath, axes, combination))
+		var names []string
+		for i, axis := range axes {
+			runArgs = append(runArgs, SweepArgs(axis.Flag, combination[i])...)
+			names = append(names, axis.Flag+"="+combination[i])
+		}
+		if err := runQuantizeWith(runArgs, inputs, histograms); err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(names, " "), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"syscall/js"
+
+	"image-quantization/quantize"
+)
+
+//
+// 			WebAssembly entry point.
+//
+
+// main exposes the quantizer to JavaScript as the global function quantize(bytes, options),
+// which takes an encoded image as a Uint8Array and an optional object of settings (see quantize.SettingsFromParams),
+// e.g. {pal: 8, dither: "floyd-steinberg"}. It returns a Promise of the encoded result as a Uint8Array.
+func main() {
+	js.Global().Set("quantize", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		var data []byte
+		var options js.Value
+		if len(args) > 0 && args[0].InstanceOf(js.Global().Get("Uint8Array")) {
+			data = make([]byte, args[0].Length())
+			js.CopyBytesToGo(data, args[0])
+		}
+		if len(args) > 1 {
+			options = args[1]
+		}
+
+		// The work runs in a goroutine, as a JavaScript callback must not block.
+		executor := js.FuncOf(func(this js.Value, promise []js.Value) interface{} {
+			resolve, reject := promise[0], promise[1]
+			go func() {
+				out, err := quantizeJS(data, options)
+				if err != nil {
+					reject.Invoke(js.Global().Get("Error").New(err.Error()))
+					return
+				}
+				result := js.Global().Get("Uint8Array").New(len(out))
+				js.CopyBytesToJS(result, out)
+				resolve.Invoke(result)
+			}()
+			return nil
+		})
+		defer executor.Release()
+
+		return js.Global().Get("Promise").New(executor)
+	}))
+
+	// Keep the functions available.
+	select {}
+}
+
+// quantizeJS quantizes an encoded image with the settings of a JavaScript object.
+func quantizeJS(data []byte, options js.Value) ([]byte, error) {
+	if data == nil {
+		return nil, fmt.Errorf("the image must be a Uint8Array")
+	}
+
+	params := url.Values{}
+	if options.Type() == js.TypeObject {
+		keys := js.Global().Get("Object").Call("keys", options)
+		for i := 0; i < keys.Length(); i++ {
+			key := keys.Index(i).String()
+			switch value := options.Get(key); value.Type() {
+			case js.TypeNumber:
+				params.Set(key, strconv.FormatFloat(value.Float(), 'f', -1, 64))
+			default:
+				params.Set(key, value.String())
+			}
+		}
+	}
+
+	// WebAssembly runs on a single thread.
+	settings, format, err := quantize.SettingsFromParams(params, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return quantize.QuantizeImageData(context.Background(), data, settings, format)
+}